@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mi-raf/comment-project/internal/database"
+	"github.com/mi-raf/comment-project/internal/loadtest"
+	"github.com/mi-raf/comment-project/internal/models"
+	"github.com/mi-raf/comment-project/internal/seed"
+	"github.com/mi-raf/comment-project/internal/transfer"
+	"github.com/rs/zerolog/log"
+)
+
+type command func(ctx context.Context, cfg *config, args []string) error
+
+var commands = map[string]command{
+	"recount": recountCommand,
+	"export":  exportCommand,
+	"import":  importCommand,
+	"migrate": migrateCommand,
+	"seed":    seedCommand,
+	"bench":   benchCommand,
+}
+
+// noSchemaCommands do not use the database, or manage its schema themselves.
+var noSchemaCommands = map[string]bool{
+	"migrate": true,
+	"bench":   true,
+}
+
+func runCommand(ctx context.Context, cfg *config, name string, args []string) error {
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %s", name)
+	}
+	log.Debug().Str("command", name).Strs("args", args).Msg("run command")
+	return cmd(ctx, cfg, args)
+}
+
+// recountCommand recomputes denormalized comment counters of posts
+// in case they have drifted from the comments table.
+func recountCommand(ctx context.Context, cfg *config, args []string) error {
+	p, cleanup, err := database.NewPostRepositoryProvider(ctx, initPostRepositoryConfig(cfg))
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	n, err := p.RecountComments(ctx)
+	if err != nil {
+		return err
+	}
+	log.Info().Int64("fixed posts", n).Msg("comment counters recomputed")
+	return nil
+}
+
+// exportCommand writes posts with their comment trees to a file or stdout.
+func exportCommand(ctx context.Context, cfg *config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", string(transfer.FormatJSONL), "output format: jsonl or json")
+	post := fs.Int64("post", 0, "export only the post with this id")
+	out := fs.String("out", "-", "output file, - for stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	f, err := transfer.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	r, cleanup, err := database.NewTransferRepositoryProvider(ctx, initTransferRepositoryConfig(cfg))
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	n, err := transfer.Export(ctx, r, w, f, *post)
+	if err != nil {
+		return err
+	}
+	log.Info().Int("posts", n).Str("format", *format).Msg("posts exported")
+	return nil
+}
+
+// importCommand stores posts from an export file with new ids.
+// The file hash identifies the import, so running it again continues after the last committed batch.
+func importCommand(ctx context.Context, cfg *config, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	batch := fs.Int("batch", transfer.BATCH_SIZE, "posts per transaction")
+	source := fs.String("source", "", "import id to resume, hash of the file by default")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: import [--batch N] [--source ID] <file>")
+	}
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if *source == "" {
+		h := sha256.New()
+		if _, err := io.Copy(h, file); err != nil {
+			return err
+		}
+		*source = hex.EncodeToString(h.Sum(nil))
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	r, cleanup, err := database.NewTransferRepositoryProvider(ctx, initTransferRepositoryConfig(cfg))
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	read, stored, err := transfer.Import(ctx, r, file, *source, *batch)
+	if err != nil {
+		return err
+	}
+	log.Info().Int("read", read).Int("stored", stored).Int("skipped", read-stored).Str("source", *source).Msg("posts imported")
+	return nil
+}
+
+// seedCommand generates posts with comment trees, the same for the same seed.
+// The in memory storage is gone when the command exits, so the command needs PostgreSQL.
+func seedCommand(ctx context.Context, cfg *config, args []string) error {
+	if cfg.InMemory {
+		return errors.New("seed is not used with in memory storage, the data would be lost on exit")
+	}
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	posts := fs.Int("posts", 100, "number of posts")
+	comments := fs.Int("comments-per-post", 50, "number of comments of every post")
+	depth := fs.Int("max-depth", 8, "max nesting of comments")
+	s := fs.Uint64("seed", 1, "random seed, 0 for random data")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	p, cleanupPosts, err := database.NewPostRepositoryProvider(ctx, initPostRepositoryConfig(cfg))
+	if err != nil {
+		return err
+	}
+	defer cleanupPosts()
+	ch := make(chan models.CommentDTO, 1)
+	c, cleanupComments, err := database.NewCommentRepositoryProvider(ctx, initCommentRepositoryConfig(cfg, ch), p)
+	if err != nil {
+		return err
+	}
+	defer cleanupComments()
+
+	start := time.Now()
+	st, err := seed.Run(ctx, p, c, seed.Config{Posts: *posts, CommentsPerPost: *comments, MaxDepth: *depth, Seed: *s})
+	if err != nil {
+		return err
+	}
+	log.Info().Int("posts", st.Posts).Int("comments", st.Comments).Int("depth", st.Depth).Dur("duration", time.Since(start)).Msg("data seeded")
+	return nil
+}
+
+// benchCommand drives the GraphQL endpoint of a running server and prints throughput,
+// latency percentiles and delivery lag of subscriptions.
+func benchCommand(ctx context.Context, cfg *config, args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	url := fs.String("url", "http://localhost"+cfg.Listen+"/query", "GraphQL endpoint")
+	duration := fs.Duration("duration", 30*time.Second, "duration of the test")
+	concurrency := fs.Int("concurrency", 16, "number of workers sending queries and mutations")
+	mix := fs.String("mix", "posts=5,comments=4,createComment=1", "relative weights of operations")
+	subscribers := fs.Int("subscribers", 50, "number of websocket clients subscribed to comments")
+	hot := fs.Int("hot-posts", 10, "number of posts which receive new comments and subscribers")
+	s := fs.Uint64("seed", 1, "random seed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	m, err := loadtest.ParseMix(*mix)
+	if err != nil {
+		return err
+	}
+
+	report, err := loadtest.Run(ctx, loadtest.Config{
+		URL:         *url,
+		Duration:    *duration,
+		Concurrency: *concurrency,
+		Mix:         m,
+		Subscribers: *subscribers,
+		HotPosts:    *hot,
+		Seed:        *s,
+	})
+	if err != nil {
+		return err
+	}
+	report.Write(os.Stdout)
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CommandsTestSuite struct {
+	suite.Suite
+}
+
+func (s *CommandsTestSuite) TestSeedInMemory() {
+	// when
+	err := seedCommand(context.Background(), &config{InMemory: true}, []string{"-posts", "1"})
+
+	// then
+	s.Error(err)
+}
+
+func (s *CommandsTestSuite) TestExportInMemory() {
+	// when
+	err := exportCommand(context.Background(), &config{InMemory: true}, nil)
+
+	// then
+	s.Error(err)
+}
+
+func (s *CommandsTestSuite) TestImportInMemory() {
+	// given
+	file := filepath.Join(s.T().TempDir(), "dump.jsonl")
+	s.Require().NoError(os.WriteFile(file, nil, 0o600))
+
+	// when
+	err := importCommand(context.Background(), &config{InMemory: true}, []string{file})
+
+	// then
+	s.Error(err)
+}
+
+func TestCommandsTestSuite(t *testing.T) {
+	suite.Run(t, new(CommandsTestSuite))
+}
@@ -1,17 +1,67 @@
 package main
 
 import (
+	"time"
+
 	"github.com/caarlos0/env/v11"
 	_ "github.com/joho/godotenv/autoload"
 )
 
 type config struct {
 	Listen             string `env:"LISTEN" envDefault:":9000"`
+	GrpcListen         string `env:"GRPC_LISTEN" envDefault:":9090"`
 	LogLevel           string `env:"LOG_LEVEL" envDefault:"debug"`
 	LogFmt             string `env:"LOG_FMT" envDefault:"console"`
 	InMemory           bool   `env:"IN_MEMORY" envDefault:"false"`
-	MigrationDirectory string `env:"MIGRATION_DIR" envDefault:"file://init/migrations"`
+	MigrationDirectory string `env:"MIGRATION_DIR"`
+	AutoMigrate        bool   `env:"AUTO_MIGRATE" envDefault:"true"`
 	DbAddr             string `env:"DB_HOST"`
+	MaxPins            int    `env:"MAX_PINS" envDefault:"3"`
+	AdminToken         string `env:"ADMIN_TOKEN"`
+	MaxQueryComplexity int    `env:"MAX_QUERY_COMPLEXITY" envDefault:"5000"`
+	MaxQueryDepth      int    `env:"MAX_QUERY_DEPTH" envDefault:"10"`
+	APQCacheSize       int    `env:"APQ_CACHE_SIZE" envDefault:"1000"`
+	QueryManifest      string `env:"QUERY_MANIFEST"`
+	Introspection      bool   `env:"INTROSPECTION" envDefault:"true"`
+
+	CacheSize int           `env:"CACHE_SIZE" envDefault:"10000"`
+	CacheTTL  time.Duration `env:"CACHE_TTL" envDefault:"30s"`
+
+	ShutdownDelay   time.Duration `env:"SHUTDOWN_DELAY" envDefault:"2s"`
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"10s"`
+
+	TLSCertFile       string        `env:"TLS_CERT_FILE"`
+	TLSKeyFile        string        `env:"TLS_KEY_FILE"`
+	TLSReloadInterval time.Duration `env:"TLS_RELOAD_INTERVAL" envDefault:"1m"`
+	TLSClientCAFile   string        `env:"TLS_CLIENT_CA_FILE"`
+	AdminClientNames  []string      `env:"ADMIN_CLIENT_NAMES"`
+	HTTP2             bool          `env:"HTTP2" envDefault:"true"`
+	ReadHeaderTimeout time.Duration `env:"HTTP_READ_HEADER_TIMEOUT" envDefault:"5s"`
+	ReadTimeout       time.Duration `env:"HTTP_READ_TIMEOUT" envDefault:"30s"`
+	WriteTimeout      time.Duration `env:"HTTP_WRITE_TIMEOUT" envDefault:"30s"`
+	IdleTimeout       time.Duration `env:"HTTP_IDLE_TIMEOUT" envDefault:"2m"`
+	WebsocketOrigins  []string      `env:"WEBSOCKET_ORIGINS"`
+
+	CORSAllowedOrigins   []string      `env:"CORS_ALLOWED_ORIGINS"`
+	CORSAllowedHeaders   []string      `env:"CORS_ALLOWED_HEADERS" envDefault:"Content-Type,Authorization,X-Request-ID,X-Requested-With,Apollo-Require-Preflight,traceparent"`
+	CORSAllowCredentials bool          `env:"CORS_ALLOW_CREDENTIALS" envDefault:"false"`
+	CORSMaxAge           time.Duration `env:"CORS_MAX_AGE" envDefault:"10m"`
+	CSRFProtection       bool          `env:"CSRF_PROTECTION" envDefault:"true"`
+
+	TracingExporter    string  `env:"TRACING_EXPORTER" envDefault:"none"`
+	TracingEndpoint    string  `env:"TRACING_ENDPOINT"`
+	TracingFile        string  `env:"TRACING_FILE" envDefault:"traces.jsonl"`
+	TracingSampleRatio float64 `env:"TRACING_SAMPLE_RATIO" envDefault:"1"`
+
+	WebhookMaxAttempts  int           `env:"WEBHOOK_MAX_ATTEMPTS" envDefault:"8"`
+	WebhookBaseBackoff  time.Duration `env:"WEBHOOK_BASE_BACKOFF" envDefault:"10s"`
+	WebhookMaxBackoff   time.Duration `env:"WEBHOOK_MAX_BACKOFF" envDefault:"1h"`
+	WebhookPollInterval time.Duration `env:"WEBHOOK_POLL_INTERVAL" envDefault:"5s"`
+	WebhookTimeout      time.Duration `env:"WEBHOOK_TIMEOUT" envDefault:"10s"`
+	WebhookRetention    time.Duration `env:"WEBHOOK_RETENTION" envDefault:"168h"`
+
+	// schemaVersion is the last migration known to the binary, it is set when the schema is prepared.
+	schemaVersion uint
 }
 
 func initConfig() (*config, error) {
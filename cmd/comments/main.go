@@ -1,25 +1,66 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"flag"
 	"fmt"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/mi-raf/comment-project/graph"
 	"github.com/mi-raf/comment-project/internal/api"
 	"github.com/mi-raf/comment-project/internal/database"
+	"github.com/mi-raf/comment-project/internal/grpcapi"
 	"github.com/mi-raf/comment-project/internal/models"
 	"github.com/mi-raf/comment-project/internal/service"
+	"github.com/mi-raf/comment-project/internal/tracing"
+	"github.com/mi-raf/comment-project/internal/webhook"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/xlab/closer"
-
-	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 )
 
+type app struct {
+	api  *api.API
+	grpc *grpcapi.Server
+	// shutdown limits the whole shutdown: the delay and waiting for requests of both servers
+	shutdown time.Duration
+}
+
+func newApp(cfg *config, a *api.API, g *grpcapi.Server) *app {
+	return &app{api: a, grpc: g, shutdown: cfg.ShutdownDelay + cmp.Or(cfg.ShutdownTimeout, api.SHUTDOWN_TIMEOUT)}
+}
+
+// Close stops both servers by one deadline, which starts before SHUTDOWN_DELAY. After the delay
+// the servers wait for their requests at the same time, the HTTP server completes subscriptions, so gRPC streams end.
+func (a *app) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), a.shutdown)
+	defer cancel()
+	a.api.Drain(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.grpc.Shutdown(ctx)
+	}()
+	a.api.Shutdown(ctx)
+	wg.Wait()
+}
+
+// parseArgs applies command line flags to the config and returns the command with its arguments.
+func parseArgs(cfg *config, args []string) []string {
+	fs := flag.NewFlagSet("comments", flag.ExitOnError)
+	noAutoMigrate := fs.Bool("no-auto-migrate", false, "do not migrate the database on start, only check its schema version")
+	fs.Parse(args)
+	if *noAutoMigrate {
+		cfg.AutoMigrate = false
+	}
+	return fs.Args()
+}
+
 func main() {
 
 	defer closer.Close()
@@ -32,29 +73,53 @@ func main() {
 	if err != nil {
 		log.Fatal().Err(err).Msg("Can't init config")
 	}
+	args := parseArgs(cfg, os.Args[1:])
 
 	if err := initLogger(cfg); err != nil {
 		log.Fatal().Err(err).Msg("Can't init logger")
 	}
 
-	mf, err := migrateData(cfg)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Can't init migration")
-	}
-	closer.Bind(mf)
-
 	ctx, cancelCtx := context.WithCancel(context.Background())
 	closer.Bind(cancelCtx)
 
+	if len(args) == 0 || !noSchemaCommands[args[0]] {
+		if err := prepareSchema(cfg); err != nil {
+			log.Fatal().Err(err).Msg("Can't prepare database schema")
+		}
+	}
+
+	if len(args) > 0 {
+		if err := runCommand(ctx, cfg, args[0], args[1:]); err != nil {
+			log.Fatal().Err(err).Str("command", args[0]).Msg("Command failed")
+		}
+		return
+	}
+
+	stopTracing, err := tracing.Start(ctx, initTracingConfig(cfg))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Can't init tracing")
+	}
+	// spans of the shutdown are exported as well
+	closer.Bind(stopTracing)
+
 	a, cleanup, err := initApp(ctx, cfg)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Can't init app")
 	}
+	// binds run in reverse order: the servers are stopped,
+	// then the cleanup stops the listener and the outbox relay before pools are closed
 	closer.Bind(cleanup)
 	closer.Bind(a.Close)
-	if err := a.Start(); err != nil {
+	go func() {
+		if err := a.grpc.Start(); err != nil {
+			log.Fatal().Err(err).Msg("Can't start grpc server")
+		}
+	}()
+	if err := a.api.Start(); err != nil {
 		log.Fatal().Err(err).Msg("Can't start app")
 	}
+	// the server is stopped by closer, which exits when the shutdown is done
+	closer.Hold()
 
 }
 
@@ -67,7 +132,8 @@ func initLogger(c *config) error {
 	zerolog.SetGlobalLevel(logLvl)
 	switch c.LogFmt {
 	case "console":
-		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout})
+		// stdout is left for command output, e.g. export
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	case "json":
 	default:
 		return fmt.Errorf("unknown output format %s", c.LogFmt)
@@ -76,46 +142,71 @@ func initLogger(c *config) error {
 	return nil
 }
 
-func migrateData(cfg *config) (func(), error) {
-	log.Debug().Msg("start migrating data")
-	m, err := migrate.New(
-		cfg.MigrationDirectory,
-		cfg.DbAddr)
-	if err != nil {
-		return nil, err
+func initApiConfig(cfg *config, res *api.Resolver, ps *service.PostService) (*api.Config, error) {
+	var allowlist map[string]string
+	if cfg.QueryManifest != "" {
+		var err error
+		allowlist, err = api.LoadAllowlist(cfg.QueryManifest)
+		if err != nil {
+			return nil, err
+		}
+		log.Info().Int("operations", len(allowlist)).Str("manifest", cfg.QueryManifest).Msg("only operations of the manifest are allowed")
 	}
-
-	err = m.Up()
-	if err != nil && err != migrate.ErrNoChange {
-		log.Error().Err(err).Msg("can not migrate data")
-		return func() {
-			if err, _ := m.Close(); err != nil {
-				log.Error().Err(err).Msg("can not graceful stop migration")
-			}
-		}, err
+	cors := api.CORSConfig{
+		AllowedOrigins:   cfg.CORSAllowedOrigins,
+		AllowedHeaders:   cfg.CORSAllowedHeaders,
+		AllowCredentials: cfg.CORSAllowCredentials,
+		MaxAge:           cfg.CORSMaxAge,
 	}
-
-	v, _, err := m.Version()
-	if err != nil {
-		log.Error().Err(err).Msg("can not get migration version")
-		return func() {
-			if err, _ := m.Close(); err != nil {
-				log.Error().Err(err).Msg("can not graceful stop migration")
-			}
-		}, err
-
+	if err := cors.Validate(); err != nil {
+		return nil, err
 	}
-	log.Info().Uint("version", v).Msg("migration succesful")
-
-	return func() {
-		if err, _ := m.Close(); err != nil {
-			log.Error().Err(err).Msg("can not graceful stop migration")
-		}
+	return &api.Config{
+		Listen: cfg.Listen,
+		GraphCfg: graph.Config{
+			Resolvers:  res,
+			Directives: graph.DirectiveRoot{Admin: api.AdminDirective},
+			Complexity: api.Complexity(),
+		},
+		Service:         ps,
+		AdminToken:      cfg.AdminToken,
+		MaxComplexity:   cfg.MaxQueryComplexity,
+		MaxDepth:        cfg.MaxQueryDepth,
+		APQCacheSize:    cfg.APQCacheSize,
+		Allowlist:       allowlist,
+		Introspection:   cfg.Introspection,
+		Metrics:         api.NewMetrics(ps),
+		ShutdownDelay:   cfg.ShutdownDelay,
+		ShutdownTimeout: cfg.ShutdownTimeout,
+		TLS: api.TLSConfig{
+			CertFile:         cfg.TLSCertFile,
+			KeyFile:          cfg.TLSKeyFile,
+			ReloadInterval:   cfg.TLSReloadInterval,
+			ClientCAFile:     cfg.TLSClientCAFile,
+			AdminClientNames: cfg.AdminClientNames,
+		},
+		HTTP2:             cfg.HTTP2,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		WebsocketOrigins:  cfg.WebsocketOrigins,
+		CORS:              cors,
+		CSRFProtection:    cfg.CSRFProtection,
 	}, nil
 }
 
-func initApiConfig(cfg *config, res *api.Resolver) *api.Config {
-	return &api.Config{Listen: cfg.Listen, GraphCfg: graph.Config{Resolvers: res}}
+func initTracingConfig(cfg *config) *tracing.Config {
+	return &tracing.Config{
+		Exporter:    cfg.TracingExporter,
+		Endpoint:    cfg.TracingEndpoint,
+		File:        cfg.TracingFile,
+		SampleRatio: cfg.TracingSampleRatio,
+	}
+}
+
+func initGrpcConfig(cfg *config) *grpcapi.Config {
+	return &grpcapi.Config{Listen: cfg.GrpcListen, ShutdownTimeout: cfg.ShutdownTimeout, AdminToken: cfg.AdminToken}
 }
 
 func initPostRepositoryConfig(cfg *config) *database.PostConfig {
@@ -126,6 +217,33 @@ func initCommentRepositoryConfig(cfg *config, ch chan models.CommentDTO) *databa
 	return &database.CommentConfig{InMemory: cfg.InMemory, DbAddr: cfg.DbAddr, CommentChan: ch}
 }
 
-func initServiceConfig(ch chan models.CommentDTO) *service.Config {
-	return &service.Config{CommentChan: ch}
+func initServiceConfig(cfg *config, ch chan models.CommentDTO, d *webhook.Dispatcher) *service.Config {
+	return &service.Config{
+		CommentChan: ch,
+		MaxPins:     cfg.MaxPins,
+		Events:      d,
+		Cache:       service.CacheConfig{Size: cfg.CacheSize, TTL: cfg.CacheTTL},
+		// readiness fails if the schema is changed by other instances
+		SchemaVersion: cfg.schemaVersion,
+	}
+}
+
+func initTransferRepositoryConfig(cfg *config) *database.TransferConfig {
+	return &database.TransferConfig{InMemory: cfg.InMemory, DbAddr: cfg.DbAddr}
+}
+
+func initWebhookRepositoryConfig(cfg *config) *database.WebhookConfig {
+	return &database.WebhookConfig{InMemory: cfg.InMemory, DbAddr: cfg.DbAddr}
+}
+
+func initWebhookConfig(cfg *config) *webhook.Config {
+	return &webhook.Config{
+		MaxAttempts:  cfg.WebhookMaxAttempts,
+		BaseBackoff:  cfg.WebhookBaseBackoff,
+		MaxBackoff:   cfg.WebhookMaxBackoff,
+		PollInterval: cfg.WebhookPollInterval,
+		Timeout:      cfg.WebhookTimeout,
+		BatchSize:    webhook.MAX_LIMIT,
+		Retention:    cfg.WebhookRetention,
+	}
 }
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+
+	"github.com/mi-raf/comment-project/init/migrations"
+	"github.com/rs/zerolog/log"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+var ErrSchemaVersion = errors.New("database schema is older than the binary")
+
+// newMigrate opens migrations from MIGRATION_DIR if it is set, embedded ones otherwise.
+// It also returns the last migration version known to the binary.
+func newMigrate(cfg *config) (*migrate.Migrate, uint, error) {
+	if cfg.InMemory {
+		return nil, 0, errors.New("migrations are not used with in memory storage")
+	}
+	var src source.Driver
+	var err error
+	if cfg.MigrationDirectory != "" {
+		src, err = source.Open(cfg.MigrationDirectory)
+	} else {
+		src, err = iofs.New(migrations.FS, ".")
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	latest, err := latestVersion(src)
+	if err != nil {
+		src.Close()
+		return nil, 0, err
+	}
+	m, err := migrate.NewWithSourceInstance("migrations", src, cfg.DbAddr)
+	if err != nil {
+		src.Close()
+		return nil, 0, err
+	}
+	return m, latest, nil
+}
+
+func latestVersion(src source.Driver) (uint, error) {
+	v, err := src.First()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := src.Next(v)
+		if errors.Is(err, fs.ErrNotExist) {
+			return v, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		v = next
+	}
+}
+
+func closeMigrate(m *migrate.Migrate) {
+	if srcErr, dbErr := m.Close(); srcErr != nil || dbErr != nil {
+		log.Error().AnErr("source", srcErr).AnErr("database", dbErr).Msg("can not graceful stop migration")
+	}
+}
+
+// prepareSchema migrates the database up unless auto migration is disabled
+// and checks that the schema version is not older than the one expected by the binary.
+// A newer schema is accepted as the readiness check does, so the previous version keeps
+// working while the next one migrates the database during a rolling update.
+func prepareSchema(cfg *config) error {
+	if cfg.InMemory {
+		return nil
+	}
+	log.Debug().Bool("auto migrate", cfg.AutoMigrate).Msg("prepare database schema")
+	m, latest, err := newMigrate(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeMigrate(m)
+
+	v, dirty, err := schemaVersion(m)
+	if err != nil {
+		return err
+	}
+	if cfg.AutoMigrate && (v < latest || dirty) {
+		if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+			log.Error().Err(err).Msg("can not migrate data")
+			return err
+		}
+		if v, dirty, err = schemaVersion(m); err != nil {
+			return err
+		}
+	}
+	if v < latest || dirty {
+		return fmt.Errorf("%w: version %d (dirty %t), expected at least %d, run `migrate up` or `migrate force`", ErrSchemaVersion, v, dirty, latest)
+	}
+	if v > latest {
+		log.Warn().Uint("version", v).Uint("expected", latest).Msg("database schema is newer than the binary")
+	} else {
+		log.Info().Uint("version", v).Msg("database schema is up to date")
+	}
+	cfg.schemaVersion = latest
+	return nil
+}
+
+func schemaVersion(m *migrate.Migrate) (uint, bool, error) {
+	v, dirty, err := m.Version()
+	if err != nil && err != migrate.ErrNilVersion {
+		log.Error().Err(err).Msg("can not get migration version")
+		return 0, false, err
+	}
+	return v, dirty, nil
+}
+
+// migrateCommand changes the database schema: up, down [N], to N, version, force N.
+func migrateCommand(ctx context.Context, cfg *config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down [N]|to N|version|force N")
+	}
+	m, latest, err := newMigrate(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeMigrate(m)
+
+	switch args[0] {
+	case "up":
+		err = m.Up()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("incorrect number of steps %q", args[1])
+			}
+		}
+		err = m.Steps(-steps)
+	case "to":
+		if len(args) < 2 {
+			return errors.New("usage: migrate to N")
+		}
+		v, perr := strconv.ParseUint(args[1], 10, 64)
+		if perr != nil {
+			return fmt.Errorf("incorrect version %q", args[1])
+		}
+		err = m.Migrate(uint(v))
+	case "force":
+		if len(args) < 2 {
+			return errors.New("usage: migrate force N")
+		}
+		v, perr := strconv.Atoi(args[1])
+		if perr != nil {
+			return fmt.Errorf("incorrect version %q", args[1])
+		}
+		err = m.Force(v)
+	case "version":
+	default:
+		return fmt.Errorf("unknown migrate command %s", args[0])
+	}
+	if err != nil && err != migrate.ErrNoChange {
+		return err
+	}
+
+	v, dirty, err := m.Version()
+	if err != nil && err != migrate.ErrNilVersion {
+		return err
+	}
+	fmt.Printf("version %d, dirty %t, latest %d\n", v, dirty, latest)
+	return nil
+}
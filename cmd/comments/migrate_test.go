@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/stub"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/mi-raf/comment-project/init/migrations"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	// stubDb is a database of the stub driver, every connection starts without applied migrations.
+	stubDb = "stub://"
+	// newerStubDb is a database of the stub driver with a schema newer than any migration of the binary.
+	newerStubDb  = "newerstub://"
+	newerVersion = 1000
+)
+
+type newerStub struct {
+	stub.Stub
+}
+
+func (d *newerStub) Open(url string) (database.Driver, error) {
+	db, err := d.Stub.Open(url)
+	if err != nil {
+		return nil, err
+	}
+	db.(*stub.Stub).CurrentVersion = newerVersion
+	return db, nil
+}
+
+func init() {
+	database.Register("newerstub", &newerStub{})
+}
+
+type MigrateTestSuite struct {
+	suite.Suite
+}
+
+func (s *MigrateTestSuite) TestLatestVersionEmbedded() {
+	// given
+	src, err := iofs.New(migrations.FS, ".")
+	s.Require().NoError(err)
+	defer src.Close()
+	ups, err := fs.Glob(migrations.FS, "*.up.sql")
+	s.Require().NoError(err)
+
+	// when
+	v, err := latestVersion(src)
+
+	// then
+	s.NoError(err)
+	s.Equal(uint(len(ups)), v)
+}
+
+func (s *MigrateTestSuite) TestLatestVersionWithGaps() {
+	// given
+	dir := s.T().TempDir()
+	for _, name := range []string{"1_a.up.sql", "1_a.down.sql", "3_b.up.sql", "10_c.up.sql"} {
+		s.Require().NoError(os.WriteFile(filepath.Join(dir, name), []byte("SELECT 1;"), 0o600))
+	}
+	src, err := source.Open("file://" + dir)
+	s.Require().NoError(err)
+	defer src.Close()
+
+	// when
+	v, err := latestVersion(src)
+
+	// then
+	s.NoError(err)
+	s.Equal(uint(10), v)
+}
+
+func (s *MigrateTestSuite) TestPrepareSchemaMigrates() {
+	// given
+	cfg := &config{DbAddr: stubDb, AutoMigrate: true}
+
+	// when
+	err := prepareSchema(cfg)
+
+	// then
+	s.NoError(err)
+	s.Equal(s.latest(), cfg.schemaVersion)
+}
+
+func (s *MigrateTestSuite) TestPrepareSchemaVersionMismatch() {
+	// given
+	cfg := &config{DbAddr: stubDb, AutoMigrate: true}
+	args := parseArgs(cfg, []string{"--no-auto-migrate"})
+
+	// when
+	err := prepareSchema(cfg)
+
+	// then
+	s.Empty(args)
+	s.False(cfg.AutoMigrate)
+	s.ErrorIs(err, ErrSchemaVersion)
+	s.Zero(cfg.schemaVersion)
+}
+
+func (s *MigrateTestSuite) TestPrepareSchemaNewer() {
+	for _, autoMigrate := range []bool{true, false} {
+		s.Run(strconv.FormatBool(autoMigrate), func() {
+			// given
+			cfg := &config{DbAddr: newerStubDb, AutoMigrate: autoMigrate}
+
+			// when
+			err := prepareSchema(cfg)
+
+			// then
+			s.NoError(err)
+			s.Equal(s.latest(), cfg.schemaVersion)
+		})
+	}
+}
+
+func (s *MigrateTestSuite) TestPrepareSchemaInMemory() {
+	// given
+	cfg := &config{InMemory: true, AutoMigrate: true}
+
+	// when
+	err := prepareSchema(cfg)
+
+	// then
+	s.NoError(err)
+	s.Zero(cfg.schemaVersion)
+}
+
+func (s *MigrateTestSuite) TestParseArgs() {
+	for _, tc := range []struct {
+		name        string
+		args        []string
+		autoMigrate bool
+		rest        []string
+	}{
+		{name: "server", args: nil, autoMigrate: true, rest: []string{}},
+		{name: "no auto migrate", args: []string{"--no-auto-migrate"}, autoMigrate: false, rest: []string{}},
+		{name: "command", args: []string{"migrate", "down", "2"}, autoMigrate: true, rest: []string{"migrate", "down", "2"}},
+		{name: "command without auto migrate", args: []string{"-no-auto-migrate", "seed", "-posts", "10"}, autoMigrate: false, rest: []string{"seed", "-posts", "10"}},
+	} {
+		s.Run(tc.name, func() {
+			// given
+			cfg := &config{AutoMigrate: true}
+
+			// when
+			rest := append([]string{}, parseArgs(cfg, tc.args)...)
+
+			// then
+			s.Equal(tc.autoMigrate, cfg.AutoMigrate)
+			s.Equal(tc.rest, rest)
+		})
+	}
+}
+
+func (s *MigrateTestSuite) TestMigrateCommand() {
+	for _, tc := range []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{name: "up", args: []string{"up"}},
+		{name: "version", args: []string{"version"}},
+		{name: "to", args: []string{"to", "1"}},
+		{name: "force", args: []string{"force", "2"}},
+		{name: "no command", args: nil, err: "usage: migrate"},
+		{name: "unknown command", args: []string{"sideways"}, err: "unknown migrate command sideways"},
+		{name: "incorrect steps", args: []string{"down", "zero"}, err: `incorrect number of steps "zero"`},
+		{name: "negative steps", args: []string{"down", "-1"}, err: `incorrect number of steps "-1"`},
+		{name: "to without version", args: []string{"to"}, err: "usage: migrate to N"},
+		{name: "incorrect to version", args: []string{"to", "last"}, err: `incorrect version "last"`},
+		{name: "force without version", args: []string{"force"}, err: "usage: migrate force N"},
+		{name: "incorrect force version", args: []string{"force", "v2"}, err: `incorrect version "v2"`},
+	} {
+		s.Run(tc.name, func() {
+			// given
+			cfg := &config{DbAddr: stubDb}
+
+			// when
+			err := migrateCommand(context.Background(), cfg, tc.args)
+
+			// then
+			if tc.err == "" {
+				s.NoError(err)
+				return
+			}
+			s.Error(err)
+			s.True(strings.HasPrefix(err.Error(), tc.err), err.Error())
+		})
+	}
+}
+
+func (s *MigrateTestSuite) TestMigrateInMemory() {
+	// when
+	err := migrateCommand(context.Background(), &config{InMemory: true}, []string{"up"})
+
+	// then
+	s.Error(err)
+}
+
+func (s *MigrateTestSuite) latest() uint {
+	src, err := iofs.New(migrations.FS, ".")
+	s.Require().NoError(err)
+	defer src.Close()
+	v, err := latestVersion(src)
+	s.Require().NoError(err)
+	return v
+}
+
+func TestMigrateTestSuite(t *testing.T) {
+	suite.Run(t, new(MigrateTestSuite))
+}
@@ -9,14 +9,20 @@ import (
 	"github.com/google/wire"
 	"github.com/mi-raf/comment-project/internal/api"
 	"github.com/mi-raf/comment-project/internal/database"
+	"github.com/mi-raf/comment-project/internal/grpcapi"
 	"github.com/mi-raf/comment-project/internal/service"
+	"github.com/mi-raf/comment-project/internal/webhook"
 )
 
-func initApp(ctx context.Context, cfg *config) (a *api.API, closer func(), err error) {
+func initApp(ctx context.Context, cfg *config) (a *app, closer func(), err error) {
 	wire.Build(
 		service.NewCommentChan,
 		initPostRepositoryConfig,
 		initCommentRepositoryConfig,
+		initWebhookRepositoryConfig,
+		initWebhookConfig,
+		database.NewWebhookRepositoryProvider,
+		webhook.NewDispatcher,
 		initServiceConfig,
 		database.NewPostRepositoryProvider,
 		database.NewCommentRepositoryProvider,
@@ -24,6 +30,9 @@ func initApp(ctx context.Context, cfg *config) (a *api.API, closer func(), err e
 		api.NewResolver,
 		initApiConfig,
 		api.NewApi,
+		initGrpcConfig,
+		grpcapi.NewServer,
+		newApp,
 	)
 	return nil, nil, nil
 }
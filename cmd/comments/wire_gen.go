@@ -10,7 +10,9 @@ import (
 	"context"
 	"github.com/mi-raf/comment-project/internal/api"
 	"github.com/mi-raf/comment-project/internal/database"
+	"github.com/mi-raf/comment-project/internal/grpcapi"
 	"github.com/mi-raf/comment-project/internal/service"
+	"github.com/mi-raf/comment-project/internal/webhook"
 )
 
 import (
@@ -21,7 +23,7 @@ import (
 
 // Injectors from wire.go:
 
-func initApp(ctx context.Context, cfg *config) (*api.API, func(), error) {
+func initApp(ctx context.Context, cfg *config) (*app, func(), error) {
 	postConfig := initPostRepositoryConfig(cfg)
 	postRepository, cleanup, err := database.NewPostRepositoryProvider(ctx, postConfig)
 	if err != nil {
@@ -29,18 +31,42 @@ func initApp(ctx context.Context, cfg *config) (*api.API, func(), error) {
 	}
 	v, cleanup2 := service.NewCommentChan()
 	commentConfig := initCommentRepositoryConfig(cfg, v)
-	commentRepository, cleanup3, err := database.NewCommentRepositoryProvider(ctx, commentConfig)
+	commentRepository, cleanup3, err := database.NewCommentRepositoryProvider(ctx, commentConfig, postRepository)
 	if err != nil {
 		cleanup2()
 		cleanup()
 		return nil, nil, err
 	}
-	serviceConfig := initServiceConfig(v)
-	postService, cleanup4 := service.NewPostService(ctx, postRepository, commentRepository, serviceConfig)
-	resolver := api.NewResolver(postService)
-	apiConfig := initApiConfig(cfg, resolver)
-	apiAPI := api.NewApi(ctx, apiConfig)
-	return apiAPI, func() {
+	webhookConfig := initWebhookRepositoryConfig(cfg)
+	webhookRepository, cleanup4, err := database.NewWebhookRepositoryProvider(ctx, webhookConfig)
+	if err != nil {
+		cleanup3()
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	config2 := initWebhookConfig(cfg)
+	dispatcher, cleanup5 := webhook.NewDispatcher(ctx, webhookRepository, config2)
+	serviceConfig := initServiceConfig(cfg, v, dispatcher)
+	postService, cleanup6 := service.NewPostService(ctx, postRepository, commentRepository, serviceConfig)
+	resolver := api.NewResolver(postService, dispatcher)
+	apiConfig, err := initApiConfig(cfg, resolver, postService)
+	if err != nil {
+		cleanup6()
+		cleanup5()
+		cleanup4()
+		cleanup3()
+		cleanup2()
+		cleanup()
+		return nil, nil, err
+	}
+	apiAPI := api.NewApi(apiConfig)
+	grpcapiConfig := initGrpcConfig(cfg)
+	server := grpcapi.NewServer(postService, grpcapiConfig)
+	mainApp := newApp(cfg, apiAPI, server)
+	return mainApp, func() {
+		cleanup6()
+		cleanup5()
 		cleanup4()
 		cleanup3()
 		cleanup2()
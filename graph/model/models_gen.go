@@ -3,9 +3,25 @@
 package model
 
 import (
+	"fmt"
+	"io"
+	"strconv"
 	"time"
 )
 
+type Author struct {
+	Name     string          `json:"name"`
+	Stats    *AuthorStats    `json:"stats"`
+	Posts    []*ShortPost    `json:"posts,omitempty"`
+	Comments *CommentsResult `json:"comments"`
+}
+
+type AuthorStats struct {
+	PostCount    int        `json:"postCount"`
+	CommentCount int        `json:"commentCount"`
+	FirstSeen    *time.Time `json:"firstSeen,omitempty"`
+}
+
 type Comment struct {
 	Author string    `json:"author"`
 	Text   string    `json:"text"`
@@ -13,11 +29,15 @@ type Comment struct {
 }
 
 type CommentConnection struct {
-	ID       string   `json:"id"`
-	ParentID *string  `json:"parentId,omitempty"`
-	Level    int      `json:"level"`
-	Comment  *Comment `json:"comment"`
-	PostID   string   `json:"postId"`
+	ID            string             `json:"id"`
+	ParentID      *string            `json:"parentId,omitempty"`
+	Level         int                `json:"level"`
+	Comment       *Comment           `json:"comment"`
+	PostID        string             `json:"postId"`
+	Pinned        bool               `json:"pinned"`
+	AuthorReplied bool               `json:"authorReplied"`
+	Post          *ShortPost         `json:"post,omitempty"`
+	Parent        *CommentConnection `json:"parent,omitempty"`
 }
 
 type CommentsResult struct {
@@ -42,6 +62,12 @@ type NewPost struct {
 	IsCommentable *bool     `json:"isCommentable,omitempty"`
 }
 
+type NewWebhook struct {
+	URL    string         `json:"url"`
+	Secret string         `json:"secret"`
+	Events []WebhookEvent `json:"events"`
+}
+
 type PageInfo struct {
 	EndCursor *string `json:"endCursor,omitempty"`
 }
@@ -53,6 +79,8 @@ type Post struct {
 	Text          string          `json:"text"`
 	Time          time.Time       `json:"time"`
 	IsCommentable bool            `json:"isCommentable"`
+	CommentCount  int             `json:"commentCount"`
+	LastCommentAt *time.Time      `json:"lastCommentAt,omitempty"`
 	Comments      *CommentsResult `json:"comments,omitempty"`
 }
 
@@ -60,10 +88,161 @@ type Query struct {
 }
 
 type ShortPost struct {
-	ID     string `json:"id"`
-	Author string `json:"author"`
-	Title  string `json:"title"`
+	ID            string          `json:"id"`
+	Author        string          `json:"author"`
+	Title         string          `json:"title"`
+	Time          time.Time       `json:"time"`
+	CommentCount  int             `json:"commentCount"`
+	LastCommentAt *time.Time      `json:"lastCommentAt,omitempty"`
+	Comments      *CommentsResult `json:"comments,omitempty"`
 }
 
 type Subscription struct {
 }
+
+type Webhook struct {
+	ID     string         `json:"id"`
+	URL    string         `json:"url"`
+	Events []WebhookEvent `json:"events"`
+	Active bool           `json:"active"`
+	Time   time.Time      `json:"time"`
+}
+
+type WebhookDelivery struct {
+	ID            string                `json:"id"`
+	WebhookID     string                `json:"webhookId"`
+	Event         WebhookEvent          `json:"event"`
+	Status        WebhookDeliveryStatus `json:"status"`
+	Attempts      int                   `json:"attempts"`
+	NextAttemptAt time.Time             `json:"nextAttemptAt"`
+	LastError     *string               `json:"lastError,omitempty"`
+	ResponseCode  *int                  `json:"responseCode,omitempty"`
+	Payload       string                `json:"payload"`
+	Time          time.Time             `json:"time"`
+	DeliveredAt   *time.Time            `json:"deliveredAt,omitempty"`
+}
+
+type PostOrder string
+
+const (
+	PostOrderTime         PostOrder = "TIME"
+	PostOrderLastActivity PostOrder = "LAST_ACTIVITY"
+)
+
+var AllPostOrder = []PostOrder{
+	PostOrderTime,
+	PostOrderLastActivity,
+}
+
+func (e PostOrder) IsValid() bool {
+	switch e {
+	case PostOrderTime, PostOrderLastActivity:
+		return true
+	}
+	return false
+}
+
+func (e PostOrder) String() string {
+	return string(e)
+}
+
+func (e *PostOrder) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = PostOrder(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid PostOrder", str)
+	}
+	return nil
+}
+
+func (e PostOrder) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryStatusPending   WebhookDeliveryStatus = "PENDING"
+	WebhookDeliveryStatusDelivered WebhookDeliveryStatus = "DELIVERED"
+	WebhookDeliveryStatusDead      WebhookDeliveryStatus = "DEAD"
+)
+
+var AllWebhookDeliveryStatus = []WebhookDeliveryStatus{
+	WebhookDeliveryStatusPending,
+	WebhookDeliveryStatusDelivered,
+	WebhookDeliveryStatusDead,
+}
+
+func (e WebhookDeliveryStatus) IsValid() bool {
+	switch e {
+	case WebhookDeliveryStatusPending, WebhookDeliveryStatusDelivered, WebhookDeliveryStatusDead:
+		return true
+	}
+	return false
+}
+
+func (e WebhookDeliveryStatus) String() string {
+	return string(e)
+}
+
+func (e *WebhookDeliveryStatus) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = WebhookDeliveryStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid WebhookDeliveryStatus", str)
+	}
+	return nil
+}
+
+func (e WebhookDeliveryStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type WebhookEvent string
+
+const (
+	WebhookEventPostCreated    WebhookEvent = "POST_CREATED"
+	WebhookEventCommentCreated WebhookEvent = "COMMENT_CREATED"
+)
+
+var AllWebhookEvent = []WebhookEvent{
+	WebhookEventPostCreated,
+	WebhookEventCommentCreated,
+}
+
+func (e WebhookEvent) IsValid() bool {
+	switch e {
+	case WebhookEventPostCreated, WebhookEventCommentCreated:
+		return true
+	}
+	return false
+}
+
+func (e WebhookEvent) String() string {
+	return string(e)
+}
+
+func (e *WebhookEvent) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = WebhookEvent(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid WebhookEvent", str)
+	}
+	return nil
+}
+
+func (e WebhookEvent) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS Pin (
+    id_c bigint PRIMARY KEY REFERENCES Comment(id_c),
+    id_p bigint NOT NULL REFERENCES Post(id_p),
+    time_pin TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS pin_post_idx ON Pin (id_p);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS post_last_activity_idx;
+ALTER TABLE Post DROP COLUMN IF EXISTS last_comment_at;
+ALTER TABLE Post DROP COLUMN IF EXISTS comment_count;
@@ -0,0 +1,9 @@
+ALTER TABLE Post ADD COLUMN IF NOT EXISTS comment_count bigint NOT NULL DEFAULT 0;
+ALTER TABLE Post ADD COLUMN IF NOT EXISTS last_comment_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE Post p
+SET comment_count = c.cnt, last_comment_at = c.last
+FROM (SELECT id_p, count(*) AS cnt, max(time_c) AS last FROM Comment GROUP BY id_p) c
+WHERE c.id_p = p.id_p;
+
+CREATE INDEX IF NOT EXISTS post_last_activity_idx ON Post ((COALESCE(last_comment_at, time_p)) DESC, id_p DESC);
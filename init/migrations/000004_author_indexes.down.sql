@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS comment_author_idx;
+DROP INDEX IF EXISTS post_author_idx;
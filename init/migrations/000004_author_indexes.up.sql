@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS post_author_idx ON Post (author, time_p DESC, id_p DESC);
+CREATE INDEX IF NOT EXISTS comment_author_idx ON Comment (author, time_c DESC, id_c DESC);
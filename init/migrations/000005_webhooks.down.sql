@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS WebhookDelivery;
+DROP TABLE IF EXISTS Webhook;
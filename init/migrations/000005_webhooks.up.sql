@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS Webhook (
+    id_w bigserial PRIMARY KEY,
+    url text NOT NULL CONSTRAINT non_empty_url CHECK(length(url)>0),
+    secret text NOT NULL,
+    events text[] NOT NULL,
+    active boolean NOT NULL DEFAULT true,
+    time_w TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS WebhookDelivery (
+    id_d bigserial PRIMARY KEY,
+    id_w bigint NOT NULL REFERENCES Webhook(id_w) ON DELETE CASCADE,
+    event text NOT NULL,
+    payload jsonb NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    attempts int NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    last_error text,
+    response_code int,
+    time_d TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    delivered_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS webhook_delivery_due_idx ON WebhookDelivery (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_delivery_hook_idx ON WebhookDelivery (id_w, id_d DESC);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS Outbox (
+    id_o bigserial PRIMARY KEY,
+    topic text NOT NULL,
+    payload jsonb NOT NULL,
+    time_o TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    published_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON Outbox (id_o) WHERE published_at IS NULL;
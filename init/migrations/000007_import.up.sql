@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS Import (
+    source text NOT NULL,
+    old_id bigint NOT NULL,
+    new_id bigint NOT NULL REFERENCES Post(id_p) ON DELETE CASCADE,
+    time_i TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    PRIMARY KEY (source, old_id)
+);
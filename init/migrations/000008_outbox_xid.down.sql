@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS outbox_unpublished_idx;
+ALTER TABLE Outbox DROP COLUMN IF EXISTS xid;
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON Outbox (id_o) WHERE published_at IS NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE Outbox ADD COLUMN IF NOT EXISTS xid xid8 NOT NULL DEFAULT pg_current_xact_id();
+
+DROP INDEX IF EXISTS outbox_unpublished_idx;
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON Outbox (xid, id_o) WHERE published_at IS NULL;
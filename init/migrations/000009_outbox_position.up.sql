@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS outbox_published_idx ON Outbox (topic, xid, id_o) WHERE published_at IS NOT NULL;
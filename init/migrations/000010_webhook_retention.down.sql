@@ -0,0 +1 @@
+DROP INDEX IF EXISTS webhook_delivery_finished_idx;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS webhook_delivery_finished_idx ON WebhookDelivery (time_d) WHERE status <> 'pending';
@@ -0,0 +1,7 @@
+// Package migrations holds SQL migrations of the database schema, so the binary does not need them on disk.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
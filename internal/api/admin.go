@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql"
+)
+
+var ErrNotAdmin = errors.New("admin token required")
+
+type adminKey struct{}
+
+// adminMiddleware marks requests with a valid "Authorization: Bearer <token>" header as admin ones.
+// Admin operations are disabled when token is empty.
+// With verification of client certificates a verified certificate with an allowed name is required,
+// and if the token is empty the certificate is enough.
+func adminMiddleware(token string, certs *TLSConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		admin := ok && token != "" && subtle.ConstantTimeCompare([]byte(auth), []byte(token)) == 1
+		if certs.ClientCAFile != "" {
+			admin = (admin || token == "") && adminCertificate(r, certs.AdminClientNames)
+		}
+		if admin {
+			r = r.WithContext(context.WithValue(r.Context(), adminKey{}, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminCertificate reports whether the client certificate of the request is verified and has one of the names.
+func adminCertificate(r *http.Request, names []string) bool {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return false
+	}
+	if len(names) == 0 {
+		return true
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	for _, n := range names {
+		if cert.Subject.CommonName == n || slices.Contains(cert.DNSNames, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// adminOnly answers 403 to requests which adminMiddleware has not marked as admin ones.
+func adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r.Context()) {
+			http.Error(w, ErrNotAdmin.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isAdmin(ctx context.Context) bool {
+	ok, _ := ctx.Value(adminKey{}).(bool)
+	return ok
+}
+
+// AdminDirective implements @admin schema directive.
+func AdminDirective(ctx context.Context, obj interface{}, next graphql.Resolver) (interface{}, error) {
+	if !isAdmin(ctx) {
+		return nil, ErrNotAdmin
+	}
+	return next(ctx)
+}
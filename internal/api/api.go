@@ -11,11 +11,59 @@ import (
 
 	"github.com/mi-raf/comment-project/graph"
 	"github.com/mi-raf/comment-project/graph/model"
+	"github.com/mi-raf/comment-project/internal/logging"
 	"github.com/rs/zerolog/log"
 )
 
 var ErrIdIncorrect = errors.New("incorrect tid format")
 
+// Posts is the resolver for the posts field.
+func (r *authorResolver) Posts(ctx context.Context, obj *model.Author, count *int, after *string) ([]*model.ShortPost, error) {
+	var c int
+	if count != nil {
+		c = *count
+	}
+
+	return r.ps.AuthorPosts(ctx, obj.Name, getFromNullableStr(after), c)
+}
+
+// Comments is the resolver for the comments field.
+func (r *authorResolver) Comments(ctx context.Context, obj *model.Author, count *int, after *string) (*model.CommentsResult, error) {
+	var c int
+	if count != nil {
+		c = *count
+	}
+
+	return r.ps.AuthorComments(ctx, obj.Name, c, getNullableCursor(after))
+}
+
+// Post is the resolver for the post field.
+func (r *commentConnectionResolver) Post(ctx context.Context, obj *model.CommentConnection) (*model.ShortPost, error) {
+	p, err := getFromStr(obj.PostID)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("can not parse id")
+		return nil, ErrIdIncorrect
+	}
+	post, err := loadersFor(ctx, r.ps).posts.Load(ctx, p)
+	if err != nil || post == nil {
+		return nil, err
+	}
+	return shortPost(post), nil
+}
+
+// Parent is the resolver for the parent field.
+func (r *commentConnectionResolver) Parent(ctx context.Context, obj *model.CommentConnection) (*model.CommentConnection, error) {
+	if obj.ParentID == nil {
+		return nil, nil
+	}
+	c, err := getFromStr(*obj.ParentID)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("can not parse id")
+		return nil, ErrIdIncorrect
+	}
+	return loadersFor(ctx, r.ps).comments.Load(ctx, c)
+}
+
 // CreatePost is the resolver for the createPost field.
 func (r *mutationResolver) CreatePost(ctx context.Context, input model.NewPost) (*model.Post, error) {
 	return r.ps.CreatePost(ctx, input)
@@ -25,7 +73,7 @@ func (r *mutationResolver) CreatePost(ctx context.Context, input model.NewPost)
 func (r *mutationResolver) CreateComment(ctx context.Context, postID string, parentComment *string, comment model.NewComment) (string, error) {
 	p, err := getFromStr(postID)
 	if err != nil {
-		log.Error().Err(err).Msg("can not parse id")
+		logging.Ctx(ctx).Error().Err(err).Msg("can not parse id")
 		return "", ErrIdIncorrect
 	}
 	id, err := r.ps.CreateComment(ctx, p, getFromNullableStr(parentComment), comment)
@@ -35,21 +83,76 @@ func (r *mutationResolver) CreateComment(ctx context.Context, postID string, par
 	return strconv.FormatInt(id, 10), nil
 }
 
+// PinComment is the resolver for the pinComment field.
+func (r *mutationResolver) PinComment(ctx context.Context, postID string, commentID string) (bool, error) {
+	p, err := getFromStr(postID)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("can not parse id")
+		return false, ErrIdIncorrect
+	}
+	c, err := getFromStr(commentID)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("can not parse id")
+		return false, ErrIdIncorrect
+	}
+	if err := r.ps.PinComment(ctx, p, c); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// UnpinComment is the resolver for the unpinComment field.
+func (r *mutationResolver) UnpinComment(ctx context.Context, postID string, commentID string) (bool, error) {
+	p, err := getFromStr(postID)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("can not parse id")
+		return false, ErrIdIncorrect
+	}
+	c, err := getFromStr(commentID)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("can not parse id")
+		return false, ErrIdIncorrect
+	}
+	if err := r.ps.UnpinComment(ctx, p, c); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RegisterWebhook is the resolver for the registerWebhook field.
+func (r *mutationResolver) RegisterWebhook(ctx context.Context, input model.NewWebhook) (*model.Webhook, error) {
+	return r.hooks.Register(ctx, input)
+}
+
+// DeleteWebhook is the resolver for the deleteWebhook field.
+func (r *mutationResolver) DeleteWebhook(ctx context.Context, id string) (bool, error) {
+	w, err := getFromStr(id)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("can not parse id")
+		return false, ErrIdIncorrect
+	}
+	return r.hooks.Delete(ctx, w)
+}
+
 // Posts is the resolver for the posts field.
-func (r *queryResolver) Posts(ctx context.Context, count *int, after *string) ([]*model.ShortPost, error) {
+func (r *queryResolver) Posts(ctx context.Context, count *int, after *string, orderBy *model.PostOrder) ([]*model.ShortPost, error) {
 	var c int
 	if count != nil {
 		c = *count
 	}
+	o := model.PostOrderTime
+	if orderBy != nil {
+		o = *orderBy
+	}
 
-	return r.ps.GetAllPosts(ctx, getFromNullableStr(after), c)
+	return r.ps.GetAllPosts(ctx, getFromNullableStr(after), c, o)
 }
 
 // Post is the resolver for the post field.
 func (r *queryResolver) Post(ctx context.Context, postID string, count *int) (*model.Post, error) {
 	p, err := getFromStr(postID)
 	if err != nil {
-		log.Error().Err(err).Msg("can not parse id")
+		logging.Ctx(ctx).Error().Err(err).Msg("can not parse id")
 		return nil, ErrIdIncorrect
 	}
 
@@ -57,35 +160,88 @@ func (r *queryResolver) Post(ctx context.Context, postID string, count *int) (*m
 	if count != nil {
 		c = *count
 	}
-	return r.ps.Post(ctx, p, c)
+	l := loadersFor(ctx, r.ps)
+	post, err := l.posts.Load(ctx, p)
+	if err != nil || post == nil {
+		return nil, err
+	}
+	comments, err := l.pages.Load(ctx, commentsPage{post: p, limit: c})
+	if err != nil {
+		return nil, err
+	}
+	// the loaded post is shared with other resolvers
+	res := *post
+	res.Comments = comments
+	return &res, nil
 }
 
 // Comments is the resolver for the comments field.
 func (r *queryResolver) Comments(ctx context.Context, postID string, count *int, after *string) (*model.CommentsResult, error) {
-	
+
 	p, err := getFromStr(postID)
 	if err != nil {
-		log.Error().Err(err).Msg("can not parse id")
+		logging.Ctx(ctx).Error().Err(err).Msg("can not parse id")
 		return nil, ErrIdIncorrect
 	}
-	
+
 	var c int
 	if count != nil {
 		c = *count
 	}
 
+	return r.ps.Comments(ctx, p, c, getNullableCursor(after))
+}
+
+// Author is the resolver for the author field.
+func (r *queryResolver) Author(ctx context.Context, name string) (*model.Author, error) {
+	return r.ps.Author(ctx, name)
+}
 
-	return r.ps.Comments(ctx, p, c, getFromNullableStr(after))
+// Webhooks is the resolver for the webhooks field.
+func (r *queryResolver) Webhooks(ctx context.Context) ([]*model.Webhook, error) {
+	return r.hooks.Webhooks(ctx)
+}
+
+// WebhookDeliveries is the resolver for the webhookDeliveries field.
+func (r *queryResolver) WebhookDeliveries(ctx context.Context, webhookID *string, status *model.WebhookDeliveryStatus, count *int, after *string) ([]*model.WebhookDelivery, error) {
+	var c int
+	if count != nil {
+		c = *count
+	}
+
+	return r.hooks.Deliveries(ctx, getFromNullableStr(webhookID), status, c, getFromNullableStr(after))
+}
+
+// Comments is the resolver for the comments field.
+func (r *shortPostResolver) Comments(ctx context.Context, obj *model.ShortPost, count *int) (*model.CommentsResult, error) {
+	p, err := getFromStr(obj.ID)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("can not parse id")
+		return nil, ErrIdIncorrect
+	}
+	var c int
+	if count != nil {
+		c = *count
+	}
+	return loadersFor(ctx, r.ps).pages.Load(ctx, commentsPage{post: p, limit: c})
 }
 
 // CommentSubscribe is the resolver for the commentSubscribe field.
 func (r *subscriptionResolver) CommentSubscribe(ctx context.Context, postID string) (<-chan *model.CommentConnection, error) {
 	p, err := getFromStr(postID)
 	if err != nil {
-		log.Error().Err(err).Msg("can not parse id")
+		logging.Ctx(ctx).Error().Err(err).Msg("can not parse id")
 		return nil, ErrIdIncorrect
 	}
-	return r.ps.CommentSubscribe(p), nil
+	return r.ps.CommentSubscribe(ctx, p), nil
+}
+
+// Author returns graph.AuthorResolver implementation.
+func (r *Resolver) Author() graph.AuthorResolver { return &authorResolver{r} }
+
+// CommentConnection returns graph.CommentConnectionResolver implementation.
+func (r *Resolver) CommentConnection() graph.CommentConnectionResolver {
+	return &commentConnectionResolver{r}
 }
 
 // Mutation returns graph.MutationResolver implementation.
@@ -94,17 +250,22 @@ func (r *Resolver) Mutation() graph.MutationResolver { return &mutationResolver{
 // Query returns graph.QueryResolver implementation.
 func (r *Resolver) Query() graph.QueryResolver { return &queryResolver{r} }
 
+// ShortPost returns graph.ShortPostResolver implementation.
+func (r *Resolver) ShortPost() graph.ShortPostResolver { return &shortPostResolver{r} }
+
 // Subscription returns graph.SubscriptionResolver implementation.
 func (r *Resolver) Subscription() graph.SubscriptionResolver { return &subscriptionResolver{r} }
 
+type authorResolver struct{ *Resolver }
+type commentConnectionResolver struct{ *Resolver }
 type mutationResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
+type shortPostResolver struct{ *Resolver }
 type subscriptionResolver struct{ *Resolver }
 
-
 func getFromNullableStr(data *string) int64 {
 	if data == nil {
-		return 0 
+		return 0
 	}
 	p, err := strconv.ParseInt(*data, 10, 64)
 	if err != nil {
@@ -114,6 +275,14 @@ func getFromNullableStr(data *string) int64 {
 	return p
 }
 
+// getNullableCursor returns the cursor or the empty cursor of the first page.
+func getNullableCursor(data *string) string {
+	if data == nil {
+		return ""
+	}
+	return *data
+}
+
 func getFromStr(data string) (int64, error) {
 	p, err := strconv.ParseInt(data, 10, 64)
 	if err != nil {
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mi-raf/comment-project/internal/logging"
+)
+
+var ErrCORSConfig = errors.New("invalid CORS config")
+
+// CORSConfig allows pages of other sites to call the API, CORS is disabled without allowed origins.
+type CORSConfig struct {
+	// AllowedOrigins are matched like websocket origins, they may use "*" and "https://*.example.com".
+	AllowedOrigins []string
+	// AllowedHeaders are request headers, which scripts of allowed origins can send.
+	AllowedHeaders []string
+	// AllowCredentials lets browsers send cookies and use responses of requests with them.
+	AllowCredentials bool
+	// MaxAge is how long browsers cache results of preflight requests.
+	MaxAge time.Duration
+}
+
+// Validate refuses credentials for any origin, since any site could make requests with cookies of users then.
+func (c *CORSConfig) Validate() error {
+	if c.AllowCredentials && slices.Contains(c.AllowedOrigins, "*") {
+		return fmt.Errorf("%w: credentials can not be allowed for any origin", ErrCORSConfig)
+	}
+	return nil
+}
+
+// corsMiddleware adds CORS headers to responses for allowed origins and answers preflight requests.
+func corsMiddleware(c *CORSConfig, next http.Handler) http.Handler {
+	if len(c.AllowedOrigins) == 0 {
+		return next
+	}
+	allowed := origins(c.AllowedOrigins)
+	headers := strings.Join(c.AllowedHeaders, ", ")
+	maxAge := strconv.Itoa(int(c.MaxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if origin != "" && allowed.allowed(origin) {
+			h.Set("Access-Control-Allow-Origin", origin)
+			if c.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if preflight {
+				h.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+				h.Set("Access-Control-Allow-Headers", headers)
+				h.Set("Access-Control-Max-Age", maxAge)
+			} else {
+				h.Set("Access-Control-Expose-Headers", logging.REQUEST_ID_HEADER)
+			}
+		}
+		if preflight {
+			// browsers fail preflight requests of other origins, since the response has no CORS headers
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,191 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mi-raf/comment-project/internal/api"
+	"github.com/stretchr/testify/suite"
+)
+
+const createComment = `mutation { createComment(postId: "1", comment: {author: "author", text: "text", time: "2024-05-01T10:00:00Z"}) }`
+
+type CORSTestSuite struct {
+	graphSuite
+}
+
+func (s *CORSTestSuite) TestPreflight() {
+	// given
+	addr := s.serve(api.Config{CORS: s.cors()})
+	cases := []struct {
+		origin  string
+		allowed bool
+	}{
+		{origin: "https://app.example.com", allowed: true},
+		{origin: "https://evil.com"},
+	}
+	for _, c := range cases {
+		req, err := http.NewRequest(http.MethodOptions, "http://"+addr+"/query", nil)
+		s.Require().NoError(err)
+		req.Header.Set("Origin", c.origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "content-type")
+
+		// when
+		resp, err := http.DefaultClient.Do(req)
+
+		// then
+		s.Require().NoError(err)
+		resp.Body.Close()
+		s.Equal(http.StatusNoContent, resp.StatusCode, c.origin)
+		if c.allowed {
+			s.Equal(c.origin, resp.Header.Get("Access-Control-Allow-Origin"))
+			s.Equal("true", resp.Header.Get("Access-Control-Allow-Credentials"))
+			s.Equal("GET, POST, OPTIONS", resp.Header.Get("Access-Control-Allow-Methods"))
+			s.Equal("Content-Type, X-Requested-With", resp.Header.Get("Access-Control-Allow-Headers"))
+			s.Equal("600", resp.Header.Get("Access-Control-Max-Age"))
+		} else {
+			s.Empty(resp.Header.Get("Access-Control-Allow-Origin"), c.origin)
+		}
+	}
+}
+
+func (s *CORSTestSuite) TestResponseHeaders() {
+	// given
+	addr := s.serve(api.Config{CORS: s.cors()})
+	req := s.jsonRequest("http://"+addr+"/query", `{ posts { id } }`)
+	req.Header.Set("Origin", "https://app.example.com")
+
+	// when
+	resp, err := http.DefaultClient.Do(req)
+
+	// then
+	s.Require().NoError(err)
+	resp.Body.Close()
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("https://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	s.Equal("X-Request-ID", resp.Header.Get("Access-Control-Expose-Headers"))
+	s.Equal("Origin", resp.Header.Get("Vary"))
+}
+
+func (s *CORSTestSuite) TestCredentialsForAnyOrigin() {
+	// given
+	cfg := api.CORSConfig{AllowedOrigins: []string{"https://app.example.com", "*"}, AllowCredentials: true}
+
+	// when
+	err := cfg.Validate()
+
+	// then
+	s.ErrorIs(err, api.ErrCORSConfig)
+}
+
+func (s *CORSTestSuite) TestCSRFPrevention() {
+	// given
+	addr := s.serve(api.Config{CORS: s.cors(), CSRFProtection: true})
+	url := "http://" + addr + "/query"
+	cases := []struct {
+		name    string
+		req     *http.Request
+		allowed bool
+	}{
+		{name: "JSON", req: s.jsonRequest(url, createComment), allowed: true},
+		{name: "JSON from allowed origin", req: s.withHeader(s.jsonRequest(url, createComment), "Origin", "https://app.example.com"), allowed: true},
+		{name: "JSON from the server", req: s.withHeader(s.jsonRequest(url, createComment), "Origin", "http://"+addr), allowed: true},
+		{name: "JSON from other origin", req: s.withHeader(s.jsonRequest(url, createComment), "Origin", "https://evil.com")},
+		{name: "form", req: s.formRequest(url, createComment)},
+		{name: "form with header", req: s.withHeader(s.formRequest(url, createComment), "X-Requested-With", "XMLHttpRequest"), allowed: true},
+		{name: "form with query", req: s.formRequest(url, `{ posts { id } }`), allowed: true},
+	}
+	for _, c := range cases {
+		// when
+		res := s.do(c.req)
+
+		// then
+		if c.allowed {
+			s.Empty(res.Errors, c.name)
+		} else {
+			s.Require().Len(res.Errors, 1, c.name)
+			s.Equal("CSRF_PREVENTED", res.Errors[0].Extensions.Code, c.name)
+		}
+	}
+}
+
+func (s *CORSTestSuite) TestCSRFPreventionDisabled() {
+	// given
+	addr := s.serve(api.Config{CORS: s.cors()})
+
+	// when
+	res := s.do(s.formRequest("http://"+addr+"/query", createComment))
+
+	// then
+	s.Empty(res.Errors)
+}
+
+func (s *CORSTestSuite) TestWebsocketFromCORSOrigin() {
+	// given
+	addr := s.serve(api.Config{CORS: s.cors()})
+	d := websocket.Dialer{Subprotocols: []string{"graphql-transport-ws"}}
+
+	// when
+	conn, _, err := d.Dial("ws://"+addr+"/query", http.Header{"Origin": []string{"https://app.example.com"}})
+
+	// then
+	s.Require().NoError(err)
+	conn.Close()
+}
+
+func (s *CORSTestSuite) cors() api.CORSConfig {
+	return api.CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedHeaders:   []string{"Content-Type", "X-Requested-With"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+}
+
+func (s *CORSTestSuite) jsonRequest(url, query string) *http.Request {
+	body, err := json.Marshal(map[string]any{"query": query})
+	s.Require().NoError(err)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	s.Require().NoError(err)
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+// formRequest sends the operation as a multipart form, which pages of any site can submit.
+func (s *CORSTestSuite) formRequest(url, query string) *http.Request {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	operations, err := json.Marshal(map[string]any{"query": query})
+	s.Require().NoError(err)
+	s.Require().NoError(w.WriteField("operations", string(operations)))
+	s.Require().NoError(w.WriteField("map", "{}"))
+	s.Require().NoError(w.Close())
+	req, err := http.NewRequest(http.MethodPost, url, &body)
+	s.Require().NoError(err)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req
+}
+
+func (s *CORSTestSuite) withHeader(req *http.Request, key, value string) *http.Request {
+	req.Header.Set(key, value)
+	return req
+}
+
+func (s *CORSTestSuite) do(req *http.Request) graphResponse {
+	resp, err := http.DefaultClient.Do(req)
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+	var res graphResponse
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&res))
+	return res
+}
+
+func TestCORSTestSuite(t *testing.T) {
+	suite.Run(t, new(CORSTestSuite))
+}
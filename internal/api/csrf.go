@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/mi-raf/comment-project/internal/logging"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const errCSRF = "CSRF_PREVENTED"
+
+// csrfHeaders mark requests of scripts: browsers send them cross origin only after a CORS preflight.
+var csrfHeaders = []string{"X-Requested-With", "Apollo-Require-Preflight"}
+
+type (
+	csrfKey struct{}
+
+	// csrfPrevention rejects mutations of requests marked by csrfMiddleware.
+	csrfPrevention struct{}
+)
+
+var (
+	_ graphql.HandlerExtension        = csrfPrevention{}
+	_ graphql.OperationContextMutator = csrfPrevention{}
+)
+
+// csrfMiddleware marks requests, which a page of another site could send with cookies of a user:
+// requests from origins which are not allowed and requests which browsers send without a CORS preflight.
+// Queries of such requests are executed, since the page can not read responses, but mutations are rejected.
+// Websocket connections are checked by the upgrader.
+func csrfMiddleware(allowed origins, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		reason := ""
+		if !allowed.fromAllowed(r) {
+			reason = "origin is not allowed"
+		} else if !preflighted(r) {
+			reason = "request is sent without CORS preflight"
+		}
+		if reason != "" {
+			logging.Ctx(r.Context()).Debug().Str("origin", r.Header.Get("Origin")).Str("reason", reason).Msg("mutations are forbidden")
+			r = r.WithContext(context.WithValue(r.Context(), csrfKey{}, reason))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// preflighted reports whether browsers send the request only after a CORS preflight,
+// it has a content type of JSON or a header of scripts.
+func preflighted(r *http.Request) bool {
+	for _, h := range csrfHeaders {
+		if r.Header.Get(h) != "" {
+			return true
+		}
+	}
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(t) {
+	case "application/x-www-form-urlencoded", "multipart/form-data", "text/plain":
+		return false
+	}
+	return true
+}
+
+func (csrfPrevention) ExtensionName() string {
+	return "CSRFPrevention"
+}
+
+func (csrfPrevention) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (csrfPrevention) MutateOperationContext(ctx context.Context, rc *graphql.OperationContext) *gqlerror.Error {
+	reason, ok := ctx.Value(csrfKey{}).(string)
+	if !ok || rc.Operation.Operation != ast.Mutation {
+		return nil
+	}
+	err := gqlerror.Errorf("mutation is forbidden: %s, send it with Content-Type: application/json or %s header", reason, csrfHeaders[0])
+	errcode.Set(err, errCSRF)
+	return err
+}
@@ -0,0 +1,378 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mi-raf/comment-project/graph/model"
+	"github.com/mi-raf/comment-project/internal/logging"
+	"github.com/mi-raf/comment-project/internal/service"
+)
+
+const (
+	FEED_SIZE = 20
+
+	atomType = "application/atom+xml; charset=utf-8"
+	rssType  = "application/rss+xml; charset=utf-8"
+)
+
+type (
+	feeds struct {
+		ps *service.PostService
+	}
+
+	atomFeed struct {
+		XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+		ID      string      `xml:"id"`
+		Title   string      `xml:"title"`
+		Updated string      `xml:"updated"`
+		Links   []atomLink  `xml:"link"`
+		Entries []atomEntry `xml:"entry"`
+	}
+
+	atomLink struct {
+		Rel  string `xml:"rel,attr,omitempty"`
+		Type string `xml:"type,attr,omitempty"`
+		Href string `xml:"href,attr"`
+	}
+
+	atomEntry struct {
+		ID      string       `xml:"id"`
+		Title   string       `xml:"title"`
+		Updated string       `xml:"updated"`
+		Author  atomAuthor   `xml:"author"`
+		Links   []atomLink   `xml:"link"`
+		Content *atomContent `xml:"content,omitempty"`
+	}
+
+	atomAuthor struct {
+		Name string `xml:"name"`
+	}
+
+	atomContent struct {
+		Type string `xml:"type,attr"`
+		Body string `xml:",chardata"`
+	}
+
+	rssFeed struct {
+		XMLName xml.Name   `xml:"rss"`
+		Version string     `xml:"version,attr"`
+		Atom    string     `xml:"xmlns:atom,attr"`
+		Channel rssChannel `xml:"channel"`
+	}
+
+	rssChannel struct {
+		Title         string     `xml:"title"`
+		Link          string     `xml:"link"`
+		Description   string     `xml:"description"`
+		LastBuildDate string     `xml:"lastBuildDate,omitempty"`
+		Links         []atomLink `xml:"atom:link"`
+		Items         []rssItem  `xml:"item"`
+	}
+
+	rssItem struct {
+		Title       string  `xml:"title"`
+		Link        string  `xml:"link"`
+		Description string  `xml:"description"`
+		Comments    string  `xml:"comments"`
+		GUID        rssGUID `xml:"guid"`
+		PubDate     string  `xml:"pubDate"`
+	}
+
+	rssGUID struct {
+		IsPermaLink bool   `xml:"isPermaLink,attr"`
+		Value       string `xml:",chardata"`
+	}
+)
+
+func registerFeeds(mux *http.ServeMux, ps *service.PostService) {
+	f := &feeds{ps: ps}
+	mux.HandleFunc("GET /feeds/posts.atom", f.postsAtom)
+	mux.HandleFunc("GET /feeds/posts.rss", f.postsRss)
+	mux.HandleFunc("GET /feeds/posts/{id}/comments.atom", f.commentsAtom)
+}
+
+func (f *feeds) postsAtom(w http.ResponseWriter, r *http.Request) {
+	offset, count, err := feedPage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	posts, err := f.ps.LatestPosts(r.Context(), offset, count)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	self := absoluteURL(r, r.URL.Path, nil)
+	feed := atomFeed{
+		ID:    self,
+		Title: "Posts",
+		Links: pageLinks(r, strconv.FormatInt(offset+int64(len(posts)), 10), count, len(posts), atomType),
+	}
+	var updated time.Time
+	for _, p := range posts {
+		u := postUpdated(p)
+		if u.After(updated) {
+			updated = u
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      postURN(p.ID),
+			Title:   p.Title,
+			Updated: u.UTC().Format(time.RFC3339),
+			Author:  atomAuthor{Name: p.Author},
+			Links: []atomLink{{
+				Rel:  "alternate",
+				Type: jsonType,
+				Href: absoluteURL(r, REST_PREFIX+"/posts/"+p.ID, nil),
+			}, {
+				Rel:  "replies",
+				Type: atomType,
+				Href: absoluteURL(r, "/feeds/posts/"+p.ID+"/comments.atom", nil),
+			}},
+			Content: &atomContent{Type: "text", Body: p.Text},
+		})
+	}
+	// updated is required by Atom, an empty feed is up to date now
+	feed.Updated = time.Now().UTC().Format(time.RFC3339)
+	if !updated.IsZero() {
+		feed.Updated = updated.UTC().Format(time.RFC3339)
+	}
+	writeFeed(w, r, atomType, updated, feed)
+}
+
+func (f *feeds) postsRss(w http.ResponseWriter, r *http.Request) {
+	offset, count, err := feedPage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	posts, err := f.ps.LatestPosts(r.Context(), offset, count)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	feed := rssFeed{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:       "Posts",
+			Link:        absoluteURL(r, r.URL.Path, nil),
+			Description: "Latest posts",
+			Links:       pageLinks(r, strconv.FormatInt(offset+int64(len(posts)), 10), count, len(posts), rssType),
+		},
+	}
+	var updated time.Time
+	for _, p := range posts {
+		u := postUpdated(p)
+		if u.After(updated) {
+			updated = u
+		}
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       p.Title,
+			Link:        absoluteURL(r, REST_PREFIX+"/posts/"+p.ID, nil),
+			Description: p.Text,
+			Comments:    absoluteURL(r, "/feeds/posts/"+p.ID+"/comments.atom", nil),
+			GUID:        rssGUID{Value: postURN(p.ID)},
+			PubDate:     p.Time.UTC().Format(time.RFC1123Z),
+		})
+	}
+	if !updated.IsZero() {
+		feed.Channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+	}
+	writeFeed(w, r, rssType, updated, feed)
+}
+
+func (f *feeds) commentsAtom(w http.ResponseWriter, r *http.Request) {
+	postID, err := getFromStr(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, ErrIdIncorrect.Error(), http.StatusBadRequest)
+		return
+	}
+	count, err := feedCount(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	post, err := f.ps.Post(r.Context(), postID, 1)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if post == nil {
+		http.NotFound(w, r)
+		return
+	}
+	cs, err := f.ps.Comments(r.Context(), postID, count, r.URL.Query().Get("after"))
+	if errors.Is(err, service.ErrClientData) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var next string
+	if cs.PageInfo.EndCursor != nil {
+		next = *cs.PageInfo.EndCursor
+	}
+
+	feed := atomFeed{
+		ID:    postURN(post.ID) + ":comments",
+		Title: "Comments: " + post.Title,
+		Links: pageLinks(r, next, count, len(cs.Comments), atomType),
+	}
+	updated := post.Time
+	if post.LastCommentAt != nil {
+		updated = *post.LastCommentAt
+	}
+	for _, c := range cs.Comments {
+		entry := atomEntry{
+			ID:      fmt.Sprintf("%s:comment:%s", postURN(post.ID), c.ID),
+			Title:   fmt.Sprintf("Comment by %s", c.Comment.Author),
+			Updated: c.Comment.Time.UTC().Format(time.RFC3339),
+			Author:  atomAuthor{Name: c.Comment.Author},
+			Content: &atomContent{Type: "text", Body: c.Comment.Text},
+		}
+		if c.ParentID != nil {
+			entry.Links = append(entry.Links, atomLink{
+				Rel:  "related",
+				Href: fmt.Sprintf("%s:comment:%s", postURN(post.ID), *c.ParentID),
+			})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+	writeFeed(w, r, atomType, updated, feed)
+}
+
+// writeFeed renders feed and answers conditional requests with 304
+// using an ETag of the rendered body and the time of the latest entry.
+func writeFeed(w http.ResponseWriter, r *http.Request, contentType string, updated time.Time, feed any) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(feed); err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("can not encode feed")
+		http.Error(w, "can not encode feed", http.StatusInternalServerError)
+		return
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	if !updated.IsZero() {
+		w.Header().Set("Last-Modified", updated.UTC().Format(http.TimeFormat))
+	}
+	if notModified(r, etag, updated) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("can not write feed")
+	}
+}
+
+// notModified evaluates If-None-Match and, without it, If-Modified-Since (RFC 9110, section 13.2.2).
+func notModified(r *http.Request, etag string, updated time.Time) bool {
+	if inm := r.Header.Values("If-None-Match"); len(inm) > 0 {
+		return etagMatch(inm, etag)
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || updated.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	return !updated.Truncate(time.Second).After(t)
+}
+
+// etagMatch reports whether the If-None-Match list has the tag, "*" matches any tag.
+// Weak comparison is used, so "W/" prefixes are ignored (RFC 9110, section 13.1.2).
+func etagMatch(values []string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			t = strings.TrimSpace(t)
+			if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func feedPage(r *http.Request) (int64, int, error) {
+	var offset int64
+	if a := r.URL.Query().Get("after"); a != "" {
+		v, err := strconv.ParseInt(a, 10, 64)
+		if err != nil || v < 0 {
+			return 0, 0, fmt.Errorf("incorrect after %q", a)
+		}
+		offset = v
+	}
+	count, err := feedCount(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	return offset, count, nil
+}
+
+// feedCount reads the number of entries from the count query parameter.
+func feedCount(r *http.Request) (int, error) {
+	count := FEED_SIZE
+	if c := r.URL.Query().Get("count"); c != "" {
+		v, err := strconv.Atoi(c)
+		if err != nil || v < 1 || v > service.MAX_LIMIT {
+			return 0, fmt.Errorf("incorrect count %q", c)
+		}
+		count = v
+	}
+	return count, nil
+}
+
+// pageLinks returns self link and, when the page is full, a link to the page after the cursor next (RFC 5005).
+func pageLinks(r *http.Request, next string, count int, size int, contentType string) []atomLink {
+	links := []atomLink{{Rel: "self", Type: contentType, Href: absoluteURL(r, r.URL.Path, r.URL.Query())}}
+	if size == count {
+		q := r.URL.Query()
+		q.Set("after", next)
+		links = append(links, atomLink{Rel: "next", Type: contentType, Href: absoluteURL(r, r.URL.Path, q)})
+	}
+	return links
+}
+
+func absoluteURL(r *http.Request, path string, q url.Values) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	u := url.URL{Scheme: scheme, Host: r.Host, Path: path}
+	if len(q) > 0 {
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+func postUpdated(p *model.Post) time.Time {
+	if p.LastCommentAt != nil && p.LastCommentAt.After(p.Time) {
+		return *p.LastCommentAt
+	}
+	return p.Time
+}
+
+func postURN(id string) string {
+	return "urn:comment-project:post:" + id
+}
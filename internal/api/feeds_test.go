@@ -0,0 +1,170 @@
+package api_test
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mi-raf/comment-project/graph/model"
+	"github.com/mi-raf/comment-project/internal/api"
+	"github.com/stretchr/testify/suite"
+)
+
+type FeedsTestSuite struct {
+	graphSuite
+	addr string
+}
+
+func (s *FeedsTestSuite) SetupTest() {
+	s.graphSuite.SetupTest()
+	_, err := s.ps.CreateComment(s.ctx, 1, 0, model.NewComment{Author: "commenter", Text: "comment text", Time: time.Now()})
+	s.Require().NoError(err)
+	s.addr = s.serve(api.Config{})
+}
+
+func (s *FeedsTestSuite) TestPostsAtom() {
+	// when
+	resp, body := s.get("/feeds/posts.atom", nil)
+
+	// then
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("application/atom+xml; charset=utf-8", resp.Header.Get("Content-Type"))
+	s.NotEmpty(resp.Header.Get("ETag"))
+	s.NotEmpty(resp.Header.Get("Last-Modified"))
+	s.Contains(body, `<feed xmlns="http://www.w3.org/2005/Atom">`)
+	s.Contains(body, "<title>title</title>")
+	s.Contains(body, "<id>urn:comment-project:post:1</id>")
+	s.Contains(body, `<link rel="alternate" type="application/json; charset=utf-8" href="http://`+s.addr+api.REST_PREFIX+`/posts/1"></link>`)
+	s.Contains(body, `href="http://`+s.addr+`/feeds/posts/1/comments.atom"`)
+	s.Contains(body, `<content type="text">text</content>`)
+}
+
+func (s *FeedsTestSuite) TestEmptyPostsAtom() {
+	// when
+	before := time.Now().Truncate(time.Second)
+	resp, body := s.get("/feeds/posts.atom?after=1", nil)
+
+	// then
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Empty(resp.Header.Get("Last-Modified"))
+	var feed struct {
+		Updated time.Time `xml:"updated"`
+	}
+	s.Require().NoError(xml.Unmarshal([]byte(body), &feed))
+	s.False(feed.Updated.Before(before), feed.Updated)
+}
+
+func (s *FeedsTestSuite) TestPostsRss() {
+	// when
+	resp, body := s.get("/feeds/posts.rss", nil)
+
+	// then
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("application/rss+xml; charset=utf-8", resp.Header.Get("Content-Type"))
+	s.Contains(body, `<rss version="2.0"`)
+	s.Contains(body, "<title>title</title>")
+	s.Contains(body, "<lastBuildDate>")
+	s.Contains(body, "<link>http://"+s.addr+api.REST_PREFIX+"/posts/1</link>")
+	s.Contains(body, "<description>text</description>")
+	s.Contains(body, "<comments>http://"+s.addr+"/feeds/posts/1/comments.atom</comments>")
+}
+
+func (s *FeedsTestSuite) TestCommentsAtom() {
+	// when
+	resp, body := s.get("/feeds/posts/1/comments.atom", nil)
+
+	// then
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Contains(body, "<title>Comments: title</title>")
+	s.Contains(body, "<id>urn:comment-project:post:1:comments</id>")
+	s.Contains(body, "<title>Comment by commenter</title>")
+	s.Contains(body, `<content type="text">comment text</content>`)
+}
+
+func (s *FeedsTestSuite) TestNextPageLink() {
+	// when
+	_, full := s.get("/feeds/posts.atom?count=1", nil)
+	_, last := s.get("/feeds/posts.atom?count=2", nil)
+
+	// then
+	s.Contains(full, `rel="next"`)
+	s.Contains(full, "after=1")
+	s.NotContains(last, `rel="next"`)
+}
+
+func (s *FeedsTestSuite) TestErrors() {
+	cases := []struct {
+		path string
+		code int
+	}{
+		{path: "/feeds/posts.atom?count=0", code: http.StatusBadRequest},
+		{path: "/feeds/posts.rss?after=-1", code: http.StatusBadRequest},
+		{path: "/feeds/posts/abc/comments.atom", code: http.StatusBadRequest},
+		{path: "/feeds/posts/2/comments.atom", code: http.StatusNotFound},
+	}
+	for _, c := range cases {
+		// when
+		resp, _ := s.get(c.path, nil)
+
+		// then
+		s.Equal(c.code, resp.StatusCode, c.path)
+	}
+}
+
+func (s *FeedsTestSuite) TestConditionalGet() {
+	// given
+	resp, _ := s.get("/feeds/posts.atom", nil)
+	etag := resp.Header.Get("ETag")
+	modified := resp.Header.Get("Last-Modified")
+	lastModified, err := http.ParseTime(modified)
+	s.Require().NoError(err)
+	earlier := lastModified.Add(-time.Minute).Format(http.TimeFormat)
+	cases := []struct {
+		name   string
+		header http.Header
+		code   int
+	}{
+		{name: "same tag", header: http.Header{"If-None-Match": {etag}}, code: http.StatusNotModified},
+		{name: "weak tag", header: http.Header{"If-None-Match": {"W/" + etag}}, code: http.StatusNotModified},
+		{name: "tag in list", header: http.Header{"If-None-Match": {`"other", ` + etag}}, code: http.StatusNotModified},
+		{name: "tag in second header", header: http.Header{"If-None-Match": {`"other"`, etag}}, code: http.StatusNotModified},
+		{name: "any tag", header: http.Header{"If-None-Match": {"*"}}, code: http.StatusNotModified},
+		{name: "other tag", header: http.Header{"If-None-Match": {`"other", W/"another"`}}, code: http.StatusOK},
+		{name: "not modified since", header: http.Header{"If-Modified-Since": {modified}}, code: http.StatusNotModified},
+		{name: "modified since", header: http.Header{"If-Modified-Since": {earlier}}, code: http.StatusOK},
+		{name: "tag takes precedence", header: http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {modified}}, code: http.StatusOK},
+	}
+	for _, c := range cases {
+		// when
+		resp, body := s.get("/feeds/posts.atom", c.header)
+
+		// then
+		s.Equal(c.code, resp.StatusCode, c.name)
+		s.Equal(etag, resp.Header.Get("ETag"), c.name)
+		if c.code == http.StatusNotModified {
+			s.Empty(body, c.name)
+		}
+	}
+}
+
+func (s *FeedsTestSuite) get(path string, h http.Header) (*http.Response, string) {
+	req, err := http.NewRequest(http.MethodGet, "http://"+s.addr+path, nil)
+	s.Require().NoError(err)
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+	var body strings.Builder
+	_, err = io.Copy(&body, resp.Body)
+	s.Require().NoError(err)
+	return resp, body.String()
+}
+
+func TestFeedsTestSuite(t *testing.T) {
+	suite.Run(t, new(FeedsTestSuite))
+}
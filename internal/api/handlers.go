@@ -1,73 +1,251 @@
 package api
 
 import (
+	"cmp"
+	"crypto/tls"
+	"errors"
+	"maps"
+	"net"
 	"net/http"
+	"slices"
 	"time"
 
 	"context"
 
 	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/lru"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/gorilla/websocket"
 	"github.com/mi-raf/comment-project/graph"
+	"github.com/mi-raf/comment-project/internal/logging"
+	"github.com/mi-raf/comment-project/internal/service"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
 	"github.com/99designs/gqlgen/graphql"
 	"github.com/vektah/gqlparser/v2/gqlerror"
 )
 
+const (
+	// QUERY_CACHE_SIZE is a number of parsed and validated queries kept in memory.
+	QUERY_CACHE_SIZE = 1000
+	// SHUTDOWN_TIMEOUT is used when the config has no ShutdownTimeout.
+	SHUTDOWN_TIMEOUT = 10 * time.Second
+)
+
 type (
 	API struct {
-		srv    *handler.Server
-		s      *http.Server
-		listen string
-		ctx    context.Context
+		srv             *handler.Server
+		s               *http.Server
+		ps              *service.PostService
+		probes          *Probes
+		conns           *connections
+		tls             TLSConfig
+		shutdownDelay   time.Duration
+		shutdownTimeout time.Duration
+		// done stops reloading of the certificate
+		done chan struct{}
 	}
 
 	Config struct {
-		GraphCfg graph.Config
-		Listen   string
+		GraphCfg   graph.Config
+		Listen     string
+		Service    *service.PostService
+		AdminToken string
+		// MaxComplexity and MaxDepth limit cost and nesting of operations, zero disables a limit.
+		MaxComplexity int
+		MaxDepth      int
+		// APQCacheSize limits a number of automatic persisted queries, zero disables them.
+		APQCacheSize int
+		// Allowlist holds the only allowed operations by their hashes, nil allows any operation.
+		Allowlist     map[string]string
+		Introspection bool
+		// Metrics are collected and served on /metrics to admin requests unless they are nil.
+		Metrics *Metrics
+		// ShutdownDelay is how long requests are served after readiness fails on shutdown,
+		// so load balancers notice it and stop sending new ones.
+		ShutdownDelay time.Duration
+		// ShutdownTimeout limits waiting for running requests and websocket connections on shutdown, zero means SHUTDOWN_TIMEOUT.
+		ShutdownTimeout time.Duration
+		TLS             TLSConfig
+		// HTTP2 is negotiated with TLS, without it clients can use HTTP/2 over cleartext (h2c).
+		HTTP2 bool
+		// Timeouts of the server, zero disables a timeout. Websocket connections are not limited by them.
+		ReadHeaderTimeout time.Duration
+		ReadTimeout       time.Duration
+		WriteTimeout      time.Duration
+		IdleTimeout       time.Duration
+		// WebsocketOrigins are allowed to open websocket connections besides the host of the server and CORS origins.
+		WebsocketOrigins []string
+		CORS             CORSConfig
+		// CSRFProtection rejects mutations, which pages of other sites could send with cookies of users.
+		CSRFProtection bool
 	}
 )
 
-func NewApi(ctx context.Context, c *Config) *API {
-	srv := handler.NewDefaultServer(graph.NewExecutableSchema(c.GraphCfg))
-	srv.SetErrorPresenter(errorHandler)
+func NewApi(c *Config) *API {
+	srv := NewGraphServer(c)
 	server := &http.Server{
-		Addr: c.Listen,
+		Addr:              c.Listen,
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		ReadTimeout:       c.ReadTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
 	}
-	http.HandleFunc("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
+	mux := http.NewServeMux()
+	probes := NewProbes(c.Service)
+	conns := newConnections()
+	mux.HandleFunc("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("OK"))
 	})
-	http.Handle("/query", logMiddleware(srv))
+	mux.HandleFunc("/livez", probes.Livez)
+	mux.HandleFunc("/readyz", probes.Readyz)
+	var h http.Handler = mux
+	if c.Metrics != nil {
+		mux.Handle("/metrics", adminMiddleware(c.AdminToken, &c.TLS, adminOnly(c.Metrics.Handler())))
+		h = c.Metrics.instrument(mux)
+	}
+	server.Handler = traceHTTP(mux, logging.Middleware(h))
+	switch {
+	case !c.HTTP2 && c.TLS.Enabled():
+		// a non nil map disables HTTP/2 negotiation
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	case c.HTTP2 && !c.TLS.Enabled():
+		server.Handler = h2c.NewHandler(server.Handler, &http2.Server{IdleTimeout: c.IdleTimeout})
+	}
+	var query http.Handler = adminMiddleware(c.AdminToken, &c.TLS, srv)
+	if c.CSRFProtection {
+		query = csrfMiddleware(c.CORS.AllowedOrigins, query)
+	}
+	mux.Handle("/query", corsMiddleware(&c.CORS, conns.track(logMiddleware(query))))
+	registerFeeds(mux, c.Service)
+	registerRest(mux, c.Service)
 	return &API{
-		srv: srv,
-		s:   server,
-		ctx: ctx,
+		srv:             srv,
+		s:               server,
+		ps:              c.Service,
+		probes:          probes,
+		conns:           conns,
+		tls:             c.TLS,
+		shutdownDelay:   c.ShutdownDelay,
+		shutdownTimeout: cmp.Or(c.ShutdownTimeout, SHUTDOWN_TIMEOUT),
+		done:            make(chan struct{}),
 	}
 }
 
-func (a *API) Start() error {
-	log.Debug().Msgf("listening on %v", a.listen)
-	return a.s.ListenAndServe()
+// NewGraphServer returns the GraphQL handler with limits of query cost and depth.
+// With an allowlist only its operations are executed, otherwise clients can use automatic persisted queries.
+func NewGraphServer(c *Config) *handler.Server {
+	srv := handler.New(graph.NewExecutableSchema(c.GraphCfg))
+	srv.AddTransport(transport.Websocket{
+		KeepAlivePingInterval: 10 * time.Second,
+		Upgrader: websocket.Upgrader{
+			CheckOrigin: origins(slices.Concat(c.WebsocketOrigins, c.CORS.AllowedOrigins)).checkOrigin,
+		},
+	})
+	srv.AddTransport(transport.Options{})
+	srv.AddTransport(transport.GET{})
+	srv.AddTransport(transport.POST{})
+	srv.AddTransport(transport.MultipartForm{})
+	srv.SetQueryCache(lru.New(QUERY_CACHE_SIZE))
+	srv.SetErrorPresenter(errorHandler)
+	srv.Use(requestIDs{})
+	if c.CSRFProtection {
+		srv.Use(csrfPrevention{})
+	}
+
+	if c.Metrics != nil {
+		srv.Use(newOperationMetrics(c.Metrics, c.Allowlist))
+	}
+	srv.Use(graphTracer{})
+	if c.Introspection {
+		srv.Use(extension.Introspection{})
+	}
+	if c.Allowlist != nil {
+		srv.Use(allowlist{queries: c.Allowlist})
+	} else if c.APQCacheSize > 0 {
+		srv.Use(extension.AutomaticPersistedQuery{Cache: lru.New(c.APQCacheSize)})
+	}
+	srv.Use(dataloaders{ps: c.Service})
+	srv.Use(depthLimit{max: c.MaxDepth})
+	srv.Use(complexityLimit(c.MaxComplexity))
+	srv.Use(costReporter{maxComplexity: c.MaxComplexity, maxDepth: c.MaxDepth})
+	return srv
 }
 
-func (a *API) Close() {
-	log.Debug().Msg("start graceful server shutdown")
-	err := a.s.Shutdown(a.ctx)
+// Start listens on the address of the config and serves until the server is closed.
+func (a *API) Start() error {
+	l, err := net.Listen("tcp", a.s.Addr)
 	if err != nil {
-		log.Error().Err(err).Msg("error while shutdowning server")
-		return
+		return err
 	}
-	log.Debug().Msg("server graceful shutdowned")
+	return a.Serve(l)
+}
+
+// Serve accepts connections of the listener, it returns nil after Close.
+// With TLS config the certificate is loaded first and the listener is closed if it fails.
+func (a *API) Serve(l net.Listener) error {
+	var err error
+	if a.tls.Enabled() {
+		a.s.TLSConfig, err = newTLSConfig(&a.tls, a.done)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		log.Debug().Bool("client certificates", a.tls.ClientCAFile != "").Msgf("listening on %v with TLS", l.Addr())
+		err = a.s.ServeTLS(l, "", "")
+	} else {
+		log.Debug().Msgf("listening on %v", l.Addr())
+		err = a.s.Serve(l)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
 func errorHandler(ctx context.Context, e error) *gqlerror.Error {
 	err := graphql.DefaultErrorPresenter(ctx, e)
 	if err != nil {
-		log.Error().Err(err).Msg("error in response")
+		logging.Ctx(ctx).Error().Err(err).Msg("error in response")
 	}
 	return err
 }
 
+// requestIDs adds the request ID to extensions of response errors, including parse and validation ones.
+type requestIDs struct{}
+
+var _ graphql.ResponseInterceptor = requestIDs{}
+
+func (requestIDs) ExtensionName() string {
+	return "RequestID"
+}
+
+func (requestIDs) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (requestIDs) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	resp := next(ctx)
+	id := logging.RequestID(ctx)
+	if resp == nil || id == "" {
+		return resp
+	}
+	for i, err := range resp.Errors {
+		// errors can be shared by responses, so they are copied
+		e := *err
+		e.Extensions = maps.Clone(err.Extensions)
+		if e.Extensions == nil {
+			e.Extensions = make(map[string]any, 1)
+		}
+		e.Extensions["requestId"] = id
+		resp.Errors[i] = &e
+	}
+	return resp
+}
+
 func logMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		req := r
@@ -76,7 +254,7 @@ func logMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 		stop := time.Now()
 
-		log.Debug().
+		logging.Ctx(r.Context()).Debug().
 			Str("remote", req.RemoteAddr).
 			Str("user_agent", req.UserAgent()).
 			Str("method", req.Method).
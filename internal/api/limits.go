@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"math"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/mi-raf/comment-project/graph"
+	"github.com/mi-raf/comment-project/graph/model"
+	"github.com/mi-raf/comment-project/internal/service"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const (
+	errDepthLimit  = "DEPTH_LIMIT_EXCEEDED"
+	depthExtension = "DepthLimit"
+	// costExtension is a key of the query cost in response extensions.
+	costExtension = "cost"
+)
+
+type (
+	// depthLimit rejects operations with selections nested deeper than max, introspection is not counted.
+	depthLimit struct {
+		max int
+	}
+
+	// costReporter adds complexity and depth of the operation to response extensions.
+	costReporter struct {
+		maxComplexity int
+		maxDepth      int
+	}
+
+	cost struct {
+		Complexity    int `json:"complexity"`
+		MaxComplexity int `json:"maxComplexity,omitempty"`
+		Depth         int `json:"depth"`
+		MaxDepth      int `json:"maxDepth,omitempty"`
+	}
+)
+
+var (
+	_ graphql.OperationContextMutator = depthLimit{}
+	_ graphql.ResponseInterceptor     = costReporter{}
+)
+
+// Complexity returns costs of fields which load pages: every element of a page costs
+// as its selection, so the cost grows with count. Other fields cost 1 and their selection.
+func Complexity() graph.ComplexityRoot {
+	var c graph.ComplexityRoot
+	c.Query.Posts = func(childComplexity int, count *int, after *string, orderBy *model.PostOrder) int {
+		return pageCost(childComplexity, count)
+	}
+	// count of the post is a number of its comments
+	c.Query.Post = func(childComplexity int, postID string, count *int) int {
+		return pageCost(childComplexity, count)
+	}
+	c.Query.Comments = func(childComplexity int, postID string, count *int, after *string) int {
+		return pageCost(childComplexity, count)
+	}
+	c.Query.WebhookDeliveries = func(childComplexity int, webhookID *string, status *model.WebhookDeliveryStatus, count *int, after *string) int {
+		return pageCost(childComplexity, count)
+	}
+	c.Author.Posts = func(childComplexity int, count *int, after *string) int {
+		return pageCost(childComplexity, count)
+	}
+	c.Author.Comments = func(childComplexity int, count *int, after *string) int {
+		return pageCost(childComplexity, count)
+	}
+	c.ShortPost.Comments = func(childComplexity int, count *int) int {
+		return pageCost(childComplexity, count)
+	}
+	return c
+}
+
+// pageCost uses the page size of the service, which returns MAX_LIMIT elements when count is not set.
+func pageCost(childComplexity int, count *int) int {
+	n := service.MAX_LIMIT
+	if count != nil && *count > 0 && *count < n {
+		n = *count
+	}
+	return 1 + n*childComplexity
+}
+
+// complexityLimit rejects operations which cost more than limit, it is not checked when limit is not positive.
+func complexityLimit(limit int) *extension.ComplexityLimit {
+	return &extension.ComplexityLimit{Func: func(ctx context.Context, rc *graphql.OperationContext) int {
+		if limit <= 0 {
+			return math.MaxInt
+		}
+		return limit
+	}}
+}
+
+func (d depthLimit) ExtensionName() string {
+	return depthExtension
+}
+
+func (d depthLimit) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (d depthLimit) MutateOperationContext(ctx context.Context, rc *graphql.OperationContext) *gqlerror.Error {
+	n := depth(rc.Operation.SelectionSet)
+	rc.Stats.SetExtension(depthExtension, n)
+	if d.max > 0 && n > d.max {
+		err := gqlerror.Errorf("operation has depth %d, which exceeds the limit of %d", n, d.max)
+		errcode.Set(err, errDepthLimit)
+		return err
+	}
+	return nil
+}
+
+func depth(set ast.SelectionSet) int {
+	res := 0
+	for _, s := range set {
+		switch s := s.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(s.Name, "__") {
+				continue
+			}
+			res = max(res, 1+depth(s.SelectionSet))
+		case *ast.InlineFragment:
+			res = max(res, depth(s.SelectionSet))
+		case *ast.FragmentSpread:
+			// cycles of fragments are rejected by validation
+			if s.Definition != nil {
+				res = max(res, depth(s.Definition.SelectionSet))
+			}
+		}
+	}
+	return res
+}
+
+func (c costReporter) ExtensionName() string {
+	return "QueryCost"
+}
+
+func (c costReporter) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (c costReporter) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	// operation context is missing when the request can not be parsed
+	if !graphql.HasOperationContext(ctx) {
+		return next(ctx)
+	}
+	rc := graphql.GetOperationContext(ctx)
+	d, ok := rc.Stats.GetExtension(depthExtension).(int)
+	if !ok {
+		return next(ctx)
+	}
+	res := cost{Depth: d, MaxDepth: max(c.maxDepth, 0), MaxComplexity: max(c.maxComplexity, 0)}
+	if s := extension.GetComplexityStats(ctx); s != nil {
+		res.Complexity = s.Complexity
+	}
+	graphql.RegisterExtension(ctx, costExtension, res)
+	return next(ctx)
+}
@@ -0,0 +1,208 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mi-raf/comment-project/graph"
+	"github.com/mi-raf/comment-project/internal/api"
+	"github.com/mi-raf/comment-project/internal/database"
+	"github.com/mi-raf/comment-project/internal/models"
+	"github.com/mi-raf/comment-project/internal/service"
+	"github.com/stretchr/testify/suite"
+)
+
+type (
+	// graphSuite runs GraphQL servers over the in-memory service with one post.
+	graphSuite struct {
+		suite.Suite
+		ctx context.Context
+		ps  *service.PostService
+	}
+
+	LimitsTestSuite struct {
+		graphSuite
+	}
+
+	graphResponse struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message    string `json:"message"`
+			Extensions struct {
+				Code      string `json:"code"`
+				RequestID string `json:"requestId"`
+			} `json:"extensions"`
+		} `json:"errors"`
+		Extensions struct {
+			Cost struct {
+				Complexity    int `json:"complexity"`
+				MaxComplexity int `json:"maxComplexity"`
+				Depth         int `json:"depth"`
+				MaxDepth      int `json:"maxDepth"`
+			} `json:"cost"`
+		} `json:"extensions"`
+	}
+)
+
+func (s *graphSuite) SetupTest() {
+	var cancel context.CancelFunc
+	s.ctx, cancel = context.WithCancel(context.Background())
+	p, _, err := database.NewPostRepositoryProvider(s.ctx, &database.PostConfig{InMemory: true})
+	s.NoError(err)
+	ch, closeCh := service.NewCommentChan()
+	c, _, err := database.NewCommentRepositoryProvider(s.ctx, &database.CommentConfig{InMemory: true, CommentChan: ch}, p)
+	s.NoError(err)
+	_, err = p.Add(s.ctx, &models.PostDTO{Author: "author", Title: "title", Text: "text", IsCommentable: true, Time: time.Now()})
+	s.NoError(err)
+	ps, cleanup := service.NewPostService(s.ctx, p, c, &service.Config{CommentChan: ch})
+	s.ps = ps
+	s.T().Cleanup(func() {
+		cancel()
+		cleanup()
+		closeCh()
+	})
+}
+
+func (s *LimitsTestSuite) TestCostReported() {
+	// given
+	q := `{ comments(postId: "1", count: 20) { comments { id comment { author } } pageInfo { endCursor } } }`
+
+	// when
+	res := s.query(api.Config{MaxComplexity: 1000, MaxDepth: 10}, q)
+
+	// then
+	s.Empty(res.Errors)
+	// 1 + 20 * (comments 1 + id 1 + comment 1 + author 1 + pageInfo 1 + endCursor 1)
+	s.Equal(121, res.Extensions.Cost.Complexity)
+	s.Equal(1000, res.Extensions.Cost.MaxComplexity)
+	s.Equal(4, res.Extensions.Cost.Depth)
+	s.Equal(10, res.Extensions.Cost.MaxDepth)
+}
+
+func (s *LimitsTestSuite) TestComplexityExceededByAliases() {
+	// given
+	q := `{ a: posts { id title } b: posts { id title } }`
+
+	// when
+	res := s.query(api.Config{MaxComplexity: 300}, q)
+
+	// then
+	s.Require().Len(res.Errors, 1)
+	s.Equal("COMPLEXITY_LIMIT_EXCEEDED", res.Errors[0].Extensions.Code)
+	// posts without count return MAX_LIMIT elements
+	s.Equal(2*(1+service.MAX_LIMIT*2), res.Extensions.Cost.Complexity)
+	s.Equal("null", string(res.Data))
+}
+
+func (s *LimitsTestSuite) TestDepthExceeded() {
+	// given
+	q := `query { post(postId: "1") { comments { comments { comment { author } } } } }`
+
+	// when
+	res := s.query(api.Config{MaxDepth: 4}, q)
+
+	// then
+	s.Require().Len(res.Errors, 1)
+	s.Equal("DEPTH_LIMIT_EXCEEDED", res.Errors[0].Extensions.Code)
+	s.Equal(5, res.Extensions.Cost.Depth)
+}
+
+func (s *LimitsTestSuite) TestDepthOfFragments() {
+	// given
+	q := `query { post(postId: "1") { ...withComments } }
+fragment withComments on Post { comments { comments { ... on CommentConnection { comment { author } } } } }`
+
+	// when
+	res := s.query(api.Config{MaxDepth: 4}, q)
+
+	// then
+	s.Require().Len(res.Errors, 1)
+	s.Equal(5, res.Extensions.Cost.Depth)
+}
+
+func (s *LimitsTestSuite) TestIntrospectionDepthNotCounted() {
+	// given
+	q := `{ __schema { types { name fields { name type { name ofType { name ofType { name } } } } } } }`
+
+	// when
+	res := s.query(api.Config{MaxDepth: 2, Introspection: true}, q)
+
+	// then
+	s.Empty(res.Errors)
+	s.Equal(0, res.Extensions.Cost.Depth)
+}
+
+func (s *LimitsTestSuite) TestLimitsDisabled() {
+	// given
+	q := `{ post(postId: "1") { title comments { comments { comment { text } } } } }`
+
+	// when
+	res := s.query(api.Config{}, q)
+
+	// then
+	s.Empty(res.Errors)
+	s.Positive(res.Extensions.Cost.Complexity)
+	s.Zero(res.Extensions.Cost.MaxComplexity)
+	s.Zero(res.Extensions.Cost.MaxDepth)
+}
+
+func (s *graphSuite) query(cfg api.Config, q string) graphResponse {
+	srv := s.server(cfg)
+	defer srv.Close()
+	return s.send(srv.URL, map[string]any{"query": q})
+}
+
+func (s *graphSuite) server(cfg api.Config) *httptest.Server {
+	return httptest.NewServer(s.handler(cfg))
+}
+
+func (s *graphSuite) handler(cfg api.Config) http.Handler {
+	return api.NewGraphServer(s.config(cfg))
+}
+
+// serve starts the API on a free port and returns its address, the API is closed after the test.
+func (s *graphSuite) serve(cfg api.Config) string {
+	cfg.ShutdownTimeout = time.Second
+	a := api.NewApi(s.config(cfg))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	go a.Serve(l)
+	s.T().Cleanup(a.Close)
+	return l.Addr().String()
+}
+
+// config adds the service and resolvers of the suite.
+func (s *graphSuite) config(cfg api.Config) *api.Config {
+	cfg.Service = s.ps
+	cfg.GraphCfg = graph.Config{
+		Resolvers:  api.NewResolver(s.ps, nil),
+		Directives: graph.DirectiveRoot{Admin: api.AdminDirective},
+		Complexity: api.Complexity(),
+	}
+	return &cfg
+}
+
+func (s *graphSuite) send(url string, params map[string]any) graphResponse {
+	return s.sendWith(http.DefaultClient, url, params)
+}
+
+func (s *graphSuite) sendWith(client *http.Client, url string, params map[string]any) graphResponse {
+	body, err := json.Marshal(params)
+	s.Require().NoError(err)
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+	var res graphResponse
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&res))
+	return res
+}
+
+func TestLimitsTestSuite(t *testing.T) {
+	suite.Run(t, new(LimitsTestSuite))
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/mi-raf/comment-project/graph/model"
+	"github.com/mi-raf/comment-project/internal/service"
+)
+
+const (
+	// LOADER_WAIT is how long a loader collects keys of concurrent resolvers before it fetches them.
+	LOADER_WAIT = time.Millisecond
+	// LOADER_MAX_BATCH is a number of keys which are fetched without waiting for more.
+	LOADER_MAX_BATCH = 100
+)
+
+type (
+	// loader batches loads of concurrent resolvers into one fetch and caches values until the end of the response.
+	// Values which are not returned by fetch are loaded as zero ones.
+	loader[K comparable, V any] struct {
+		fetch func(ctx context.Context, keys []K) (map[K]V, error)
+		ctx   context.Context
+		m     sync.Mutex
+		cache map[K]*loaded[V]
+		batch *loaderBatch[K, V]
+	}
+
+	loaded[V any] struct {
+		done chan struct{}
+		v    V
+		err  error
+	}
+
+	loaderBatch[K comparable, V any] struct {
+		keys       []K
+		results    []*loaded[V]
+		dispatched bool
+	}
+
+	// commentsPage is a key of the first page of comments of a post.
+	commentsPage struct {
+		post  int64
+		limit int
+	}
+
+	loaders struct {
+		posts    *loader[int64, *model.Post]
+		comments *loader[int64, *model.CommentConnection]
+		pages    *loader[commentsPage, *model.CommentsResult]
+	}
+
+	loadersKey struct{}
+
+	// dataloaders adds new loaders to the context of every response,
+	// so events of a subscription do not get values cached for previous ones.
+	dataloaders struct {
+		ps *service.PostService
+	}
+)
+
+var _ graphql.ResponseInterceptor = dataloaders{}
+
+func newLoader[K comparable, V any](ctx context.Context, fetch func(ctx context.Context, keys []K) (map[K]V, error)) *loader[K, V] {
+	return &loader[K, V]{fetch: fetch, ctx: ctx, cache: make(map[K]*loaded[V])}
+}
+
+// Load returns the value of the key, which is fetched with keys of other loads made during LOADER_WAIT.
+func (l *loader[K, V]) Load(ctx context.Context, key K) (V, error) {
+	l.m.Lock()
+	r, ok := l.cache[key]
+	if !ok {
+		r = &loaded[V]{done: make(chan struct{})}
+		l.cache[key] = r
+		if l.batch == nil {
+			b := &loaderBatch[K, V]{}
+			l.batch = b
+			time.AfterFunc(LOADER_WAIT, func() { l.dispatch(b) })
+		}
+		b := l.batch
+		b.keys = append(b.keys, key)
+		b.results = append(b.results, r)
+		if len(b.keys) >= LOADER_MAX_BATCH {
+			l.batch = nil
+			go l.dispatch(b)
+		}
+	}
+	l.m.Unlock()
+
+	select {
+	case <-r.done:
+		return r.v, r.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// dispatch fetches the batch once, it is called by the timer and when the batch is full.
+func (l *loader[K, V]) dispatch(b *loaderBatch[K, V]) {
+	l.m.Lock()
+	if b.dispatched {
+		l.m.Unlock()
+		return
+	}
+	b.dispatched = true
+	if l.batch == b {
+		l.batch = nil
+	}
+	l.m.Unlock()
+
+	values, err := l.fetch(l.ctx, b.keys)
+	for i, key := range b.keys {
+		r := b.results[i]
+		r.v, r.err = values[key], err
+		close(r.done)
+	}
+}
+
+func newLoaders(ctx context.Context, ps *service.PostService) *loaders {
+	return &loaders{
+		posts:    newLoader(ctx, ps.PostsByIDs),
+		comments: newLoader(ctx, ps.CommentsByIDs),
+		pages: newLoader(ctx, func(ctx context.Context, keys []commentsPage) (map[commentsPage]*model.CommentsResult, error) {
+			byLimit := make(map[int][]int64)
+			for _, k := range keys {
+				byLimit[k.limit] = append(byLimit[k.limit], k.post)
+			}
+			res := make(map[commentsPage]*model.CommentsResult, len(keys))
+			for limit, ids := range byLimit {
+				pages, err := ps.CommentsOfPosts(ctx, ids, limit)
+				if err != nil {
+					return nil, err
+				}
+				for id, p := range pages {
+					res[commentsPage{post: id, limit: limit}] = p
+				}
+			}
+			return res, nil
+		}),
+	}
+}
+
+// loadersFor returns loaders of the response, or new ones for the only call
+// if the server is made without dataloaders.
+func loadersFor(ctx context.Context, ps *service.PostService) *loaders {
+	if l, ok := ctx.Value(loadersKey{}).(*loaders); ok {
+		return l
+	}
+	return newLoaders(ctx, ps)
+}
+
+func (d dataloaders) ExtensionName() string {
+	return "Dataloaders"
+}
+
+func (d dataloaders) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (d dataloaders) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	return next(context.WithValue(ctx, loadersKey{}, newLoaders(ctx, d.ps)))
+}
+
+func shortPost(p *model.Post) *model.ShortPost {
+	return &model.ShortPost{
+		ID:            p.ID,
+		Author:        p.Author,
+		Title:         p.Title,
+		Time:          p.Time,
+		CommentCount:  p.CommentCount,
+		LastCommentAt: p.LastCommentAt,
+	}
+}
@@ -0,0 +1,200 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/mi-raf/comment-project/internal/api"
+	"github.com/mi-raf/comment-project/internal/database"
+	"github.com/mi-raf/comment-project/internal/models"
+	"github.com/mi-raf/comment-project/internal/seed"
+	"github.com/mi-raf/comment-project/internal/service"
+	"github.com/stretchr/testify/suite"
+)
+
+type (
+	LoaderTestSuite struct {
+		graphSuite
+		p *countingPosts
+		c *countingComments
+	}
+
+	// calls counts calls of repository methods by name.
+	calls struct {
+		m sync.Mutex
+		n map[string]int
+	}
+
+	countingPosts struct {
+		database.PostRepository
+		calls
+	}
+
+	countingComments struct {
+		database.CommentRepository
+		calls
+	}
+)
+
+func (c *calls) add(name string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.n == nil {
+		c.n = make(map[string]int)
+	}
+	c.n[name]++
+}
+
+func (c *calls) count(name string) int {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.n[name]
+}
+
+func (p *countingPosts) Get(ctx context.Context, id int64) (*models.PostDTO, error) {
+	p.add("Get")
+	return p.PostRepository.Get(ctx, id)
+}
+
+func (p *countingPosts) GetManyByIDs(ctx context.Context, ids []int64) ([]*models.PostDTO, error) {
+	p.add("GetManyByIDs")
+	return p.PostRepository.GetManyByIDs(ctx, ids)
+}
+
+func (c *countingComments) GetAllOfPost(ctx context.Context, idPost int64, after models.CommentCursor, limit int) ([]*models.CommentDTO, error) {
+	c.add("GetAllOfPost")
+	return c.CommentRepository.GetAllOfPost(ctx, idPost, after, limit)
+}
+
+func (c *countingComments) GetAllOfPosts(ctx context.Context, ids []int64, limit int) (map[int64][]*models.CommentDTO, error) {
+	c.add("GetAllOfPosts")
+	return c.CommentRepository.GetAllOfPosts(ctx, ids, limit)
+}
+
+func (c *countingComments) GetManyByIDs(ctx context.Context, ids []int64) ([]*models.CommentDTO, error) {
+	c.add("GetManyByIDs")
+	return c.CommentRepository.GetManyByIDs(ctx, ids)
+}
+
+func (s *LoaderTestSuite) SetupTest() {
+	var cancel context.CancelFunc
+	s.ctx, cancel = context.WithCancel(context.Background())
+	p, _, err := database.NewPostRepositoryProvider(s.ctx, &database.PostConfig{InMemory: true})
+	s.NoError(err)
+	ch, closeCh := service.NewCommentChan()
+	c, _, err := database.NewCommentRepositoryProvider(s.ctx, &database.CommentConfig{InMemory: true, CommentChan: ch}, p)
+	s.NoError(err)
+	// seed stores comments with batches, so they are not sent to the channel
+	_, err = seed.Run(s.ctx, p, c, seed.Config{Posts: 5, CommentsPerPost: 10, MaxDepth: 3, Seed: 1})
+	s.NoError(err)
+	s.p = &countingPosts{PostRepository: p}
+	s.c = &countingComments{CommentRepository: c}
+	ps, cleanup := service.NewPostService(s.ctx, s.p, s.c, &service.Config{CommentChan: ch})
+	s.ps = ps
+	s.T().Cleanup(func() {
+		cancel()
+		cleanup()
+		closeCh()
+	})
+}
+
+func (s *LoaderTestSuite) TestNestedLookupsBatched() {
+	// given
+	q := `{ posts(count: 5) { id comments(count: 3) { comments { id postId parentId post { id } parent { id } } } } }`
+
+	// when
+	res := s.query(api.Config{}, q)
+
+	// then
+	s.Empty(res.Errors)
+	var data struct {
+		Posts []struct {
+			ID       string `json:"id"`
+			Comments struct {
+				Comments []struct {
+					ID       string  `json:"id"`
+					PostID   string  `json:"postId"`
+					ParentID *string `json:"parentId"`
+					Post     struct {
+						ID string `json:"id"`
+					} `json:"post"`
+					Parent *struct {
+						ID string `json:"id"`
+					} `json:"parent"`
+				} `json:"comments"`
+			} `json:"comments"`
+		} `json:"posts"`
+	}
+	s.Require().NoError(json.Unmarshal(res.Data, &data))
+	s.Require().Len(data.Posts, 5)
+	parents := 0
+	for _, p := range data.Posts {
+		s.Len(p.Comments.Comments, 3)
+		for _, c := range p.Comments.Comments {
+			s.Equal(p.ID, c.PostID)
+			s.Equal(p.ID, c.Post.ID)
+			if c.ParentID == nil {
+				s.Nil(c.Parent)
+				continue
+			}
+			parents++
+			s.Require().NotNil(c.Parent)
+			s.Equal(*c.ParentID, c.Parent.ID)
+		}
+	}
+	s.Positive(parents)
+	// keys of concurrent resolvers are collected during LOADER_WAIT, so a slow resolver can get the next batch,
+	// but a loader fetches no more than once per level of the query: posts, comments and their post and parent
+	levels := 3
+	s.Equal(0, s.c.count("GetAllOfPost"))
+	s.loadedPerLevel(s.c.count("GetAllOfPosts"), levels)
+	s.loadedPerLevel(s.c.count("GetManyByIDs"), levels)
+	s.loadedPerLevel(s.p.count("GetManyByIDs"), levels)
+}
+
+func (s *LoaderTestSuite) TestAliasedPostsBatched() {
+	// given
+	q := `{ a: post(postId: "1", count: 2) { id comments { comments { id } } }
+	b: post(postId: "2", count: 2) { id comments { comments { id } } } }`
+
+	// when
+	res := s.query(api.Config{}, q)
+
+	// then
+	s.Empty(res.Errors)
+	var data map[string]struct {
+		ID       string `json:"id"`
+		Comments struct {
+			Comments []struct {
+				ID string `json:"id"`
+			} `json:"comments"`
+		} `json:"comments"`
+	}
+	s.Require().NoError(json.Unmarshal(res.Data, &data))
+	for alias, id := range map[string]int64{"a": 1, "b": 2} {
+		want, err := s.ps.Post(s.ctx, id, 2)
+		s.Require().NoError(err)
+		got := data[alias]
+		s.Equal(want.ID, got.ID)
+		s.Require().Len(got.Comments.Comments, len(want.Comments.Comments))
+		for i, c := range want.Comments.Comments {
+			s.Equal(c.ID, got.Comments.Comments[i].ID)
+		}
+	}
+	// post and its comments
+	levels := 2
+	s.loadedPerLevel(s.p.count("GetManyByIDs"), levels)
+	s.loadedPerLevel(s.c.count("GetAllOfPosts"), levels)
+}
+
+func (s *LoaderTestSuite) loadedPerLevel(calls int, levels int) {
+	s.T().Helper()
+	s.Positive(calls)
+	s.LessOrEqual(calls, levels)
+}
+
+func TestLoaderTestSuite(t *testing.T) {
+	suite.Run(t, new(LoaderTestSuite))
+}
@@ -0,0 +1,314 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/gorilla/websocket"
+	"github.com/mi-raf/comment-project/internal/service"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// METRICS_NAMESPACE prefixes names of all metrics.
+const METRICS_NAMESPACE = "comments"
+
+type (
+	// Metrics counts HTTP requests and GraphQL operations and reads stats of the service when it is scraped.
+	Metrics struct {
+		reg        *prometheus.Registry
+		requests   *prometheus.CounterVec
+		durations  *prometheus.HistogramVec
+		operations *prometheus.CounterVec
+		opDuration *prometheus.HistogramVec
+		errors     *prometheus.CounterVec
+		handlers   sync.Map
+	}
+
+	// instrumented are handlers of a mux pattern, websocket connections are not timed.
+	instrumented struct {
+		timed   http.Handler
+		counted http.Handler
+	}
+
+	// operationMetrics is a GraphQL extension which counts operations and their errors.
+	// Clients choose names of operations, so only names of the allowlist are used as labels.
+	operationMetrics struct {
+		m     *Metrics
+		names map[string]struct{}
+	}
+
+	// serviceCollector reads stats of the service on every scrape.
+	serviceCollector struct {
+		ps                   *service.PostService
+		subscriptions        *prometheus.Desc
+		queued               *prometheus.Desc
+		queueCapacity        *prometheus.Desc
+		dropped              *prometheus.Desc
+		listenerConnected    *prometheus.Desc
+		listenerReconnects   *prometheus.Desc
+		cacheHits            *prometheus.Desc
+		cacheMisses          *prometheus.Desc
+		cacheEntries         *prometheus.Desc
+		poolAcquires         *prometheus.Desc
+		poolEmptyAcquires    *prometheus.Desc
+		poolCanceledAcquires *prometheus.Desc
+		poolAcquireSeconds   *prometheus.Desc
+		poolConns            *prometheus.Desc
+		poolMaxConns         *prometheus.Desc
+	}
+)
+
+var (
+	_ graphql.OperationInterceptor = operationMetrics{}
+	_ graphql.ResponseInterceptor  = operationMetrics{}
+)
+
+func NewMetrics(ps *service.PostService) *Metrics {
+	m := &Metrics{
+		reg: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by handler pattern, method and status code.",
+		}, []string{"handler", "method", "code"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "http_request_duration_seconds",
+			Help:      "Durations of HTTP requests except websocket connections.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"handler", "method"}),
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "graphql_operations_total",
+			Help:      "GraphQL operations by name and type.",
+		}, []string{"operation", "type"}),
+		opDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "graphql_operation_duration_seconds",
+			Help:      "Durations of GraphQL queries and mutations from reading of the request to the response.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation", "type"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "graphql_errors_total",
+			Help:      "Errors of GraphQL responses by code.",
+		}, []string{"code"}),
+	}
+	m.reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.durations, m.operations, m.opDuration, m.errors,
+		newServiceCollector(ps),
+	)
+	return m
+}
+
+// Handler serves metrics in the Prometheus format to anyone, NewApi serves them only to admin requests.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.reg, promhttp.HandlerOpts{})
+}
+
+// instrument counts and times requests of the mux by patterns of their handlers.
+func (m *Metrics) instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		if pattern == "" {
+			pattern = "unmatched"
+		}
+		h, ok := m.handlers.Load(pattern)
+		if !ok {
+			labels := prometheus.Labels{"handler": pattern}
+			counted := promhttp.InstrumentHandlerCounter(m.requests.MustCurryWith(labels), mux)
+			h, _ = m.handlers.LoadOrStore(pattern, instrumented{
+				timed:   promhttp.InstrumentHandlerDuration(m.durations.MustCurryWith(labels), counted),
+				counted: counted,
+			})
+		}
+		// a websocket request lasts as long as its subscriptions
+		if websocket.IsWebSocketUpgrade(r) {
+			h.(instrumented).counted.ServeHTTP(w, r)
+			return
+		}
+		h.(instrumented).timed.ServeHTTP(w, r)
+	})
+}
+
+func (o operationMetrics) ExtensionName() string {
+	return "OperationMetrics"
+}
+
+func (o operationMetrics) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+// InterceptOperation counts subscriptions, which get a response for every event.
+func (o operationMetrics) InterceptOperation(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+	if name, typ := o.labels(ctx); typ == string(ast.Subscription) {
+		o.m.operations.WithLabelValues(name, typ).Inc()
+	}
+	return next(ctx)
+}
+
+// InterceptResponse counts and times queries and mutations, including rejected ones, and errors of all responses.
+func (o operationMetrics) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	resp := next(ctx)
+	if resp != nil {
+		for _, err := range resp.Errors {
+			o.m.errors.WithLabelValues(errorCode(err)).Inc()
+		}
+	}
+	name, typ := o.labels(ctx)
+	if typ == string(ast.Subscription) {
+		return resp
+	}
+	o.m.operations.WithLabelValues(name, typ).Inc()
+	if graphql.HasOperationContext(ctx) {
+		o.m.opDuration.WithLabelValues(name, typ).Observe(time.Since(graphql.GetOperationContext(ctx).Stats.OperationStart).Seconds())
+	}
+	return resp
+}
+
+// newOperationMetrics returns the extension which labels operations by names of the allowlist,
+// other named operations are labeled "other".
+func newOperationMetrics(m *Metrics, allowlist map[string]string) operationMetrics {
+	names := make(map[string]struct{})
+	for _, q := range allowlist {
+		doc, err := parser.ParseQuery(&ast.Source{Input: q})
+		if err != nil {
+			continue
+		}
+		for _, op := range doc.Operations {
+			names[op.Name] = struct{}{}
+		}
+	}
+	return operationMetrics{m: m, names: names}
+}
+
+// labels returns operationLabels with the name replaced by "other" if it is not in the allowlist.
+func (o operationMetrics) labels(ctx context.Context) (string, string) {
+	name, typ := operationLabels(ctx)
+	if _, ok := o.names[name]; !ok && name != "anonymous" {
+		name = "other"
+	}
+	return name, typ
+}
+
+// operationLabels returns the name and the type of the operation, the type is "invalid" when it can not be parsed.
+func operationLabels(ctx context.Context) (string, string) {
+	if !graphql.HasOperationContext(ctx) {
+		return "anonymous", "invalid"
+	}
+	oc := graphql.GetOperationContext(ctx)
+	if oc.Operation == nil {
+		return "anonymous", "invalid"
+	}
+	name := oc.Operation.Name
+	if name == "" {
+		name = "anonymous"
+	}
+	return name, string(oc.Operation.Operation)
+}
+
+// errorCode returns the code of the error extensions or the code of the error returned by resolvers.
+func errorCode(err *gqlerror.Error) string {
+	if code, ok := err.Extensions["code"].(string); ok {
+		return code
+	}
+	switch {
+	case errors.Is(err, service.ErrClientData), errors.Is(err, ErrIdIncorrect):
+		return "INVALID_DATA"
+	case errors.Is(err, ErrNotAdmin):
+		return "FORBIDDEN"
+	case errors.Is(err, service.ErrDatabase):
+		return "DATABASE"
+	default:
+		return "INTERNAL"
+	}
+}
+
+func newServiceCollector(ps *service.PostService) *serviceCollector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(METRICS_NAMESPACE, "", name), help, labels, nil)
+	}
+	return &serviceCollector{
+		ps:                   ps,
+		subscriptions:        desc("subscriptions", "Active comment subscriptions by post.", "post"),
+		queued:               desc("comment_queue_length", "Received comments waiting for delivery to subscribers."),
+		queueCapacity:        desc("comment_queue_capacity", "Capacity of the queue of received comments."),
+		dropped:              desc("dropped_comments_total", "Comments which were not sent to slow subscribers, their subscriptions are closed."),
+		listenerConnected:    desc("listener_connected", "Whether the listener of comment notifications is connected."),
+		listenerReconnects:   desc("listener_reconnects_total", "Reconnects of the listener of comment notifications."),
+		cacheHits:            desc("cache_hits_total", "Hits of the posts cache.", "cache"),
+		cacheMisses:          desc("cache_misses_total", "Misses of the posts cache.", "cache"),
+		cacheEntries:         desc("cache_entries", "Entries of the posts cache.", "cache"),
+		poolAcquires:         desc("db_pool_acquires_total", "Connections acquired from the pool.", "pool"),
+		poolEmptyAcquires:    desc("db_pool_empty_acquires_total", "Acquires which waited for a connection because the pool was empty.", "pool"),
+		poolCanceledAcquires: desc("db_pool_canceled_acquires_total", "Acquires canceled by their context.", "pool"),
+		poolAcquireSeconds:   desc("db_pool_acquire_seconds_total", "Total time of acquires.", "pool"),
+		poolConns:            desc("db_pool_connections", "Connections of the pool by state.", "pool", "state"),
+		poolMaxConns:         desc("db_pool_max_connections", "Maximum size of the pool.", "pool"),
+	}
+}
+
+func (c *serviceCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		c.subscriptions, c.queued, c.queueCapacity, c.dropped,
+		c.listenerConnected, c.listenerReconnects,
+		c.cacheHits, c.cacheMisses, c.cacheEntries,
+		c.poolAcquires, c.poolEmptyAcquires, c.poolCanceledAcquires, c.poolAcquireSeconds, c.poolConns, c.poolMaxConns,
+	} {
+		ch <- d
+	}
+}
+
+func (c *serviceCollector) Collect(ch chan<- prometheus.Metric) {
+	metric := func(desc *prometheus.Desc, t prometheus.ValueType, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, t, v, labels...)
+	}
+
+	st := c.ps.SubscriptionStats()
+	for post, n := range st.Subscribers {
+		metric(c.subscriptions, prometheus.GaugeValue, float64(n), strconv.FormatInt(post, 10))
+	}
+	metric(c.queued, prometheus.GaugeValue, float64(st.Queued))
+	metric(c.queueCapacity, prometheus.GaugeValue, float64(st.QueueCapacity))
+	metric(c.dropped, prometheus.CounterValue, float64(st.Dropped))
+
+	if l, ok := c.ps.ListenerStatus(); ok {
+		connected := 0.0
+		if l.Connected {
+			connected = 1
+		}
+		metric(c.listenerConnected, prometheus.GaugeValue, connected)
+		metric(c.listenerReconnects, prometheus.CounterValue, float64(l.Reconnects))
+	}
+
+	if cs, ok := c.ps.CacheStats(); ok {
+		for name, cc := range map[string]service.CacheCounters{"posts": cs.Posts, "pages": cs.Pages} {
+			metric(c.cacheHits, prometheus.CounterValue, float64(cc.Hits), name)
+			metric(c.cacheMisses, prometheus.CounterValue, float64(cc.Misses), name)
+			metric(c.cacheEntries, prometheus.GaugeValue, float64(cc.Entries), name)
+		}
+	}
+
+	for name, p := range c.ps.PoolStats() {
+		metric(c.poolAcquires, prometheus.CounterValue, float64(p.AcquireCount), name)
+		metric(c.poolEmptyAcquires, prometheus.CounterValue, float64(p.EmptyAcquireCount), name)
+		metric(c.poolCanceledAcquires, prometheus.CounterValue, float64(p.CanceledAcquireCount), name)
+		metric(c.poolAcquireSeconds, prometheus.CounterValue, p.AcquireDuration.Seconds(), name)
+		metric(c.poolConns, prometheus.GaugeValue, float64(p.AcquiredConns), name, "acquired")
+		metric(c.poolConns, prometheus.GaugeValue, float64(p.IdleConns), name, "idle")
+		metric(c.poolConns, prometheus.GaugeValue, float64(p.TotalConns), name, "total")
+		metric(c.poolMaxConns, prometheus.GaugeValue, float64(p.MaxConns), name)
+	}
+}
@@ -0,0 +1,123 @@
+package api_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mi-raf/comment-project/internal/api"
+	"github.com/stretchr/testify/suite"
+)
+
+type MetricsTestSuite struct {
+	graphSuite
+}
+
+func (s *MetricsTestSuite) TestOperationsAndErrors() {
+	// given
+	m := api.NewMetrics(s.ps)
+	srv := s.server(api.Config{Metrics: m})
+	defer srv.Close()
+
+	// when
+	s.send(srv.URL, map[string]any{"query": titleQuery})
+	s.send(srv.URL, map[string]any{"query": titleQuery})
+	s.send(srv.URL, map[string]any{"query": `{ post(postId: "first") { title } }`})
+	s.send(srv.URL, map[string]any{"query": `{ post { title } }`})
+
+	// then
+	body := s.scrape(m)
+	s.Contains(body, `comments_graphql_operations_total{operation="other",type="query"} 2`)
+	s.Contains(body, `comments_graphql_operations_total{operation="anonymous",type="query"} 1`)
+	s.Contains(body, `comments_graphql_operations_total{operation="anonymous",type="invalid"} 1`)
+	s.Contains(body, `comments_graphql_operation_duration_seconds_count{operation="other",type="query"} 2`)
+	s.NotContains(body, `operation="Title"`)
+	s.Contains(body, `comments_graphql_errors_total{code="INVALID_DATA"} 1`)
+	s.Contains(body, `comments_graphql_errors_total{code="GRAPHQL_VALIDATION_FAILED"} 1`)
+}
+
+func (s *MetricsTestSuite) TestOperationsOfAllowlist() {
+	// given
+	m := api.NewMetrics(s.ps)
+	srv := s.server(api.Config{Metrics: m, Allowlist: map[string]string{hash(titleQuery): titleQuery}})
+	defer srv.Close()
+	ext := map[string]any{"persistedQuery": map[string]any{"version": 1, "sha256Hash": hash(titleQuery)}}
+
+	// when
+	s.send(srv.URL, map[string]any{"extensions": ext})
+	s.send(srv.URL, map[string]any{"query": `query Other { post(postId: "1") { title } }`})
+
+	// then
+	body := s.scrape(m)
+	s.Contains(body, `comments_graphql_operations_total{operation="Title",type="query"} 1`)
+	s.Contains(body, `comments_graphql_errors_total{code="PERSISTED_QUERY_NOT_ALLOWED"} 1`)
+	s.NotContains(body, `operation="Other"`)
+}
+
+func (s *MetricsTestSuite) TestMetricsForAdmin() {
+	// given
+	addr := s.serve(api.Config{Metrics: api.NewMetrics(s.ps), AdminToken: "secret"})
+	url := "http://" + addr + "/metrics"
+
+	for _, tc := range []struct {
+		name string
+		auth string
+		code int
+	}{
+		{name: "admin", auth: "Bearer secret", code: http.StatusOK},
+		{name: "wrong token", auth: "Bearer wrong", code: http.StatusForbidden},
+		{name: "no token", code: http.StatusForbidden},
+	} {
+		s.Run(tc.name, func() {
+			req, err := http.NewRequest(http.MethodGet, url, nil)
+			s.Require().NoError(err)
+			if tc.auth != "" {
+				req.Header.Set("Authorization", tc.auth)
+			}
+
+			// when
+			resp, err := http.DefaultClient.Do(req)
+
+			// then
+			s.Require().NoError(err)
+			resp.Body.Close()
+			s.Equal(tc.code, resp.StatusCode)
+		})
+	}
+}
+
+func (s *MetricsTestSuite) TestServiceStats() {
+	// given
+	m := api.NewMetrics(s.ps)
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+
+	// when
+	s.ps.CommentSubscribe(ctx, 1)
+	s.ps.CommentSubscribe(ctx, 1)
+
+	// then
+	body := s.scrape(m)
+	s.Contains(body, `comments_subscriptions{post="1"} 2`)
+	s.Contains(body, `comments_comment_queue_length 0`)
+	s.Contains(body, `comments_comment_queue_capacity 10`)
+	s.Contains(body, `comments_dropped_comments_total 0`)
+	s.NotContains(body, `comments_listener_connected`)
+}
+
+func (s *MetricsTestSuite) scrape(m *api.Metrics) string {
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	s.Require().NoError(err)
+	return string(body)
+}
+
+func TestMetricsTestSuite(t *testing.T) {
+	suite.Run(t, new(MetricsTestSuite))
+}
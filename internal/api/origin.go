@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mi-raf/comment-project/internal/logging"
+)
+
+// origins is an allowlist of request origins. An origin is "scheme://host[:port]",
+// "*" allows any origin and "https://*.example.com" allows subdomains of the domain.
+type origins []string
+
+// allowed reports whether the origin is in the list.
+func (o origins) allowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, a := range o {
+		a = strings.ToLower(a)
+		if a == "*" || a == origin {
+			return true
+		}
+		if scheme, domain, ok := strings.Cut(a, "://*."); ok {
+			if host, ok := strings.CutPrefix(origin, scheme+"://"); ok && strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fromAllowed reports whether the request has no origin, e.g. it is not sent by a browser,
+// or it is sent from the host of the server or from an origin of the list.
+func (o origins) fromAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return o.allowed(origin)
+}
+
+// checkOrigin is CheckOrigin of the websocket upgrader.
+func (o origins) checkOrigin(r *http.Request) bool {
+	if o.fromAllowed(r) {
+		return true
+	}
+	logging.Ctx(r.Context()).Warn().Str("origin", r.Header.Get("Origin")).Msg("websocket origin is not allowed")
+	return false
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const (
+	errNotAllowed = "PERSISTED_QUERY_NOT_ALLOWED"
+	// apolloManifestFormat is the format of manifests generated by Apollo tools.
+	apolloManifestFormat = "apollo-persisted-query-manifest"
+)
+
+var ErrManifest = errors.New("incorrect persisted queries manifest")
+
+type (
+	// allowlist executes only operations of the manifest. Clients send the hash of the operation
+	// in the persistedQuery extension like for automatic persisted queries, the query text may be omitted.
+	allowlist struct {
+		queries map[string]string
+	}
+
+	apolloManifest struct {
+		Format     string `json:"format"`
+		Version    int    `json:"version"`
+		Operations []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+			Body string `json:"body"`
+		} `json:"operations"`
+	}
+)
+
+var _ graphql.OperationParameterMutator = allowlist{}
+
+// LoadAllowlist reads operations from a manifest, which is either an Apollo persisted query manifest
+// or a JSON object of queries by their hashes. Ids of operations should be SHA-256 of their text.
+func LoadAllowlist(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var head struct {
+		Format string `json:"format"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrManifest, err)
+	}
+
+	queries := make(map[string]string)
+	if head.Format == apolloManifestFormat {
+		var m apolloManifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrManifest, err)
+		}
+		if m.Version != 1 {
+			return nil, fmt.Errorf("%w: unsupported version %d", ErrManifest, m.Version)
+		}
+		for _, op := range m.Operations {
+			queries[op.ID] = op.Body
+		}
+	} else if err := json.Unmarshal(data, &queries); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrManifest, err)
+	}
+
+	for id, q := range queries {
+		if queryHash(q) != id {
+			return nil, fmt.Errorf("%w: id %s is not SHA-256 of the operation", ErrManifest, id)
+		}
+	}
+	return queries, nil
+}
+
+func (a allowlist) ExtensionName() string {
+	return "OperationAllowlist"
+}
+
+func (a allowlist) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (a allowlist) MutateOperationParameters(ctx context.Context, p *graphql.RawParams) *gqlerror.Error {
+	hash := queryHash(p.Query)
+	if ext, ok := p.Extensions["persistedQuery"].(map[string]any); ok {
+		h, _ := ext["sha256Hash"].(string)
+		if p.Query != "" && h != hash {
+			return gqlerror.Errorf("provided APQ hash does not match query")
+		}
+		hash = h
+	}
+	q, ok := a.queries[hash]
+	if !ok {
+		err := gqlerror.Errorf("operation is not allowed")
+		errcode.Set(err, errNotAllowed)
+		return err
+	}
+	p.Query = q
+	return nil
+}
+
+func queryHash(q string) string {
+	h := sha256.Sum256([]byte(q))
+	return hex.EncodeToString(h[:])
+}
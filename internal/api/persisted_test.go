@@ -0,0 +1,120 @@
+package api_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mi-raf/comment-project/internal/api"
+	"github.com/stretchr/testify/suite"
+)
+
+const titleQuery = `query Title { post(postId: "1") { title } }`
+
+type PersistedTestSuite struct {
+	graphSuite
+}
+
+func (s *PersistedTestSuite) TestAutomaticPersistedQuery() {
+	// given
+	srv := s.server(api.Config{APQCacheSize: 10})
+	defer srv.Close()
+	ext := map[string]any{"persistedQuery": map[string]any{"version": 1, "sha256Hash": hash(titleQuery)}}
+
+	// when
+	missed := s.send(srv.URL, map[string]any{"extensions": ext})
+	registered := s.send(srv.URL, map[string]any{"query": titleQuery, "extensions": ext})
+	res := s.send(srv.URL, map[string]any{"extensions": ext})
+
+	// then
+	s.Require().Len(missed.Errors, 1)
+	s.Equal("PERSISTED_QUERY_NOT_FOUND", missed.Errors[0].Extensions.Code)
+	s.Empty(registered.Errors)
+	s.Empty(res.Errors)
+	s.JSONEq(`{"post": {"title": "title"}}`, string(res.Data))
+}
+
+func (s *PersistedTestSuite) TestAllowlist() {
+	// given
+	srv := s.server(api.Config{APQCacheSize: 10, Allowlist: map[string]string{hash(titleQuery): titleQuery}})
+	defer srv.Close()
+
+	// when
+	byHash := s.send(srv.URL, map[string]any{"extensions": map[string]any{"persistedQuery": map[string]any{"version": 1, "sha256Hash": hash(titleQuery)}}})
+	byText := s.send(srv.URL, map[string]any{"query": titleQuery})
+	other := s.send(srv.URL, map[string]any{"query": `{ post(postId: "1") { text } }`})
+
+	// then
+	s.Empty(byHash.Errors)
+	s.JSONEq(`{"post": {"title": "title"}}`, string(byHash.Data))
+	s.Empty(byText.Errors)
+	s.Require().Len(other.Errors, 1)
+	s.Equal("PERSISTED_QUERY_NOT_ALLOWED", other.Errors[0].Extensions.Code)
+}
+
+func (s *PersistedTestSuite) TestIntrospectionDisabled() {
+	// when
+	res := s.query(api.Config{}, `{ __schema { queryType { name } } }`)
+
+	// then
+	s.NotEmpty(res.Errors)
+}
+
+func (s *PersistedTestSuite) TestLoadApolloManifest() {
+	// given
+	path := s.manifest(map[string]any{
+		"format":     "apollo-persisted-query-manifest",
+		"version":    1,
+		"operations": []map[string]any{{"id": hash(titleQuery), "name": "Title", "type": "query", "body": titleQuery}},
+	})
+
+	// when
+	queries, err := api.LoadAllowlist(path)
+
+	// then
+	s.NoError(err)
+	s.Equal(map[string]string{hash(titleQuery): titleQuery}, queries)
+}
+
+func (s *PersistedTestSuite) TestLoadManifestOfHashes() {
+	// given
+	path := s.manifest(map[string]string{hash(titleQuery): titleQuery})
+
+	// when
+	queries, err := api.LoadAllowlist(path)
+
+	// then
+	s.NoError(err)
+	s.Equal(map[string]string{hash(titleQuery): titleQuery}, queries)
+}
+
+func (s *PersistedTestSuite) TestLoadManifestIncorrectHash() {
+	// given
+	path := s.manifest(map[string]string{"Title": titleQuery})
+
+	// when
+	_, err := api.LoadAllowlist(path)
+
+	// then
+	s.ErrorIs(err, api.ErrManifest)
+}
+
+func (s *PersistedTestSuite) manifest(m any) string {
+	data, err := json.Marshal(m)
+	s.Require().NoError(err)
+	path := filepath.Join(s.T().TempDir(), "manifest.json")
+	s.Require().NoError(os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func hash(q string) string {
+	h := sha256.Sum256([]byte(q))
+	return hex.EncodeToString(h[:])
+}
+
+func TestPersistedTestSuite(t *testing.T) {
+	suite.Run(t, new(PersistedTestSuite))
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/mi-raf/comment-project/internal/service"
+)
+
+// READINESS_TIMEOUT limits checks of one readiness request.
+const READINESS_TIMEOUT = 2 * time.Second
+
+const (
+	STATUS_READY         = "READY"
+	STATUS_NOT_READY     = "NOT_READY"
+	STATUS_SHUTTING_DOWN = "SHUTTING_DOWN"
+)
+
+type (
+	// Probes serve liveness and readiness of the service for orchestrators and load balancers.
+	Probes struct {
+		ps       *service.PostService
+		draining atomic.Bool
+	}
+
+	readiness struct {
+		Status string                         `json:"status"`
+		Checks map[string]service.CheckResult `json:"checks"`
+	}
+)
+
+func NewProbes(ps *service.PostService) *Probes {
+	return &Probes{ps: ps}
+}
+
+// Drain makes the service not ready, so load balancers stop sending new requests before shutdown.
+func (p *Probes) Drain() {
+	p.draining.Store(true)
+}
+
+// Livez reports that the process is able to serve requests, dependencies are not checked.
+func (p *Probes) Livez(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "OK"})
+}
+
+// Readyz runs readiness checks and fails if any of them fails or the service is shutting down.
+func (p *Probes) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), READINESS_TIMEOUT)
+	defer cancel()
+	res := readiness{Status: STATUS_READY, Checks: p.ps.Readiness(ctx)}
+	code := http.StatusOK
+	for _, c := range res.Checks {
+		if c.Status != service.CHECK_OK {
+			res.Status = STATUS_NOT_READY
+			code = http.StatusServiceUnavailable
+		}
+	}
+	if p.draining.Load() {
+		res.Status = STATUS_SHUTTING_DOWN
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, res)
+}
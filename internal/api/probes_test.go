@@ -0,0 +1,92 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mi-raf/comment-project/internal/api"
+	"github.com/mi-raf/comment-project/internal/service"
+	"github.com/stretchr/testify/suite"
+)
+
+type ProbesTestSuite struct {
+	graphSuite
+}
+
+type readinessResponse struct {
+	Status string                         `json:"status"`
+	Checks map[string]service.CheckResult `json:"checks"`
+}
+
+func (s *ProbesTestSuite) TestReady() {
+	// given
+	p := api.NewProbes(s.ps)
+
+	// when
+	code, res := s.probe(p.Readyz)
+
+	// then
+	s.Equal(http.StatusOK, code)
+	s.Equal(api.STATUS_READY, res.Status)
+	s.Equal(service.CHECK_OK, res.Checks["hub"].Status)
+	s.NotContains(res.Checks, "database")
+}
+
+func (s *ProbesTestSuite) TestNotReadyWhileDraining() {
+	// given
+	p := api.NewProbes(s.ps)
+
+	// when
+	p.Drain()
+	code, res := s.probe(p.Readyz)
+	liveCode, _ := s.probe(p.Livez)
+
+	// then
+	s.Equal(http.StatusServiceUnavailable, code)
+	s.Equal(api.STATUS_SHUTTING_DOWN, res.Status)
+	s.Equal(http.StatusOK, liveCode)
+}
+
+func (s *ProbesTestSuite) TestHealthcheck() {
+	// given
+	url := "http://" + s.serve(api.Config{})
+
+	// when
+	resp, err := http.Get(url + "/healthcheck")
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+
+	// then
+	s.NoError(err)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("OK", string(body))
+}
+
+func (s *ProbesTestSuite) TestHealthcheckV2Removed() {
+	// given
+	url := "http://" + s.serve(api.Config{})
+
+	// when
+	resp, err := http.Get(url + "/v2/healthcheck")
+	s.Require().NoError(err)
+	resp.Body.Close()
+
+	// then
+	s.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func (s *ProbesTestSuite) probe(h http.HandlerFunc) (int, readinessResponse) {
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	var res readinessResponse
+	s.Require().NoError(json.NewDecoder(rec.Body).Decode(&res))
+	return rec.Code, res
+}
+
+func TestProbesTestSuite(t *testing.T) {
+	suite.Run(t, new(ProbesTestSuite))
+}
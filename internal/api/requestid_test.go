@@ -0,0 +1,63 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mi-raf/comment-project/internal/api"
+	"github.com/mi-raf/comment-project/internal/logging"
+	"github.com/stretchr/testify/suite"
+)
+
+type RequestIDTestSuite struct {
+	graphSuite
+}
+
+func (s *RequestIDTestSuite) TestResolverErrorHasRequestID() {
+	// when
+	res, header := s.postWithID(`{ post(postId: "first") { title } }`, "request-1")
+
+	// then
+	s.Equal("request-1", header.Get(logging.REQUEST_ID_HEADER))
+	s.Require().Len(res.Errors, 1)
+	s.Equal("request-1", res.Errors[0].Extensions.RequestID)
+}
+
+func (s *RequestIDTestSuite) TestValidationErrorHasGeneratedRequestID() {
+	// when
+	res, header := s.postWithID(`{ post { title } }`, "")
+
+	// then
+	id := header.Get(logging.REQUEST_ID_HEADER)
+	s.Len(id, 32)
+	s.Require().NotEmpty(res.Errors)
+	for _, e := range res.Errors {
+		s.Equal(id, e.Extensions.RequestID)
+	}
+}
+
+func (s *RequestIDTestSuite) postWithID(q string, id string) (graphResponse, http.Header) {
+	srv := httptest.NewServer(logging.Middleware(s.handler(api.Config{})))
+	defer srv.Close()
+	body, err := json.Marshal(map[string]any{"query": q})
+	s.Require().NoError(err)
+	req, err := http.NewRequest(http.MethodPost, srv.URL, bytes.NewReader(body))
+	s.Require().NoError(err)
+	req.Header.Set("Content-Type", "application/json")
+	if id != "" {
+		req.Header.Set(logging.REQUEST_ID_HEADER, id)
+	}
+	resp, err := srv.Client().Do(req)
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+	var res graphResponse
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&res))
+	return res, resp.Header
+}
+
+func TestRequestIDTestSuite(t *testing.T) {
+	suite.Run(t, new(RequestIDTestSuite))
+}
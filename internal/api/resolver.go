@@ -2,16 +2,20 @@ package api
 
 //go:generate go run github.com/99designs/gqlgen generate
 
-import "github.com/mi-raf/comment-project/internal/service"
+import (
+	"github.com/mi-raf/comment-project/internal/service"
+	"github.com/mi-raf/comment-project/internal/webhook"
+)
 
 // This file will not be regenerated automatically.
 //
 // It serves as dependency injection for your app, add any dependencies you require here.
 
 type Resolver struct {
-	ps *service.PostService
+	ps    *service.PostService
+	hooks *webhook.Dispatcher
 }
 
-func NewResolver(ps *service.PostService) *Resolver {
-	return &Resolver{ps: ps}
+func NewResolver(ps *service.PostService, hooks *webhook.Dispatcher) *Resolver {
+	return &Resolver{ps: ps, hooks: hooks}
 }
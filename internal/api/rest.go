@@ -0,0 +1,367 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mi-raf/comment-project/graph/model"
+	"github.com/mi-raf/comment-project/internal/logging"
+	"github.com/mi-raf/comment-project/internal/service"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	REST_PREFIX   = "/api/v1"
+	MAX_BODY_SIZE = 1 << 20
+
+	jsonType = "application/json; charset=utf-8"
+)
+
+//go:embed openapi.yaml
+var openapi []byte
+
+type (
+	rest struct {
+		ps *service.PostService
+	}
+
+	restError struct {
+		Error restErrorBody `json:"error"`
+	}
+
+	restErrorBody struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+
+	restNewComment struct {
+		ParentID *string    `json:"parentId"`
+		Author   string     `json:"author"`
+		Text     string     `json:"text"`
+		Time     *time.Time `json:"time"`
+	}
+
+	restCreated struct {
+		ID     string `json:"id"`
+		PostID string `json:"postId"`
+	}
+
+	// restShortPost, restPost and restComment are documented in openapi.yaml,
+	// so they are separate from GraphQL models whose changes should not change the REST API.
+	restShortPost struct {
+		ID            string     `json:"id"`
+		Author        string     `json:"author"`
+		Title         string     `json:"title"`
+		Time          time.Time  `json:"time"`
+		CommentCount  int        `json:"commentCount"`
+		LastCommentAt *time.Time `json:"lastCommentAt,omitempty"`
+	}
+
+	restPost struct {
+		ID            string       `json:"id"`
+		Author        string       `json:"author"`
+		Title         string       `json:"title"`
+		Text          string       `json:"text"`
+		Time          time.Time    `json:"time"`
+		IsCommentable bool         `json:"isCommentable"`
+		CommentCount  int          `json:"commentCount"`
+		LastCommentAt *time.Time   `json:"lastCommentAt,omitempty"`
+		Comments      restComments `json:"comments"`
+	}
+
+	restComments struct {
+		Comments []restComment `json:"comments"`
+		PageInfo restPageInfo  `json:"pageInfo"`
+	}
+
+	restPageInfo struct {
+		EndCursor *string `json:"endCursor,omitempty"`
+	}
+
+	restComment struct {
+		ID            string          `json:"id"`
+		ParentID      *string         `json:"parentId,omitempty"`
+		Level         int             `json:"level"`
+		Comment       restCommentBody `json:"comment"`
+		PostID        string          `json:"postId"`
+		Pinned        bool            `json:"pinned"`
+		AuthorReplied bool            `json:"authorReplied"`
+	}
+
+	restCommentBody struct {
+		Author string    `json:"author"`
+		Text   string    `json:"text"`
+		Time   time.Time `json:"time"`
+	}
+)
+
+func registerRest(mux *http.ServeMux, ps *service.PostService) {
+	a := &rest{ps: ps}
+	mux.HandleFunc("GET "+REST_PREFIX+"/openapi.yaml", serveOpenAPI)
+	mux.HandleFunc("GET "+REST_PREFIX+"/posts", a.posts)
+	mux.HandleFunc("GET "+REST_PREFIX+"/posts/{id}", a.post)
+	mux.HandleFunc("GET "+REST_PREFIX+"/posts/{id}/comments", a.comments)
+	mux.HandleFunc("POST "+REST_PREFIX+"/posts/{id}/comments", a.createComment)
+	// the catch-all pattern matches any method, so the mux does not answer 405 itself
+	mux.HandleFunc(REST_PREFIX+"/", func(w http.ResponseWriter, r *http.Request) {
+		if allow := allowedMethods(mux, r); len(allow) > 0 {
+			w.Header().Set("Allow", strings.Join(allow, ", "))
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", fmt.Sprintf("method %s is not allowed", r.Method))
+			return
+		}
+		writeError(w, http.StatusNotFound, "not_found", "no such endpoint")
+	})
+}
+
+// allowedMethods returns methods of other patterns of the mux which match the path of the request.
+func allowedMethods(mux *http.ServeMux, r *http.Request) []string {
+	var res []string
+	for _, m := range []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		probe := &http.Request{Method: m, URL: r.URL, Host: r.Host}
+		if _, pattern := mux.Handler(probe); pattern != "" && pattern != REST_PREFIX+"/" {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	if _, err := w.Write(openapi); err != nil {
+		logging.Ctx(r.Context()).Error().Err(err).Msg("can not write openapi document")
+	}
+}
+
+func (a *rest) posts(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := restPage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	order := model.PostOrderTime
+	if o := r.URL.Query().Get("orderBy"); o != "" {
+		order = model.PostOrder(strings.ToUpper(o))
+		if !order.IsValid() {
+			writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("incorrect orderBy %q", o))
+			return
+		}
+	}
+	posts, err := a.ps.GetAllPosts(r.Context(), offset, limit, order)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	setNextCursor(w, r, strconv.FormatInt(offset+int64(len(posts)), 10), limit, len(posts))
+	writeJSON(w, http.StatusOK, mapRestShortPosts(posts))
+}
+
+func (a *rest) post(w http.ResponseWriter, r *http.Request) {
+	postID, err := getFromStr(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", ErrIdIncorrect.Error())
+		return
+	}
+	var limit int
+	if c := r.URL.Query().Get("limit"); c != "" {
+		if limit, err = strconv.Atoi(c); err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("incorrect limit %q", c))
+			return
+		}
+	}
+	post, err := a.ps.Post(r.Context(), postID, limit)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	if post == nil {
+		writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("no post %d", postID))
+		return
+	}
+	writeJSON(w, http.StatusOK, mapRestPost(post))
+}
+
+func (a *rest) comments(w http.ResponseWriter, r *http.Request) {
+	postID, err := getFromStr(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", ErrIdIncorrect.Error())
+		return
+	}
+	limit, err := restLimit(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	cs, err := a.ps.Comments(r.Context(), postID, limit, r.URL.Query().Get("after"))
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	if cs.PageInfo != nil && cs.PageInfo.EndCursor != nil {
+		setNextCursor(w, r, *cs.PageInfo.EndCursor, limit, len(cs.Comments))
+	}
+	writeJSON(w, http.StatusOK, mapRestComments(cs).Comments)
+}
+
+func (a *rest) createComment(w http.ResponseWriter, r *http.Request) {
+	postID, err := getFromStr(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", ErrIdIncorrect.Error())
+		return
+	}
+	var in restNewComment
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_BODY_SIZE))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("incorrect body: %v", err))
+		return
+	}
+	if in.Author == "" || in.Text == "" {
+		writeError(w, http.StatusBadRequest, "bad_request", "author and text are required")
+		return
+	}
+	var parent int64
+	if in.ParentID != nil {
+		if parent, err = getFromStr(*in.ParentID); err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", ErrIdIncorrect.Error())
+			return
+		}
+	}
+	t := time.Now()
+	if in.Time != nil {
+		t = *in.Time
+	}
+
+	id, err := a.ps.CreateComment(r.Context(), postID, parent, model.NewComment{Author: in.Author, Text: in.Text, Time: t})
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, restCreated{ID: strconv.FormatInt(id, 10), PostID: strconv.FormatInt(postID, 10)})
+}
+
+func mapRestShortPosts(posts []*model.ShortPost) []restShortPost {
+	res := make([]restShortPost, 0, len(posts))
+	for _, p := range posts {
+		res = append(res, restShortPost{
+			ID:            p.ID,
+			Author:        p.Author,
+			Title:         p.Title,
+			Time:          p.Time,
+			CommentCount:  p.CommentCount,
+			LastCommentAt: p.LastCommentAt,
+		})
+	}
+	return res
+}
+
+func mapRestPost(p *model.Post) restPost {
+	return restPost{
+		ID:            p.ID,
+		Author:        p.Author,
+		Title:         p.Title,
+		Text:          p.Text,
+		Time:          p.Time,
+		IsCommentable: p.IsCommentable,
+		CommentCount:  p.CommentCount,
+		LastCommentAt: p.LastCommentAt,
+		Comments:      mapRestComments(p.Comments),
+	}
+}
+
+// mapRestComments maps a page of comments, a nil page is an empty one.
+func mapRestComments(cs *model.CommentsResult) restComments {
+	res := restComments{Comments: []restComment{}}
+	if cs == nil {
+		return res
+	}
+	if cs.PageInfo != nil {
+		res.PageInfo.EndCursor = cs.PageInfo.EndCursor
+	}
+	for _, c := range cs.Comments {
+		rc := restComment{
+			ID:            c.ID,
+			ParentID:      c.ParentID,
+			Level:         c.Level,
+			PostID:        c.PostID,
+			Pinned:        c.Pinned,
+			AuthorReplied: c.AuthorReplied,
+		}
+		if c.Comment != nil {
+			rc.Comment = restCommentBody{Author: c.Comment.Author, Text: c.Comment.Text, Time: c.Comment.Time}
+		}
+		res.Comments = append(res.Comments, rc)
+	}
+	return res
+}
+
+// restPage reads offset cursor and page size from after and limit query parameters.
+func restPage(r *http.Request) (int64, int, error) {
+	var offset int64
+	if a := r.URL.Query().Get("after"); a != "" {
+		v, err := strconv.ParseInt(a, 10, 64)
+		if err != nil || v < 0 {
+			return 0, 0, fmt.Errorf("incorrect after %q", a)
+		}
+		offset = v
+	}
+	limit, err := restLimit(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	return offset, limit, nil
+}
+
+// restLimit reads page size from the limit query parameter.
+func restLimit(r *http.Request) (int, error) {
+	limit := service.MAX_LIMIT
+	if l := r.URL.Query().Get("limit"); l != "" {
+		v, err := strconv.Atoi(l)
+		if err != nil || v < 1 || v > service.MAX_LIMIT {
+			return 0, fmt.Errorf("incorrect limit %q", l)
+		}
+		limit = v
+	}
+	return limit, nil
+}
+
+// setNextCursor sets X-Next-Cursor and Link headers with the cursor next when there can be one more page.
+func setNextCursor(w http.ResponseWriter, r *http.Request, next string, limit int, size int) {
+	if size < limit {
+		return
+	}
+	q := r.URL.Query()
+	q.Set("after", next)
+	w.Header().Set("X-Next-Cursor", next)
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", absoluteURL(r, r.URL.Path, q)))
+}
+
+// writeServiceError maps errors of the service to statuses, messages of internal errors are only logged.
+func writeServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, service.ErrPostNotFound):
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, service.ErrClientData):
+		writeError(w, http.StatusUnprocessableEntity, "invalid_data", err.Error())
+	default:
+		logging.Ctx(r.Context()).Error().Err(err).Str("path", r.URL.Path).Msg("rest request failed")
+		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	writeJSON(w, status, restError{Error: restErrorBody{Code: code, Message: message}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", jsonType)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Error().Err(err).Msg("can not write json response")
+	}
+}
@@ -0,0 +1,237 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mi-raf/comment-project/graph/model"
+	"github.com/mi-raf/comment-project/internal/api"
+	"github.com/mi-raf/comment-project/internal/service"
+	"github.com/stretchr/testify/suite"
+)
+
+type (
+	RestTestSuite struct {
+		graphSuite
+		url string
+	}
+
+	restError struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+)
+
+func (s *RestTestSuite) SetupTest() {
+	s.graphSuite.SetupTest()
+	s.url = "http://" + s.serve(api.Config{}) + api.REST_PREFIX
+}
+
+func (s *RestTestSuite) TestOpenAPI() {
+	// when
+	resp, body := s.do(http.MethodGet, "/openapi.yaml", "")
+
+	// then
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("application/yaml", resp.Header.Get("Content-Type"))
+	s.True(strings.HasPrefix(body, "openapi: 3.0.3"))
+	for _, path := range []string{"/posts:", "/posts/{id}:", "/posts/{id}/comments:"} {
+		s.Contains(body, "\n  "+path+"\n", path)
+	}
+}
+
+func (s *RestTestSuite) TestPosts() {
+	// given
+	s.createPost(true)
+
+	// when
+	resp, body := s.do(http.MethodGet, "/posts?limit=1", "")
+	next, _ := s.do(http.MethodGet, "/posts?limit=1&after="+resp.Header.Get("X-Next-Cursor"), "")
+	all, _ := s.do(http.MethodGet, "/posts?limit=3", "")
+
+	// then
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("application/json; charset=utf-8", resp.Header.Get("Content-Type"))
+	var posts []model.ShortPost
+	s.Require().NoError(json.Unmarshal([]byte(body), &posts))
+	s.Len(posts, 1)
+	s.Equal("1", resp.Header.Get("X-Next-Cursor"))
+	s.Equal(`<`+s.url+`/posts?after=1&limit=1>; rel="next"`, resp.Header.Get("Link"))
+	s.Equal("2", next.Header.Get("X-Next-Cursor"))
+	s.Empty(all.Header.Get("X-Next-Cursor"))
+	s.Empty(all.Header.Get("Link"))
+}
+
+func (s *RestTestSuite) TestPost() {
+	// given
+	_, err := s.ps.CreateComment(s.ctx, 1, 0, model.NewComment{Author: "author", Text: "text", Time: time.Now()})
+	s.Require().NoError(err)
+
+	// when
+	resp, body := s.do(http.MethodGet, "/posts/1", "")
+
+	// then
+	s.Equal(http.StatusOK, resp.StatusCode)
+	var post map[string]any
+	s.Require().NoError(json.Unmarshal([]byte(body), &post))
+	s.Equal("1", post["id"])
+	s.Equal("title", post["title"])
+	s.ElementsMatch([]string{"id", "author", "title", "text", "time", "isCommentable", "commentCount", "lastCommentAt", "comments"}, keys(post))
+	comments := post["comments"].(map[string]any)["comments"].([]any)
+	s.Require().Len(comments, 1)
+	s.ElementsMatch([]string{"id", "level", "comment", "postId", "pinned", "authorReplied"}, keys(comments[0].(map[string]any)))
+}
+
+func (s *RestTestSuite) TestComments() {
+	// given
+	for _, text := range []string{"first", "second"} {
+		_, err := s.ps.CreateComment(s.ctx, 1, 0, model.NewComment{Author: "author", Text: text, Time: time.Now()})
+		s.Require().NoError(err)
+	}
+
+	// when
+	first, firstBody := s.do(http.MethodGet, "/posts/1/comments?limit=1", "")
+	second, secondBody := s.do(http.MethodGet, "/posts/1/comments?limit=1&after="+first.Header.Get("X-Next-Cursor"), "")
+
+	// then
+	s.Equal(http.StatusOK, first.StatusCode)
+	s.Equal([]string{"first"}, s.commentTexts(firstBody))
+	s.Equal(http.StatusOK, second.StatusCode)
+	s.Equal([]string{"second"}, s.commentTexts(secondBody))
+}
+
+func (s *RestTestSuite) TestCreateComment() {
+	// when
+	resp, body := s.do(http.MethodPost, "/posts/1/comments", `{"author": "author", "text": "text"}`)
+
+	// then
+	s.Equal(http.StatusCreated, resp.StatusCode)
+	var created struct {
+		ID     string `json:"id"`
+		PostID string `json:"postId"`
+	}
+	s.Require().NoError(json.Unmarshal([]byte(body), &created))
+	s.NotEmpty(created.ID)
+	s.Equal("1", created.PostID)
+	_, comments := s.do(http.MethodGet, "/posts/1/comments", "")
+	s.Equal([]string{"text"}, s.commentTexts(comments))
+}
+
+func (s *RestTestSuite) TestErrors() {
+	// given
+	closed := s.createPost(false)
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		code   string
+	}{
+		{name: "incorrect limit", method: http.MethodGet, path: "/posts?limit=0", status: http.StatusBadRequest, code: "bad_request"},
+		{name: "incorrect cursor", method: http.MethodGet, path: "/posts?after=-1", status: http.StatusBadRequest, code: "bad_request"},
+		{name: "incorrect order", method: http.MethodGet, path: "/posts?orderBy=title", status: http.StatusBadRequest, code: "bad_request"},
+		{name: "incorrect id", method: http.MethodGet, path: "/posts/abc", status: http.StatusBadRequest, code: "bad_request"},
+		{name: "missing post", method: http.MethodGet, path: "/posts/100", status: http.StatusNotFound, code: "not_found"},
+		{name: "comments of incorrect id", method: http.MethodGet, path: "/posts/abc/comments", status: http.StatusBadRequest, code: "bad_request"},
+		{name: "unknown endpoint", method: http.MethodGet, path: "/authors", status: http.StatusNotFound, code: "not_found"},
+		{name: "unknown method", method: http.MethodDelete, path: "/posts/1", status: http.StatusMethodNotAllowed, code: "method_not_allowed"},
+		{name: "comment of missing post", method: http.MethodPost, path: "/posts/100/comments", body: `{"author": "a", "text": "t"}`, status: http.StatusNotFound, code: "not_found"},
+		{name: "comment of closed post", method: http.MethodPost, path: "/posts/" + closed + "/comments", body: `{"author": "a", "text": "t"}`, status: http.StatusUnprocessableEntity, code: "invalid_data"},
+		{name: "incorrect body", method: http.MethodPost, path: "/posts/1/comments", body: `{"author": `, status: http.StatusBadRequest, code: "bad_request"},
+		{name: "unknown field", method: http.MethodPost, path: "/posts/1/comments", body: `{"author": "a", "text": "t", "title": "t"}`, status: http.StatusBadRequest, code: "bad_request"},
+		{name: "empty text", method: http.MethodPost, path: "/posts/1/comments", body: `{"author": "a", "text": ""}`, status: http.StatusBadRequest, code: "bad_request"},
+		{name: "too long text", method: http.MethodPost, path: "/posts/1/comments", body: `{"author": "a", "text": "` + strings.Repeat("t", service.MAX_COMMENT_LEN+1) + `"}`, status: http.StatusUnprocessableEntity, code: "invalid_data"},
+		{name: "too long author", method: http.MethodPost, path: "/posts/1/comments", body: `{"author": "` + strings.Repeat("a", service.MAX_AUTHOR_LEN+1) + `", "text": "t"}`, status: http.StatusUnprocessableEntity, code: "invalid_data"},
+	}
+	for _, c := range cases {
+		// when
+		resp, body := s.do(c.method, c.path, c.body)
+
+		// then
+		s.Equal(c.status, resp.StatusCode, c.name)
+		s.Equal(c.code, s.errorOf(body).Error.Code, c.name)
+	}
+}
+
+func (s *RestTestSuite) TestMethodNotAllowed() {
+	for path, allow := range map[string]string{
+		"/posts":            "GET, HEAD",
+		"/posts/1":          "GET, HEAD",
+		"/posts/1/comments": "GET, HEAD, POST",
+	} {
+		// when
+		resp, body := s.do(http.MethodPut, path, "")
+
+		// then
+		s.Equal(http.StatusMethodNotAllowed, resp.StatusCode, path)
+		s.Equal(allow, resp.Header.Get("Allow"), path)
+		s.Equal("method_not_allowed", s.errorOf(body).Error.Code, path)
+	}
+}
+
+func (s *RestTestSuite) TestInternalErrorHidden() {
+	// when the in-memory repository fails to find the parent comment
+	resp, body := s.do(http.MethodPost, "/posts/1/comments", `{"parentId": "100", "author": "a", "text": "t"}`)
+
+	// then
+	s.Equal(http.StatusInternalServerError, resp.StatusCode)
+	res := s.errorOf(body)
+	s.Equal("internal", res.Error.Code)
+	s.Equal("internal error", res.Error.Message)
+}
+
+// createPost adds a post and returns its id.
+func (s *RestTestSuite) createPost(commentable bool) string {
+	p, err := s.ps.CreatePost(s.ctx, model.NewPost{Author: "author", Title: "other", Text: "text", Time: time.Now(), IsCommentable: &commentable})
+	s.Require().NoError(err)
+	return p.ID
+}
+
+func (s *RestTestSuite) do(method, path, body string) (*http.Response, string) {
+	req, err := http.NewRequest(method, s.url+path, strings.NewReader(body))
+	s.Require().NoError(err)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	s.Require().NoError(err)
+	return resp, string(b)
+}
+
+func (s *RestTestSuite) errorOf(body string) restError {
+	var res restError
+	s.Require().NoError(json.Unmarshal([]byte(body), &res), body)
+	return res
+}
+
+func (s *RestTestSuite) commentTexts(body string) []string {
+	var comments []model.CommentConnection
+	s.Require().NoError(json.Unmarshal([]byte(body), &comments), body)
+	var res []string
+	for _, c := range comments {
+		res = append(res, c.Comment.Text)
+	}
+	return res
+}
+
+func TestRestTestSuite(t *testing.T) {
+	suite.Run(t, new(RestTestSuite))
+}
+
+func keys(m map[string]any) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	return res
+}
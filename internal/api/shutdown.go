@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// connections tracks websocket connections, which are hijacked from the server,
+// so http.Server.Shutdown neither closes them nor waits for them.
+type connections struct {
+	m       sync.Mutex
+	wg      sync.WaitGroup
+	closing bool
+	next    int
+	cancels map[int]context.CancelFunc
+}
+
+func newConnections() *connections {
+	return &connections{cancels: make(map[int]context.CancelFunc)}
+}
+
+// Close shuts the server down in ShutdownDelay plus ShutdownTimeout: Drain, then Shutdown
+// with the rest of one deadline, which starts before the delay.
+func (a *API) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), a.shutdownDelay+a.shutdownTimeout)
+	defer cancel()
+	a.Drain(ctx)
+	a.Shutdown(ctx)
+}
+
+// Drain fails readiness and keeps serving requests for ShutdownDelay or until ctx is done,
+// so load balancers notice it and stop sending new ones.
+func (a *API) Drain(ctx context.Context) {
+	a.probes.Drain()
+	if a.shutdownDelay <= 0 {
+		return
+	}
+	log.Info().Dur("delay", a.shutdownDelay).Msg("readiness failed, waiting for load balancers before shutdown")
+	t := time.NewTimer(a.shutdownDelay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// Shutdown refuses new connections, completes subscriptions, waits for running requests
+// and closes websocket connections with a close frame. Connections left when ctx is done are closed at once.
+func (a *API) Shutdown(ctx context.Context) {
+	a.probes.Drain()
+	close(a.done)
+	log.Debug().Msg("start graceful server shutdown")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- a.s.Shutdown(ctx)
+	}()
+	// a complete message is sent for every subscription whose channel is closed
+	a.ps.CloseSubscriptions()
+	err := <-done
+	if cerr := a.conns.close(ctx); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("graceful server shutdown timed out, closing connections")
+		a.s.Close()
+		return
+	}
+	log.Debug().Msg("server graceful shutdowned")
+}
+
+// track registers websocket connections of the handler, new ones are refused on shutdown.
+func (c *connections) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		c.m.Lock()
+		if c.closing {
+			c.m.Unlock()
+			cancel()
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		id := c.next
+		c.next++
+		c.cancels[id] = cancel
+		c.wg.Add(1)
+		c.m.Unlock()
+		defer func() {
+			c.m.Lock()
+			delete(c.cancels, id)
+			c.m.Unlock()
+			cancel()
+			c.wg.Done()
+		}()
+		// the connection is closed by its transport when ctx is done
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// close cancels contexts of connections and waits for them to be closed until ctx is done.
+func (c *connections) close(ctx context.Context) error {
+	c.m.Lock()
+	c.closing = true
+	for _, cancel := range c.cancels {
+		cancel()
+	}
+	c.m.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,132 @@
+package api_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mi-raf/comment-project/internal/api"
+	"github.com/stretchr/testify/suite"
+)
+
+type ShutdownTestSuite struct {
+	graphSuite
+}
+
+type wsMessage struct {
+	ID      string         `json:"id,omitempty"`
+	Type    string         `json:"type"`
+	Payload map[string]any `json:"payload,omitempty"`
+}
+
+func (s *ShutdownTestSuite) TestSubscriptionsDrainedOnClose() {
+	// given
+	a := api.NewApi(s.config(api.Config{ShutdownTimeout: time.Second}))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	served := make(chan error, 1)
+	go func() {
+		served <- a.Serve(l)
+	}()
+	conn := s.subscribe("ws://" + l.Addr().String() + "/query")
+	defer conn.Close()
+
+	// when
+	closed := make(chan struct{})
+	go func() {
+		a.Close()
+		close(closed)
+	}()
+
+	// then
+	var msg wsMessage
+	s.Require().NoError(conn.ReadJSON(&msg))
+	s.Equal(wsMessage{ID: "1", Type: "complete"}, msg)
+	_, _, err = conn.ReadMessage()
+	s.True(websocket.IsCloseError(err, websocket.CloseNormalClosure), err)
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		s.Fail("server is not closed in time")
+	}
+	s.NoError(<-served)
+	s.Empty(s.ps.SubscriptionStats().Subscribers)
+	// subscriptions made after shutdown are completed at once
+	select {
+	case _, ok := <-s.ps.CommentSubscribe(s.ctx, 1):
+		s.False(ok)
+	case <-time.After(time.Second):
+		s.Fail("subscription is not completed")
+	}
+}
+
+func (s *ShutdownTestSuite) TestConnectionsRefusedAfterClose() {
+	// given
+	a := api.NewApi(s.config(api.Config{ShutdownTimeout: time.Second}))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	go a.Serve(l)
+	resp, err := http.Get("http://" + l.Addr().String() + "/livez")
+	s.Require().NoError(err)
+	resp.Body.Close()
+
+	// when
+	a.Close()
+
+	// then
+	_, err = http.Get("http://" + l.Addr().String() + "/livez")
+	s.Error(err)
+}
+
+func (s *ShutdownTestSuite) TestDrainEndsByDeadline() {
+	// given
+	a := api.NewApi(s.config(api.Config{ShutdownDelay: time.Minute, ShutdownTimeout: time.Second}))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	go a.Serve(l)
+	ctx, cancel := context.WithTimeout(s.ctx, 100*time.Millisecond)
+	defer cancel()
+
+	// when
+	start := time.Now()
+	a.Drain(ctx)
+	resp, err := http.Get("http://" + l.Addr().String() + "/readyz")
+	a.Shutdown(ctx)
+
+	// then
+	s.Less(time.Since(start), time.Second)
+	s.Require().NoError(err)
+	resp.Body.Close()
+	s.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+	_, err = http.Get("http://" + l.Addr().String() + "/livez")
+	s.Error(err)
+}
+
+// subscribe opens a connection with the graphql-transport-ws protocol and subscribes to comments of the post.
+func (s *graphSuite) subscribe(url string) *websocket.Conn {
+	return s.subscribeWith(&websocket.Dialer{}, url, nil)
+}
+
+func (s *graphSuite) subscribeWith(d *websocket.Dialer, url string, h http.Header) *websocket.Conn {
+	d.Subprotocols = []string{"graphql-transport-ws"}
+	conn, _, err := d.Dial(url, h)
+	s.Require().NoError(err)
+	s.Require().NoError(conn.WriteJSON(wsMessage{Type: "connection_init"}))
+	var ack wsMessage
+	s.Require().NoError(conn.ReadJSON(&ack))
+	s.Require().Equal("connection_ack", ack.Type)
+	s.Require().NoError(conn.WriteJSON(wsMessage{ID: "1", Type: "subscribe", Payload: map[string]any{
+		"query": `subscription { commentSubscribe(postId: "1") { id } }`,
+	}}))
+	s.Require().Eventually(func() bool {
+		return s.ps.SubscriptionStats().Subscribers[1] == 1
+	}, time.Second, 10*time.Millisecond)
+	return conn
+}
+
+func TestShutdownTestSuite(t *testing.T) {
+	suite.Run(t, new(ShutdownTestSuite))
+}
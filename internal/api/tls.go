@@ -0,0 +1,135 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var ErrTLSConfig = errors.New("invalid TLS config")
+
+type (
+	// TLSConfig enables HTTPS when both the certificate and the key files are set.
+	TLSConfig struct {
+		CertFile string
+		KeyFile  string
+		// ReloadInterval is how often the files are checked for changes, zero disables reloading.
+		ReloadInterval time.Duration
+		// ClientCAFile enables verification of client certificates, admin requests must have a verified one.
+		ClientCAFile string
+		// AdminClientNames limit admin certificates by their common or DNS names, empty allows any verified one.
+		AdminClientNames []string
+	}
+
+	// certReloader serves the certificate of the files and loads it again when they are changed,
+	// so renewed certificates are used without restart.
+	certReloader struct {
+		certFile string
+		keyFile  string
+		cert     atomic.Pointer[tls.Certificate]
+		// modTime is the latest modification time of the files
+		modTime time.Time
+	}
+)
+
+// Enabled reports whether any TLS option is set, the server fails to start if some required ones are missing.
+func (c *TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || c.ClientCAFile != ""
+}
+
+// newTLSConfig loads the certificate and client CAs, the certificate is reloaded until done is closed.
+func newTLSConfig(c *TLSConfig, done <-chan struct{}) (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, fmt.Errorf("%w: both certificate and key files are required", ErrTLSConfig)
+	}
+	r := &certReloader{certFile: c.CertFile, keyFile: c.KeyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.getCertificate,
+	}
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("can not read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: no certificates in client CA file %s", ErrTLSConfig, c.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+		// only admin requests need a certificate, so clients without one are accepted
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if c.ReloadInterval > 0 {
+		go r.watch(c.ReloadInterval, done)
+	}
+	return cfg, nil
+}
+
+func (r *certReloader) load() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("can not load TLS certificate: %w", err)
+	}
+	r.cert.Store(&cert)
+	r.modTime = modTime
+	return nil
+}
+
+func (r *certReloader) lastModified() (time.Time, error) {
+	var res time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		st, err := os.Stat(f)
+		if err != nil {
+			return res, fmt.Errorf("can not stat TLS file: %w", err)
+		}
+		if st.ModTime().After(res) {
+			res = st.ModTime()
+		}
+	}
+	return res, nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// watch reloads the certificate when the files are changed. The previous certificate is kept
+// if the new one can not be loaded, e.g. when only one of the files is written yet.
+func (r *certReloader) watch(interval time.Duration, done <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		modTime, err := r.lastModified()
+		if err != nil {
+			log.Error().Err(err).Msg("can not check TLS certificate")
+			continue
+		}
+		if modTime.Equal(r.modTime) {
+			continue
+		}
+		if err := r.load(); err != nil {
+			log.Error().Err(err).Msg("can not reload TLS certificate, the previous one is used")
+			continue
+		}
+		log.Info().Str("cert", r.certFile).Msg("TLS certificate reloaded")
+	}
+}
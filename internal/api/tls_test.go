@@ -0,0 +1,327 @@
+package api_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mi-raf/comment-project/graph/model"
+	"github.com/mi-raf/comment-project/internal/api"
+	"github.com/mi-raf/comment-project/internal/database"
+	"github.com/mi-raf/comment-project/internal/webhook"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/net/http2"
+)
+
+type TLSTestSuite struct {
+	graphSuite
+	dir    string
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	caPool *x509.CertPool
+}
+
+func (s *TLSTestSuite) SetupTest() {
+	s.graphSuite.SetupTest()
+	s.dir = s.T().TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	s.Require().NoError(err)
+	s.ca, err = x509.ParseCertificate(der)
+	s.Require().NoError(err)
+	s.caKey = key
+	s.caPool = x509.NewCertPool()
+	s.caPool.AddCert(s.ca)
+	s.writePEM("ca.pem", "CERTIFICATE", der)
+}
+
+func (s *TLSTestSuite) TestCertificateReloaded() {
+	// given
+	cfg := s.tlsConfig(1)
+	cfg.TLS.ReloadInterval = 10 * time.Millisecond
+	addr := s.serve(cfg)
+	s.Equal(int64(1), s.serverSerial(addr))
+
+	// when
+	s.issue("server", 2, true)
+	// the modification time is moved, so the change is noticed on file systems with coarse time
+	future := time.Now().Add(time.Minute)
+	s.Require().NoError(os.Chtimes(filepath.Join(s.dir, "server.pem"), future, future))
+
+	// then
+	s.Eventually(func() bool {
+		return s.serverSerial(addr) == 2
+	}, 2*time.Second, 20*time.Millisecond)
+}
+
+func (s *TLSTestSuite) TestInvalidCertificateNotReloaded() {
+	// given
+	cfg := s.tlsConfig(1)
+	cfg.TLS.ReloadInterval = 10 * time.Millisecond
+	addr := s.serve(cfg)
+	s.Require().Equal(int64(1), s.serverSerial(addr))
+
+	// when
+	s.Require().NoError(os.WriteFile(filepath.Join(s.dir, "server.pem"), []byte("broken"), 0o600))
+	time.Sleep(50 * time.Millisecond)
+
+	// then
+	s.Equal(int64(1), s.serverSerial(addr))
+}
+
+func (s *TLSTestSuite) TestServeFailsWithoutKey() {
+	// given
+	cfg := s.tlsConfig(1)
+	cfg.TLS.KeyFile = ""
+	a := api.NewApi(s.config(cfg))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+
+	// when
+	err = a.Serve(l)
+
+	// then
+	s.ErrorIs(err, api.ErrTLSConfig)
+}
+
+func (s *TLSTestSuite) TestHTTP2() {
+	for _, enabled := range []bool{true, false} {
+		// given
+		cfg := s.tlsConfig(1)
+		cfg.HTTP2 = enabled
+		addr := s.serve(cfg)
+		client := s.client(nil)
+
+		// when
+		resp, err := client.Get("https://" + addr + "/livez")
+
+		// then
+		s.Require().NoError(err)
+		resp.Body.Close()
+		s.Equal(enabled, resp.ProtoMajor == 2, "HTTP/2 enabled: %t", enabled)
+	}
+}
+
+func (s *TLSTestSuite) TestCleartextHTTP2() {
+	// given
+	addr := s.serve(api.Config{HTTP2: true, ShutdownTimeout: time.Second})
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+
+	// when
+	resp, err := client.Get("http://" + addr + "/livez")
+
+	// then
+	s.Require().NoError(err)
+	resp.Body.Close()
+	s.Equal(2, resp.ProtoMajor)
+}
+
+func (s *TLSTestSuite) TestAdminClientCertificate() {
+	// given
+	cfg := s.tlsConfig(1)
+	cfg.TLS.ClientCAFile = filepath.Join(s.dir, "ca.pem")
+	cfg.TLS.AdminClientNames = []string{"admin"}
+	addr := s.serve(cfg)
+	admin := s.issue("admin", 3, false)
+	other := s.issue("other", 4, false)
+	cases := []struct {
+		name  string
+		cert  *tls.Certificate
+		admin bool
+	}{
+		{name: "allowed name", cert: &admin, admin: true},
+		{name: "other name", cert: &other},
+		{name: "no certificate"},
+	}
+	for _, c := range cases {
+		// when
+		res := s.sendWith(s.client(c.cert), "https://"+addr+"/query", map[string]any{"query": "{ webhooks { id } }"})
+
+		// then
+		if c.admin {
+			s.Empty(res.Errors, c.name)
+		} else {
+			s.Require().Len(res.Errors, 1, c.name)
+			s.Equal(api.ErrNotAdmin.Error(), res.Errors[0].Message, c.name)
+		}
+	}
+}
+
+func (s *TLSTestSuite) TestAdminTokenRequiredWithCertificate() {
+	// given
+	cfg := s.tlsConfig(1)
+	cfg.TLS.ClientCAFile = filepath.Join(s.dir, "ca.pem")
+	cfg.AdminToken = "secret"
+	addr := s.serve(cfg)
+	admin := s.issue("admin", 3, false)
+	client := s.client(&admin)
+
+	// when
+	res := s.sendWith(client, "https://"+addr+"/query", map[string]any{"query": "{ webhooks { id } }"})
+
+	// then
+	s.Require().Len(res.Errors, 1)
+	s.Equal(api.ErrNotAdmin.Error(), res.Errors[0].Message)
+}
+
+func (s *TLSTestSuite) TestWebsocketOutlivesTimeouts() {
+	// given
+	addr := s.serve(api.Config{ReadTimeout: 50 * time.Millisecond, WriteTimeout: 50 * time.Millisecond, ShutdownTimeout: time.Second})
+	conn := s.subscribe("ws://" + addr + "/query")
+	defer conn.Close()
+	time.Sleep(150 * time.Millisecond)
+
+	// when
+	_, err := s.ps.CreateComment(s.ctx, 1, 0, model.NewComment{Author: "author", Text: "text", Time: time.Now()})
+	s.Require().NoError(err)
+
+	// then
+	var msg wsMessage
+	s.Require().NoError(conn.ReadJSON(&msg))
+	s.Equal("next", msg.Type)
+}
+
+func (s *TLSTestSuite) TestWebsocketOrigins() {
+	// given
+	addr := s.serve(api.Config{WebsocketOrigins: []string{"https://app.example.com", "https://*.example.org"}, ShutdownTimeout: time.Second})
+	cases := []struct {
+		origin  string
+		allowed bool
+	}{
+		{origin: "https://app.example.com", allowed: true},
+		{origin: "https://EXAMPLE.example.org", allowed: true},
+		{origin: "http://" + addr, allowed: true},
+		{origin: ""},
+		{origin: "https://example.org"},
+		{origin: "http://app.example.com"},
+		{origin: "https://evil.com"},
+	}
+	for _, c := range cases {
+		h := http.Header{}
+		if c.origin != "" {
+			h.Set("Origin", c.origin)
+		} else {
+			// clients without origin are not browsers, so they are allowed
+			c.allowed = true
+		}
+		d := websocket.Dialer{Subprotocols: []string{"graphql-transport-ws"}}
+
+		// when
+		conn, resp, err := d.Dial("ws://"+addr+"/query", h)
+
+		// then
+		if c.allowed {
+			s.NoError(err, c.origin)
+			conn.Close()
+		} else {
+			s.Error(err, c.origin)
+			s.Equal(http.StatusForbidden, resp.StatusCode, c.origin)
+		}
+	}
+}
+
+// tlsConfig returns a config with the server certificate of the serial number.
+func (s *TLSTestSuite) tlsConfig(serial int64) api.Config {
+	s.issue("server", serial, true)
+	return api.Config{
+		HTTP2: true,
+		TLS: api.TLSConfig{
+			CertFile: filepath.Join(s.dir, "server.pem"),
+			KeyFile:  filepath.Join(s.dir, "server-key.pem"),
+		},
+		ShutdownTimeout: time.Second,
+	}
+}
+
+// serve starts the API with resolvers of webhooks and returns its address.
+func (s *TLSTestSuite) serve(cfg api.Config) string {
+	hooks, stop := webhook.NewDispatcher(s.ctx, database.NewInMemoryWebhookRepository(), &webhook.Config{PollInterval: time.Minute})
+	s.T().Cleanup(stop)
+	c := s.config(cfg)
+	c.GraphCfg.Resolvers = api.NewResolver(s.ps, hooks)
+	a := api.NewApi(c)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	go a.Serve(l)
+	s.T().Cleanup(a.Close)
+	return l.Addr().String()
+}
+
+// issue writes the certificate and the key signed by the CA to "<name>.pem" and "<name>-key.pem".
+func (s *TLSTestSuite) issue(name string, serial int64, server bool) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, s.ca, &key.PublicKey, s.caKey)
+	s.Require().NoError(err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	s.Require().NoError(err)
+	s.writePEM(name+"-key.pem", "EC PRIVATE KEY", keyDer)
+	s.writePEM(name+".pem", "CERTIFICATE", der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (s *TLSTestSuite) writePEM(file, typ string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	s.Require().NoError(os.WriteFile(filepath.Join(s.dir, file), data, 0o600))
+}
+
+// client trusts the CA and presents the certificate if it is not nil.
+func (s *TLSTestSuite) client(cert *tls.Certificate) *http.Client {
+	cfg := &tls.Config{RootCAs: s.caPool}
+	if cert != nil {
+		cfg.Certificates = []tls.Certificate{*cert}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg, ForceAttemptHTTP2: true}}
+}
+
+func (s *TLSTestSuite) serverSerial(addr string) int64 {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: s.caPool})
+	s.Require().NoError(err)
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestTLSTestSuite(t *testing.T) {
+	suite.Run(t, new(TLSTestSuite))
+}
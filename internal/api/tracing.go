@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// graphTracer is a GraphQL extension which makes spans of operations and resolvers.
+type graphTracer struct{}
+
+var (
+	tracer = otel.Tracer("github.com/mi-raf/comment-project/internal/api")
+
+	_ graphql.OperationInterceptor = graphTracer{}
+	_ graphql.FieldInterceptor     = graphTracer{}
+)
+
+// traceHTTP makes a span of every request named by the mux pattern, trace context is read from request headers.
+func traceHTTP(mux *http.ServeMux, next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		return r.Method + " " + pattern
+	}))
+}
+
+func (graphTracer) ExtensionName() string {
+	return "Tracing"
+}
+
+func (graphTracer) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+// InterceptOperation makes a span, which ends with the response or with the last event of a subscription.
+func (graphTracer) InterceptOperation(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+	name, typ := operationLabels(ctx)
+	ctx, span := tracer.Start(ctx, typ+" "+name, trace.WithAttributes(
+		attribute.String("graphql.operation.name", name),
+		attribute.String("graphql.operation.type", typ),
+	))
+	responses := next(ctx)
+	return func(ctx context.Context) *graphql.Response {
+		resp := responses(ctx)
+		if resp != nil && len(resp.Errors) > 0 {
+			span.SetStatus(codes.Error, resp.Errors.Error())
+		}
+		if resp == nil || typ != string(ast.Subscription) {
+			span.End()
+		}
+		return resp
+	}
+}
+
+// InterceptField makes spans of fields with resolvers, when the operation is sampled.
+func (graphTracer) InterceptField(ctx context.Context, next graphql.Resolver) (any, error) {
+	fc := graphql.GetFieldContext(ctx)
+	if fc == nil || !fc.IsResolver || !trace.SpanFromContext(ctx).IsRecording() {
+		return next(ctx)
+	}
+	ctx, span := tracer.Start(ctx, fc.Object+"."+fc.Field.Name, trace.WithAttributes(
+		attribute.String("graphql.field.path", fc.Path().String()),
+	))
+	defer span.End()
+	res, err := next(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return res, err
+}
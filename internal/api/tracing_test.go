@@ -0,0 +1,88 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mi-raf/comment-project/internal/api"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+type TracingTestSuite struct {
+	graphSuite
+	spans *tracetest.InMemoryExporter
+}
+
+// SetupSuite sets the global provider once, because tracers of packages are bound to the first one.
+func (s *TracingTestSuite) SetupSuite() {
+	s.spans = tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(s.spans)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+}
+
+func (s *TracingTestSuite) TestSpansContinueIncomingTrace() {
+	// when
+	s.post(`query Title { post(postId: "1") { title } }`)
+
+	// then
+	spans := s.ended()
+	s.Contains(spans, "query Title")
+	s.Contains(spans, "Query.post")
+	s.Contains(spans, "PostService.PostsByIDs")
+	s.Contains(spans, "PostService.CommentsOfPosts")
+	for name, span := range spans {
+		s.Equal(traceID, span.SpanContext.TraceID().String(), name)
+	}
+	s.Equal(spans["query Title"].SpanContext.SpanID(), spans["Query.post"].Parent.SpanID())
+}
+
+func (s *TracingTestSuite) TestResolverErrorRecorded() {
+	// when
+	s.post(`{ post(postId: "first") { title } }`)
+
+	// then
+	spans := s.ended()
+	s.Require().Contains(spans, "Query.post")
+	s.Equal(codes.Error, spans["Query.post"].Status.Code)
+	s.Equal(codes.Error, spans["query anonymous"].Status.Code)
+}
+
+// post sends the query with trace context of a remote parent span.
+func (s *TracingTestSuite) post(q string) {
+	srv := httptest.NewServer(otelhttp.NewHandler(s.handler(api.Config{}), "http"))
+	defer srv.Close()
+	body, err := json.Marshal(map[string]any{"query": q})
+	s.Require().NoError(err)
+	req, err := http.NewRequest(http.MethodPost, srv.URL, bytes.NewReader(body))
+	s.Require().NoError(err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	resp, err := srv.Client().Do(req)
+	s.Require().NoError(err)
+	resp.Body.Close()
+}
+
+// ended returns spans ended since the previous call by their names.
+func (s *TracingTestSuite) ended() map[string]tracetest.SpanStub {
+	res := make(map[string]tracetest.SpanStub)
+	for _, span := range s.spans.GetSpans() {
+		res[span.Name] = span
+	}
+	s.spans.Reset()
+	return res
+}
+
+func TestTracingTestSuite(t *testing.T) {
+	suite.Run(t, new(TracingTestSuite))
+}
@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype/zeronull"
+	"github.com/mi-raf/comment-project/internal/logging"
+	mod "github.com/mi-raf/comment-project/internal/models"
+)
+
+const (
+	reserveIds      = "SELECT nextval(pg_get_serial_sequence($1, $2)) FROM generate_series(1, $3)"
+	addPostCounters = "UPDATE Post SET comment_count = comment_count + $2, last_comment_at = GREATEST(last_comment_at, $3) WHERE id_p = $1"
+)
+
+var (
+	postColumns    = []string{"id_p", "author", "title", "text_p", "comm", "time_p"}
+	commentColumns = []string{"id_c", "id_p", "parent", "author", "text_c", "time_c"}
+)
+
+// reserve returns n new values of the serial column, so rows can be copied with known ids.
+func reserve(ctx context.Context, tx pgx.Tx, table string, column string, n int) ([]int64, error) {
+	rows, err := tx.Query(ctx, reserveIds, table, column, n)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[int64])
+}
+
+// AddBatch stores posts with COPY and returns their ids in the same order.
+func (r *PgPostRepository) AddBatch(ctx context.Context, posts []*mod.PostDTO) ([]int64, error) {
+	if len(posts) == 0 {
+		return nil, nil
+	}
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("can't open transaction for add posts")
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	ids, err := reserve(ctx, tx, "post", "id_p", len(posts))
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("can not reserve post ids")
+		return nil, err
+	}
+	rows := make([][]any, 0, len(posts))
+	for i, p := range posts {
+		rows = append(rows, []any{ids[i], p.Author, p.Title, p.Text, p.IsCommentable, p.Time})
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"post"}, postColumns, pgx.CopyFromRows(rows)); err != nil {
+		logging.Ctx(ctx).Error().Err(err).Int("posts", len(posts)).Msg("can not copy posts")
+		return nil, err
+	}
+	return ids, tx.Commit(ctx)
+}
+
+// AddBatch stores comments with COPY and returns their ids in the same order.
+// Parents should be stored before, comments are not sent to subscribers.
+func (r *PgCommentRepository) AddBatch(ctx context.Context, comments []*mod.CommentDTO) ([]int64, error) {
+	if len(comments) == 0 {
+		return nil, nil
+	}
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("can't open transaction for add comments")
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	ids, err := reserve(ctx, tx, "comment", "id_c", len(comments))
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("can not reserve comment ids")
+		return nil, err
+	}
+	rows := make([][]any, 0, len(comments))
+	byPost := make(map[int64][]*mod.CommentDTO)
+	var order []int64
+	for i, c := range comments {
+		rows = append(rows, []any{ids[i], c.PostId, zeronull.Int8(c.ParentId), c.Author, c.Text, c.Time})
+		if _, ok := byPost[c.PostId]; !ok {
+			order = append(order, c.PostId)
+		}
+		byPost[c.PostId] = append(byPost[c.PostId], c)
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"comment"}, commentColumns, pgx.CopyFromRows(rows)); err != nil {
+		logging.Ctx(ctx).Error().Err(err).Int("comments", len(comments)).Msg("can not copy comments")
+		return nil, err
+	}
+	b := &pgx.Batch{}
+	for _, id := range order {
+		b.Queue(addPostCounters, id, len(byPost[id]), lastTime(byPost[id]))
+	}
+	if err := tx.SendBatch(ctx, b).Close(); err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("can not update post counters")
+		return nil, err
+	}
+	return ids, tx.Commit(ctx)
+}
+
+func (r *InMemoryPostRepository) AddBatch(ctx context.Context, posts []*mod.PostDTO) ([]int64, error) {
+	ids := make([]int64, 0, len(posts))
+	for _, p := range posts {
+		id, err := r.Add(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// AddBatch stores comments without sending them to the comment channel.
+func (r *InMemoryCommentRepository) AddBatch(ctx context.Context, comments []*mod.CommentDTO) ([]int64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	ids := make([]int64, 0, len(comments))
+	for _, c := range comments {
+		if c.ParentId != 0 && !hasComment(r.c[c.PostId], c.ParentId) {
+			return nil, errors.New("there is no such parent comment")
+		}
+		cc := *c
+		cc.Id = r.idGen
+		r.idGen++
+		r.c[c.PostId] = append(r.c[c.PostId], &cc)
+		if r.posts != nil {
+			r.posts.addComment(c.PostId, c.Time)
+		}
+		ids = append(ids, cc.Id)
+	}
+	return ids, nil
+}
+
+// hasComment looks for the comment in comments of a post, which are stored in order of ids.
+func hasComment(comments []*mod.CommentDTO, id int64) bool {
+	i := sort.Search(len(comments), func(i int) bool { return comments[i].Id >= id })
+	return i < len(comments) && comments[i].Id == id
+}
+
+// lastTime returns the latest time of comments.
+func lastTime(comments []*mod.CommentDTO) time.Time {
+	var last time.Time
+	for _, c := range comments {
+		if c.Time.After(last) {
+			last = c.Time
+		}
+	}
+	return last
+}
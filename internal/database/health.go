@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	// SCHEMA_TABLE is where migrations keep the version of the schema.
+	SCHEMA_TABLE = "schema_migrations"
+	// undefinedTable is an error code of PostgreSQL.
+	undefinedTable = "42P01"
+)
+
+type (
+	// Pinger is implemented by repositories which use a database pool.
+	Pinger interface {
+		Ping(ctx context.Context) error
+	}
+
+	// SchemaReporter reads the version of migrations applied to the database.
+	SchemaReporter interface {
+		SchemaVersion(ctx context.Context) (version uint, dirty bool, err error)
+	}
+)
+
+func (r *PgPostRepository) Ping(ctx context.Context) error {
+	return r.pool.Ping(ctx)
+}
+
+func (r *PgCommentRepository) Ping(ctx context.Context) error {
+	return r.pool.Ping(ctx)
+}
+
+// SchemaVersion returns zero version if no migrations were applied or the table of versions does not exist.
+func (r *PgPostRepository) SchemaVersion(ctx context.Context) (uint, bool, error) {
+	var v int64
+	var dirty bool
+	err := r.pool.QueryRow(ctx, "SELECT version, dirty FROM "+SCHEMA_TABLE+" LIMIT 1").Scan(&v, &dirty)
+	var pgErr *pgconn.PgError
+	if errors.Is(err, pgx.ErrNoRows) || errors.As(err, &pgErr) && pgErr.Code == undefinedTable {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return uint(v), dirty, nil
+}
@@ -0,0 +1,419 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	mod "github.com/mi-raf/comment-project/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	LISTEN_MIN_BACKOFF = 100 * time.Millisecond
+	LISTEN_MAX_BACKOFF = 30 * time.Second
+	LISTEN_BATCH       = 100
+	// LISTEN_BATCH_WAIT is how long the listener waits for more notifications
+	// before loading the received comments.
+	LISTEN_BATCH_WAIT = 10 * time.Millisecond
+	// LISTEN_SEEN_SIZE is a number of last delivered comments which are not sent twice
+	// when they are both backfilled and received with a notification.
+	LISTEN_SEEN_SIZE = 1024
+	// LISTEN_UPDATES_SIZE is a buffer of ids of posts with changed comments.
+	LISTEN_UPDATES_SIZE = 100
+
+	// searchLastEvent returns the position of the last published event of the topic.
+	searchLastEvent = `SELECT xid::text, id_o FROM Outbox
+	WHERE topic = $1 AND published_at IS NOT NULL
+	ORDER BY xid DESC, id_o DESC
+	LIMIT 1`
+	// searchEventsAfter returns events of the topic published after the position, in the order of publishing.
+	searchEventsAfter = `SELECT xid::text, id_o, payload::text FROM Outbox
+	WHERE topic = $1 AND published_at IS NOT NULL AND (xid, id_o) > ($2::text::xid8, $3)
+	ORDER BY xid, id_o
+	LIMIT $4`
+	searchCommentsByIds = `WITH RECURSIVE page AS (
+		SELECT id_c, id_p, parent, author, text_c, time_c
+		FROM Comment
+		WHERE id_c = ANY($1)
+	),
+	ancestors AS (
+		SELECT id_c AS origin, parent, 1 AS level
+		FROM page
+
+		UNION ALL
+
+		SELECT a.origin, c.parent, a.level + 1
+		FROM ancestors a
+		INNER JOIN Comment c ON c.id_c = a.parent
+	)
+	SELECT p.id_c, p.id_p, p.parent, p.author, p.text_c, p.time_c,
+		(SELECT max(level) FROM ancestors a WHERE a.origin = p.id_c) AS level
+	FROM page p
+	ORDER BY p.id_c;`
+)
+
+type (
+	// ListenerStatus describes health of the comment notifications listener.
+	ListenerStatus struct {
+		// Running is false after the listener goroutine stopped.
+		Running    bool   `json:"running"`
+		Connected  bool   `json:"connected"`
+		Reconnects int64  `json:"reconnects"`
+		LastError  string `json:"lastError,omitempty"`
+		LastSeenId int64  `json:"lastSeenId"`
+	}
+
+	// ListenerReporter is implemented by comment repositories which receive comments from the database.
+	ListenerReporter interface {
+		ListenerStatus() ListenerStatus
+	}
+
+	// UpdateNotifier is implemented by comment repositories which receive changes of existing comments
+	// made by any instance, e.g. pins. Updates returns ids of posts with changed comments.
+	UpdateNotifier interface {
+		Updates() <-chan int64
+	}
+
+	// commentNotification is a payload of notifications on COMMENTS_CHANNEL.
+	commentNotification struct {
+		Event  string `json:"event"`
+		Id     int64  `json:"id"`
+		PostId int64  `json:"postId,omitempty"`
+		// Xid and OutboxId are the position of the event in the outbox, the relay adds them on publishing.
+		Xid      uint64 `json:"xid,string,omitempty"`
+		OutboxId int64  `json:"outboxId,omitempty"`
+	}
+
+	// outboxPosition is the position of an event in the order the relay publishes events.
+	outboxPosition struct {
+		xid uint64
+		id  int64
+	}
+
+	listenerState struct {
+		m      sync.Mutex
+		status ListenerStatus
+		// seen is nil until the listener connects for the first time
+		seen  map[int64]struct{}
+		order []int64
+		next  int
+		// pos is the last event which is handled, the backfill continues after it
+		pos outboxPosition
+	}
+)
+
+func (r *PgCommentRepository) Updates() <-chan int64 {
+	return r.updates
+}
+
+func (r *PgCommentRepository) ListenerStatus() ListenerStatus {
+	r.listener.m.Lock()
+	defer r.listener.m.Unlock()
+	return r.listener.status
+}
+
+// Listen receives new comments and sends them to the comment channel until ctx is done.
+// Lost connection is restored with backoff, events published while the listener
+// was disconnected are read from the outbox after the last handled one,
+// so the listener misses only events older than OUTBOX_RETENTION.
+func (r *PgCommentRepository) Listen(ctx context.Context) {
+	r.setRunning(true)
+	defer r.setRunning(false)
+	backoff := LISTEN_MIN_BACKOFF
+	for {
+		start := time.Now()
+		err := r.listenOnce(ctx)
+		if ctx.Err() != nil {
+			log.Info().Msg("comments listener stopped")
+			return
+		}
+		// the connection was healthy for a while, so it is a new failure
+		if time.Since(start) > LISTEN_MAX_BACKOFF {
+			backoff = LISTEN_MIN_BACKOFF
+		}
+		r.setDisconnected(err)
+		log.Error().Err(err).Dur("backoff", backoff).Msg("comments listener disconnected, reconnecting")
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("comments listener stopped")
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, LISTEN_MAX_BACKOFF)
+	}
+}
+
+func (r *PgCommentRepository) listenOnce(ctx context.Context) error {
+	conn, err := r.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// listening connection is not returned to the pool
+	c := conn.Hijack()
+	defer c.Close(context.Background())
+
+	if _, err := c.Exec(ctx, "LISTEN "+COMMENTS_CHANNEL); err != nil {
+		return err
+	}
+	// comments committed before LISTEN are loaded after it, so nothing is lost in between
+	if err := r.backfill(ctx, c); err != nil {
+		return err
+	}
+	r.setConnected()
+
+	for {
+		ids, pos, err := r.waitComments(ctx, c)
+		if err != nil {
+			return err
+		}
+		if err := r.hydrate(ctx, c, ids); err != nil {
+			return err
+		}
+		r.setPosition(pos)
+	}
+}
+
+// waitComments blocks until a comment notification is received and returns ids
+// of comments notified together with it, up to LISTEN_BATCH, and the position of the last notification.
+func (r *PgCommentRepository) waitComments(ctx context.Context, c *pgx.Conn) ([]int64, outboxPosition, error) {
+	ids := make([]int64, 0, LISTEN_BATCH)
+	var pos outboxPosition
+	for len(ids) < LISTEN_BATCH {
+		var n *pgconn.Notification
+		var err error
+		if len(ids) == 0 {
+			n, err = c.WaitForNotification(ctx)
+		} else {
+			wctx, cancel := context.WithTimeout(ctx, LISTEN_BATCH_WAIT)
+			n, err = c.WaitForNotification(wctx)
+			cancel()
+		}
+		if err != nil {
+			// timeout leaves the connection usable
+			if len(ids) > 0 && ctx.Err() == nil && pgconn.Timeout(err) {
+				break
+			}
+			return nil, pos, err
+		}
+		log.Debug().Uint32("PID", n.PID).Str("channel", n.Channel).Str("payload", n.Payload).Msg("notification received")
+		var cn commentNotification
+		if err := json.Unmarshal([]byte(n.Payload), &cn); err != nil {
+			log.Error().Err(err).Str("payload", n.Payload).Msg("can not unmarshall notification payload")
+			continue
+		}
+		// relays of older versions do not send the position
+		if cn.OutboxId != 0 {
+			pos = outboxPosition{xid: cn.Xid, id: cn.OutboxId}
+		}
+		if id, ok := r.handleNotification(cn); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, pos, nil
+}
+
+// handleNotification sends updates of posts and returns the id of the created comment.
+func (r *PgCommentRepository) handleNotification(cn commentNotification) (int64, bool) {
+	if cn.Event == mod.EventCommentUpdated {
+		r.notifyUpdated(cn.PostId)
+		return 0, false
+	}
+	// payloads written before events were added hold the whole comment, its id is read as well
+	if cn.Event != mod.EventCommentCreated && cn.Event != "" {
+		log.Warn().Str("event", cn.Event).Msg("unknown comment notification")
+		return 0, false
+	}
+	return cn.Id, true
+}
+
+// hydrate loads notified comments which were not delivered yet and sends them to the channel.
+func (r *PgCommentRepository) hydrate(ctx context.Context, c *pgx.Conn, ids []int64) error {
+	ids = r.unseen(ids)
+	if len(ids) == 0 {
+		return nil
+	}
+	rows, err := c.Query(ctx, searchCommentsByIds, ids)
+	if err != nil {
+		return err
+	}
+	comments, err := scanCommentsWithPost(rows, len(ids))
+	if err != nil {
+		return err
+	}
+	if len(comments) < len(ids) {
+		log.Warn().Int("notified", len(ids)).Int("found", len(comments)).Msg("some notified comments are not found")
+	}
+	for _, cm := range comments {
+		if err := r.deliver(ctx, cm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfill handles events of the comments topic which were published after the last handled one,
+// comments are read from the outbox rather than by id because ids are taken before commit and do not follow the commit order.
+// On the first connect there is nothing to catch up, so it only remembers the last published event.
+func (r *PgCommentRepository) backfill(ctx context.Context, c *pgx.Conn) error {
+	r.listener.m.Lock()
+	pos, first := r.listener.pos, r.listener.seen == nil
+	r.listener.m.Unlock()
+	if first {
+		var xid string
+		err := c.QueryRow(ctx, searchLastEvent, COMMENTS_CHANNEL).Scan(&xid, &pos.id)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+		if err == nil {
+			if pos.xid, err = strconv.ParseUint(xid, 10, 64); err != nil {
+				return err
+			}
+		}
+		r.listener.m.Lock()
+		r.listener.seen = make(map[int64]struct{}, LISTEN_SEEN_SIZE)
+		r.listener.pos = pos
+		r.listener.m.Unlock()
+		return nil
+	}
+
+	total := 0
+	for {
+		rows, err := c.Query(ctx, searchEventsAfter, COMMENTS_CHANNEL, strconv.FormatUint(pos.xid, 10), pos.id, LISTEN_BATCH)
+		if err != nil {
+			return err
+		}
+		ids := make([]int64, 0, LISTEN_BATCH)
+		n := 0
+		for rows.Next() {
+			var xid, payload string
+			err := rows.Scan(&xid, &pos.id, &payload)
+			if err == nil {
+				pos.xid, err = strconv.ParseUint(xid, 10, 64)
+			}
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			n++
+			var cn commentNotification
+			if err := json.Unmarshal([]byte(payload), &cn); err != nil {
+				log.Error().Err(err).Str("payload", payload).Msg("can not unmarshall outbox event")
+				continue
+			}
+			if id, ok := r.handleNotification(cn); ok {
+				ids = append(ids, id)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if err := r.hydrate(ctx, c, ids); err != nil {
+			return err
+		}
+		r.setPosition(pos)
+		total += n
+		if n < LISTEN_BATCH {
+			break
+		}
+	}
+	log.Info().Int("events", total).Int64("last event id", pos.id).Msg("comment events backfilled after reconnect")
+	return nil
+}
+
+// setPosition remembers the last handled event, events without position keep the previous one.
+func (r *PgCommentRepository) setPosition(pos outboxPosition) {
+	if pos.id == 0 {
+		return
+	}
+	r.listener.m.Lock()
+	defer r.listener.m.Unlock()
+	r.listener.pos = pos
+}
+
+// deliver sends the comment to the channel unless it was already sent.
+func (r *PgCommentRepository) deliver(ctx context.Context, c *mod.CommentDTO) error {
+	if !r.markSeen(c.Id) {
+		log.Debug().Int64("comment id", c.Id).Msg("comment already delivered")
+		return nil
+	}
+	select {
+	case r.ch <- *c:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// notifyUpdated sends the id of the post with changed comments without blocking the listener,
+// the update is dropped if the buffer is full and readers see the change after their cache expires.
+func (r *PgCommentRepository) notifyUpdated(postId int64) {
+	select {
+	case r.updates <- postId:
+	default:
+		log.Warn().Int64("post id", postId).Msg("comment updates are not read, update dropped")
+	}
+}
+
+// unseen returns ids which were not delivered yet.
+func (r *PgCommentRepository) unseen(ids []int64) []int64 {
+	r.listener.m.Lock()
+	defer r.listener.m.Unlock()
+	res := ids[:0]
+	for _, id := range ids {
+		if _, ok := r.listener.seen[id]; !ok {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// markSeen remembers the comment id and reports whether it was not seen before.
+func (r *PgCommentRepository) markSeen(id int64) bool {
+	l := &r.listener
+	l.m.Lock()
+	defer l.m.Unlock()
+	if _, ok := l.seen[id]; ok {
+		return false
+	}
+	if len(l.order) < LISTEN_SEEN_SIZE {
+		l.order = append(l.order, id)
+	} else {
+		delete(l.seen, l.order[l.next])
+		l.order[l.next] = id
+		l.next = (l.next + 1) % LISTEN_SEEN_SIZE
+	}
+	l.seen[id] = struct{}{}
+	l.status.LastSeenId = max(l.status.LastSeenId, id)
+	return true
+}
+
+func (r *PgCommentRepository) setRunning(running bool) {
+	r.listener.m.Lock()
+	defer r.listener.m.Unlock()
+	r.listener.status.Running = running
+	if !running {
+		r.listener.status.Connected = false
+	}
+}
+
+func (r *PgCommentRepository) setConnected() {
+	r.listener.m.Lock()
+	defer r.listener.m.Unlock()
+	r.listener.status.Connected = true
+	log.Info().Int64("reconnects", r.listener.status.Reconnects).Msg("comments listener connected")
+}
+
+func (r *PgCommentRepository) setDisconnected(err error) {
+	r.listener.m.Lock()
+	defer r.listener.m.Unlock()
+	r.listener.status.Connected = false
+	r.listener.status.Reconnects++
+	r.listener.status.LastError = err.Error()
+}
@@ -0,0 +1,179 @@
+package database
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype/zeronull"
+	mod "github.com/mi-raf/comment-project/internal/models"
+)
+
+const (
+	searchPostsByIds = `SELECT id_p, author, title, text_p, comm, time_p, comment_count, last_comment_at
+	FROM Post
+	WHERE id_p = ANY($1)
+	ORDER BY id_p`
+	searchCommentsWithStateByIds = `WITH RECURSIVE page AS (
+		SELECT id_c, id_p, parent, author, text_c, time_c
+		FROM Comment
+		WHERE id_c = ANY($1)
+	),
+	ancestors AS (
+		SELECT id_c AS origin, parent, 1 AS level
+		FROM page
+
+		UNION ALL
+
+		SELECT a.origin, c.parent, a.level + 1
+		FROM ancestors a
+		INNER JOIN Comment c ON c.id_c = a.parent
+	)
+	SELECT p.id_c, p.id_p, p.parent, p.author, p.text_c, p.time_c,
+		(SELECT max(level) FROM ancestors a WHERE a.origin = p.id_c) AS level,
+		EXISTS (SELECT 1 FROM Pin WHERE Pin.id_c = p.id_c) AS pinned,
+		EXISTS (SELECT 1 FROM Comment r INNER JOIN Post po ON po.id_p = r.id_p WHERE r.parent = p.id_c AND r.author = po.author) AS author_replied
+	FROM page p
+	ORDER BY p.id_c;`
+)
+
+// GetManyByIDs returns existing posts with the ids ordered by id.
+func (r *PgPostRepository) GetManyByIDs(ctx context.Context, ids []int64) ([]*mod.PostDTO, error) {
+	rows, err := r.pool.Query(ctx, searchPostsByIds, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := make([]*mod.PostDTO, 0, len(ids))
+	for rows.Next() {
+		p, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+// GetManyByIDs returns existing comments with the ids ordered by id.
+func (r *PgCommentRepository) GetManyByIDs(ctx context.Context, ids []int64) ([]*mod.CommentDTO, error) {
+	rows, err := r.pool.Query(ctx, searchCommentsWithStateByIds, ids)
+	if err != nil {
+		return nil, err
+	}
+	return scanCommentsWithState(rows, len(ids))
+}
+
+// GetAllOfPosts returns the first limit comments of every post in the order of GetAllOfPost.
+func (r *PgCommentRepository) GetAllOfPosts(ctx context.Context, ids []int64, limit int) (map[int64][]*mod.CommentDTO, error) {
+	rows, err := r.pool.Query(ctx, searchCommentAllPost, 0, limit, ids, []int64{}, true)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := scanCommentPage(rows, len(ids)*limit)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[int64][]*mod.CommentDTO, len(ids))
+	for _, c := range comments {
+		res[c.PostId] = append(res[c.PostId], c)
+	}
+	return res, nil
+}
+
+func scanCommentsWithState(rows pgx.Rows, capacity int) ([]*mod.CommentDTO, error) {
+	defer rows.Close()
+	comments := make([]*mod.CommentDTO, 0, capacity)
+	for rows.Next() {
+		var c mod.CommentDTO
+		var parIdNil zeronull.Int8
+		err := rows.Scan(&c.Id, &c.PostId, &parIdNil, &c.Author, &c.Text, &c.Time, &c.Level, &c.Pinned, &c.AuthorReplied)
+		if err != nil {
+			return nil, err
+		}
+		c.ParentId = int64(parIdNil)
+		comments = append(comments, &c)
+	}
+	return comments, rows.Err()
+}
+
+// scanCommentPage scans comments of searchCommentAllPost, which also tells whether a comment is in a pinned subtree.
+func scanCommentPage(rows pgx.Rows, capacity int) ([]*mod.CommentDTO, error) {
+	defer rows.Close()
+	comments := make([]*mod.CommentDTO, 0, capacity)
+	for rows.Next() {
+		var c mod.CommentDTO
+		var parIdNil zeronull.Int8
+		err := rows.Scan(&c.Id, &c.PostId, &parIdNil, &c.Author, &c.Text, &c.Time, &c.Level, &c.Pinned, &c.AuthorReplied, &c.PinSection)
+		if err != nil {
+			return nil, err
+		}
+		c.ParentId = int64(parIdNil)
+		comments = append(comments, &c)
+	}
+	return comments, rows.Err()
+}
+
+func (r *InMemoryPostRepository) GetManyByIDs(ctx context.Context, ids []int64) ([]*mod.PostDTO, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	posts := make([]*mod.PostDTO, 0, len(ids))
+	for _, id := range ids {
+		if p, ok := r.posts[id]; ok {
+			c := *p
+			posts = append(posts, &c)
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool { return posts[i].Id < posts[j].Id })
+	return posts, nil
+}
+
+// GetManyByIDs builds trees of posts with the comments, so levels and marks are the same as in GetAllOfPost.
+func (r *InMemoryCommentRepository) GetManyByIDs(ctx context.Context, ids []int64) ([]*mod.CommentDTO, error) {
+	want := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+	posts := make(map[int64]bool)
+	r.m.RLock()
+	for post, comments := range r.c {
+		for _, c := range comments {
+			if want[c.Id] {
+				posts[post] = true
+				break
+			}
+		}
+	}
+	r.m.RUnlock()
+
+	res := make([]*mod.CommentDTO, 0, len(ids))
+	for post := range posts {
+		comments, err := r.GetAllOfPost(ctx, post, mod.CommentCursor{}, math.MaxInt)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range comments {
+			if want[c.Id] {
+				res = append(res, c)
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Id < res[j].Id })
+	return res, nil
+}
+
+func (r *InMemoryCommentRepository) GetAllOfPosts(ctx context.Context, ids []int64, limit int) (map[int64][]*mod.CommentDTO, error) {
+	res := make(map[int64][]*mod.CommentDTO, len(ids))
+	for _, id := range ids {
+		comments, err := r.GetAllOfPost(ctx, id, mod.CommentCursor{}, limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(comments) > 0 {
+			res[id] = comments
+		}
+	}
+	return res, nil
+}
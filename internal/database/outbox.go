@@ -0,0 +1,197 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	OUTBOX_CHANNEL   = "outbox"
+	COMMENTS_CHANNEL = "comments"
+
+	OUTBOX_BATCH         = 100
+	OUTBOX_POLL_INTERVAL = time.Second
+	OUTBOX_RETENTION     = 24 * time.Hour
+	// OUTBOX_FLUSH_TIMEOUT limits publishing of events left in the outbox on shutdown.
+	OUTBOX_FLUSH_TIMEOUT = 5 * time.Second
+	// OUTBOX_MAX_WAIT limits how long an event waits for older transactions. Any long transaction
+	// of the cluster holds back the snapshot xmin, so without the limit it would stop all notifications;
+	// events older than the limit are published even if an older transaction commits an event later,
+	// so such an event can come out of order.
+	OUTBOX_MAX_WAIT = 5 * time.Second
+
+	// outboxLockKey is a key of the advisory lock which allows only one relay
+	// to publish events at a time, so they are published in order.
+	outboxLockKey = 0x6f7574626f78
+
+	insertOutbox = "INSERT INTO Outbox (topic, payload) VALUES ($1, $2)"
+	notifyOutbox = "SELECT pg_notify('" + OUTBOX_CHANNEL + "', '')"
+	lockOutbox   = "SELECT pg_try_advisory_xact_lock($1)"
+	// searchUnpublished skips events of transactions which can still be running for up to $2 milliseconds,
+	// so an event written by a transaction which commits later is not published before them.
+	// Payloads get the position of the event, so listeners can read events they missed from the outbox.
+	searchUnpublished = `SELECT id_o, topic, (payload || jsonb_build_object('xid', xid::text, 'outboxId', id_o))::text
+	FROM Outbox
+	WHERE published_at IS NULL
+		AND (xid < pg_snapshot_xmin(pg_current_snapshot()) OR time_o < now() - $2::float8 * interval '1 millisecond')
+	ORDER BY xid, id_o
+	LIMIT $1
+	FOR UPDATE`
+	notifyTopic     = "SELECT pg_notify($1, $2)"
+	markPublished   = "UPDATE Outbox SET published_at = now() WHERE id_o = ANY($1)"
+	deletePublished = "DELETE FROM Outbox WHERE published_at < $1"
+)
+
+// writeOutbox stores an event in the outbox inside tx and wakes up the relay after commit.
+func writeOutbox(ctx context.Context, tx pgx.Tx, topic string, payload []byte) error {
+	if _, err := tx.Exec(ctx, insertOutbox, topic, payload); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, notifyOutbox)
+	return err
+}
+
+// RelayOutbox publishes committed outbox events with NOTIFY on their topics.
+// Events are published in order of transactions which wrote them: an event waits until every older transaction finishes,
+// since ids of the outbox are taken before commit and do not follow the commit order, but not longer than OUTBOX_MAX_WAIT.
+// Events are published at least once: an event is marked as published in the same transaction that sends it.
+func (r *PgCommentRepository) RelayOutbox(ctx context.Context) {
+	wake := make(chan struct{}, 1)
+	go r.listenOutbox(ctx, wake)
+
+	t := time.NewTicker(OUTBOX_POLL_INTERVAL)
+	defer t.Stop()
+	cleaned := time.Now()
+	for {
+		for {
+			n, err := r.relayBatch(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Error().Err(err).Msg("can not relay outbox events")
+				}
+				break
+			}
+			if n < OUTBOX_BATCH {
+				break
+			}
+		}
+		if time.Since(cleaned) > OUTBOX_RETENTION/24 {
+			if _, err := r.pool.Exec(ctx, deletePublished, time.Now().Add(-OUTBOX_RETENTION)); err != nil && ctx.Err() == nil {
+				log.Error().Err(err).Msg("can not clean outbox")
+			}
+			cleaned = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("outbox relay stopped")
+			return
+		case <-t.C:
+		case <-wake:
+		}
+	}
+}
+
+// flushOutbox publishes events left in the outbox after the relay is stopped.
+func (r *PgCommentRepository) flushOutbox(ctx context.Context) error {
+	for {
+		n, err := r.relayBatch(ctx)
+		if err != nil {
+			return err
+		}
+		if n < OUTBOX_BATCH {
+			return nil
+		}
+	}
+}
+
+func (r *PgCommentRepository) relayBatch(ctx context.Context) (int, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var locked bool
+	if err := tx.QueryRow(ctx, lockOutbox, outboxLockKey).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		log.Debug().Msg("outbox is relayed by another instance")
+		return 0, nil
+	}
+
+	rows, err := tx.Query(ctx, searchUnpublished, OUTBOX_BATCH, OUTBOX_MAX_WAIT.Milliseconds())
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	b := &pgx.Batch{}
+	for rows.Next() {
+		var id int64
+		var topic, payload string
+		if err := rows.Scan(&id, &topic, &payload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+		b.Queue(notifyTopic, topic, payload)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	b.Queue(markPublished, ids)
+	if err := tx.SendBatch(ctx, b).Close(); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	log.Debug().Int("events", len(ids)).Int64("last id", ids[len(ids)-1]).Msg("outbox events published")
+	return len(ids), nil
+}
+
+// listenOutbox wakes up the relay when new events are committed.
+// The relay also polls the outbox, so lost connection only delays events.
+func (r *PgCommentRepository) listenOutbox(ctx context.Context, wake chan<- struct{}) {
+	for ctx.Err() == nil {
+		err := r.waitOutbox(ctx, wake)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Error().Err(err).Msg("outbox listener stopped, restarting")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(OUTBOX_POLL_INTERVAL):
+		}
+	}
+}
+
+func (r *PgCommentRepository) waitOutbox(ctx context.Context, wake chan<- struct{}) error {
+	conn, err := r.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// listening connection is not returned to the pool
+	c := conn.Hijack()
+	defer c.Close(context.Background())
+	if _, err := c.Exec(ctx, "LISTEN "+OUTBOX_CHANNEL); err != nil {
+		return err
+	}
+	for {
+		if _, err := c.WaitForNotification(ctx); err != nil {
+			return err
+		}
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
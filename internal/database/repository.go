@@ -4,8 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"slices"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/mi-raf/comment-project/internal/logging"
 	"github.com/rs/zerolog/log"
 
 	"github.com/jackc/pgx/v5"
@@ -17,68 +21,158 @@ import (
 const (
 	insertPost    = "INSERT INTO Post (author, title, text_p, comm, time_p) VALUES ($1, $2, $3, $4, $5) RETURNING id_p"
 	insertComment = "INSERT INTO Comment (id_p, parent, author, text_c, time_c) VALUES ($1, $2, $3, $4, $5) RETURNING id_c"
-	searchAllPost = `SELECT id_p, author, title, text_p, comm, time_p 
+	searchAllPost = `SELECT id_p, author, title, text_p, comm, time_p, comment_count, last_comment_at 
 	FROM Post 
 	ORDER BY time_p DESC
 	LIMIT $1
 	OFFSET $2`
-	searchPost = `SELECT  id_p, author, title, text_p, comm, time_p 
+	searchAllPostByActivity = `SELECT id_p, author, title, text_p, comm, time_p, comment_count, last_comment_at 
+	FROM Post 
+	ORDER BY COALESCE(last_comment_at, time_p) DESC, id_p DESC
+	LIMIT $1
+	OFFSET $2`
+	searchPost = `SELECT  id_p, author, title, text_p, comm, time_p, comment_count, last_comment_at 
 	FROM Post 
 	WHERE id_p = $1`
-	searchPostIsComment  = "SELECT  comm FROM Post WHERE id_p = $1"
-	searchCommentAllPost = `WITH RECURSIVE comment_tree AS (
+	searchPostsOfAuthor = `SELECT id_p, author, title, text_p, comm, time_p, comment_count, last_comment_at 
+	FROM Post 
+	WHERE author = $1
+	ORDER BY time_p DESC, id_p DESC
+	LIMIT $2
+	OFFSET $3`
+	searchPostActivity = "SELECT count(*), min(time_p) FROM Post WHERE author = $1"
+	updatePostCounters = "UPDATE Post SET comment_count = comment_count + 1, last_comment_at = GREATEST(last_comment_at, $2) WHERE id_p = $1"
+	recountComments    = `UPDATE Post p
+	SET comment_count = COALESCE(c.cnt, 0), last_comment_at = c.last
+	FROM Post p2
+	LEFT JOIN (SELECT id_p, count(*) AS cnt, max(time_c) AS last FROM Comment GROUP BY id_p) c ON c.id_p = p2.id_p
+	WHERE p.id_p = p2.id_p
+		AND (p.comment_count <> COALESCE(c.cnt, 0) OR p.last_comment_at IS DISTINCT FROM c.last)`
+	searchPostIsComment = "SELECT  comm FROM Post WHERE id_p = $1"
+	// searchCommentAllPost returns $2 comments of every post with the id in $3 after the comment $1, 0 starts from the first one.
+	// Pinned subtrees go first: subtrees of comments in $4 in their order, then, if $5 is set, of other pins in pin order.
+	// A comment belongs to the subtree of its first pinned ancestor, so pins made later do not move comments
+	// which are already read, and the place of a comment depends only on $4 and $5.
+	searchCommentAllPost = `WITH RECURSIVE pins AS (
+		SELECT s.id_c, s.pos
+		FROM unnest($4::bigint[]) WITH ORDINALITY AS s(id_c, pos)
+
+		UNION ALL
+
+		SELECT pn.id_c, cardinality($4::bigint[]) + ROW_NUMBER() OVER (PARTITION BY pn.id_p ORDER BY pn.time_pin, pn.id_c)
+		FROM Pin pn
+		WHERE $5 AND pn.id_p = ANY($3) AND pn.id_c <> ALL($4::bigint[])
+	),
+	comment_tree AS (
 		SELECT
-			id_c,
-			parent,
-			author, 
-			text_c,
-			time_c,
+			c.id_c,
+			c.id_p,
+			c.parent,
+			c.author, 
+			c.text_c,
+			c.time_c,
 			1 AS level,
-			ARRAY[id_c] AS path
-		FROM Comment
-		WHERE parent IS NULL
+			ARRAY[c.id_c] AS path,
+			p.pos AS pin_pos
+		FROM Comment c
+		LEFT JOIN pins p ON p.id_c = c.id_c
+		WHERE c.parent IS NULL AND c.id_p = ANY($3)
 	
 		UNION ALL
 	
 		SELECT
 			c.id_c,
+			c.id_p,
 			c.parent,
 			c.author, 
 			c.text_c,
 			c.time_c,
 			ct.level + 1 AS level,
-			ct.path || c.id_c
+			ct.path || c.id_c,
+			LEAST(ct.pin_pos, p.pos)
 		FROM Comment c
 		INNER JOIN comment_tree ct ON c.parent = ct.id_c
+		LEFT JOIN pins p ON p.id_c = c.id_c
 	),
 	numbered_comments AS (
-		SELECT *,
-			   ROW_NUMBER() OVER (ORDER BY path) AS row_num
-		FROM comment_tree
+		SELECT ct.*,
+			   ROW_NUMBER() OVER (PARTITION BY ct.id_p ORDER BY ct.pin_pos IS NULL, ct.pin_pos, ct.path) AS row_num
+		FROM comment_tree ct
+	),
+	start AS (
+		SELECT CASE WHEN $1::bigint = 0 THEN 0 ELSE (SELECT row_num FROM numbered_comments WHERE id_c = $1) END AS row_num
 	)
-	SELECT id_c, parent, author, text_c, time_c, level
-	FROM numbered_comments
-	WHERE row_num > $1
-	ORDER BY row_num
-	LIMIT $2;`
+	SELECT n.id_c, n.id_p, n.parent, n.author, n.text_c, n.time_c, n.level,
+		EXISTS (SELECT 1 FROM Pin WHERE Pin.id_c = n.id_c) AS pinned,
+		EXISTS (SELECT 1 FROM Comment r INNER JOIN Post p ON p.id_p = r.id_p WHERE r.parent = n.id_c AND r.author = p.author) AS author_replied,
+		n.pin_pos IS NOT NULL AS pin_section
+	FROM numbered_comments n, start s
+	WHERE n.row_num > s.row_num AND n.row_num <= s.row_num + $2
+	ORDER BY n.id_p, n.row_num;`
+	searchCommentsOfAuthor = `WITH RECURSIVE page AS (
+		SELECT id_c, id_p, parent, author, text_c, time_c
+		FROM Comment
+		WHERE author = $1 AND ($3::bigint = 0 OR (time_c, id_c) < (SELECT time_c, id_c FROM Comment WHERE id_c = $3))
+		ORDER BY time_c DESC, id_c DESC
+		LIMIT $2
+	),
+	ancestors AS (
+		SELECT id_c AS origin, parent, 1 AS level
+		FROM page
+
+		UNION ALL
+
+		SELECT a.origin, c.parent, a.level + 1
+		FROM ancestors a
+		INNER JOIN Comment c ON c.id_c = a.parent
+	)
+	SELECT p.id_c, p.id_p, p.parent, p.author, p.text_c, p.time_c,
+		(SELECT max(level) FROM ancestors a WHERE a.origin = p.id_c) AS level
+	FROM page p
+	ORDER BY p.time_c DESC, p.id_c DESC;`
+	searchCommentActivity = "SELECT count(*), min(time_c) FROM Comment WHERE author = $1"
+	lockPost              = "SELECT id_p FROM Post WHERE id_p = $1 FOR UPDATE"
+	searchPinExists       = "SELECT EXISTS (SELECT 1 FROM Pin WHERE id_c = $1 AND id_p = $2)"
+	searchPinCount        = "SELECT count(*) FROM Pin WHERE id_p = $1"
+	insertPin             = "INSERT INTO Pin (id_c, id_p) SELECT id_c, id_p FROM Comment WHERE id_c = $1 AND id_p = $2"
+	deletePin             = "DELETE FROM Pin WHERE id_c = $1 AND id_p = $2"
 )
 
 const (
 	STARTCAP = 128
 )
 
+var (
+	ErrPinLimit  = errors.New("pinned comments limit reached")
+	ErrNoComment = errors.New("there is no such comment in post")
+)
+
 type (
 	PostRepository interface {
 		//Delete(ctx context.Context) error
 		Add(ctx context.Context, post *mod.PostDTO) (int64, error)
-		GetAll(ctx context.Context, offset int64, limit int) ([]*mod.PostDTO, error)
+		AddBatch(ctx context.Context, posts []*mod.PostDTO) ([]int64, error)
+		GetAll(ctx context.Context, offset int64, limit int, order mod.PostOrder) ([]*mod.PostDTO, error)
 		Get(ctx context.Context, id int64) (*mod.PostDTO, error)
+		GetManyByIDs(ctx context.Context, ids []int64) ([]*mod.PostDTO, error)
 		IsCommentable(ctx context.Context, id int64) (bool, error)
+		RecountComments(ctx context.Context) (int64, error)
+		GetAllOfAuthor(ctx context.Context, author string, offset int64, limit int) ([]*mod.PostDTO, error)
+		AuthorActivity(ctx context.Context, author string) (*mod.ActivityDTO, error)
 	}
 
 	CommentRepository interface {
 		Add(ctx context.Context, c *mod.CommentDTO) (int64, error)
-		GetAllOfPost(ctx context.Context, idPost int64, offset int64, limit int) ([]*mod.CommentDTO, error)
+		AddBatch(ctx context.Context, comments []*mod.CommentDTO) ([]int64, error)
+		// GetAllOfPost returns limit comments of the post after the cursor, pinned subtrees first.
+		GetAllOfPost(ctx context.Context, idPost int64, after mod.CommentCursor, limit int) ([]*mod.CommentDTO, error)
+		GetAllOfPosts(ctx context.Context, ids []int64, limit int) (map[int64][]*mod.CommentDTO, error)
+		GetManyByIDs(ctx context.Context, ids []int64) ([]*mod.CommentDTO, error)
+		Pin(ctx context.Context, idPost int64, id int64, limit int) error
+		Unpin(ctx context.Context, idPost int64, id int64) error
+		// GetAllOfAuthor returns limit comments of the author from the newest one after the comment with the id after, 0 starts from the newest.
+		GetAllOfAuthor(ctx context.Context, author string, after int64, limit int) ([]*mod.CommentDTO, error)
+		AuthorActivity(ctx context.Context, author string) (*mod.ActivityDTO, error)
 	}
 
 	PostConfig struct {
@@ -93,12 +187,19 @@ type (
 	}
 
 	PgPostRepository struct {
-		pool *pgxpool.Pool
+		pool   *pgxpool.Pool
+		events EventEncoder
 	}
 
 	PgCommentRepository struct {
-		pool *pgxpool.Pool
-		ch   chan<- mod.CommentDTO
+		pool     *pgxpool.Pool
+		ch       chan<- mod.CommentDTO
+		updates  chan int64
+		events   EventEncoder
+		listener listenerState
+		// stop cancels the listener and the outbox relay, wg waits for them
+		stop context.CancelFunc
+		wg   sync.WaitGroup
 	}
 
 	InMemoryPostRepository struct {
@@ -108,11 +209,12 @@ type (
 	}
 
 	InMemoryCommentRepository struct {
-		c        map[int64][]*mod.CommentDTO
-		indexMap map[int64]int
-		ch       chan<- mod.CommentDTO
-		m        sync.RWMutex
-		idGen    int64
+		c     map[int64][]*mod.CommentDTO
+		pins  map[int64][]int64
+		posts *InMemoryPostRepository
+		ch    chan<- mod.CommentDTO
+		m     sync.RWMutex
+		idGen int64
 	}
 
 	InMemCommentNode struct {
@@ -129,10 +231,10 @@ type (
 
 func NewPostRepositoryProvider(ctx context.Context, cfg *PostConfig) (PostRepository, func(), error) {
 	if cfg.InMemory {
-		return NewInMemnoryPostRepository(), nil, nil
+		return NewInMemnoryPostRepository(), func() {}, nil
 	}
 
-	pg, err := pgxpool.New(ctx, cfg.DbAddr)
+	pg, err := newPool(ctx, cfg.DbAddr)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -143,11 +245,15 @@ func NewPostRepositoryProvider(ctx context.Context, cfg *PostConfig) (PostReposi
 
 	return NewPgPostRepository(ctx, pg), pg.Close, nil
 }
-func NewCommentRepositoryProvider(ctx context.Context, cfg *CommentConfig) (CommentRepository, func(), error) {
+func NewCommentRepositoryProvider(ctx context.Context, cfg *CommentConfig, p PostRepository) (CommentRepository, func(), error) {
 	if cfg.InMemory {
-		return NewInMemnoryCommentRepository(cfg.CommentChan), nil, nil
+		r := NewInMemnoryCommentRepository(cfg.CommentChan)
+		if ip, ok := p.(*InMemoryPostRepository); ok {
+			r.posts = ip
+		}
+		return r, func() {}, nil
 	}
-	pg, err := pgxpool.New(ctx, cfg.DbAddr)
+	pg, err := newPool(ctx, cfg.DbAddr)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -155,7 +261,14 @@ func NewCommentRepositoryProvider(ctx context.Context, cfg *CommentConfig) (Comm
 	if err != nil {
 		return nil, nil, err
 	}
-	return NewPgCommentRepository(ctx, pg, cfg.CommentChan), pg.Close, nil
+	r := NewPgCommentRepository(ctx, pg, cfg.CommentChan)
+	return r, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), OUTBOX_FLUSH_TIMEOUT)
+		defer cancel()
+		if err := r.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("can not publish outbox events on shutdown")
+		}
+	}, nil
 }
 
 func NewPgPostRepository(ctx context.Context, p *pgxpool.Pool) *PgPostRepository {
@@ -170,59 +283,139 @@ func (r *PgPostRepository) Close(ctx context.Context) error {
 func (r *PgPostRepository) Add(ctx context.Context, post *mod.PostDTO) (int64, error) {
 	tx, err := r.pool.Begin(ctx)
 	if err != nil {
-		log.Error().Err(err).Msg("can't open transaction for add post")
+		logging.Ctx(ctx).Error().Err(err).Msg("can't open transaction for add post")
 		return -1, err
 	}
 
 	defer func() {
 		err = tx.Rollback(ctx)
 		if !errors.Is(err, pgx.ErrTxClosed) {
-			log.Error().Err(err).Msg("Undefinded error in tx")
+			logging.Ctx(ctx).Error().Err(err).Msg("Undefinded error in tx")
 		}
 	}()
 
-	log.Debug().Interface("post", post).Msg("add post")
+	logging.Ctx(ctx).Debug().Interface("post", post).Msg("add post")
 	var id int64
 	err = tx.QueryRow(ctx, insertPost, post.Author, post.Title, post.Text, post.IsCommentable, post.Time).Scan(&id)
 
 	if err != nil {
-		log.Error().Err(err).Msg("error insert received")
+		logging.Ctx(ctx).Error().Err(err).Msg("error insert received")
+		return -1, err
+	}
+
+	created := *post
+	created.Id = id
+	err = enqueueEvent(ctx, tx, r.events, mod.EventPostCreated, &created)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Int64("post id", id).Msg("can not enqueue webhook deliveries")
 		return -1, err
 	}
 
 	return id, tx.Commit(ctx)
 }
 
-func (r *PgPostRepository) GetAll(ctx context.Context, offset int64, limit int) ([]*mod.PostDTO, error) {
-	rows, err := r.pool.Query(ctx, searchAllPost, limit, offset)
+func (r *PgPostRepository) WriteEvents(enc EventEncoder) {
+	r.events = enc
+}
+
+func (r *PgPostRepository) GetAll(ctx context.Context, offset int64, limit int, order mod.PostOrder) ([]*mod.PostDTO, error) {
+	query := searchAllPost
+	if order == mod.PostOrderLastActivity {
+		query = searchAllPostByActivity
+	}
+	rows, err := r.pool.Query(ctx, query, limit, offset)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	posts := make([]*mod.PostDTO, 0)
 
 	for rows.Next() {
-		var p mod.PostDTO
-		err = rows.Scan(&p.Id, &p.Author, &p.Title, &p.Text, &p.IsCommentable, &p.Time)
+		p, err := scanPost(rows)
 		if err != nil {
 			return nil, err
 		}
-		posts = append(posts, &p)
+		posts = append(posts, p)
 	}
 
-	return posts, nil
+	return posts, rows.Err()
 }
 
 func (r *PgPostRepository) Get(ctx context.Context, id int64) (*mod.PostDTO, error) {
-	row := r.pool.QueryRow(ctx, searchPost, id)
-	p := mod.PostDTO{}
-	err := row.Scan(&p.Id, &p.Author, &p.Title, &p.Text, &p.IsCommentable, &p.Time)
+	p, err := scanPost(r.pool.QueryRow(ctx, searchPost, id))
 	if err == pgx.ErrNoRows {
-		log.Error().Msg("no post with this id")
+		logging.Ctx(ctx).Error().Msg("no post with this id")
 		return nil, nil
 	}
 
-	return &p, err
+	return p, err
+}
+
+func (r *PgPostRepository) RecountComments(ctx context.Context) (int64, error) {
+	tag, err := r.pool.Exec(ctx, recountComments)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("can not recount comments")
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (r *PgPostRepository) GetAllOfAuthor(ctx context.Context, author string, offset int64, limit int) ([]*mod.PostDTO, error) {
+	rows, err := r.pool.Query(ctx, searchPostsOfAuthor, author, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := make([]*mod.PostDTO, 0, limit)
+	for rows.Next() {
+		p, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+func (r *PgPostRepository) AuthorActivity(ctx context.Context, author string) (*mod.ActivityDTO, error) {
+	var a mod.ActivityDTO
+	var first zeronull.Timestamptz
+	err := r.pool.QueryRow(ctx, searchPostActivity, author).Scan(&a.Count, &first)
+	if err != nil {
+		return nil, err
+	}
+	a.FirstSeen = time.Time(first)
+	return &a, nil
+}
+
+// scanCommentsWithPost reads rows of id, post id, parent, author, text, time and level.
+func scanCommentsWithPost(rows pgx.Rows, capacity int) ([]*mod.CommentDTO, error) {
+	defer rows.Close()
+	comments := make([]*mod.CommentDTO, 0, capacity)
+	for rows.Next() {
+		var c mod.CommentDTO
+		var parIdNil zeronull.Int8
+		err := rows.Scan(&c.Id, &c.PostId, &parIdNil, &c.Author, &c.Text, &c.Time, &c.Level)
+		if err != nil {
+			return nil, err
+		}
+		c.ParentId = int64(parIdNil)
+		comments = append(comments, &c)
+	}
+	return comments, rows.Err()
+}
+
+func scanPost(row pgx.Row) (*mod.PostDTO, error) {
+	var p mod.PostDTO
+	var last zeronull.Timestamptz
+	err := row.Scan(&p.Id, &p.Author, &p.Title, &p.Text, &p.IsCommentable, &p.Time, &p.CommentCount, &last)
+	if err != nil {
+		return nil, err
+	}
+	p.LastCommentAt = time.Time(last)
+	return &p, nil
 }
 
 func (r *PgPostRepository) IsCommentable(ctx context.Context, id int64) (bool, error) {
@@ -235,110 +428,212 @@ func (r *PgPostRepository) IsCommentable(ctx context.Context, id int64) (bool, e
 }
 
 func NewPgCommentRepository(ctx context.Context, p *pgxpool.Pool, commentChan chan<- mod.CommentDTO) *PgCommentRepository {
-	repo := &PgCommentRepository{pool: p, ch: commentChan}
-	go repo.Listen(ctx)
+	ctx, stop := context.WithCancel(ctx)
+	repo := &PgCommentRepository{pool: p, ch: commentChan, updates: make(chan int64, LISTEN_UPDATES_SIZE), stop: stop}
+	repo.wg.Add(2)
+	go func() {
+		defer repo.wg.Done()
+		repo.Listen(ctx)
+	}()
+	go func() {
+		defer repo.wg.Done()
+		repo.RelayOutbox(ctx)
+	}()
 	return repo
 }
 
+// Close stops the listener, so nothing is sent to the comment channel after it returns,
+// and the outbox relay, then publishes events left in the outbox and closes the pool.
 func (r *PgCommentRepository) Close(ctx context.Context) error {
+	r.stop()
+	r.wg.Wait()
+	err := r.flushOutbox(ctx)
 	r.pool.Close()
-	return nil
+	return err
 }
 
 func (r *PgCommentRepository) Add(ctx context.Context, c *mod.CommentDTO) (int64, error) {
 	tx, err := r.pool.Begin(ctx)
 	if err != nil {
-		log.Error().Err(err).Msg("can't open transaction for add comment")
+		logging.Ctx(ctx).Error().Err(err).Msg("can't open transaction for add comment")
 		return -1, err
 	}
 
 	defer func() {
 		err = tx.Rollback(ctx)
 		if !errors.Is(err, pgx.ErrTxClosed) {
-			log.Error().Err(err).Msg("Undefinded error in tx")
+			logging.Ctx(ctx).Error().Err(err).Msg("Undefinded error in tx")
 		}
 	}()
 
-	log.Debug().Interface("comment", c).Msg("add comment")
+	logging.Ctx(ctx).Debug().Int64("post id", c.PostId).Int64("parent id", c.ParentId).Str("author", c.Author).Str("text", logging.Redact(c.Text)).Msg("add comment")
 	var id int64
 	err = tx.QueryRow(ctx, insertComment, c.PostId, zeronull.Int8(c.ParentId), c.Author, c.Text, c.Time).Scan(&id)
 
 	if err != nil {
-		log.Error().Err(err).Msg("error insert received")
+		logging.Ctx(ctx).Error().Err(err).Msg("error insert received")
+		return -1, err
+	}
+
+	_, err = tx.Exec(ctx, updatePostCounters, c.PostId, c.Time)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Int64("post id", c.PostId).Msg("can not update post counters")
 		return -1, err
 	}
 
 	c.Id = id
-	j, err := json.Marshal(c)
+	// NOTIFY payload is limited, so the listener loads the comment by id
+	j, err := json.Marshal(commentNotification{Event: mod.EventCommentCreated, Id: id})
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Int64("comment id", id).Msg("can not marshall comment notification")
+		return -1, err
+	}
+
+	err = writeOutbox(ctx, tx, COMMENTS_CHANNEL, j)
 	if err != nil {
-		log.Error().Err(err).Interface("comment", c).Msg("can not marshall comment")
+		logging.Ctx(ctx).Error().Err(err).Int64("comment id", id).Msg("can not write comment to outbox")
 		return -1, err
 	}
 
-	_, err = r.pool.Exec(ctx, "select pg_notify('comments', $1)", string(j))
+	err = enqueueEvent(ctx, tx, r.events, mod.EventCommentCreated, c)
 	if err != nil {
-		log.Error().Err(err).Msg("can not notify clients")
+		logging.Ctx(ctx).Error().Err(err).Int64("comment id", id).Msg("can not enqueue webhook deliveries")
+		return -1, err
 	}
 	return id, tx.Commit(ctx)
 }
 
-func (r *PgCommentRepository) GetAllOfPost(ctx context.Context, idPost int64, offset int64, limit int) ([]*mod.CommentDTO, error) {
-	log.Debug().Interface("post id", idPost).Msg("get comments for post")
-	rows, err := r.pool.Query(ctx, searchCommentAllPost, offset, limit)
-	if err == pgx.ErrNoRows {
-		log.Debug().Msg("GetAll return 0 rows")
-		return nil, nil
-	}
+func (r *PgCommentRepository) WriteEvents(enc EventEncoder) {
+	r.events = enc
+}
+
+func (r *PgCommentRepository) GetAllOfPost(ctx context.Context, idPost int64, after mod.CommentCursor, limit int) ([]*mod.CommentDTO, error) {
+	logging.Ctx(ctx).Debug().Interface("post id", idPost).Int64("after", after.After).Msg("get comments for post")
+	rows, err := r.pool.Query(ctx, searchCommentAllPost, after.After, limit, []int64{idPost}, cursorPins(after), after.After == 0 || after.MorePins)
 	if err != nil {
-		log.Error().Err(err).Msg("can't return result getAll")
+		logging.Ctx(ctx).Error().Err(err).Msg("can't return result getAll")
 		return nil, err
 	}
-	comments := make([]*mod.CommentDTO, 0, limit)
-	for rows.Next() {
-		c := mod.CommentDTO{PostId: idPost}
-		var parIdNil zeronull.Int2
-		err = rows.Scan(&c.Id, &parIdNil, &c.Author, &c.Text, &c.Time, &c.Level)
-		c.ParentId = int64(parIdNil)
-		if err != nil {
-			return nil, err
-		}
-		comments = append(comments, &c)
+	return scanCommentPage(rows, limit)
+}
+
+// cursorPins returns pins of the cursor as a not nil array, NULL would not match any comment in searchCommentAllPost.
+func cursorPins(after mod.CommentCursor) []int64 {
+	if after.Pins == nil {
+		return []int64{}
 	}
-	return comments, nil
+	return after.Pins
 }
 
-func (c *PgCommentRepository) Listen(ctx context.Context) {
-	conn, err := c.pool.Acquire(ctx)
+func (r *PgCommentRepository) Pin(ctx context.Context, idPost int64, id int64, limit int) error {
+	tx, err := r.pool.Begin(ctx)
 	if err != nil {
-		log.Fatal().Err(err).Msg("can not accure conntection")
+		logging.Ctx(ctx).Error().Err(err).Msg("can't open transaction for pin comment")
+		return err
 	}
-	defer conn.Release()
 
-	_, err = conn.Exec(ctx, "listen comments")
+	defer func() {
+		err = tx.Rollback(ctx)
+		if !errors.Is(err, pgx.ErrTxClosed) {
+			logging.Ctx(ctx).Error().Err(err).Msg("Undefinded error in tx")
+		}
+	}()
+
+	var lockedId int64
+	err = tx.QueryRow(ctx, lockPost, idPost).Scan(&lockedId)
+	if err == pgx.ErrNoRows {
+		return ErrNoComment
+	}
 	if err != nil {
-		log.Fatal().Err(err).Msg("can not listen")
+		logging.Ctx(ctx).Error().Err(err).Int64("post id", idPost).Msg("can not lock post")
+		return err
 	}
 
-	for {
-		n, err := conn.Conn().WaitForNotification(ctx)
-		if err != nil {
-			log.Error().Err(err).Msg("listen stopped")
-			return
-		}
+	var exists bool
+	if err = tx.QueryRow(ctx, searchPinExists, id, idPost).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		logging.Ctx(ctx).Debug().Int64("comment id", id).Msg("comment already pinned")
+		return nil
+	}
 
-		var res mod.CommentDTO
-		log.Debug().Uint32("PID", n.PID).Str("channel", n.Channel).Str("payload", n.Payload).Msg("notification received")
-		err = json.Unmarshal([]byte(n.Payload), &res)
-		if err != nil {
-			log.Error().Err(err).Str("payload", n.Payload).Msg("can not unmarshall notification payload")
-			continue
+	var count int
+	if err = tx.QueryRow(ctx, searchPinCount, idPost).Scan(&count); err != nil {
+		return err
+	}
+	if count >= limit {
+		return ErrPinLimit
+	}
+
+	tag, err := tx.Exec(ctx, insertPin, id, idPost)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("error insert received")
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoComment
+	}
+	if err = writeUpdate(ctx, tx, idPost, id); err != nil {
+		logging.Ctx(ctx).Error().Err(err).Int64("comment id", id).Msg("can not write pin to outbox")
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *PgCommentRepository) Unpin(ctx context.Context, idPost int64, id int64) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("can't open transaction for unpin comment")
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, deletePin, id, idPost)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() > 0 {
+		if err := writeUpdate(ctx, tx, idPost, id); err != nil {
+			logging.Ctx(ctx).Error().Err(err).Int64("comment id", id).Msg("can not write unpin to outbox")
+			return err
 		}
-		c.ch <- res
 	}
+	return tx.Commit(ctx)
+}
+
+// writeUpdate notifies all instances about the changed comment of the post, so they drop the post from caches.
+func writeUpdate(ctx context.Context, tx pgx.Tx, idPost int64, id int64) error {
+	j, err := json.Marshal(commentNotification{Event: mod.EventCommentUpdated, Id: id, PostId: idPost})
+	if err != nil {
+		return err
+	}
+	return writeOutbox(ctx, tx, COMMENTS_CHANNEL, j)
+}
+
+func (r *PgCommentRepository) GetAllOfAuthor(ctx context.Context, author string, after int64, limit int) ([]*mod.CommentDTO, error) {
+	logging.Ctx(ctx).Debug().Str("author", author).Int64("after", after).Msg("get comments of author")
+	rows, err := r.pool.Query(ctx, searchCommentsOfAuthor, author, limit, after)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("can't return result of author comments")
+		return nil, err
+	}
+	return scanCommentsWithPost(rows, limit)
+}
+
+func (r *PgCommentRepository) AuthorActivity(ctx context.Context, author string) (*mod.ActivityDTO, error) {
+	var a mod.ActivityDTO
+	var first zeronull.Timestamp
+	err := r.pool.QueryRow(ctx, searchCommentActivity, author).Scan(&a.Count, &first)
+	if err != nil {
+		return nil, err
+	}
+	a.FirstSeen = time.Time(first)
+	return &a, nil
 }
 
 func NewInMemnoryPostRepository() *InMemoryPostRepository {
-	return &InMemoryPostRepository{}
+	return &InMemoryPostRepository{posts: make(map[int64]*mod.PostDTO, STARTCAP), idGen: 1}
 }
 
 func (r *InMemoryPostRepository) Add(ctx context.Context, post *mod.PostDTO) (int64, error) {
@@ -346,59 +641,153 @@ func (r *InMemoryPostRepository) Add(ctx context.Context, post *mod.PostDTO) (in
 	defer r.m.Unlock()
 	post.Id = r.idGen
 	r.idGen++
-	r.posts[int64(post.Id)] = post
+	p := *post
+	r.posts[int64(post.Id)] = &p
 	return post.Id, nil
 }
 
-func (r *InMemoryPostRepository) GetAll(ctx context.Context, offset int64, limit int) ([]*mod.PostDTO, error) {
+func (r *InMemoryPostRepository) addComment(id int64, t time.Time) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	p, ok := r.posts[id]
+	if !ok {
+		return
+	}
+	p.CommentCount++
+	if t.After(p.LastCommentAt) {
+		p.LastCommentAt = t
+	}
+}
+
+func (r *InMemoryPostRepository) author(id int64) string {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	p, ok := r.posts[id]
+	if !ok {
+		return ""
+	}
+	return p.Author
+}
+
+func (r *InMemoryPostRepository) GetAll(ctx context.Context, offset int64, limit int, order mod.PostOrder) ([]*mod.PostDTO, error) {
 	r.m.RLock()
 	defer r.m.RUnlock()
-	p := make([]*mod.PostDTO, 0, limit)
-	for i := offset; limit > 0; {
-		p = append(p, r.posts[i])
-		i++
-		limit--
+	all := make([]*mod.PostDTO, 0, len(r.posts))
+	for _, p := range r.posts {
+		c := *p
+		all = append(all, &c)
+	}
+	key := func(p *mod.PostDTO) time.Time {
+		if order == mod.PostOrderLastActivity && p.LastCommentAt.After(p.Time) {
+			return p.LastCommentAt
+		}
+		return p.Time
 	}
-	return p, nil
+	sort.Slice(all, func(i, j int) bool {
+		if ki, kj := key(all[i]), key(all[j]); !ki.Equal(kj) {
+			return ki.After(kj)
+		}
+		return all[i].Id > all[j].Id
+	})
+	if offset >= int64(len(all)) {
+		return []*mod.PostDTO{}, nil
+	}
+	all = all[offset:]
+	if limit < len(all) {
+		all = all[:limit]
+	}
+	return all, nil
 }
 
 func (r *InMemoryPostRepository) Get(ctx context.Context, id int64) (*mod.PostDTO, error) {
 	r.m.RLock()
 	defer r.m.RUnlock()
-	p := r.posts[id]
-	return p, nil
+	p, ok := r.posts[id]
+	if !ok {
+		return nil, nil
+	}
+	c := *p
+	return &c, nil
+}
+
+func (r *InMemoryPostRepository) GetAllOfAuthor(ctx context.Context, author string, offset int64, limit int) ([]*mod.PostDTO, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	var all []*mod.PostDTO
+	for _, p := range r.posts {
+		if p.Author == author {
+			c := *p
+			all = append(all, &c)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if !all[i].Time.Equal(all[j].Time) {
+			return all[i].Time.After(all[j].Time)
+		}
+		return all[i].Id > all[j].Id
+	})
+	if offset >= int64(len(all)) {
+		return []*mod.PostDTO{}, nil
+	}
+	all = all[offset:]
+	if limit < len(all) {
+		all = all[:limit]
+	}
+	return all, nil
+}
+
+func (r *InMemoryPostRepository) AuthorActivity(ctx context.Context, author string) (*mod.ActivityDTO, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	var a mod.ActivityDTO
+	for _, p := range r.posts {
+		if p.Author != author {
+			continue
+		}
+		a.Count++
+		if a.FirstSeen.IsZero() || p.Time.Before(a.FirstSeen) {
+			a.FirstSeen = p.Time
+		}
+	}
+	return &a, nil
+}
+
+// RecountComments has nothing to fix for in-memory posts,
+// their counters are updated under the same lock as comments are stored.
+func (r *InMemoryPostRepository) RecountComments(ctx context.Context) (int64, error) {
+	return 0, nil
 }
 
 func (r *InMemoryPostRepository) IsCommentable(ctx context.Context, id int64) (bool, error) {
 	r.m.RLock()
 	defer r.m.RUnlock()
-	isCom := r.posts[id].IsCommentable
-	return isCom, nil
+	p, ok := r.posts[id]
+	if !ok {
+		return false, nil
+	}
+	return p.IsCommentable, nil
 }
 
 func NewInMemnoryCommentRepository(ch chan<- mod.CommentDTO) *InMemoryCommentRepository {
 	c := make(map[int64][]*mod.CommentDTO, STARTCAP)
-	return &InMemoryCommentRepository{c: c, ch: ch, idGen: 1}
+	return &InMemoryCommentRepository{c: c, pins: make(map[int64][]int64), ch: ch, idGen: 1}
 }
 
 func (r *InMemoryCommentRepository) Add(ctx context.Context, c *mod.CommentDTO) (int64, error) {
 	r.m.Lock()
 	defer r.m.Unlock()
-	comments, ok := r.c[c.PostId]
-	if !ok {
-		return 0, errors.New("there is no such post")
+	comments := r.c[c.PostId]
+	if c.ParentId != 0 {
+		if _, ok := searchIndex(comments, c.ParentId); !ok {
+			return 0, errors.New("there is no such parent comment")
+		}
 	}
 	c.Id = r.idGen
 	r.idGen++
-	ind, ok := searchIndex(comments, c.ParentId)
-	if !ok {
-		comments = append(comments, c)
-		copy(comments[1:], comments)
-		comments[0] = c
-
+	r.c[c.PostId] = append(comments, c)
+	if r.posts != nil {
+		r.posts.addComment(c.PostId, c.Time)
 	}
-	comments = append(comments[:ind+1], comments[ind:]...)
-	comments[ind] = c
 	r.ch <- *c
 	return c.Id, nil
 }
@@ -412,18 +801,175 @@ func searchIndex(data []*mod.CommentDTO, id int64) (int, bool) {
 	return -1, false
 }
 
-func (r *InMemoryCommentRepository) GetAllOfPost(ctx context.Context, idPost, offset int64, limit int) ([]*mod.CommentDTO, error) {
+func (r *InMemoryCommentRepository) GetAllOfPost(ctx context.Context, idPost int64, after mod.CommentCursor, limit int) ([]*mod.CommentDTO, error) {
+	var author string
+	if r.posts != nil {
+		author = r.posts.author(idPost)
+	}
 	r.m.RLock()
 	defer r.m.RUnlock()
-	comments, ok := r.c[idPost]
-	if !ok {
-		return nil, errors.New("there is no such post")
+	pins := after.Pins
+	if after.After == 0 || after.MorePins {
+		for _, id := range r.pins[idPost] {
+			if !slices.Contains(pins, id) {
+				pins = append(slices.Clip(pins), id)
+			}
+		}
 	}
-	ind, ok := searchIndex(comments, offset)
-	if !ok {
-		return nil, errors.New("there is no such parent comment")
+	comments := r.sorted(idPost, author, pins)
+	start := 0
+	if after.After != 0 {
+		i := slices.IndexFunc(comments, func(c *mod.CommentDTO) bool { return c.Id == after.After })
+		if i < 0 {
+			return nil, nil
+		}
+		start = i + 1
+	}
+	comments = comments[start:]
+	if limit < len(comments) {
+		comments = comments[:limit]
+	}
+	return comments, nil
+}
+
+// sorted returns copies of post comments in the same order as searchCommentAllPost:
+// subtrees of pins in their order first, then the rest of the tree without them.
+// A comment belongs to the subtree of its first pinned ancestor.
+func (r *InMemoryCommentRepository) sorted(idPost int64, author string, pins []int64) []*mod.CommentDTO {
+	nodes := make(map[int64]*InMemCommentNode, len(r.c[idPost]))
+	var roots []*InMemCommentNode
+	for _, c := range r.c[idPost] {
+		n := &InMemCommentNode{v: *c}
+		nodes[c.Id] = n
+		if c.ParentId == 0 {
+			roots = append(roots, n)
+			continue
+		}
+		p := nodes[c.ParentId]
+		p.childs = append(p.childs, n)
+		if author != "" && c.Author == author {
+			p.v.AuthorReplied = true
+		}
+	}
+
+	pinned := make(map[int64]bool, len(r.pins[idPost]))
+	for _, id := range r.pins[idPost] {
+		pinned[id] = true
+	}
+	pos := make(map[int64]int, len(pins))
+	for i, id := range pins {
+		pos[id] = i
+	}
+
+	// comments of every pinned subtree and the rest in the order of the tree
+	sections := make([][]*mod.CommentDTO, len(pins)+1)
+	var walk func(n *InMemCommentNode, level int, section int)
+	walk = func(n *InMemCommentNode, level int, section int) {
+		if i, ok := pos[n.v.Id]; ok && i < section {
+			section = i
+		}
+		c := n.v
+		c.Level = level
+		c.Pinned = pinned[c.Id]
+		c.PinSection = section < len(pins)
+		sections[section] = append(sections[section], &c)
+		for _, ch := range n.childs {
+			walk(ch, level+1, section)
+		}
+	}
+	for _, n := range roots {
+		walk(n, 1, len(pins))
+	}
+	res := make([]*mod.CommentDTO, 0, len(nodes))
+	for _, cs := range sections {
+		res = append(res, cs...)
+	}
+	return res
+}
+
+func (r *InMemoryCommentRepository) Pin(ctx context.Context, idPost int64, id int64, limit int) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if _, ok := searchIndex(r.c[idPost], id); !ok {
+		return ErrNoComment
+	}
+	pins := r.pins[idPost]
+	for _, p := range pins {
+		if p == id {
+			return nil
+		}
+	}
+	if len(pins) >= limit {
+		return ErrPinLimit
+	}
+	r.pins[idPost] = append(pins, id)
+	return nil
+}
+
+func (r *InMemoryCommentRepository) Unpin(ctx context.Context, idPost int64, id int64) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	pins := r.pins[idPost]
+	for i, p := range pins {
+		if p == id {
+			r.pins[idPost] = append(pins[:i], pins[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r *InMemoryCommentRepository) GetAllOfAuthor(ctx context.Context, author string, after int64, limit int) ([]*mod.CommentDTO, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	var all []*mod.CommentDTO
+	for _, comments := range r.c {
+		for _, c := range comments {
+			if c.Author != author {
+				continue
+			}
+			res := *c
+			res.Level = 1
+			for p := c.ParentId; p != 0; res.Level++ {
+				i, _ := searchIndex(comments, p)
+				p = comments[i].ParentId
+			}
+			all = append(all, &res)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if !all[i].Time.Equal(all[j].Time) {
+			return all[i].Time.After(all[j].Time)
+		}
+		return all[i].Id > all[j].Id
+	})
+	if after != 0 {
+		i := slices.IndexFunc(all, func(c *mod.CommentDTO) bool { return c.Id == after })
+		if i < 0 {
+			return nil, nil
+		}
+		all = all[i+1:]
+	}
+	if limit < len(all) {
+		all = all[:limit]
+	}
+	return all, nil
+}
+
+func (r *InMemoryCommentRepository) AuthorActivity(ctx context.Context, author string) (*mod.ActivityDTO, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	var a mod.ActivityDTO
+	for _, comments := range r.c {
+		for _, c := range comments {
+			if c.Author != author {
+				continue
+			}
+			a.Count++
+			if a.FirstSeen.IsZero() || c.Time.Before(a.FirstSeen) {
+				a.FirstSeen = c.Time
+			}
+		}
 	}
-	var result []*mod.CommentDTO
-	copy(comments[ind:ind+int(limit)], result)
-	return result, nil
+	return &a, nil
 }
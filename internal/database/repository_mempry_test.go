@@ -83,7 +83,7 @@ func (s *PgPostRepositoryMemoryTestSuite) TestGetSmallPosts() {
 	}
 
 	// when
-	actual, err := s.r.GetAll(s.ctx, 0, 30)
+	actual, err := s.r.GetAll(s.ctx, 0, 30, models.PostOrderTime)
 
 	log.Debug().Int("actual len", len(actual)).Msgf("actual posts: %+v", actual)
 	// then
@@ -107,7 +107,7 @@ func (s *PgPostRepositoryMemoryTestSuite) TestGetSmallPostsPagination() {
 	}
 
 	// when
-	actual, err := s.r.GetAll(s.ctx, 5, 10)
+	actual, err := s.r.GetAll(s.ctx, 5, 10, models.PostOrderTime)
 
 	// then
 	log.Debug().Int("actual len", len(actual)).Msgf(" pagination actual posts: %+v", actual)
@@ -128,10 +128,17 @@ type PgCommentRepositoryMemoryTestSuite struct {
 
 func (suite *PgCommentRepositoryMemoryTestSuite) SetupSuite() {
 	suite.ctx = context.Background()
-	var err error
-	c := make(chan<- models.CommentDTO, 10)
-	suite.r = database.NewInMemnoryCommentRepository(c)
-	suite.NoError(err)
+	suite.ch = make(chan models.CommentDTO, 100)
+}
+
+func (suite *PgCommentRepositoryMemoryTestSuite) SetupTest() {
+	suite.r = database.NewInMemnoryCommentRepository(suite.ch)
+}
+
+func (suite *PgCommentRepositoryMemoryTestSuite) TearDownTest() {
+	for len(suite.ch) > 0 {
+		<-suite.ch
+	}
 }
 
 func (s *PgCommentRepositoryMemoryTestSuite) TearDownSuite() {
@@ -171,7 +178,7 @@ func (s *PgCommentRepositoryMemoryTestSuite) TestGetComments() {
 	}
 
 	// when
-	actual, err := s.r.GetAllOfPost(s.ctx, postID, 0, 10)
+	actual, err := s.r.GetAllOfPost(s.ctx, postID, models.CommentCursor{}, 10)
 
 	// then
 	s.NoError(err)
@@ -188,13 +195,270 @@ func (s *PgCommentRepositoryMemoryTestSuite) TestGetComments() {
 func (s *PgCommentRepositoryMemoryTestSuite) TestGetEmptyComments() {
 
 	// when
-	actual, err := s.r.GetAllOfPost(s.ctx, 1, 0, 10)
+	actual, err := s.r.GetAllOfPost(s.ctx, 1, models.CommentCursor{}, 10)
 
 	// then
 	s.NoError(err)
 	s.Len(actual, 0)
 }
 
+func (s *PgCommentRepositoryMemoryTestSuite) TestGetCommentsPinnedFirst() {
+	// given
+	postID := int64(1)
+	add := func(parent int64) *models.CommentDTO {
+		var f models.CommentDTO
+		gofakeit.Struct(&f)
+		f.PostId = postID
+		f.ParentId = parent
+		id, err := s.r.Add(s.ctx, &f)
+		s.NoError(err)
+		f.Id = id
+		return &f
+	}
+	r1 := add(0)
+	c11 := add(r1.Id)
+	r2 := add(0)
+	c21 := add(r2.Id)
+	c211 := add(c21.Id)
+	r3 := add(0)
+	s.NoError(s.r.Pin(s.ctx, postID, r3.Id, 2))
+	s.NoError(s.r.Pin(s.ctx, postID, c21.Id, 2))
+
+	// when
+	actual, err := s.r.GetAllOfPost(s.ctx, postID, models.CommentCursor{}, 10)
+
+	// then
+	s.NoError(err)
+	var ids []int64
+	for _, c := range actual {
+		ids = append(ids, c.Id)
+	}
+	s.Equal([]int64{r3.Id, c21.Id, c211.Id, r1.Id, c11.Id, r2.Id}, ids)
+	s.True(actual[0].Pinned)
+	s.True(actual[1].Pinned)
+	s.Equal(2, actual[1].Level)
+	s.False(actual[2].Pinned)
+}
+
+func (s *PgCommentRepositoryMemoryTestSuite) TestGetCommentsOfPostsAndByIds() {
+	// given
+	add := func(postID, parent int64) *models.CommentDTO {
+		var f models.CommentDTO
+		gofakeit.Struct(&f)
+		f.PostId = postID
+		f.ParentId = parent
+		id, err := s.r.Add(s.ctx, &f)
+		s.NoError(err)
+		f.Id = id
+		return &f
+	}
+	r1 := add(1, 0)
+	c11 := add(1, r1.Id)
+	r2 := add(1, 0)
+	o1 := add(2, 0)
+	s.NoError(s.r.Pin(s.ctx, 1, r2.Id, 2))
+	first, err := s.r.GetAllOfPost(s.ctx, 1, models.CommentCursor{}, 2)
+	s.NoError(err)
+
+	// when
+	pages, err := s.r.GetAllOfPosts(s.ctx, []int64{1, 2, 3}, 2)
+	s.NoError(err)
+	byIds, err := s.r.GetManyByIDs(s.ctx, []int64{o1.Id, c11.Id, r2.Id, 1000})
+	s.NoError(err)
+
+	// then
+	s.Len(pages, 2)
+	s.Equal(first, pages[1])
+	s.Require().Len(pages[2], 1)
+	s.Equal(o1.Id, pages[2][0].Id)
+	var ids []int64
+	for _, c := range byIds {
+		ids = append(ids, c.Id)
+	}
+	s.Equal([]int64{c11.Id, r2.Id, o1.Id}, ids)
+	s.Equal(2, byIds[0].Level)
+	s.True(byIds[1].Pinned)
+}
+
+func (s *PgCommentRepositoryMemoryTestSuite) TestGetCommentsPinnedPagination() {
+	// given
+	postID := int64(1)
+	var ids []int64
+	for i := 0; i < 4; i++ {
+		var f models.CommentDTO
+		gofakeit.Struct(&f)
+		f.PostId = postID
+		f.ParentId = 0
+		id, err := s.r.Add(s.ctx, &f)
+		s.NoError(err)
+		ids = append(ids, id)
+	}
+	s.NoError(s.r.Pin(s.ctx, postID, ids[2], 3))
+
+	// when
+	first, err := s.r.GetAllOfPost(s.ctx, postID, models.CommentCursor{}, 2)
+	s.NoError(err)
+	s.NoError(s.r.Unpin(s.ctx, postID, ids[2]))
+	s.NoError(s.r.Pin(s.ctx, postID, ids[3], 3))
+	second, err := s.r.GetAllOfPost(s.ctx, postID, models.CommentCursor{After: first[1].Id, Pins: []int64{ids[2]}}, 2)
+	s.NoError(err)
+
+	// then
+	var actual []int64
+	for _, c := range append(first, second...) {
+		actual = append(actual, c.Id)
+	}
+	s.Equal([]int64{ids[2], ids[0], ids[1], ids[3]}, actual)
+	s.True(first[0].PinSection)
+	s.False(first[1].PinSection)
+	s.False(second[0].Pinned)
+	s.True(second[1].Pinned)
+}
+
+func (s *PgCommentRepositoryMemoryTestSuite) TestGetCommentsPinnedWhileReadingPins() {
+	// given
+	postID := int64(1)
+	var ids []int64
+	for i := 0; i < 4; i++ {
+		var f models.CommentDTO
+		gofakeit.Struct(&f)
+		f.PostId = postID
+		f.ParentId = 0
+		id, err := s.r.Add(s.ctx, &f)
+		s.NoError(err)
+		ids = append(ids, id)
+	}
+	var child models.CommentDTO
+	gofakeit.Struct(&child)
+	child.PostId = postID
+	child.ParentId = ids[1]
+	childID, err := s.r.Add(s.ctx, &child)
+	s.NoError(err)
+	s.NoError(s.r.Pin(s.ctx, postID, ids[1], 3))
+
+	// when
+	first, err := s.r.GetAllOfPost(s.ctx, postID, models.CommentCursor{}, 1)
+	s.NoError(err)
+	s.NoError(s.r.Pin(s.ctx, postID, ids[3], 3))
+	rest, err := s.r.GetAllOfPost(s.ctx, postID, models.CommentCursor{After: first[0].Id, Pins: []int64{ids[1]}, MorePins: true}, 10)
+	s.NoError(err)
+
+	// then
+	var actual []int64
+	for _, c := range append(first, rest...) {
+		actual = append(actual, c.Id)
+	}
+	s.Equal([]int64{ids[1], childID, ids[3], ids[0], ids[2]}, actual)
+}
+
+func (s *PgCommentRepositoryMemoryTestSuite) TestPinLimit() {
+	// given
+	postID := int64(1)
+	var ids []int64
+	for i := 0; i < 2; i++ {
+		var f models.CommentDTO
+		gofakeit.Struct(&f)
+		f.PostId = postID
+		f.ParentId = 0
+		id, err := s.r.Add(s.ctx, &f)
+		s.NoError(err)
+		ids = append(ids, id)
+	}
+	s.NoError(s.r.Pin(s.ctx, postID, ids[0], 1))
+
+	// when
+	err := s.r.Pin(s.ctx, postID, ids[1], 1)
+
+	// then
+	s.ErrorIs(err, database.ErrPinLimit)
+	s.NoError(s.r.Unpin(s.ctx, postID, ids[0]))
+	s.NoError(s.r.Pin(s.ctx, postID, ids[1], 1))
+}
+
+func (s *PgCommentRepositoryMemoryTestSuite) TestPinUnknownComment() {
+
+	// when
+	err := s.r.Pin(s.ctx, 1, 100, 3)
+
+	// then
+	s.ErrorIs(err, database.ErrNoComment)
+}
+
+func (s *PgCommentRepositoryMemoryTestSuite) TestCommentCounters() {
+	// given
+	pr := database.NewInMemnoryPostRepository()
+	cr, _, err := database.NewCommentRepositoryProvider(s.ctx, &database.CommentConfig{InMemory: true, CommentChan: s.ch}, pr)
+	s.NoError(err)
+	now := time.Now()
+	var older, newer models.PostDTO
+	gofakeit.Struct(&older)
+	gofakeit.Struct(&newer)
+	older.Time = now.Add(-time.Hour)
+	newer.Time = now.Add(-time.Minute)
+	olderID, err := pr.Add(s.ctx, &older)
+	s.NoError(err)
+	newerID, err := pr.Add(s.ctx, &newer)
+	s.NoError(err)
+	for i := 0; i < 2; i++ {
+		var f models.CommentDTO
+		gofakeit.Struct(&f)
+		f.PostId = olderID
+		f.ParentId = 0
+		f.Time = now.Add(time.Duration(i) * time.Second)
+		_, err := cr.Add(s.ctx, &f)
+		s.NoError(err)
+	}
+
+	// when
+	actual, err := pr.GetAll(s.ctx, 0, 10, models.PostOrderLastActivity)
+
+	// then
+	s.NoError(err)
+	s.Len(actual, 2)
+	s.Equal(olderID, actual[0].Id)
+	s.Equal(int64(2), actual[0].CommentCount)
+	s.True(now.Add(time.Second).Equal(actual[0].LastCommentAt))
+	s.Equal(newerID, actual[1].Id)
+	s.Equal(int64(0), actual[1].CommentCount)
+}
+
+func (s *PgCommentRepositoryMemoryTestSuite) TestGetCommentsOfAuthor() {
+	// given
+	now := time.Now()
+	add := func(postID, parent int64, author string, t time.Time) int64 {
+		var f models.CommentDTO
+		gofakeit.Struct(&f)
+		f.PostId = postID
+		f.ParentId = parent
+		f.Author = author
+		f.Time = t
+		id, err := s.r.Add(s.ctx, &f)
+		s.NoError(err)
+		return id
+	}
+	root := add(1, 0, "other", now)
+	reply := add(1, root, "user", now.Add(time.Second))
+	other := add(2, 0, "user", now.Add(2*time.Second))
+
+	// when
+	actual, err := s.r.GetAllOfAuthor(s.ctx, "user", 0, 10)
+
+	// then
+	s.NoError(err)
+	s.Len(actual, 2)
+	s.Equal(other, actual[0].Id)
+	s.Equal(int64(2), actual[0].PostId)
+	s.Equal(1, actual[0].Level)
+	s.Equal(reply, actual[1].Id)
+	s.Equal(root, actual[1].ParentId)
+	s.Equal(2, actual[1].Level)
+
+	activity, err := s.r.AuthorActivity(s.ctx, "user")
+	s.NoError(err)
+	s.Equal(int64(2), activity.Count)
+	s.True(now.Add(time.Second).Equal(activity.FirstSeen))
+}
+
 func TestPgCommentRepositoryMemoryTestSuite(t *testing.T) {
 	suite.Run(t, new(PgCommentRepositoryMemoryTestSuite))
 }
@@ -2,9 +2,12 @@ package database_test
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -68,6 +71,22 @@ func (s *PgPostRepositoryTestSuite) TearDownSuite() {
 	s.NoError(err)
 }
 
+func (s *PgPostRepositoryTestSuite) TestPingAndSchemaVersion() {
+	// given
+	r := s.r.(*database.PgPostRepository)
+
+	// when
+	err := r.Ping(s.ctx)
+	v, dirty, verr := r.SchemaVersion(s.ctx)
+
+	// then
+	s.NoError(err)
+	s.NoError(verr)
+	// the schema of tests is created without migrations
+	s.Zero(v)
+	s.False(dirty)
+}
+
 func (s *PgPostRepositoryTestSuite) TestCreatePost() {
 	// given
 	var f models.PostDTO
@@ -119,7 +138,7 @@ func (s *PgPostRepositoryTestSuite) TestGetSmallPostsPagination() {
 	}
 
 	// when
-	actual, err := s.r.GetAll(s.ctx, 5, 10)
+	actual, err := s.r.GetAll(s.ctx, 5, 10, models.PostOrderTime)
 
 	// then
 	s.NoError(err)
@@ -208,6 +227,7 @@ func (s *PgCommentRepositoryTestSuite) TestCreateCommentAndListen() {
 	gofakeit.Struct(&f)
 	f.ParentId = 0
 	f.PostId = s.getRandomPostId()
+	f.Level = 1
 
 	// when
 	_, err := s.r.Add(s.ctx, &f)
@@ -216,13 +236,225 @@ func (s *PgCommentRepositoryTestSuite) TestCreateCommentAndListen() {
 	s.NoError(err)
 	select {
 	case actual := <-s.ch:
-
+		// the comment is loaded from the database, which stores time in microseconds
+		s.WithinDuration(f.Time, actual.Time, time.Millisecond)
+		actual.Time = f.Time
 		s.Equal(f, actual)
 	case <-time.After(2 * time.Second):
 		s.FailNow("it's been too long")
 	}
 }
 
+func (s *PgCommentRepositoryTestSuite) TestListenLargeComment() {
+	// given
+	for len(s.ch) > 0 {
+		<-s.ch
+	}
+	var f models.CommentDTO
+	gofakeit.Struct(&f)
+	f.ParentId = 0
+	f.PostId = s.getRandomPostId()
+	// every character is escaped as \u2028 in JSON, so the comment does not fit into NOTIFY payload
+	f.Text = strings.Repeat("\u2028", 2000)
+
+	// when
+	id, err := s.r.Add(s.ctx, &f)
+
+	// then
+	s.NoError(err)
+	select {
+	case actual := <-s.ch:
+		s.Equal(id, actual.Id)
+		s.Equal(f.Text, actual.Text)
+	case <-time.After(2 * time.Second):
+		s.FailNow("it's been too long")
+	}
+}
+
+func (s *PgCommentRepositoryTestSuite) TestListenBackfillAfterReconnect() {
+	// given
+	for len(s.ch) > 0 {
+		<-s.ch
+	}
+	postID := s.getRandomPostId()
+	var low, high int64
+	err := s.pool.QueryRow(s.ctx, "INSERT INTO Comment (id_p, author, text_c, time_c) VALUES ($1, 'author', 'missed', now()) RETURNING id_c", postID).Scan(&low)
+	s.NoError(err)
+	err = s.pool.QueryRow(s.ctx, "INSERT INTO Comment (id_p, author, text_c, time_c) VALUES ($1, 'author', 'seen', now()) RETURNING id_c", postID).Scan(&high)
+	s.NoError(err)
+	event := func(id int64) string {
+		return fmt.Sprintf(`{"event": %q, "id": %d, "postId": %d}`, models.EventCommentCreated, id, postID)
+	}
+	// the comment with the higher id is published first and delivered with a notification
+	_, err = s.pool.Exec(s.ctx, "INSERT INTO Outbox (topic, payload) VALUES ($1, $2)", database.COMMENTS_CHANNEL, event(high))
+	s.NoError(err)
+	_, err = s.pool.Exec(s.ctx, "SELECT pg_notify($1, '')", database.OUTBOX_CHANNEL)
+	s.NoError(err)
+	select {
+	case actual := <-s.ch:
+		s.Equal(high, actual.Id)
+	case <-time.After(5 * time.Second):
+		s.FailNow("it's been too long")
+	}
+	// the comment with the lower id is published without a notification, like while the listener is disconnected
+	_, err = s.pool.Exec(s.ctx, "INSERT INTO Outbox (topic, payload, published_at) VALUES ($1, $2, now())", database.COMMENTS_CHANNEL, event(low))
+	s.NoError(err)
+	before := s.r.(database.ListenerReporter).ListenerStatus().Reconnects
+
+	// when
+	_, err = s.pool.Exec(s.ctx, "SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = current_database() AND pid <> pg_backend_pid()")
+	s.NoError(err)
+
+	// then
+	select {
+	case actual := <-s.ch:
+		s.Equal(low, actual.Id)
+		s.Equal("missed", actual.Text)
+		s.Equal(1, actual.Level)
+	case <-time.After(5 * time.Second):
+		s.FailNow("it's been too long")
+	}
+	s.Eventually(func() bool {
+		st := s.r.(database.ListenerReporter).ListenerStatus()
+		return st.Connected && st.Reconnects > before
+	}, 5*time.Second, 50*time.Millisecond)
+	select {
+	case actual := <-s.ch:
+		s.Failf("comment delivered twice", "%+v", actual)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func (s *PgCommentRepositoryTestSuite) TestRolledBackCommentNotPublished() {
+	// given
+	for len(s.ch) > 0 {
+		<-s.ch
+	}
+	var f models.CommentDTO
+	gofakeit.Struct(&f)
+	f.ParentId = 0
+	f.PostId = -1
+
+	// when
+	_, err := s.r.Add(s.ctx, &f)
+
+	// then
+	s.Error(err)
+	select {
+	case actual := <-s.ch:
+		s.Failf("rolled back comment published", "%+v", actual)
+	case <-time.After(2 * database.OUTBOX_POLL_INTERVAL):
+	}
+	var unpublished int
+	s.NoError(s.pool.QueryRow(s.ctx, "SELECT count(*) FROM Outbox WHERE published_at IS NULL").Scan(&unpublished))
+	s.Equal(0, unpublished)
+}
+
+func (s *PgCommentRepositoryTestSuite) TestCommentFailedOnCommitNotPublished() {
+	// given
+	for len(s.ch) > 0 {
+		<-s.ch
+	}
+	// the deferred trigger fails the commit, after the comment is written to the outbox
+	_, err := s.pool.Exec(s.ctx, `CREATE OR REPLACE FUNCTION fail_commit() RETURNS trigger AS $$
+	BEGIN
+		RAISE EXCEPTION 'commit failed';
+	END;
+	$$ LANGUAGE plpgsql`)
+	s.Require().NoError(err)
+	_, err = s.pool.Exec(s.ctx, `CREATE CONSTRAINT TRIGGER fail_commit AFTER INSERT ON Comment
+	DEFERRABLE INITIALLY DEFERRED FOR EACH ROW WHEN (NEW.author = 'fail on commit') EXECUTE FUNCTION fail_commit()`)
+	s.Require().NoError(err)
+	defer func() {
+		_, err := s.pool.Exec(s.ctx, "DROP TRIGGER fail_commit ON Comment")
+		s.NoError(err)
+	}()
+	var f models.CommentDTO
+	gofakeit.Struct(&f)
+	f.ParentId = 0
+	f.PostId = s.getRandomPostId()
+	f.Author = "fail on commit"
+
+	// when
+	_, err = s.r.Add(s.ctx, &f)
+
+	// then
+	s.ErrorContains(err, "commit failed")
+	select {
+	case actual := <-s.ch:
+		s.Failf("rolled back comment published", "%+v", actual)
+	case <-time.After(2 * database.OUTBOX_POLL_INTERVAL):
+	}
+	var events int
+	s.NoError(s.pool.QueryRow(s.ctx, "SELECT count(*) FROM Outbox").Scan(&events))
+	s.Equal(0, events)
+}
+
+func (s *PgCommentRepositoryTestSuite) TestOutboxWaitsForOlderTransactions() {
+	// given
+	for len(s.ch) > 0 {
+		<-s.ch
+	}
+	older, err := s.pool.Begin(s.ctx)
+	s.Require().NoError(err)
+	defer older.Rollback(s.ctx)
+	// the transaction gets its id before the comment is written, as if it writes an event which is committed later
+	_, err = older.Exec(s.ctx, "SELECT pg_current_xact_id()")
+	s.Require().NoError(err)
+	var f models.CommentDTO
+	gofakeit.Struct(&f)
+	f.ParentId = 0
+	f.PostId = s.getRandomPostId()
+
+	// when
+	id, err := s.r.Add(s.ctx, &f)
+	s.Require().NoError(err)
+
+	// then
+	select {
+	case actual := <-s.ch:
+		s.Failf("comment published before older transaction finished", "%+v", actual)
+	case <-time.After(2 * database.OUTBOX_POLL_INTERVAL):
+	}
+	s.NoError(older.Rollback(s.ctx))
+	select {
+	case actual := <-s.ch:
+		s.Equal(id, actual.Id)
+	case <-time.After(2 * database.OUTBOX_POLL_INTERVAL):
+		s.Fail("comment is not published after older transaction finished")
+	}
+}
+
+func (s *PgCommentRepositoryTestSuite) TestOutboxWaitsForLongTransactionAtMostMaxWait() {
+	// given
+	for len(s.ch) > 0 {
+		<-s.ch
+	}
+	long, err := s.pool.Begin(s.ctx)
+	s.Require().NoError(err)
+	defer long.Rollback(s.ctx)
+	_, err = long.Exec(s.ctx, "SELECT pg_current_xact_id()")
+	s.Require().NoError(err)
+	var f models.CommentDTO
+	gofakeit.Struct(&f)
+	f.ParentId = 0
+	f.PostId = s.getRandomPostId()
+	start := time.Now()
+
+	// when
+	id, err := s.r.Add(s.ctx, &f)
+	s.Require().NoError(err)
+
+	// then
+	select {
+	case actual := <-s.ch:
+		s.Equal(id, actual.Id)
+		s.GreaterOrEqual(time.Since(start), database.OUTBOX_MAX_WAIT-database.OUTBOX_POLL_INTERVAL)
+	case <-time.After(database.OUTBOX_MAX_WAIT + 2*database.OUTBOX_POLL_INTERVAL):
+		s.Fail("comment is held back by the long transaction")
+	}
+}
+
 func (s *PgCommentRepositoryTestSuite) TestGetComments() {
 	// given
 	var expected []*models.CommentDTO
@@ -242,7 +474,7 @@ func (s *PgCommentRepositoryTestSuite) TestGetComments() {
 	}
 
 	// when
-	actual, err := s.r.GetAllOfPost(s.ctx, postID, 0, 10)
+	actual, err := s.r.GetAllOfPost(s.ctx, postID, models.CommentCursor{}, 10)
 
 	// then
 	s.NoError(err)
@@ -360,7 +592,7 @@ func (s *PgCommentRepositoryTestSuite) TestGetCommentsOrdered() {
 	expected = append(expected, &crr12)
 
 	// when
-	actual, err := s.r.GetAllOfPost(s.ctx, postID, 0, 5)
+	actual, err := s.r.GetAllOfPost(s.ctx, postID, models.CommentCursor{}, 5)
 
 	// then
 	s.NoError(err)
@@ -471,7 +703,7 @@ func (s *PgCommentRepositoryTestSuite) TestGetCommentsOrderedPagination() {
 	expected = append(expected, &crr12)
 
 	// when
-	actual, err := s.r.GetAllOfPost(s.ctx, postID, 5, 5)
+	actual, err := s.r.GetAllOfPost(s.ctx, postID, models.CommentCursor{After: expected[4].Id}, 5)
 
 	// then
 	s.NoError(err)
@@ -481,13 +713,320 @@ func (s *PgCommentRepositoryTestSuite) TestGetCommentsOrderedPagination() {
 func (s *PgCommentRepositoryTestSuite) TestGetEmptyComments() {
 
 	// when
-	actual, err := s.r.GetAllOfPost(s.ctx, 1, 0, 10)
+	actual, err := s.r.GetAllOfPost(s.ctx, 1, models.CommentCursor{}, 10)
 
 	// then
 	s.NoError(err)
 	s.Len(actual, 0)
 }
 
+func (s *PgCommentRepositoryTestSuite) TestGetCommentsPinnedFirst() {
+	// given
+	postID := s.getRandomPostId()
+	add := func(parent int64) *models.CommentDTO {
+		var f models.CommentDTO
+		gofakeit.Struct(&f)
+		f.PostId = postID
+		f.ParentId = parent
+		id, err := s.r.Add(s.ctx, &f)
+		s.NoError(err)
+		f.Id = id
+		return &f
+	}
+	r1 := add(0)
+	c11 := add(r1.Id)
+	r2 := add(0)
+	c21 := add(r2.Id)
+	c211 := add(c21.Id)
+	r3 := add(0)
+	s.NoError(s.r.Pin(s.ctx, postID, r3.Id, 2))
+	s.NoError(s.r.Pin(s.ctx, postID, c21.Id, 2))
+
+	// when
+	actual, err := s.r.GetAllOfPost(s.ctx, postID, models.CommentCursor{}, 10)
+
+	// then
+	s.NoError(err)
+	var ids []int64
+	for _, c := range actual {
+		ids = append(ids, c.Id)
+	}
+	s.Equal([]int64{r3.Id, c21.Id, c211.Id, r1.Id, c11.Id, r2.Id}, ids)
+	s.True(actual[0].Pinned)
+	s.True(actual[1].Pinned)
+	s.Equal(2, actual[1].Level)
+	s.False(actual[2].Pinned)
+}
+
+func (s *PgCommentRepositoryTestSuite) TestGetCommentsOfPostsAndByIds() {
+	// given
+	rows, err := s.pool.Query(s.ctx, "SELECT id_p FROM Post ORDER BY id_p LIMIT 2")
+	s.NoError(err)
+	posts, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	s.NoError(err)
+	s.Require().Len(posts, 2)
+	add := func(postID, parent int64) *models.CommentDTO {
+		var f models.CommentDTO
+		gofakeit.Struct(&f)
+		f.PostId = postID
+		f.ParentId = parent
+		id, err := s.r.Add(s.ctx, &f)
+		s.NoError(err)
+		f.Id = id
+		return &f
+	}
+	r1 := add(posts[0], 0)
+	c11 := add(posts[0], r1.Id)
+	r2 := add(posts[0], 0)
+	o1 := add(posts[1], 0)
+	s.NoError(s.r.Pin(s.ctx, posts[0], r2.Id, 2))
+	first, err := s.r.GetAllOfPost(s.ctx, posts[0], models.CommentCursor{}, 2)
+	s.NoError(err)
+
+	// when
+	pages, err := s.r.GetAllOfPosts(s.ctx, []int64{posts[0], posts[1], -1}, 2)
+	s.NoError(err)
+	byIds, err := s.r.GetManyByIDs(s.ctx, []int64{o1.Id, c11.Id, r2.Id, -1})
+	s.NoError(err)
+
+	// then
+	s.Len(pages, 2)
+	s.Equal(first, pages[posts[0]])
+	s.Require().Len(pages[posts[1]], 1)
+	s.Equal(o1.Id, pages[posts[1]][0].Id)
+	var ids []int64
+	for _, c := range byIds {
+		ids = append(ids, c.Id)
+	}
+	s.Equal([]int64{c11.Id, r2.Id, o1.Id}, ids)
+	s.Equal(2, byIds[0].Level)
+	s.True(byIds[1].Pinned)
+}
+
+func (s *PgCommentRepositoryTestSuite) TestGetCommentsPinnedPagination() {
+	// given
+	postID := s.getRandomPostId()
+	var ids []int64
+	for i := 0; i < 4; i++ {
+		var f models.CommentDTO
+		gofakeit.Struct(&f)
+		f.PostId = postID
+		f.ParentId = 0
+		id, err := s.r.Add(s.ctx, &f)
+		s.NoError(err)
+		ids = append(ids, id)
+	}
+	s.NoError(s.r.Pin(s.ctx, postID, ids[2], 3))
+
+	// when
+	first, err := s.r.GetAllOfPost(s.ctx, postID, models.CommentCursor{}, 2)
+	s.NoError(err)
+	s.NoError(s.r.Unpin(s.ctx, postID, ids[2]))
+	s.NoError(s.r.Pin(s.ctx, postID, ids[3], 3))
+	second, err := s.r.GetAllOfPost(s.ctx, postID, models.CommentCursor{After: first[1].Id, Pins: []int64{ids[2]}}, 2)
+	s.NoError(err)
+
+	// then
+	var actual []int64
+	for _, c := range append(first, second...) {
+		actual = append(actual, c.Id)
+	}
+	s.Equal([]int64{ids[2], ids[0], ids[1], ids[3]}, actual)
+	s.True(first[0].PinSection)
+	s.False(first[1].PinSection)
+	s.False(second[0].Pinned)
+	s.True(second[1].Pinned)
+}
+
+func (s *PgCommentRepositoryTestSuite) TestGetCommentsPinnedWhileReadingPins() {
+	// given
+	postID := s.getRandomPostId()
+	var ids []int64
+	for i := 0; i < 4; i++ {
+		var f models.CommentDTO
+		gofakeit.Struct(&f)
+		f.PostId = postID
+		f.ParentId = 0
+		id, err := s.r.Add(s.ctx, &f)
+		s.NoError(err)
+		ids = append(ids, id)
+	}
+	var child models.CommentDTO
+	gofakeit.Struct(&child)
+	child.PostId = postID
+	child.ParentId = ids[1]
+	childID, err := s.r.Add(s.ctx, &child)
+	s.NoError(err)
+	s.NoError(s.r.Pin(s.ctx, postID, ids[1], 3))
+
+	// when
+	first, err := s.r.GetAllOfPost(s.ctx, postID, models.CommentCursor{}, 1)
+	s.NoError(err)
+	s.NoError(s.r.Pin(s.ctx, postID, ids[3], 3))
+	rest, err := s.r.GetAllOfPost(s.ctx, postID, models.CommentCursor{After: first[0].Id, Pins: []int64{ids[1]}, MorePins: true}, 10)
+	s.NoError(err)
+
+	// then
+	var actual []int64
+	for _, c := range append(first, rest...) {
+		actual = append(actual, c.Id)
+	}
+	s.Equal([]int64{ids[1], childID, ids[3], ids[0], ids[2]}, actual)
+}
+
+func (s *PgCommentRepositoryTestSuite) TestPinLimit() {
+	// given
+	postID := s.getRandomPostId()
+	var ids []int64
+	for i := 0; i < 2; i++ {
+		var f models.CommentDTO
+		gofakeit.Struct(&f)
+		f.PostId = postID
+		f.ParentId = 0
+		id, err := s.r.Add(s.ctx, &f)
+		s.NoError(err)
+		ids = append(ids, id)
+	}
+	s.NoError(s.r.Pin(s.ctx, postID, ids[0], 1))
+
+	// when
+	err := s.r.Pin(s.ctx, postID, ids[1], 1)
+
+	// then
+	s.ErrorIs(err, database.ErrPinLimit)
+	s.NoError(s.r.Unpin(s.ctx, postID, ids[0]))
+	s.NoError(s.r.Pin(s.ctx, postID, ids[1], 1))
+}
+
+func (s *PgCommentRepositoryTestSuite) TestPinAndUnpinNotified() {
+	// given
+	for len(s.ch) > 0 {
+		<-s.ch
+	}
+	var f models.CommentDTO
+	gofakeit.Struct(&f)
+	f.PostId = s.getRandomPostId()
+	f.ParentId = 0
+	id, err := s.r.Add(s.ctx, &f)
+	s.Require().NoError(err)
+	// the listener delivers the comment before the updates
+	select {
+	case <-s.ch:
+	case <-time.After(2 * time.Second):
+		s.FailNow("it's been too long")
+	}
+	updates := s.r.(database.UpdateNotifier).Updates()
+
+	// when
+	err = s.r.Pin(s.ctx, f.PostId, id, 1)
+
+	// then
+	s.NoError(err)
+	s.waitUpdate(updates, f.PostId)
+
+	// when
+	err = s.r.Unpin(s.ctx, f.PostId, id)
+
+	// then
+	s.NoError(err)
+	s.waitUpdate(updates, f.PostId)
+}
+
+// waitUpdate skips updates of other tests until the post is updated.
+func (s *PgCommentRepositoryTestSuite) waitUpdate(updates <-chan int64, postId int64) {
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case id := <-updates:
+			if id == postId {
+				return
+			}
+		case <-timeout:
+			s.FailNow("it's been too long")
+		}
+	}
+}
+
+func (s *PgCommentRepositoryTestSuite) TestCommentCounters() {
+	// given
+	pr := database.NewPgPostRepository(s.ctx, s.pool)
+	postID := s.getRandomPostId()
+	now := time.Now().Truncate(time.Second)
+	for i := 0; i < 3; i++ {
+		var f models.CommentDTO
+		gofakeit.Struct(&f)
+		f.PostId = postID
+		f.ParentId = 0
+		f.Time = now.Add(time.Duration(i) * time.Second)
+		_, err := s.r.Add(s.ctx, &f)
+		s.NoError(err)
+	}
+
+	// when
+	actual, err := pr.Get(s.ctx, postID)
+
+	// then
+	s.NoError(err)
+	s.Equal(int64(3), actual.CommentCount)
+	s.True(now.Add(2 * time.Second).Equal(actual.LastCommentAt))
+}
+
+func (s *PgCommentRepositoryTestSuite) TestRecountComments() {
+	// given
+	pr := database.NewPgPostRepository(s.ctx, s.pool)
+	postID := s.getRandomPostId()
+	var f models.CommentDTO
+	gofakeit.Struct(&f)
+	f.PostId = postID
+	f.ParentId = 0
+	_, err := s.r.Add(s.ctx, &f)
+	s.NoError(err)
+	_, err = s.pool.Exec(s.ctx, "UPDATE Post SET comment_count = 10 WHERE id_p = $1", postID)
+	s.NoError(err)
+
+	// when
+	fixed, err := pr.RecountComments(s.ctx)
+
+	// then
+	s.NoError(err)
+	s.Equal(int64(1), fixed)
+	actual, err := pr.Get(s.ctx, postID)
+	s.NoError(err)
+	s.Equal(int64(1), actual.CommentCount)
+}
+
+func (s *PgCommentRepositoryTestSuite) TestGetCommentsOfAuthor() {
+	// given
+	postID := s.getRandomPostId()
+	add := func(parent int64, author string) int64 {
+		var f models.CommentDTO
+		gofakeit.Struct(&f)
+		f.PostId = postID
+		f.ParentId = parent
+		f.Author = author
+		id, err := s.r.Add(s.ctx, &f)
+		s.NoError(err)
+		return id
+	}
+	root := add(0, "other")
+	reply := add(root, "user")
+
+	// when
+	actual, err := s.r.GetAllOfAuthor(s.ctx, "user", 0, 10)
+
+	// then
+	s.NoError(err)
+	s.Len(actual, 1)
+	s.Equal(reply, actual[0].Id)
+	s.Equal(root, actual[0].ParentId)
+	s.Equal(postID, actual[0].PostId)
+	s.Equal(2, actual[0].Level)
+
+	activity, err := s.r.AuthorActivity(s.ctx, "user")
+	s.NoError(err)
+	s.Equal(int64(1), activity.Count)
+}
+
 func (s *PgCommentRepositoryTestSuite) getRandomPostId() int64 {
 	var postId int64
 	err := s.pool.QueryRow(s.ctx, "SELECT id_p FROM Post limit 1").Scan(&postId)
@@ -498,6 +1037,165 @@ func (s *PgCommentRepositoryTestSuite) getRandomPostId() int64 {
 	return postId
 }
 
+func (s *PgCommentRepositoryTestSuite) TestWebhookDeliveries() {
+	// given
+	r := database.NewPgWebhookRepository(s.pool)
+	id, err := r.Add(s.ctx, &models.WebhookDTO{URL: "http://example.com", Secret: "secret", Events: []string{models.EventCommentCreated}})
+	s.NoError(err)
+	_, err = r.Add(s.ctx, &models.WebhookDTO{URL: "http://example.org", Secret: "secret", Events: []string{models.EventPostCreated}})
+	s.NoError(err)
+
+	// when
+	n, err := r.Enqueue(s.ctx, models.EventCommentCreated, []byte(`{"id":"1"}`))
+	s.NoError(err)
+	now := time.Now()
+	claimed, err := r.Claim(s.ctx, now, time.Minute, 10)
+	s.NoError(err)
+	again, err := r.Claim(s.ctx, now, time.Minute, 10)
+	s.NoError(err)
+
+	// then
+	s.Equal(int64(1), n)
+	s.Len(claimed, 1)
+	s.Len(again, 0)
+	s.Equal(id, claimed[0].WebhookId)
+	s.Equal("http://example.com", claimed[0].URL)
+	s.JSONEq(`{"id":"1"}`, string(claimed[0].Payload))
+
+	s.NoError(r.MarkFailed(s.ctx, claimed[0].Id, 500, "unexpected status 500", now, true))
+	dead, err := r.GetDeliveries(s.ctx, models.DeliveryFilter{Status: models.DeliveryDead}, 0, 10)
+	s.NoError(err)
+	s.Len(dead, 1)
+	s.Equal(1, dead[0].Attempts)
+	s.Equal(500, dead[0].ResponseCode)
+	s.Equal("unexpected status 500", dead[0].LastError)
+}
+
+func (s *PgCommentRepositoryTestSuite) TestDeleteFinishedWebhookDeliveries() {
+	// given
+	r := database.NewPgWebhookRepository(s.pool)
+	id, err := r.Add(s.ctx, &models.WebhookDTO{URL: "http://example.com", Secret: "secret", Events: []string{"test.retention"}})
+	s.NoError(err)
+	_, err = r.Enqueue(s.ctx, "test.retention", []byte(`{"id":"1"}`))
+	s.NoError(err)
+	_, err = r.Enqueue(s.ctx, "test.retention", []byte(`{"id":"2"}`))
+	s.NoError(err)
+	ds, err := r.GetDeliveries(s.ctx, models.DeliveryFilter{WebhookId: id}, 0, 10)
+	s.NoError(err)
+	s.Require().Len(ds, 2)
+	s.NoError(r.MarkDelivered(s.ctx, ds[1].Id, 200, time.Now()))
+
+	// when
+	_, err = r.DeleteFinished(s.ctx, ds[1].Time.Add(-time.Minute), 1000)
+	s.NoError(err)
+	kept, err := r.GetDeliveries(s.ctx, models.DeliveryFilter{WebhookId: id}, 0, 10)
+	s.NoError(err)
+	n, err := r.DeleteFinished(s.ctx, time.Now().Add(time.Minute), 1000)
+	s.NoError(err)
+
+	// then
+	s.Len(kept, 2)
+	s.GreaterOrEqual(n, int64(1))
+	left, err := r.GetDeliveries(s.ctx, models.DeliveryFilter{WebhookId: id}, 0, 10)
+	s.NoError(err)
+	s.Require().Len(left, 1)
+	s.Equal(ds[0].Id, left[0].Id)
+	s.Equal(models.DeliveryPending, left[0].Status)
+}
+
+func (s *PgCommentRepositoryTestSuite) TestCommentEnqueuesWebhookDeliveries() {
+	// given
+	w := database.NewPgWebhookRepository(s.pool)
+	_, err := w.Add(s.ctx, &models.WebhookDTO{URL: "http://example.com", Secret: "secret", Events: []string{models.EventCommentCreated}})
+	s.NoError(err)
+	s.r.(database.EventWriter).WriteEvents(func(event string, entity any) ([]byte, error) {
+		return json.Marshal(map[string]any{"event": event, "id": entity.(*models.CommentDTO).Id})
+	})
+	defer s.r.(database.EventWriter).WriteEvents(nil)
+	var f models.CommentDTO
+	gofakeit.Struct(&f)
+	f.ParentId = 0
+	f.PostId = s.getRandomPostId()
+	rolledBack := f
+	rolledBack.PostId = -1
+
+	// when
+	id, err := s.r.Add(s.ctx, &f)
+	s.NoError(err)
+	_, err = s.r.Add(s.ctx, &rolledBack)
+	s.Error(err)
+
+	// then
+	// the delivery is enqueued only with the committed comment
+	ds, err := w.GetDeliveries(s.ctx, models.DeliveryFilter{}, 0, 10)
+	s.NoError(err)
+	s.Require().Len(ds, 1)
+	s.Equal(models.EventCommentCreated, ds[0].Event)
+	s.JSONEq(fmt.Sprintf(`{"event":%q,"id":%d}`, models.EventCommentCreated, id), string(ds[0].Payload))
+}
+
+func (s *PgCommentRepositoryTestSuite) TestAddBatch() {
+	// given
+	postId := s.getRandomPostId()
+	before, err := database.NewPgPostRepository(s.ctx, s.pool).Get(s.ctx, postId)
+	s.NoError(err)
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	roots := []*models.CommentDTO{
+		{PostId: postId, Author: "a", Text: "first", Time: now},
+		{PostId: postId, Author: "b", Text: "second", Time: now},
+	}
+
+	// when
+	ids, err := s.r.AddBatch(s.ctx, roots)
+	s.NoError(err)
+	replies, err := s.r.AddBatch(s.ctx, []*models.CommentDTO{{PostId: postId, ParentId: ids[1], Author: "c", Text: "reply", Time: now}})
+
+	// then
+	s.NoError(err)
+	s.Len(ids, 2)
+	s.Len(replies, 1)
+	after, err := database.NewPgPostRepository(s.ctx, s.pool).Get(s.ctx, postId)
+	s.NoError(err)
+	s.Equal(before.CommentCount+3, after.CommentCount)
+	var parent int64
+	s.NoError(s.pool.QueryRow(s.ctx, "SELECT parent FROM Comment WHERE id_c = $1", replies[0]).Scan(&parent))
+	s.Equal(ids[1], parent)
+}
+
+func (s *PgCommentRepositoryTestSuite) TestImportRemapsIds() {
+	// given
+	r := database.NewPgTransferRepository(s.pool)
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	tree := &models.PostTreeDTO{
+		Post: models.PostDTO{Id: 1000, Author: "author", Title: "title", Text: "text", IsCommentable: true, Time: now},
+		Comments: []*models.CommentDTO{
+			{Id: 2000, Author: "a", Text: "root", Time: now},
+			{Id: 2001, ParentId: 2000, Author: "b", Text: "reply", Time: now, Pinned: true},
+		},
+	}
+
+	// when
+	n, err := r.Import(s.ctx, "source", []*models.PostTreeDTO{tree})
+	s.NoError(err)
+	again, err := r.Import(s.ctx, "source", []*models.PostTreeDTO{tree})
+	s.NoError(err)
+
+	// then
+	s.Equal(1, n)
+	s.Equal(0, again)
+	var postId int64
+	s.NoError(s.pool.QueryRow(s.ctx, "SELECT new_id FROM Import WHERE source = 'source' AND old_id = 1000").Scan(&postId))
+	comments, err := r.ExportComments(s.ctx, postId, 0, 10)
+	s.NoError(err)
+	s.Len(comments, 2)
+	s.Equal(comments[0].Id, comments[1].ParentId)
+	s.NotEqual(int64(2000), comments[0].Id)
+	s.True(comments[1].Pinned)
+	p, err := database.NewPgPostRepository(s.ctx, s.pool).Get(s.ctx, postId)
+	s.NoError(err)
+	s.Equal(int64(2), p.CommentCount)
+}
+
 func TestPgCommentRepositoryTestSuite(t *testing.T) {
 	suite.Run(t, new(PgCommentRepositoryTestSuite))
 }
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type (
+	// PoolStats describes connections of a database pool.
+	PoolStats struct {
+		AcquireCount         int64
+		EmptyAcquireCount    int64
+		CanceledAcquireCount int64
+		AcquireDuration      time.Duration
+		AcquiredConns        int32
+		IdleConns            int32
+		TotalConns           int32
+		MaxConns             int32
+	}
+
+	// PoolReporter is implemented by repositories which use a database pool.
+	PoolReporter interface {
+		PoolStats() PoolStats
+	}
+)
+
+func (r *PgPostRepository) PoolStats() PoolStats {
+	return poolStats(r.pool.Stat())
+}
+
+func (r *PgCommentRepository) PoolStats() PoolStats {
+	return poolStats(r.pool.Stat())
+}
+
+func poolStats(s *pgxpool.Stat) PoolStats {
+	return PoolStats{
+		AcquireCount:         s.AcquireCount(),
+		EmptyAcquireCount:    s.EmptyAcquireCount(),
+		CanceledAcquireCount: s.CanceledAcquireCount(),
+		AcquireDuration:      s.AcquireDuration(),
+		AcquiredConns:        s.AcquiredConns(),
+		IdleConns:            s.IdleConns(),
+		TotalConns:           s.TotalConns(),
+		MaxConns:             s.MaxConns(),
+	}
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// queryTracer makes a span of every query, batch and COPY of a pool inside a trace.
+// Background queries, e.g. polling of the outbox relay, have no trace and make no spans.
+type queryTracer struct{}
+
+var (
+	tracer = otel.Tracer("github.com/mi-raf/comment-project/internal/database")
+
+	_ pgx.QueryTracer    = queryTracer{}
+	_ pgx.BatchTracer    = queryTracer{}
+	_ pgx.CopyFromTracer = queryTracer{}
+)
+
+// newPool connects to the database with tracing of queries.
+func newPool(ctx context.Context, addr string) (*pgxpool.Pool, error) {
+	cfg, err := pgxpool.ParseConfig(addr)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ConnConfig.Tracer = queryTracer{}
+	return pgxpool.NewWithConfig(ctx, cfg)
+}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	op := operation(data.SQL)
+	return startSpan(ctx, op,
+		semconv.DBSystemPostgreSQL,
+		semconv.DBOperation(op),
+		semconv.DBStatement(data.SQL),
+	)
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	endSpan(ctx, data.CommandTag, data.Err)
+}
+
+func (queryTracer) TraceBatchStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	return startSpan(ctx, "BATCH",
+		semconv.DBSystemPostgreSQL,
+		attribute.Int("db.batch.size", data.Batch.Len()),
+	)
+}
+
+func (queryTracer) TraceBatchQuery(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchQueryData) {
+	span := trace.SpanFromContext(ctx)
+	span.AddEvent("query", trace.WithAttributes(semconv.DBStatement(data.SQL)))
+	if data.Err != nil {
+		span.RecordError(data.Err)
+	}
+}
+
+func (queryTracer) TraceBatchEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchEndData) {
+	endSpan(ctx, pgconn.CommandTag{}, data.Err)
+}
+
+func (queryTracer) TraceCopyFromStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	return startSpan(ctx, "COPY",
+		semconv.DBSystemPostgreSQL,
+		semconv.DBOperation("COPY"),
+		semconv.DBSQLTable(data.TableName.Sanitize()),
+	)
+}
+
+func (queryTracer) TraceCopyFromEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	endSpan(ctx, data.CommandTag, data.Err)
+}
+
+// startSpan starts a client span only as a child of the span of ctx, so background queries make no root spans.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) context.Context {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	ctx, _ = tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return ctx
+}
+
+// endSpan ends the span of the query, without a trace the span of ctx is a no-op one.
+func endSpan(ctx context.Context, tag pgconn.CommandTag, err error) {
+	span := trace.SpanFromContext(ctx)
+	defer span.End()
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	span.SetAttributes(attribute.Int64("db.rows_affected", tag.RowsAffected()))
+}
+
+// operation returns the first keyword of the statement, which names its span.
+func operation(sql string) string {
+	op, _, _ := strings.Cut(strings.TrimSpace(sql), " ")
+	if i := strings.IndexAny(op, "\n\t("); i >= 0 {
+		op = op[:i]
+	}
+	return strings.ToUpper(op)
+}
@@ -0,0 +1,319 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype/zeronull"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mi-raf/comment-project/internal/logging"
+	mod "github.com/mi-raf/comment-project/internal/models"
+)
+
+const (
+	exportPosts = `SELECT id_p, author, title, text_p, comm, time_p
+	FROM Post
+	WHERE ($1::bigint = 0 OR id_p = $1) AND id_p > $2
+	ORDER BY id_p
+	LIMIT $3`
+	exportComments = `SELECT c.id_c, c.parent, c.author, c.text_c, c.time_c, pn.id_c IS NOT NULL
+	FROM Comment c
+	LEFT JOIN Pin pn ON pn.id_c = c.id_c
+	WHERE c.id_p = $1 AND c.id_c > $2
+	ORDER BY c.id_c
+	LIMIT $3`
+	searchImported     = "SELECT old_id FROM Import WHERE source = $1 AND old_id = ANY($2)"
+	insertImportedPost = `INSERT INTO Post (author, title, text_p, comm, time_p, comment_count, last_comment_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id_p`
+	insertImportedPins = "INSERT INTO Pin (id_c, id_p) SELECT unnest($1::bigint[]), $2"
+	insertImport       = "INSERT INTO Import (source, old_id, new_id) VALUES ($1, $2, $3)"
+)
+
+type (
+	// TransferRepository reads and writes posts with their comment trees to move them between databases.
+	TransferRepository interface {
+		// ExportPosts returns posts with id more than after ordered by id, only the post with postId if it is not 0.
+		ExportPosts(ctx context.Context, postId int64, after int64, limit int) ([]*mod.PostDTO, error)
+		// ExportComments returns comments of the post with id more than after ordered by id.
+		ExportComments(ctx context.Context, postId int64, after int64, limit int) ([]*mod.CommentDTO, error)
+		// Import stores posts with new ids in one transaction and returns a number of stored posts.
+		// Posts which were already imported from the source are skipped, so an interrupted import can be repeated.
+		Import(ctx context.Context, source string, posts []*mod.PostTreeDTO) (int, error)
+	}
+
+	TransferConfig struct {
+		InMemory bool
+		DbAddr   string
+	}
+
+	PgTransferRepository struct {
+		pool *pgxpool.Pool
+	}
+
+	InMemoryTransferRepository struct {
+		posts    *InMemoryPostRepository
+		comments *InMemoryCommentRepository
+		imported map[string]map[int64]int64
+		m        sync.Mutex
+	}
+)
+
+// NewTransferRepositoryProvider connects to PostgreSQL, the in memory storage of a command is empty
+// on start and is lost on exit, so it can not be exported or imported.
+func NewTransferRepositoryProvider(ctx context.Context, cfg *TransferConfig) (TransferRepository, func(), error) {
+	if cfg.InMemory {
+		return nil, nil, errors.New("export and import are not used with in memory storage")
+	}
+	pg, err := newPool(ctx, cfg.DbAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = pg.Ping(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewPgTransferRepository(pg), pg.Close, nil
+}
+
+func NewPgTransferRepository(p *pgxpool.Pool) *PgTransferRepository {
+	return &PgTransferRepository{pool: p}
+}
+
+func (r *PgTransferRepository) ExportPosts(ctx context.Context, postId int64, after int64, limit int) ([]*mod.PostDTO, error) {
+	rows, err := r.pool.Query(ctx, exportPosts, postId, after, limit)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Int64("after", after).Msg("can not export posts")
+		return nil, err
+	}
+	defer rows.Close()
+	posts := make([]*mod.PostDTO, 0, limit)
+	for rows.Next() {
+		var p mod.PostDTO
+		if err := rows.Scan(&p.Id, &p.Author, &p.Title, &p.Text, &p.IsCommentable, &p.Time); err != nil {
+			return nil, err
+		}
+		posts = append(posts, &p)
+	}
+	return posts, rows.Err()
+}
+
+func (r *PgTransferRepository) ExportComments(ctx context.Context, postId int64, after int64, limit int) ([]*mod.CommentDTO, error) {
+	rows, err := r.pool.Query(ctx, exportComments, postId, after, limit)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Int64("post id", postId).Msg("can not export comments")
+		return nil, err
+	}
+	defer rows.Close()
+	comments := make([]*mod.CommentDTO, 0, limit)
+	for rows.Next() {
+		c := mod.CommentDTO{PostId: postId}
+		var parIdNil zeronull.Int8
+		if err := rows.Scan(&c.Id, &parIdNil, &c.Author, &c.Text, &c.Time, &c.Pinned); err != nil {
+			return nil, err
+		}
+		c.ParentId = int64(parIdNil)
+		comments = append(comments, &c)
+	}
+	return comments, rows.Err()
+}
+
+func (r *PgTransferRepository) Import(ctx context.Context, source string, posts []*mod.PostTreeDTO) (int, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	done, err := r.imported(ctx, tx, source, posts)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, p := range posts {
+		if _, ok := done[p.Post.Id]; ok {
+			continue
+		}
+		if err := r.importPost(ctx, tx, source, p); err != nil {
+			logging.Ctx(ctx).Error().Err(err).Int64("post id", p.Post.Id).Msg("can not import post")
+			return 0, err
+		}
+		n++
+	}
+	return n, tx.Commit(ctx)
+}
+
+func (r *PgTransferRepository) imported(ctx context.Context, tx pgx.Tx, source string, posts []*mod.PostTreeDTO) (map[int64]struct{}, error) {
+	ids := make([]int64, 0, len(posts))
+	for _, p := range posts {
+		ids = append(ids, p.Post.Id)
+	}
+	rows, err := tx.Query(ctx, searchImported, source, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	done := make(map[int64]struct{})
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		done[id] = struct{}{}
+	}
+	return done, rows.Err()
+}
+
+func (r *PgTransferRepository) importPost(ctx context.Context, tx pgx.Tx, source string, p *mod.PostTreeDTO) error {
+	var postId int64
+	err := tx.QueryRow(ctx, insertImportedPost, p.Post.Author, p.Post.Title, p.Post.Text, p.Post.IsCommentable, p.Post.Time,
+		len(p.Comments), zeronull.Timestamptz(lastTime(p.Comments))).Scan(&postId)
+	if err != nil {
+		return err
+	}
+
+	if len(p.Comments) > 0 {
+		// ids are reserved before copy, so parents of following comments are known
+		newIds, err := reserve(ctx, tx, "comment", "id_c", len(p.Comments))
+		if err != nil {
+			return err
+		}
+		ids := make(map[int64]int64, len(p.Comments))
+		copyRows := make([][]any, 0, len(p.Comments))
+		var pins []int64
+		for i, c := range p.Comments {
+			var parent zeronull.Int8
+			if c.ParentId != 0 {
+				id, ok := ids[c.ParentId]
+				if !ok {
+					return fmt.Errorf("parent %d of comment %d is not found before it", c.ParentId, c.Id)
+				}
+				parent = zeronull.Int8(id)
+			}
+			ids[c.Id] = newIds[i]
+			copyRows = append(copyRows, []any{newIds[i], postId, parent, c.Author, c.Text, c.Time})
+			if c.Pinned {
+				pins = append(pins, newIds[i])
+			}
+		}
+		_, err = tx.CopyFrom(ctx, pgx.Identifier{"comment"}, commentColumns, pgx.CopyFromRows(copyRows))
+		if err != nil {
+			return err
+		}
+		if len(pins) > 0 {
+			if _, err := tx.Exec(ctx, insertImportedPins, pins, postId); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tx.Exec(ctx, insertImport, source, p.Post.Id, postId)
+	return err
+}
+
+func NewInMemoryTransferRepository(p *InMemoryPostRepository, c *InMemoryCommentRepository) *InMemoryTransferRepository {
+	c.posts = p
+	return &InMemoryTransferRepository{posts: p, comments: c, imported: make(map[string]map[int64]int64)}
+}
+
+func (r *InMemoryTransferRepository) ExportPosts(ctx context.Context, postId int64, after int64, limit int) ([]*mod.PostDTO, error) {
+	r.posts.m.RLock()
+	defer r.posts.m.RUnlock()
+	var all []*mod.PostDTO
+	for id, p := range r.posts.posts {
+		if id <= after || (postId != 0 && id != postId) {
+			continue
+		}
+		c := *p
+		all = append(all, &c)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Id < all[j].Id })
+	if limit < len(all) {
+		all = all[:limit]
+	}
+	return all, nil
+}
+
+func (r *InMemoryTransferRepository) ExportComments(ctx context.Context, postId int64, after int64, limit int) ([]*mod.CommentDTO, error) {
+	r.comments.m.RLock()
+	defer r.comments.m.RUnlock()
+	pinned := make(map[int64]bool)
+	for _, id := range r.comments.pins[postId] {
+		pinned[id] = true
+	}
+	var res []*mod.CommentDTO
+	// comments of a post are stored in order of their ids
+	for _, c := range r.comments.c[postId] {
+		if c.Id <= after {
+			continue
+		}
+		if len(res) == limit {
+			break
+		}
+		cc := *c
+		cc.Pinned = pinned[c.Id]
+		res = append(res, &cc)
+	}
+	return res, nil
+}
+
+func (r *InMemoryTransferRepository) Import(ctx context.Context, source string, posts []*mod.PostTreeDTO) (int, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.posts.m.Lock()
+	defer r.posts.m.Unlock()
+	r.comments.m.Lock()
+	defer r.comments.m.Unlock()
+
+	// everything is checked before changes, so a failed batch stores nothing
+	for _, p := range posts {
+		seen := make(map[int64]struct{}, len(p.Comments))
+		for _, c := range p.Comments {
+			if _, ok := seen[c.ParentId]; c.ParentId != 0 && !ok {
+				return 0, fmt.Errorf("parent %d of comment %d is not found before it", c.ParentId, c.Id)
+			}
+			seen[c.Id] = struct{}{}
+		}
+	}
+
+	done := r.imported[source]
+	if done == nil {
+		done = make(map[int64]int64)
+		r.imported[source] = done
+	}
+	n := 0
+	for _, p := range posts {
+		if _, ok := done[p.Post.Id]; ok {
+			continue
+		}
+		post := p.Post
+		post.Id = r.posts.idGen
+		r.posts.idGen++
+		post.CommentCount = int64(len(p.Comments))
+		ids := make(map[int64]int64, len(p.Comments))
+		for _, c := range p.Comments {
+			cc := *c
+			cc.Id = r.comments.idGen
+			r.comments.idGen++
+			cc.PostId = post.Id
+			cc.ParentId = ids[c.ParentId]
+			cc.Pinned = false
+			ids[c.Id] = cc.Id
+			r.comments.c[post.Id] = append(r.comments.c[post.Id], &cc)
+			if c.Pinned {
+				r.comments.pins[post.Id] = append(r.comments.pins[post.Id], cc.Id)
+			}
+			if cc.Time.After(post.LastCommentAt) {
+				post.LastCommentAt = cc.Time
+			}
+		}
+		r.posts.posts[post.Id] = &post
+		done[p.Post.Id] = post.Id
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,400 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype/zeronull"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mi-raf/comment-project/internal/logging"
+	mod "github.com/mi-raf/comment-project/internal/models"
+)
+
+const (
+	insertWebhook    = "INSERT INTO Webhook (url, secret, events) VALUES ($1, $2, $3) RETURNING id_w, time_w"
+	deleteWebhook    = "DELETE FROM Webhook WHERE id_w = $1"
+	searchWebhooks   = "SELECT id_w, url, secret, events, active, time_w FROM Webhook ORDER BY id_w"
+	insertDeliveries = `INSERT INTO WebhookDelivery (id_w, event, payload)
+	SELECT id_w, $1::text, $2::jsonb FROM Webhook WHERE active AND $1::text = ANY(events)`
+	// claimDeliveries moves next attempt of due deliveries forward by a lease,
+	// so a delivery is picked again if the process dies before it is finished.
+	claimDeliveries = `UPDATE WebhookDelivery d SET next_attempt_at = $2
+	FROM Webhook w
+	WHERE d.id_w = w.id_w AND d.id_d IN (
+		SELECT id_d FROM WebhookDelivery
+		WHERE status = 'pending' AND next_attempt_at <= $1
+		ORDER BY next_attempt_at, id_d
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING d.id_d, d.id_w, d.event, d.payload, d.status, d.attempts, d.next_attempt_at, d.last_error, d.response_code, d.time_d, d.delivered_at, w.url, w.secret`
+	markDelivered = `UPDATE WebhookDelivery
+	SET status = 'delivered', attempts = attempts + 1, response_code = $2, delivered_at = $3, last_error = NULL
+	WHERE id_d = $1`
+	markFailed = `UPDATE WebhookDelivery
+	SET status = $2, attempts = attempts + 1, response_code = $3, last_error = $4, next_attempt_at = $5
+	WHERE id_d = $1`
+	// deleteFinished removes delivered and dead deliveries in batches, so a long backlog does not hold locks for long.
+	deleteFinished = `DELETE FROM WebhookDelivery WHERE id_d IN (
+		SELECT id_d FROM WebhookDelivery
+		WHERE status <> 'pending' AND time_d < $1
+		ORDER BY time_d
+		LIMIT $2
+	)`
+	searchDeliveries = `SELECT id_d, id_w, event, payload, status, attempts, next_attempt_at, last_error, response_code, time_d, delivered_at, '', ''
+	FROM WebhookDelivery
+	WHERE ($1::bigint = 0 OR id_w = $1) AND ($2::text = '' OR status = $2)
+	ORDER BY id_d DESC
+	LIMIT $3
+	OFFSET $4`
+)
+
+type (
+	WebhookRepository interface {
+		Add(ctx context.Context, w *mod.WebhookDTO) (int64, error)
+		Delete(ctx context.Context, id int64) (bool, error)
+		GetAll(ctx context.Context) ([]*mod.WebhookDTO, error)
+		// Enqueue creates a pending delivery for every active webhook subscribed to the event.
+		Enqueue(ctx context.Context, event string, payload []byte) (int64, error)
+		// Claim returns up to limit due deliveries and postpones them until now+lease.
+		Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*mod.WebhookDeliveryDTO, error)
+		MarkDelivered(ctx context.Context, id int64, code int, at time.Time) error
+		MarkFailed(ctx context.Context, id int64, code int, reason string, next time.Time, dead bool) error
+		GetDeliveries(ctx context.Context, f mod.DeliveryFilter, offset int64, limit int) ([]*mod.WebhookDeliveryDTO, error)
+		// DeleteFinished deletes up to limit delivered and dead deliveries created before the time
+		// and returns a number of deleted ones.
+		DeleteFinished(ctx context.Context, before time.Time, limit int) (int64, error)
+	}
+
+	// EventEncoder builds the body of webhook deliveries of the event about a stored *mod.PostDTO or *mod.CommentDTO.
+	EventEncoder func(event string, entity any) ([]byte, error)
+
+	// EventWriter is a repository which enqueues webhook deliveries in the transaction which stores an entity,
+	// so deliveries are enqueued only for committed entities and are not lost if the process stops after commit.
+	EventWriter interface {
+		WriteEvents(enc EventEncoder)
+	}
+
+	WebhookConfig struct {
+		InMemory bool
+		DbAddr   string
+	}
+
+	PgWebhookRepository struct {
+		pool *pgxpool.Pool
+	}
+
+	InMemoryWebhookRepository struct {
+		hooks      map[int64]*mod.WebhookDTO
+		deliveries []*mod.WebhookDeliveryDTO
+		m          sync.Mutex
+		idGen      int64
+		deliveryID int64
+	}
+)
+
+func NewWebhookRepositoryProvider(ctx context.Context, cfg *WebhookConfig) (WebhookRepository, func(), error) {
+	if cfg.InMemory {
+		return NewInMemoryWebhookRepository(), func() {}, nil
+	}
+	pg, err := newPool(ctx, cfg.DbAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = pg.Ping(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewPgWebhookRepository(pg), pg.Close, nil
+}
+
+func NewPgWebhookRepository(p *pgxpool.Pool) *PgWebhookRepository {
+	return &PgWebhookRepository{pool: p}
+}
+
+func (r *PgWebhookRepository) Add(ctx context.Context, w *mod.WebhookDTO) (int64, error) {
+	var id int64
+	err := r.pool.QueryRow(ctx, insertWebhook, w.URL, w.Secret, w.Events).Scan(&id, &w.Time)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Str("url", w.URL).Msg("can not insert webhook")
+		return -1, err
+	}
+	return id, nil
+}
+
+func (r *PgWebhookRepository) Delete(ctx context.Context, id int64) (bool, error) {
+	tag, err := r.pool.Exec(ctx, deleteWebhook, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *PgWebhookRepository) GetAll(ctx context.Context) ([]*mod.WebhookDTO, error) {
+	rows, err := r.pool.Query(ctx, searchWebhooks)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := make([]*mod.WebhookDTO, 0)
+	for rows.Next() {
+		var w mod.WebhookDTO
+		if err := rows.Scan(&w.Id, &w.URL, &w.Secret, &w.Events, &w.Active, &w.Time); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, &w)
+	}
+	return hooks, rows.Err()
+}
+
+func (r *PgWebhookRepository) Enqueue(ctx context.Context, event string, payload []byte) (int64, error) {
+	tag, err := r.pool.Exec(ctx, insertDeliveries, event, payload)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Str("event", event).Msg("can not enqueue webhook deliveries")
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// enqueueEvent creates deliveries of the event about the entity inside tx, nothing is enqueued without an encoder.
+func enqueueEvent(ctx context.Context, tx pgx.Tx, enc EventEncoder, event string, entity any) error {
+	if enc == nil {
+		return nil
+	}
+	body, err := enc(event, entity)
+	if err != nil {
+		return err
+	}
+	tag, err := tx.Exec(ctx, insertDeliveries, event, body)
+	if err != nil {
+		return err
+	}
+	logging.Ctx(ctx).Debug().Str("event", event).Int64("deliveries", tag.RowsAffected()).Msg("webhook deliveries enqueued")
+	return nil
+}
+
+func (r *PgWebhookRepository) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*mod.WebhookDeliveryDTO, error) {
+	rows, err := r.pool.Query(ctx, claimDeliveries, now, now.Add(lease), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeliveries(rows)
+}
+
+func (r *PgWebhookRepository) MarkDelivered(ctx context.Context, id int64, code int, at time.Time) error {
+	_, err := r.pool.Exec(ctx, markDelivered, id, code, at)
+	return err
+}
+
+func (r *PgWebhookRepository) MarkFailed(ctx context.Context, id int64, code int, reason string, next time.Time, dead bool) error {
+	status := mod.DeliveryPending
+	if dead {
+		status = mod.DeliveryDead
+	}
+	_, err := r.pool.Exec(ctx, markFailed, id, status, zeronull.Int4(code), reason, next)
+	return err
+}
+
+func (r *PgWebhookRepository) GetDeliveries(ctx context.Context, f mod.DeliveryFilter, offset int64, limit int) ([]*mod.WebhookDeliveryDTO, error) {
+	rows, err := r.pool.Query(ctx, searchDeliveries, f.WebhookId, string(f.Status), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeliveries(rows)
+}
+
+func (r *PgWebhookRepository) DeleteFinished(ctx context.Context, before time.Time, limit int) (int64, error) {
+	tag, err := r.pool.Exec(ctx, deleteFinished, before, limit)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func scanDeliveries(rows pgx.Rows) ([]*mod.WebhookDeliveryDTO, error) {
+	defer rows.Close()
+	res := make([]*mod.WebhookDeliveryDTO, 0)
+	for rows.Next() {
+		var d mod.WebhookDeliveryDTO
+		var lastError zeronull.Text
+		var code zeronull.Int4
+		var delivered zeronull.Timestamptz
+		err := rows.Scan(&d.Id, &d.WebhookId, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+			&lastError, &code, &d.Time, &delivered, &d.URL, &d.Secret)
+		if err != nil {
+			return nil, err
+		}
+		d.LastError = string(lastError)
+		d.ResponseCode = int(code)
+		d.DeliveredAt = time.Time(delivered)
+		res = append(res, &d)
+	}
+	return res, rows.Err()
+}
+
+func NewInMemoryWebhookRepository() *InMemoryWebhookRepository {
+	return &InMemoryWebhookRepository{hooks: make(map[int64]*mod.WebhookDTO), idGen: 1, deliveryID: 1}
+}
+
+func (r *InMemoryWebhookRepository) Add(ctx context.Context, w *mod.WebhookDTO) (int64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	id := r.idGen
+	r.idGen++
+	w.Time = time.Now()
+	h := *w
+	h.Id = id
+	h.Active = true
+	h.Events = slices.Clone(w.Events)
+	r.hooks[id] = &h
+	return id, nil
+}
+
+func (r *InMemoryWebhookRepository) Delete(ctx context.Context, id int64) (bool, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if _, ok := r.hooks[id]; !ok {
+		return false, nil
+	}
+	delete(r.hooks, id)
+	r.deliveries = slices.DeleteFunc(r.deliveries, func(d *mod.WebhookDeliveryDTO) bool {
+		return d.WebhookId == id
+	})
+	return true, nil
+}
+
+func (r *InMemoryWebhookRepository) GetAll(ctx context.Context) ([]*mod.WebhookDTO, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	res := make([]*mod.WebhookDTO, 0, len(r.hooks))
+	for _, h := range r.hooks {
+		c := *h
+		res = append(res, &c)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Id < res[j].Id
+	})
+	return res, nil
+}
+
+func (r *InMemoryWebhookRepository) Enqueue(ctx context.Context, event string, payload []byte) (int64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	now := time.Now()
+	var n int64
+	for _, h := range r.hooks {
+		if !h.Active || !slices.Contains(h.Events, event) {
+			continue
+		}
+		r.deliveries = append(r.deliveries, &mod.WebhookDeliveryDTO{
+			Id:            r.deliveryID,
+			WebhookId:     h.Id,
+			Event:         event,
+			Payload:       slices.Clone(payload),
+			Status:        mod.DeliveryPending,
+			NextAttemptAt: now,
+			Time:          now,
+		})
+		r.deliveryID++
+		n++
+	}
+	return n, nil
+}
+
+func (r *InMemoryWebhookRepository) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*mod.WebhookDeliveryDTO, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	res := make([]*mod.WebhookDeliveryDTO, 0)
+	for _, d := range r.deliveries {
+		if len(res) == limit {
+			break
+		}
+		if d.Status != mod.DeliveryPending || d.NextAttemptAt.After(now) {
+			continue
+		}
+		d.NextAttemptAt = now.Add(lease)
+		c := *d
+		c.URL = r.hooks[d.WebhookId].URL
+		c.Secret = r.hooks[d.WebhookId].Secret
+		res = append(res, &c)
+	}
+	return res, nil
+}
+
+func (r *InMemoryWebhookRepository) MarkDelivered(ctx context.Context, id int64, code int, at time.Time) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if d := r.delivery(id); d != nil {
+		d.Status = mod.DeliveryDelivered
+		d.Attempts++
+		d.ResponseCode = code
+		d.DeliveredAt = at
+		d.LastError = ""
+	}
+	return nil
+}
+
+func (r *InMemoryWebhookRepository) MarkFailed(ctx context.Context, id int64, code int, reason string, next time.Time, dead bool) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if d := r.delivery(id); d != nil {
+		d.Attempts++
+		d.ResponseCode = code
+		d.LastError = reason
+		d.NextAttemptAt = next
+		if dead {
+			d.Status = mod.DeliveryDead
+		}
+	}
+	return nil
+}
+
+func (r *InMemoryWebhookRepository) GetDeliveries(ctx context.Context, f mod.DeliveryFilter, offset int64, limit int) ([]*mod.WebhookDeliveryDTO, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	res := make([]*mod.WebhookDeliveryDTO, 0)
+	for i := len(r.deliveries) - 1; i >= 0; i-- {
+		d := r.deliveries[i]
+		if (f.WebhookId != 0 && d.WebhookId != f.WebhookId) || (f.Status != "" && d.Status != f.Status) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if len(res) == limit {
+			break
+		}
+		c := *d
+		res = append(res, &c)
+	}
+	return res, nil
+}
+
+func (r *InMemoryWebhookRepository) DeleteFinished(ctx context.Context, before time.Time, limit int) (int64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	var n int64
+	r.deliveries = slices.DeleteFunc(r.deliveries, func(d *mod.WebhookDeliveryDTO) bool {
+		if n == int64(limit) || d.Status == mod.DeliveryPending || !d.Time.Before(before) {
+			return false
+		}
+		n++
+		return true
+	})
+	return n, nil
+}
+
+func (r *InMemoryWebhookRepository) delivery(id int64) *mod.WebhookDeliveryDTO {
+	for _, d := range r.deliveries {
+		if d.Id == id {
+			return d
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+
+	commentsv1 "github.com/mi-raf/comment-project/proto/comments/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// adminMethods are methods which need the admin token like @admin fields of the GraphQL schema.
+var adminMethods = map[string]bool{
+	commentsv1.PostService_PinComment_FullMethodName:   true,
+	commentsv1.PostService_UnpinComment_FullMethodName: true,
+}
+
+// unaryAdmin rejects calls of admin methods without "authorization: Bearer <token>" metadata,
+// admin methods are disabled when token is empty.
+func unaryAdmin(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if adminMethods[info.FullMethod] && !isAdmin(ctx, token) {
+			return nil, status.Error(codes.PermissionDenied, "admin token required")
+		}
+		return handler(ctx, req)
+	}
+}
+
+func isAdmin(ctx context.Context, token string) bool {
+	if token == "" {
+		return false
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+	for _, v := range md.Get("authorization") {
+		if auth, ok := strings.CutPrefix(v, "Bearer "); ok && subtle.ConstantTimeCompare([]byte(auth), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
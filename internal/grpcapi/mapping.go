@@ -0,0 +1,101 @@
+package grpcapi
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/mi-raf/comment-project/graph/model"
+	commentsv1 "github.com/mi-raf/comment-project/proto/comments/v1"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func toShortPosts(posts []*model.ShortPost) []*commentsv1.ShortPost {
+	res := make([]*commentsv1.ShortPost, 0, len(posts))
+	for _, p := range posts {
+		res = append(res, &commentsv1.ShortPost{
+			Id:            toID(p.ID),
+			Author:        p.Author,
+			Title:         p.Title,
+			Time:          timestamppb.New(p.Time),
+			CommentCount:  int64(p.CommentCount),
+			LastCommentAt: toNullableTimestamp(p.LastCommentAt),
+		})
+	}
+	return res
+}
+
+func toPost(p *model.Post) *commentsv1.Post {
+	res := &commentsv1.Post{
+		Id:            toID(p.ID),
+		Author:        p.Author,
+		Title:         p.Title,
+		Text:          p.Text,
+		Time:          timestamppb.New(p.Time),
+		IsCommentable: p.IsCommentable,
+		CommentCount:  int64(p.CommentCount),
+		LastCommentAt: toNullableTimestamp(p.LastCommentAt),
+	}
+	if p.Comments != nil {
+		res.Comments = toComments(p.Comments)
+	}
+	return res
+}
+
+func toComments(cs *model.CommentsResult) *commentsv1.CommentsResult {
+	res := &commentsv1.CommentsResult{
+		Comments: make([]*commentsv1.CommentConnection, 0, len(cs.Comments)),
+		PageInfo: &commentsv1.PageInfo{},
+	}
+	for _, c := range cs.Comments {
+		res.Comments = append(res.Comments, toComment(c))
+	}
+	if cs.PageInfo != nil && cs.PageInfo.EndCursor != nil {
+		res.PageInfo.EndCursor = *cs.PageInfo.EndCursor
+	}
+	return res
+}
+
+func toComment(c *model.CommentConnection) *commentsv1.CommentConnection {
+	res := &commentsv1.CommentConnection{
+		Id:            toID(c.ID),
+		Level:         int32(c.Level),
+		PostId:        toID(c.PostID),
+		Pinned:        c.Pinned,
+		AuthorReplied: c.AuthorReplied,
+	}
+	if c.ParentID != nil {
+		res.ParentId = toID(*c.ParentID)
+	}
+	if c.Comment != nil {
+		res.Comment = &commentsv1.Comment{
+			Author: c.Comment.Author,
+			Text:   c.Comment.Text,
+			Time:   timestamppb.New(c.Comment.Time),
+		}
+	}
+	return res
+}
+
+func toID(id string) int64 {
+	res, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		log.Error().Err(err).Str("data", id).Msg("can not parse id to int64")
+		return 0
+	}
+	return res
+}
+
+func toNullableTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func toTime(t *timestamppb.Timestamp) time.Time {
+	if t == nil {
+		return time.Now()
+	}
+	return t.AsTime()
+}
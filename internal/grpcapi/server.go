@@ -0,0 +1,223 @@
+package grpcapi
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/mi-raf/comment-project/graph/model"
+	"github.com/mi-raf/comment-project/internal/logging"
+	"github.com/mi-raf/comment-project/internal/service"
+	commentsv1 "github.com/mi-raf/comment-project/proto/comments/v1"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// SHUTDOWN_TIMEOUT is used when the config has no ShutdownTimeout.
+const SHUTDOWN_TIMEOUT = 10 * time.Second
+
+type (
+	Server struct {
+		commentsv1.UnimplementedPostServiceServer
+		ps              *service.PostService
+		s               *grpc.Server
+		listen          string
+		shutdownTimeout time.Duration
+	}
+
+	Config struct {
+		Listen string
+		// ShutdownTimeout limits waiting for running calls and streams on shutdown, zero means SHUTDOWN_TIMEOUT.
+		ShutdownTimeout time.Duration
+		// AdminToken is required by admin methods, they are disabled when it is empty.
+		AdminToken string
+	}
+)
+
+func NewServer(ps *service.PostService, c *Config) *Server {
+	srv := &Server{ps: ps, s: grpc.NewServer(
+		grpc.ChainUnaryInterceptor(unaryRequestID, unaryAdmin(c.AdminToken)),
+		grpc.ChainStreamInterceptor(streamRequestID),
+	), listen: c.Listen, shutdownTimeout: cmp.Or(c.ShutdownTimeout, SHUTDOWN_TIMEOUT)}
+	commentsv1.RegisterPostServiceServer(srv.s, srv)
+	reflection.Register(srv.s)
+	return srv
+}
+
+func (s *Server) Start() error {
+	l, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msgf("grpc listening on %v", s.listen)
+	return s.Serve(l)
+}
+
+// Serve accepts connections of the listener until Close.
+func (s *Server) Serve(l net.Listener) error {
+	return s.s.Serve(l)
+}
+
+// Close waits for running calls to finish and stops the server
+// if they do not finish in ShutdownTimeout. Streams of comments end when the service closes subscriptions.
+func (s *Server) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	s.Shutdown(ctx)
+}
+
+// Shutdown waits for running calls to finish and stops the server when ctx is done.
+func (s *Server) Shutdown(ctx context.Context) {
+	log.Debug().Msg("start graceful grpc server shutdown")
+	done := make(chan struct{})
+	go func() {
+		s.s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Debug().Msg("grpc server graceful shutdowned")
+	case <-ctx.Done():
+		log.Warn().Msg("grpc server graceful shutdown timed out, stopping")
+		s.s.Stop()
+	}
+}
+
+func (s *Server) ListPosts(ctx context.Context, req *commentsv1.ListPostsRequest) (*commentsv1.ListPostsResponse, error) {
+	order := model.PostOrderTime
+	if req.GetOrderBy() == commentsv1.PostOrder_POST_ORDER_LAST_ACTIVITY {
+		order = model.PostOrderLastActivity
+	}
+	posts, err := s.ps.GetAllPosts(ctx, req.GetAfter(), int(req.GetCount()), order)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &commentsv1.ListPostsResponse{Posts: toShortPosts(posts)}, nil
+}
+
+func (s *Server) GetPost(ctx context.Context, req *commentsv1.GetPostRequest) (*commentsv1.Post, error) {
+	p, err := s.ps.Post(ctx, req.GetPostId(), int(req.GetCount()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if p == nil {
+		return nil, status.Errorf(codes.NotFound, "no post %d", req.GetPostId())
+	}
+	return toPost(p), nil
+}
+
+func (s *Server) ListComments(ctx context.Context, req *commentsv1.ListCommentsRequest) (*commentsv1.CommentsResult, error) {
+	cs, err := s.ps.Comments(ctx, req.GetPostId(), int(req.GetCount()), req.GetAfter())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toComments(cs), nil
+}
+
+func (s *Server) GetAuthor(ctx context.Context, req *commentsv1.GetAuthorRequest) (*commentsv1.Author, error) {
+	a, err := s.ps.Author(ctx, req.GetName())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if a == nil {
+		return nil, status.Errorf(codes.NotFound, "no author %q", req.GetName())
+	}
+	return &commentsv1.Author{
+		Name: a.Name,
+		Stats: &commentsv1.AuthorStats{
+			PostCount:    int64(a.Stats.PostCount),
+			CommentCount: int64(a.Stats.CommentCount),
+			FirstSeen:    toNullableTimestamp(a.Stats.FirstSeen),
+		},
+	}, nil
+}
+
+func (s *Server) ListAuthorPosts(ctx context.Context, req *commentsv1.ListAuthorPostsRequest) (*commentsv1.ListPostsResponse, error) {
+	posts, err := s.ps.AuthorPosts(ctx, req.GetName(), req.GetAfter(), int(req.GetCount()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &commentsv1.ListPostsResponse{Posts: toShortPosts(posts)}, nil
+}
+
+func (s *Server) ListAuthorComments(ctx context.Context, req *commentsv1.ListAuthorCommentsRequest) (*commentsv1.CommentsResult, error) {
+	cs, err := s.ps.AuthorComments(ctx, req.GetName(), int(req.GetCount()), req.GetAfter())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toComments(cs), nil
+}
+
+func (s *Server) CreatePost(ctx context.Context, req *commentsv1.CreatePostRequest) (*commentsv1.Post, error) {
+	p, err := s.ps.CreatePost(ctx, model.NewPost{
+		Author:        req.GetAuthor(),
+		Title:         req.GetTitle(),
+		Text:          req.GetText(),
+		Time:          toTime(req.GetTime()),
+		IsCommentable: req.IsCommentable,
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toPost(p), nil
+}
+
+func (s *Server) CreateComment(ctx context.Context, req *commentsv1.CreateCommentRequest) (*commentsv1.CreateCommentResponse, error) {
+	id, err := s.ps.CreateComment(ctx, req.GetPostId(), req.GetParentComment(), model.NewComment{
+		Author: req.GetAuthor(),
+		Text:   req.GetText(),
+		Time:   toTime(req.GetTime()),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &commentsv1.CreateCommentResponse{Id: id}, nil
+}
+
+func (s *Server) PinComment(ctx context.Context, req *commentsv1.PinCommentRequest) (*commentsv1.PinCommentResponse, error) {
+	if err := s.ps.PinComment(ctx, req.GetPostId(), req.GetCommentId()); err != nil {
+		return nil, toStatus(err)
+	}
+	return &commentsv1.PinCommentResponse{}, nil
+}
+
+func (s *Server) UnpinComment(ctx context.Context, req *commentsv1.PinCommentRequest) (*commentsv1.PinCommentResponse, error) {
+	if err := s.ps.UnpinComment(ctx, req.GetPostId(), req.GetCommentId()); err != nil {
+		return nil, toStatus(err)
+	}
+	return &commentsv1.PinCommentResponse{}, nil
+}
+
+// SubscribeComments uses the same subscription hub as the commentSubscribe GraphQL subscription.
+func (s *Server) SubscribeComments(req *commentsv1.SubscribeCommentsRequest, stream commentsv1.PostService_SubscribeCommentsServer) error {
+	ctx := stream.Context()
+	ch := s.ps.CommentSubscribe(ctx, req.GetPostId())
+	for {
+		select {
+		case c, ok := <-ch:
+			if !ok {
+				return status.Error(codes.Unavailable, "subscription closed")
+			}
+			if err := stream.Send(toComment(c)); err != nil {
+				logging.Ctx(stream.Context()).Error().Err(err).Int64("post id", req.GetPostId()).Msg("can not send comment to stream")
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, service.ErrClientData):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
@@ -0,0 +1,260 @@
+package grpcapi_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mi-raf/comment-project/internal/database"
+	"github.com/mi-raf/comment-project/internal/grpcapi"
+	"github.com/mi-raf/comment-project/internal/logging"
+	"github.com/mi-raf/comment-project/internal/models"
+	"github.com/mi-raf/comment-project/internal/service"
+	commentsv1 "github.com/mi-raf/comment-project/proto/comments/v1"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type ServerTestSuite struct {
+	suite.Suite
+	ctx     context.Context
+	ps      *service.PostService
+	closePs func()
+	client  commentsv1.PostServiceClient
+}
+
+func TestServerTestSuite(t *testing.T) {
+	suite.Run(t, new(ServerTestSuite))
+}
+
+func (s *ServerTestSuite) SetupTest() {
+	var cancel context.CancelFunc
+	s.ctx, cancel = context.WithCancel(context.Background())
+	p, _, err := database.NewPostRepositoryProvider(s.ctx, &database.PostConfig{InMemory: true})
+	s.NoError(err)
+	ch, closeCh := service.NewCommentChan()
+	c, _, err := database.NewCommentRepositoryProvider(s.ctx, &database.CommentConfig{InMemory: true, CommentChan: ch}, p)
+	s.NoError(err)
+	_, err = p.Add(s.ctx, &models.PostDTO{Author: "author", Title: "title", Text: "text", IsCommentable: true, Time: time.Now()})
+	s.NoError(err)
+	ps, cleanup := service.NewPostService(s.ctx, p, c, &service.Config{CommentChan: ch, MaxPins: 1})
+	s.ps = ps
+	s.closePs = cleanup
+
+	l := bufconn.Listen(1 << 20)
+	srv := grpcapi.NewServer(ps, &grpcapi.Config{AdminToken: "secret"})
+	go srv.Serve(l)
+	conn, err := grpc.DialContext(s.ctx, "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	s.Require().NoError(err)
+	s.client = commentsv1.NewPostServiceClient(conn)
+	s.T().Cleanup(func() {
+		conn.Close()
+		srv.Close()
+		cancel()
+		cleanup()
+		closeCh()
+	})
+}
+
+func (s *ServerTestSuite) TestListPosts() {
+	// when
+	res, err := s.client.ListPosts(s.ctx, &commentsv1.ListPostsRequest{Count: 10})
+
+	// then
+	s.NoError(err)
+	s.Require().Len(res.GetPosts(), 1)
+	s.Equal(int64(1), res.GetPosts()[0].GetId())
+	s.Equal("title", res.GetPosts()[0].GetTitle())
+}
+
+func (s *ServerTestSuite) TestGetPost() {
+	// given
+	_, err := s.client.CreateComment(s.ctx, &commentsv1.CreateCommentRequest{PostId: 1, Author: "reader", Text: "text"})
+	s.NoError(err)
+
+	// when
+	res, err := s.client.GetPost(s.ctx, &commentsv1.GetPostRequest{PostId: 1, Count: 10})
+
+	// then
+	s.NoError(err)
+	s.Equal("author", res.GetAuthor())
+	s.True(res.GetIsCommentable())
+	s.Require().Len(res.GetComments().GetComments(), 1)
+	s.Equal("reader", res.GetComments().GetComments()[0].GetComment().GetAuthor())
+}
+
+func (s *ServerTestSuite) TestGetPostNotFound() {
+	// when
+	_, err := s.client.GetPost(s.ctx, &commentsv1.GetPostRequest{PostId: 100})
+
+	// then
+	s.Equal(codes.NotFound, status.Code(err))
+}
+
+func (s *ServerTestSuite) TestCreatePost() {
+	// given
+	tm := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	// when
+	res, err := s.client.CreatePost(s.ctx, &commentsv1.CreatePostRequest{Author: "writer", Title: "new", Text: "text", Time: timestamppb.New(tm)})
+
+	// then
+	s.NoError(err)
+	s.Equal(int64(2), res.GetId())
+	s.Equal("writer", res.GetAuthor())
+	s.True(res.GetIsCommentable())
+	s.True(tm.Equal(res.GetTime().AsTime()))
+}
+
+func (s *ServerTestSuite) TestCreateComment() {
+	// when
+	first, err := s.client.CreateComment(s.ctx, &commentsv1.CreateCommentRequest{PostId: 1, Author: "reader", Text: "first"})
+	s.NoError(err)
+	second, err := s.client.CreateComment(s.ctx, &commentsv1.CreateCommentRequest{PostId: 1, ParentComment: first.GetId(), Author: "reader", Text: "second"})
+	s.NoError(err)
+
+	// then
+	res, err := s.client.ListComments(s.ctx, &commentsv1.ListCommentsRequest{PostId: 1, Count: 10})
+	s.NoError(err)
+	s.Require().Len(res.GetComments(), 2)
+	s.Equal(first.GetId(), res.GetComments()[0].GetId())
+	s.Equal(second.GetId(), res.GetComments()[1].GetId())
+	s.Equal(first.GetId(), res.GetComments()[1].GetParentId())
+	s.Equal(res.GetComments()[0].GetLevel()+1, res.GetComments()[1].GetLevel())
+	s.Equal(strconv.FormatInt(second.GetId(), 10), res.GetPageInfo().GetEndCursor())
+}
+
+func (s *ServerTestSuite) TestCreateCommentInvalid() {
+	// when
+	_, err := s.client.CreateComment(s.ctx, &commentsv1.CreateCommentRequest{PostId: 1, Author: strings.Repeat("a", service.MAX_AUTHOR_LEN+1), Text: "text"})
+
+	// then
+	s.Equal(codes.InvalidArgument, status.Code(err))
+}
+
+func (s *ServerTestSuite) TestPinComment() {
+	// given
+	c, err := s.client.CreateComment(s.ctx, &commentsv1.CreateCommentRequest{PostId: 1, Author: "reader", Text: "text"})
+	s.NoError(err)
+
+	admin := metadata.AppendToOutgoingContext(s.ctx, "authorization", "Bearer secret")
+
+	// when
+	_, err = s.client.PinComment(admin, &commentsv1.PinCommentRequest{PostId: 1, CommentId: c.GetId()})
+
+	// then
+	s.NoError(err)
+	res, err := s.client.ListComments(s.ctx, &commentsv1.ListCommentsRequest{PostId: 1, Count: 10})
+	s.NoError(err)
+	s.Require().Len(res.GetComments(), 1)
+	s.True(res.GetComments()[0].GetPinned())
+
+	// when
+	_, err = s.client.UnpinComment(admin, &commentsv1.PinCommentRequest{PostId: 1, CommentId: c.GetId()})
+
+	// then
+	s.NoError(err)
+	res, err = s.client.ListComments(s.ctx, &commentsv1.ListCommentsRequest{PostId: 1, Count: 10})
+	s.NoError(err)
+	s.Require().Len(res.GetComments(), 1)
+	s.False(res.GetComments()[0].GetPinned())
+}
+
+func (s *ServerTestSuite) TestPinCommentNotAdmin() {
+	// given
+	c, err := s.client.CreateComment(s.ctx, &commentsv1.CreateCommentRequest{PostId: 1, Author: "reader", Text: "text"})
+	s.NoError(err)
+
+	// when
+	_, err = s.client.PinComment(s.ctx, &commentsv1.PinCommentRequest{PostId: 1, CommentId: c.GetId()})
+	_, wrongErr := s.client.UnpinComment(metadata.AppendToOutgoingContext(s.ctx, "authorization", "Bearer wrong"), &commentsv1.PinCommentRequest{PostId: 1, CommentId: c.GetId()})
+
+	// then
+	s.Equal(codes.PermissionDenied, status.Code(err))
+	s.Equal(codes.PermissionDenied, status.Code(wrongErr))
+}
+
+func (s *ServerTestSuite) TestGetAuthor() {
+	// given
+	_, err := s.client.CreateComment(s.ctx, &commentsv1.CreateCommentRequest{PostId: 1, Author: "author", Text: "text"})
+	s.NoError(err)
+
+	// when
+	res, err := s.client.GetAuthor(s.ctx, &commentsv1.GetAuthorRequest{Name: "author"})
+
+	// then
+	s.NoError(err)
+	s.Equal(int64(1), res.GetStats().GetPostCount())
+	s.Equal(int64(1), res.GetStats().GetCommentCount())
+	posts, err := s.client.ListAuthorPosts(s.ctx, &commentsv1.ListAuthorPostsRequest{Name: "author", Count: 10})
+	s.NoError(err)
+	s.Len(posts.GetPosts(), 1)
+	comments, err := s.client.ListAuthorComments(s.ctx, &commentsv1.ListAuthorCommentsRequest{Name: "author", Count: 10})
+	s.NoError(err)
+	s.Len(comments.GetComments(), 1)
+}
+
+func (s *ServerTestSuite) TestGetAuthorNotFound() {
+	// when
+	_, err := s.client.GetAuthor(s.ctx, &commentsv1.GetAuthorRequest{Name: "nobody"})
+
+	// then
+	s.Equal(codes.NotFound, status.Code(err))
+}
+
+func (s *ServerTestSuite) TestRequestID() {
+	// given
+	var header metadata.MD
+	ctx := metadata.AppendToOutgoingContext(s.ctx, logging.REQUEST_ID_HEADER, "test-request-id")
+
+	// when
+	_, err := s.client.ListPosts(ctx, &commentsv1.ListPostsRequest{Count: 10}, grpc.Header(&header))
+
+	// then
+	s.NoError(err)
+	s.Equal([]string{"test-request-id"}, header.Get(logging.REQUEST_ID_HEADER))
+}
+
+func (s *ServerTestSuite) TestSubscribeComments() {
+	// given
+	stream, err := s.client.SubscribeComments(s.ctx, &commentsv1.SubscribeCommentsRequest{PostId: 1})
+	s.Require().NoError(err)
+	s.Eventually(func() bool { return s.ps.SubscriptionStats().Subscribers[1] == 1 }, 2*time.Second, 10*time.Millisecond)
+
+	// when
+	c, err := s.client.CreateComment(s.ctx, &commentsv1.CreateCommentRequest{PostId: 1, Author: "reader", Text: "text"})
+	s.NoError(err)
+
+	// then
+	res, err := stream.Recv()
+	s.Require().NoError(err)
+	s.Equal(c.GetId(), res.GetId())
+	s.Equal(int64(1), res.GetPostId())
+	s.Equal("text", res.GetComment().GetText())
+}
+
+func (s *ServerTestSuite) TestSubscribeCommentsClosed() {
+	// given
+	stream, err := s.client.SubscribeComments(s.ctx, &commentsv1.SubscribeCommentsRequest{PostId: 1})
+	s.Require().NoError(err)
+	s.Eventually(func() bool { return s.ps.SubscriptionStats().Subscribers[1] == 1 }, 2*time.Second, 10*time.Millisecond)
+
+	// when
+	s.closePs()
+
+	// then
+	_, err = stream.Recv()
+	s.Equal(codes.Unavailable, status.Code(err))
+}
@@ -0,0 +1,162 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	postsQuery = `query($after: ID) { posts(count: 20, after: $after) { id title commentCount lastCommentAt } }`
+	// commentsQuery reads a page of the comment tree, which is built by the recursive query.
+	commentsQuery = `query($postId: ID!, $after: ID) {
+	comments(postId: $postId, count: 20, after: $after) {
+		comments { id parentId level pinned authorReplied comment { author text time } }
+		pageInfo { endCursor }
+	}
+}`
+	createCommentMutation = `mutation($postId: ID!, $comment: NewComment!) { createComment(postId: $postId, comment: $comment) }`
+	subscribeQuery        = `subscription($postId: ID!) { commentSubscribe(postId: $postId) { id comment { author time } } }`
+
+	wsProtocol = "graphql-transport-ws"
+)
+
+type (
+	request struct {
+		Query     string         `json:"query"`
+		Variables map[string]any `json:"variables,omitempty"`
+	}
+
+	response struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	wsMessage struct {
+		ID      string          `json:"id,omitempty"`
+		Type    string          `json:"type"`
+		Payload json.RawMessage `json:"payload,omitempty"`
+	}
+
+	client struct {
+		url  string
+		http *http.Client
+	}
+)
+
+// do sends a GraphQL request and decodes data of the response into res if it is not nil.
+func (c *client) do(ctx context.Context, query string, vars map[string]any, res any) error {
+	body, err := json.Marshal(request{Query: query, Variables: vars})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return err
+	}
+	if len(r.Errors) > 0 {
+		return errors.New(r.Errors[0].Message)
+	}
+	if res != nil {
+		return json.Unmarshal(r.Data, res)
+	}
+	return nil
+}
+
+// subscribe opens a websocket, subscribes to comments of the post and calls received
+// with the time of every received comment until ctx is done. Probe comments call probed instead.
+func (c *client) subscribe(ctx context.Context, postID string, probed func(), received func(time.Time)) error {
+	u := "ws" + strings.TrimPrefix(c.url, "http")
+	d := websocket.Dialer{Subprotocols: []string{wsProtocol}, HandshakeTimeout: 10 * time.Second}
+	conn, _, err := d.DialContext(ctx, u, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		conn.Close()
+	}()
+
+	if err := conn.WriteJSON(wsMessage{Type: "connection_init"}); err != nil {
+		return err
+	}
+	var ack wsMessage
+	if err := conn.ReadJSON(&ack); err != nil {
+		return err
+	}
+	if ack.Type != "connection_ack" {
+		return fmt.Errorf("unexpected message %s instead of connection_ack", ack.Type)
+	}
+	payload, err := json.Marshal(request{Query: subscribeQuery, Variables: map[string]any{"postId": postID}})
+	if err != nil {
+		return err
+	}
+	if err := conn.WriteJSON(wsMessage{ID: "1", Type: "subscribe", Payload: payload}); err != nil {
+		return err
+	}
+
+	for {
+		var msg wsMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		switch msg.Type {
+		case "next":
+			var r response
+			if err := json.Unmarshal(msg.Payload, &r); err != nil {
+				return err
+			}
+			var data struct {
+				CommentSubscribe struct {
+					Comment struct {
+						Author string    `json:"author"`
+						Time   time.Time `json:"time"`
+					} `json:"comment"`
+				} `json:"commentSubscribe"`
+			}
+			if err := json.Unmarshal(r.Data, &data); err != nil {
+				return err
+			}
+			if data.CommentSubscribe.Comment.Author == PROBE_AUTHOR {
+				probed()
+			} else {
+				received(data.CommentSubscribe.Comment.Time)
+			}
+		case "ping":
+			if err := conn.WriteJSON(wsMessage{Type: "pong"}); err != nil {
+				return err
+			}
+		case "error", "complete":
+			return fmt.Errorf("subscription stopped with %s: %s", msg.Type, msg.Payload)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+// Package loadtest drives a running GraphQL endpoint with a mix of queries, mutations and
+// websocket subscriptions and reports throughput, latency and comment delivery lag.
+package loadtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	OpPosts         = "posts"
+	OpComments      = "comments"
+	OpCreateComment = "createComment"
+
+	// DRAIN_TIMEOUT is how long subscribers wait for comments after writers are stopped.
+	DRAIN_TIMEOUT = time.Second
+	// PROBE_AUTHOR writes probe comments to hot posts until every subscriber receives one,
+	// so the test starts with registered subscriptions. Probe comments are not counted.
+	PROBE_AUTHOR   = "loadtest-probe"
+	PROBE_INTERVAL = 100 * time.Millisecond
+	PROBE_TIMEOUT  = 5 * time.Second
+	// MAX_COMMENT_PAGE is the last page of comments which is read, pages are followed by end cursors up to a random one.
+	MAX_COMMENT_PAGE = 5
+	PAGE_SIZE        = 20
+)
+
+var opNames = []string{OpPosts, OpComments, OpCreateComment}
+
+var ErrClientData = errors.New("incorrect load test parameters")
+
+type (
+	Config struct {
+		// URL of the GraphQL endpoint, e.g. http://localhost:9000/query.
+		URL      string
+		Duration time.Duration
+		// Concurrency is a number of workers sending queries and mutations.
+		Concurrency int
+		// Mix holds relative weights of operations by name.
+		Mix map[string]int
+		// Subscribers is a number of websocket clients subscribed to comments.
+		Subscribers int
+		// HotPosts is a number of posts which receive new comments and subscribers.
+		HotPosts int
+		Seed     uint64
+	}
+)
+
+// ParseMix parses weights like "posts=5,comments=4,createComment=1".
+func ParseMix(s string) (map[string]int, error) {
+	mix := make(map[string]int)
+	for _, part := range strings.Split(s, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: incorrect mix %q", ErrClientData, part)
+		}
+		w, err := strconv.Atoi(weight)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("%w: incorrect weight of %s", ErrClientData, name)
+		}
+		mix[name] = w
+	}
+	return mix, nil
+}
+
+// Run sends operations until the duration passes or ctx is done.
+// Posts are loaded from the endpoint, so it should have data, e.g. made by seed.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	ops, total, err := weights(cfg.Mix)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Concurrency < 1 && total > 0 {
+		return nil, fmt.Errorf("%w: concurrency should be positive", ErrClientData)
+	}
+	c := &client{url: cfg.URL, http: &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{MaxIdleConnsPerHost: cfg.Concurrency}}}
+
+	posts, err := loadPosts(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("can not load posts: %w", err)
+	}
+	if len(posts) == 0 {
+		return nil, fmt.Errorf("%w: there are no posts, run seed first", ErrClientData)
+	}
+	hot := posts[:max(1, min(cfg.HotPosts, len(posts)))]
+	rec := newRecorder()
+
+	subCtx, stopSubs := context.WithCancel(ctx)
+	defer stopSubs()
+	subscribed := startSubscribers(subCtx, c, hot, cfg.Subscribers, rec)
+
+	log.Info().Int("posts", len(posts)).Int("hot posts", len(hot)).Int("subscribers", subscribed).Msg("load test started")
+	start := time.Now()
+	runCtx, stop := context.WithTimeout(ctx, cfg.Duration)
+	defer stop()
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Concurrency && total > 0; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := &worker{c: c, rec: rec, posts: posts, hot: hot, ops: ops, total: total,
+				rnd: rand.New(rand.NewPCG(cfg.Seed, uint64(i)))}
+			w.run(runCtx)
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	if subscribed > 0 {
+		select {
+		case <-ctx.Done():
+		case <-time.After(DRAIN_TIMEOUT):
+		}
+	}
+	stopSubs()
+	return rec.report(elapsed, subscribed), nil
+}
+
+// weights returns operations repeated by their weights, so a random element follows the mix.
+func weights(mix map[string]int) ([]string, int, error) {
+	for name := range mix {
+		if !slices.Contains(opNames, name) {
+			return nil, 0, fmt.Errorf("%w: unknown operation %q", ErrClientData, name)
+		}
+	}
+	var ops []string
+	for _, name := range opNames {
+		for range mix[name] {
+			ops = append(ops, name)
+		}
+	}
+	return ops, len(ops), nil
+}
+
+func loadPosts(ctx context.Context, c *client) ([]string, error) {
+	var res []string
+	for after := 0; ; after += 100 {
+		var data struct {
+			Posts []struct {
+				ID string `json:"id"`
+			} `json:"posts"`
+		}
+		if err := c.do(ctx, `query($after: ID) { posts(count: 100, after: $after) { id } }`, map[string]any{"after": strconv.Itoa(after)}, &data); err != nil {
+			return nil, err
+		}
+		for _, p := range data.Posts {
+			res = append(res, p.ID)
+		}
+		if len(data.Posts) < 100 || len(res) >= 10000 {
+			return res, nil
+		}
+	}
+}
+
+// startSubscribers connects subscribers to hot posts and returns a number of them which have received a probe comment.
+func startSubscribers(ctx context.Context, c *client, hot []string, n int, rec *recorder) int {
+	if n < 1 {
+		return 0
+	}
+	// every subscriber sends to one of them once
+	probed := make(chan struct{}, n)
+	stopped := make(chan struct{}, n)
+	for i := 0; i < n; i++ {
+		go func(post string) {
+			var once sync.Once
+			err := c.subscribe(ctx, post, func() {
+				once.Do(func() { probed <- struct{}{} })
+			}, func(t time.Time) {
+				rec.delivered(time.Since(t))
+			})
+			once.Do(func() { stopped <- struct{}{} })
+			if err != nil {
+				log.Error().Err(err).Str("post id", post).Msg("subscriber stopped")
+			}
+		}(hot[i%len(hot)])
+	}
+	return waitProbes(ctx, c, hot[:min(n, len(hot))], n, probed, stopped)
+}
+
+// waitProbes writes probe comments to the posts until n subscribers have received one or have stopped.
+// The server registers a subscription after it is received, so the first probes may be missed.
+func waitProbes(ctx context.Context, c *client, posts []string, n int, probed, stopped <-chan struct{}) int {
+	timeout := time.NewTimer(PROBE_TIMEOUT)
+	defer timeout.Stop()
+	t := time.NewTicker(PROBE_INTERVAL)
+	defer t.Stop()
+	subscribed := 0
+	for left := n; left > 0; {
+		select {
+		case <-probed:
+			subscribed++
+			left--
+		case <-stopped:
+			left--
+		case <-t.C:
+			for _, post := range posts {
+				comment := map[string]any{"author": PROBE_AUTHOR, "text": "probe", "time": time.Now().UTC().Format(time.RFC3339Nano)}
+				if err := c.do(ctx, createCommentMutation, map[string]any{"postId": post, "comment": comment}, nil); err != nil {
+					log.Debug().Err(err).Str("post id", post).Msg("can not write probe comment")
+				}
+			}
+		case <-timeout.C:
+			log.Warn().Int("subscribers", subscribed).Int("waiting", left).Msg("subscribers have not received probe comments in time")
+			return subscribed
+		case <-ctx.Done():
+			return subscribed
+		}
+	}
+	return subscribed
+}
+
+type worker struct {
+	c     *client
+	rec   *recorder
+	posts []string
+	hot   []string
+	ops   []string
+	total int
+	rnd   *rand.Rand
+}
+
+func (w *worker) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		op := w.ops[w.rnd.IntN(w.total)]
+		start := time.Now()
+		err := w.do(ctx, op)
+		if ctx.Err() != nil {
+			// requests interrupted by the end of the test are not counted
+			return
+		}
+		if err != nil {
+			log.Debug().Err(err).Str("operation", op).Msg("operation failed")
+		}
+		w.rec.op(op, time.Since(start), err)
+	}
+}
+
+func (w *worker) do(ctx context.Context, op string) error {
+	switch op {
+	case OpPosts:
+		after := w.rnd.IntN(max(1, len(w.posts)/PAGE_SIZE)) * PAGE_SIZE
+		return w.c.do(ctx, postsQuery, map[string]any{"after": strconv.Itoa(after)}, nil)
+	case OpComments:
+		post := w.posts[w.rnd.IntN(len(w.posts))]
+		return w.comments(ctx, post, w.rnd.IntN(MAX_COMMENT_PAGE)+1)
+	case OpCreateComment:
+		post := w.hot[w.rnd.IntN(len(w.hot))]
+		comment := map[string]any{
+			"author": "loadtest",
+			"text":   "load test comment " + strconv.Itoa(w.rnd.Int()),
+			// subscribers measure delivery lag from this time
+			"time": time.Now().UTC().Format(time.RFC3339Nano),
+		}
+		return w.c.do(ctx, createCommentMutation, map[string]any{"postId": post, "comment": comment}, nil)
+	}
+	return fmt.Errorf("unknown operation %s", op)
+}
+
+// comments reads pages of comments of the post one by one until the last one.
+func (w *worker) comments(ctx context.Context, post string, pages int) error {
+	var after *string
+	for i := 0; i < pages; i++ {
+		var data struct {
+			Comments struct {
+				Comments []json.RawMessage `json:"comments"`
+				PageInfo struct {
+					EndCursor *string `json:"endCursor"`
+				} `json:"pageInfo"`
+			} `json:"comments"`
+		}
+		if err := w.c.do(ctx, commentsQuery, map[string]any{"postId": post, "after": after}, &data); err != nil {
+			return err
+		}
+		if len(data.Comments.Comments) < PAGE_SIZE {
+			return nil
+		}
+		after = data.Comments.PageInfo.EndCursor
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package loadtest_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/mi-raf/comment-project/graph"
+	"github.com/mi-raf/comment-project/internal/api"
+	"github.com/mi-raf/comment-project/internal/database"
+	"github.com/mi-raf/comment-project/internal/loadtest"
+	"github.com/mi-raf/comment-project/internal/seed"
+	"github.com/mi-raf/comment-project/internal/service"
+	"github.com/stretchr/testify/suite"
+)
+
+type LoadTestSuite struct {
+	suite.Suite
+	ctx    context.Context
+	cancel context.CancelFunc
+	srv    *httptest.Server
+}
+
+func (s *LoadTestSuite) SetupTest() {
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	p, _, err := database.NewPostRepositoryProvider(s.ctx, &database.PostConfig{InMemory: true})
+	s.NoError(err)
+	ch, closeCh := service.NewCommentChan()
+	c, _, err := database.NewCommentRepositoryProvider(s.ctx, &database.CommentConfig{InMemory: true, CommentChan: ch}, p)
+	s.NoError(err)
+	_, err = seed.Run(s.ctx, p, c, seed.Config{Posts: 20, CommentsPerPost: 10, MaxDepth: 3, Seed: 1})
+	s.NoError(err)
+	// comments of the seed are not interesting for subscribers
+	for len(ch) > 0 {
+		<-ch
+	}
+	ps, cleanup := service.NewPostService(s.ctx, p, c, &service.Config{CommentChan: ch})
+	srv := handler.NewDefaultServer(graph.NewExecutableSchema(graph.Config{
+		Resolvers:  api.NewResolver(ps, nil),
+		Directives: graph.DirectiveRoot{Admin: api.AdminDirective},
+	}))
+	s.srv = httptest.NewServer(srv)
+	s.T().Cleanup(func() {
+		s.cancel()
+		s.srv.Close()
+		cleanup()
+		closeCh()
+	})
+}
+
+func (s *LoadTestSuite) TestRun() {
+	// given
+	cfg := loadtest.Config{
+		URL:         s.srv.URL,
+		Duration:    300 * time.Millisecond,
+		Concurrency: 4,
+		Mix:         map[string]int{loadtest.OpPosts: 1, loadtest.OpComments: 1, loadtest.OpCreateComment: 1},
+		Subscribers: 3,
+		HotPosts:    2,
+		Seed:        1,
+	}
+
+	// when
+	r, err := loadtest.Run(s.ctx, cfg)
+
+	// then
+	s.NoError(err)
+	s.Equal(3, r.Subscribers)
+	for _, op := range []string{loadtest.OpPosts, loadtest.OpComments, loadtest.OpCreateComment} {
+		s.Require().Contains(r.Ops, op)
+		s.Positive(r.Ops[op].Count)
+		s.Zero(r.Ops[op].Errors)
+		s.Positive(r.Ops[op].Latency.P50)
+		s.LessOrEqual(r.Ops[op].Latency.P50, r.Ops[op].Latency.P99)
+	}
+	s.Positive(r.Delivered)
+	s.Equal(r.Delivered, r.Lag.Count)
+	s.Positive(r.Lag.Max)
+}
+
+func (s *LoadTestSuite) TestRunWithoutSubscribers() {
+	// given
+	cfg := loadtest.Config{
+		URL:         s.srv.URL,
+		Duration:    100 * time.Millisecond,
+		Concurrency: 2,
+		Mix:         map[string]int{loadtest.OpCreateComment: 1},
+		HotPosts:    1,
+		Seed:        1,
+	}
+
+	// when
+	start := time.Now()
+	r, err := loadtest.Run(s.ctx, cfg)
+
+	// then
+	s.NoError(err)
+	s.Zero(r.Subscribers)
+	s.Zero(r.Delivered)
+	s.Less(time.Since(start), loadtest.DRAIN_TIMEOUT)
+}
+
+func (s *LoadTestSuite) TestRunUnknownOperation() {
+	// when
+	_, err := loadtest.Run(s.ctx, loadtest.Config{URL: s.srv.URL, Concurrency: 1, Mix: map[string]int{"deletePost": 1}})
+
+	// then
+	s.ErrorIs(err, loadtest.ErrClientData)
+}
+
+func (s *LoadTestSuite) TestParseMix() {
+	// when
+	mix, err := loadtest.ParseMix("posts=5, comments=4,createComment=0")
+
+	// then
+	s.NoError(err)
+	s.Equal(map[string]int{"posts": 5, "comments": 4, "createComment": 0}, mix)
+}
+
+func (s *LoadTestSuite) TestParseMixIncorrect() {
+	for _, mix := range []string{"posts", "posts=x", "posts=-1"} {
+		// when
+		_, err := loadtest.ParseMix(mix)
+
+		// then
+		s.ErrorIs(err, loadtest.ErrClientData, mix)
+	}
+}
+
+func TestLoadTestSuite(t *testing.T) {
+	suite.Run(t, new(LoadTestSuite))
+}
@@ -0,0 +1,136 @@
+package loadtest
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// Summary describes a distribution of durations.
+	Summary struct {
+		Count int
+		Mean  time.Duration
+		P50   time.Duration
+		P90   time.Duration
+		P99   time.Duration
+		Max   time.Duration
+	}
+
+	OpStats struct {
+		Count   int
+		Errors  int
+		Latency Summary
+		// Throughput is a number of successful operations per second.
+		Throughput float64
+	}
+
+	Report struct {
+		Duration time.Duration
+		Ops      map[string]*OpStats
+		// Subscribers is a number of websocket clients which received the subscription acknowledgement.
+		Subscribers int
+		// Delivered is a number of comments received by subscribers.
+		Delivered int
+		// Lag is time between sending createComment and receiving the comment by a subscriber.
+		Lag Summary
+	}
+
+	// recorder collects durations of operations from concurrent workers.
+	recorder struct {
+		m      sync.Mutex
+		ok     map[string][]time.Duration
+		errors map[string]int
+		lag    []time.Duration
+	}
+)
+
+func newRecorder() *recorder {
+	return &recorder{ok: make(map[string][]time.Duration), errors: make(map[string]int)}
+}
+
+func (r *recorder) op(name string, d time.Duration, err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if err != nil {
+		r.errors[name]++
+		return
+	}
+	r.ok[name] = append(r.ok[name], d)
+}
+
+func (r *recorder) delivered(lag time.Duration) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.lag = append(r.lag, lag)
+}
+
+func (r *recorder) report(elapsed time.Duration, subscribers int) *Report {
+	r.m.Lock()
+	defer r.m.Unlock()
+	rep := &Report{Duration: elapsed, Ops: make(map[string]*OpStats), Subscribers: subscribers, Delivered: len(r.lag), Lag: summarize(r.lag)}
+	for _, name := range opNames {
+		ds := r.ok[name]
+		if len(ds) == 0 && r.errors[name] == 0 {
+			continue
+		}
+		rep.Ops[name] = &OpStats{
+			Count:      len(ds),
+			Errors:     r.errors[name],
+			Latency:    summarize(ds),
+			Throughput: float64(len(ds)) / elapsed.Seconds(),
+		}
+	}
+	return rep
+}
+
+func summarize(ds []time.Duration) Summary {
+	if len(ds) == 0 {
+		return Summary{}
+	}
+	s := slices.Clone(ds)
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	var sum time.Duration
+	for _, d := range s {
+		sum += d
+	}
+	return Summary{
+		Count: len(s),
+		Mean:  sum / time.Duration(len(s)),
+		P50:   percentile(s, 0.50),
+		P90:   percentile(s, 0.90),
+		P99:   percentile(s, 0.99),
+		Max:   s[len(s)-1],
+	}
+}
+
+// percentile returns the nearest rank percentile of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(p*float64(len(sorted))+0.5) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+// Write prints the report as a table.
+func (r *Report) Write(w io.Writer) {
+	fmt.Fprintf(w, "duration %s\n", r.Duration.Round(time.Millisecond))
+	fmt.Fprintf(w, "%-15s %8s %7s %9s %10s %10s %10s %10s\n", "operation", "count", "errors", "rps", "p50", "p90", "p99", "max")
+	for _, name := range opNames {
+		op, ok := r.Ops[name]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(w, "%-15s %8d %7d %9.1f %10s %10s %10s %10s\n", name, op.Count, op.Errors, op.Throughput,
+			round(op.Latency.P50), round(op.Latency.P90), round(op.Latency.P99), round(op.Latency.Max))
+	}
+	fmt.Fprintf(w, "subscribers %d, delivered %d comments\n", r.Subscribers, r.Delivered)
+	if r.Delivered > 0 {
+		fmt.Fprintf(w, "delivery lag p50 %s, p90 %s, p99 %s, max %s\n", round(r.Lag.P50), round(r.Lag.P90), round(r.Lag.P99), round(r.Lag.Max))
+	}
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// REQUEST_ID_HEADER is read from requests and set in responses.
+	REQUEST_ID_HEADER = "X-Request-ID"
+	// MAX_REQUEST_ID_LEN limits IDs of clients, longer or non-printable ones are replaced with generated ones.
+	MAX_REQUEST_ID_LEN = 128
+)
+
+type requestIDKey struct{}
+
+// Ctx returns the logger of the request, or the global one if the context has none.
+func Ctx(ctx context.Context) *zerolog.Logger {
+	if l := zerolog.Ctx(ctx); l.GetLevel() != zerolog.Disabled {
+		return l
+	}
+	return &log.Logger
+}
+
+// WithRequestID returns a context with the ID and a logger, which adds the ID and the trace ID to every line.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	lc := Ctx(ctx).With().Str("request id", id)
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		lc = lc.Str("trace id", sc.TraceID().String())
+	}
+	l := lc.Logger()
+	return l.WithContext(context.WithValue(ctx, requestIDKey{}, id))
+}
+
+// RequestID returns the ID of the request or an empty string.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID generates a random ID.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("can not read random bytes: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// Middleware takes the request ID from the header or generates one,
+// echoes it in the response and adds it with a logger to the request context.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(REQUEST_ID_HEADER)
+		if !ValidRequestID(id) {
+			id = NewRequestID()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, id)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
+
+// Redact hides user text in logs, only its length is kept.
+func Redact(text string) string {
+	return fmt.Sprintf("[redacted %d bytes]", len(text))
+}
+
+// ValidRequestID reports whether the ID of a client can be used, it must be short and printable.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > MAX_REQUEST_ID_LEN {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
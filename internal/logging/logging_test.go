@@ -0,0 +1,85 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mi-raf/comment-project/internal/logging"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/suite"
+)
+
+type LoggingTestSuite struct {
+	suite.Suite
+}
+
+func (s *LoggingTestSuite) TestMiddlewareKeepsRequestID() {
+	// given
+	var got string
+	h := logging.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = logging.RequestID(r.Context())
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(logging.REQUEST_ID_HEADER, "client-id")
+	rec := httptest.NewRecorder()
+
+	// when
+	h.ServeHTTP(rec, req)
+
+	// then
+	s.Equal("client-id", got)
+	s.Equal("client-id", rec.Header().Get(logging.REQUEST_ID_HEADER))
+}
+
+func (s *LoggingTestSuite) TestMiddlewareReplacesInvalidRequestID() {
+	for _, id := range []string{"", "with space", strings.Repeat("a", logging.MAX_REQUEST_ID_LEN+1)} {
+		// given
+		var got string
+		h := logging.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = logging.RequestID(r.Context())
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(logging.REQUEST_ID_HEADER, id)
+		rec := httptest.NewRecorder()
+
+		// when
+		h.ServeHTTP(rec, req)
+
+		// then
+		s.NotEqual(id, got)
+		s.True(logging.ValidRequestID(got))
+		s.Equal(got, rec.Header().Get(logging.REQUEST_ID_HEADER))
+	}
+}
+
+func (s *LoggingTestSuite) TestLoggerAddsRequestID() {
+	// given
+	var buf bytes.Buffer
+	ctx := zerolog.New(&buf).WithContext(context.Background())
+	ctx = logging.WithRequestID(ctx, "request-1")
+
+	// when
+	logging.Ctx(ctx).Info().Str("text", logging.Redact("secret text")).Msg("comment")
+
+	// then
+	s.Contains(buf.String(), `"request id":"request-1"`)
+	s.Contains(buf.String(), `"text":"[redacted 11 bytes]"`)
+	s.NotContains(buf.String(), "secret")
+}
+
+func (s *LoggingTestSuite) TestGlobalLoggerWithoutRequest() {
+	// when
+	l := logging.Ctx(context.Background())
+
+	// then
+	s.NotEqual(zerolog.Disabled, l.GetLevel())
+	s.Empty(logging.RequestID(context.Background()))
+}
+
+func TestLoggingTestSuite(t *testing.T) {
+	suite.Run(t, new(LoggingTestSuite))
+}
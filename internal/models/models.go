@@ -2,6 +2,28 @@ package models
 
 import "time"
 
+type PostOrder int
+
+const (
+	PostOrderTime PostOrder = iota
+	PostOrderLastActivity
+)
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryDead      DeliveryStatus = "dead"
+)
+
+// Event types of webhook deliveries.
+const (
+	EventPostCreated    = "post.created"
+	EventCommentCreated = "comment.created"
+	EventCommentUpdated = "comment.updated"
+)
+
 type (
 	PostDTO struct {
 		Id            int64
@@ -10,15 +32,74 @@ type (
 		Text          string
 		IsCommentable bool
 		Time          time.Time `fake:"{futuredate}"`
+		CommentCount  int64     `fake:"skip"`
+		LastCommentAt time.Time `fake:"skip"`
 	}
 
 	CommentDTO struct {
-		Id       int64
-		PostId   int64
-		ParentId int64
-		Author   string
-		Text     string
-		Time     time.Time `fake:"{futuredate}"`
-		Level    int
+		Id            int64
+		PostId        int64
+		ParentId      int64
+		Author        string
+		Text          string
+		Time          time.Time `fake:"{futuredate}"`
+		Level         int
+		Pinned        bool `fake:"skip"`
+		AuthorReplied bool `fake:"skip"`
+		// PinSection is set for comments of pinned subtrees, which are listed before the rest of the post.
+		PinSection bool `fake:"skip"`
+	}
+
+	// CommentCursor is a position in comments of a post, the zero cursor is the start.
+	CommentCursor struct {
+		// After is the id of the last read comment.
+		After int64
+		// Pins are pinned comments whose subtrees are already read, they keep their place in the order
+		// even when they are unpinned later, so no comment is read twice.
+		Pins []int64
+		// MorePins is set while pinned subtrees are read, then current pins which are not in Pins follow them.
+		MorePins bool
+	}
+
+	// PostTreeDTO is a post with all its comments ordered by id.
+	PostTreeDTO struct {
+		Post     PostDTO
+		Comments []*CommentDTO
+	}
+
+	ActivityDTO struct {
+		Count     int64
+		FirstSeen time.Time
+	}
+
+	WebhookDTO struct {
+		Id     int64
+		URL    string
+		Secret string
+		Events []string
+		Active bool
+		Time   time.Time
+	}
+
+	WebhookDeliveryDTO struct {
+		Id            int64
+		WebhookId     int64
+		Event         string
+		Payload       []byte
+		Status        DeliveryStatus
+		Attempts      int
+		NextAttemptAt time.Time
+		LastError     string
+		ResponseCode  int
+		Time          time.Time
+		DeliveredAt   time.Time
+		// URL and Secret of the webhook, filled only for claimed deliveries.
+		URL    string
+		Secret string
+	}
+
+	DeliveryFilter struct {
+		WebhookId int64
+		Status    DeliveryStatus
 	}
 )
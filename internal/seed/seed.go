@@ -0,0 +1,174 @@
+package seed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/mi-raf/comment-project/internal/database"
+	"github.com/mi-raf/comment-project/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// BATCH_COMMENTS is an approximate number of comments generated and stored at once.
+	BATCH_COMMENTS = 10000
+	// ROOT_SHARE is a probability of a comment to be a reply to the post, not to another comment.
+	ROOT_SHARE = 0.3
+
+	MAX_AUTHOR_LEN = 20
+	MAX_TITLE_LEN  = 100
+)
+
+// epoch is the time of the last generated post, so data does not depend on the current time.
+var epoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var ErrClientData = errors.New("incorrect seed parameters")
+
+type (
+	Config struct {
+		Posts           int
+		CommentsPerPost int
+		// MaxDepth limits nesting of comments, 1 means replies to posts only.
+		MaxDepth int
+		// Seed makes generated data the same for the same parameters.
+		Seed uint64
+	}
+
+	Stats struct {
+		Posts    int
+		Comments int
+		// Depth is the deepest level of generated comments.
+		Depth int
+	}
+
+	// node is a generated comment which refers to its parent by position in the post.
+	node struct {
+		c      *models.CommentDTO
+		parent int
+		depth  int
+	}
+
+	tree struct {
+		post  *models.PostDTO
+		nodes []node
+	}
+)
+
+// Run generates posts with comment trees and stores them with batches.
+// Replies are attached to popular comments more often, so trees have a few long discussions
+// and many short ones, like real threads.
+func Run(ctx context.Context, p database.PostRepository, c database.CommentRepository, cfg Config) (Stats, error) {
+	if cfg.Posts < 0 || cfg.CommentsPerPost < 0 || cfg.MaxDepth < 1 {
+		return Stats{}, fmt.Errorf("%w: posts %d, comments per post %d, max depth %d", ErrClientData, cfg.Posts, cfg.CommentsPerPost, cfg.MaxDepth)
+	}
+	f := gofakeit.New(cfg.Seed)
+	chunk := max(1, BATCH_COMMENTS/max(1, cfg.CommentsPerPost))
+
+	var st Stats
+	for done := 0; done < cfg.Posts; done += chunk {
+		if err := ctx.Err(); err != nil {
+			return st, err
+		}
+		trees := make([]*tree, 0, min(chunk, cfg.Posts-done))
+		for i := done; i < cfg.Posts && i < done+chunk; i++ {
+			trees = append(trees, generate(f, cfg, i))
+		}
+		if err := store(ctx, p, c, trees, &st); err != nil {
+			return st, err
+		}
+		log.Info().Int("posts", st.Posts).Int("comments", st.Comments).Msg("seed batch stored")
+	}
+	return st, nil
+}
+
+// store saves posts, then comments level by level, so ids of parents are known.
+func store(ctx context.Context, p database.PostRepository, c database.CommentRepository, trees []*tree, st *Stats) error {
+	posts := make([]*models.PostDTO, 0, len(trees))
+	for _, t := range trees {
+		posts = append(posts, t.post)
+	}
+	ids, err := p.AddBatch(ctx, posts)
+	if err != nil {
+		return err
+	}
+	st.Posts += len(ids)
+
+	for depth := 1; ; depth++ {
+		var level []*models.CommentDTO
+		for i, t := range trees {
+			for _, n := range t.nodes {
+				if n.depth != depth {
+					continue
+				}
+				n.c.PostId = ids[i]
+				if n.parent >= 0 {
+					n.c.ParentId = t.nodes[n.parent].c.Id
+				}
+				level = append(level, n.c)
+			}
+		}
+		if len(level) == 0 {
+			return nil
+		}
+		cids, err := c.AddBatch(ctx, level)
+		if err != nil {
+			return err
+		}
+		for i, id := range cids {
+			level[i].Id = id
+		}
+		st.Comments += len(cids)
+		st.Depth = max(st.Depth, depth)
+	}
+}
+
+func generate(f *gofakeit.Faker, cfg Config, i int) *tree {
+	// posts are spread over a year before epoch
+	step := 365 * 24 * time.Hour / time.Duration(max(cfg.Posts, 1))
+	t := epoch.Add(-time.Duration(cfg.Posts-i) * step)
+	post := &models.PostDTO{
+		Author:        truncate(f.Username(), MAX_AUTHOR_LEN),
+		Title:         truncate(f.Sentence(f.IntRange(2, 8)), MAX_TITLE_LEN),
+		Text:          f.Paragraph(f.IntRange(1, 4), f.IntRange(2, 6), f.IntRange(5, 15), "\n\n"),
+		IsCommentable: true,
+		Time:          t,
+	}
+
+	nodes := make([]node, 0, cfg.CommentsPerPost)
+	// every comment which can be replied has a ticket and gets one more for every reply,
+	// so a random ticket chooses popular comments more often
+	tickets := make([]int, 0, 2*cfg.CommentsPerPost)
+	for j := 0; j < cfg.CommentsPerPost; j++ {
+		t = t.Add(time.Duration(f.IntRange(1, 600)) * time.Second)
+		n := node{
+			c: &models.CommentDTO{
+				Author: truncate(f.Username(), MAX_AUTHOR_LEN),
+				Text:   f.Sentence(f.IntRange(3, 40)),
+				Time:   t,
+			},
+			parent: -1,
+			depth:  1,
+		}
+		if len(tickets) > 0 && f.Float64() >= ROOT_SHARE {
+			n.parent = tickets[f.IntN(len(tickets))]
+			n.depth = nodes[n.parent].depth + 1
+			tickets = append(tickets, n.parent)
+		}
+		if n.depth < cfg.MaxDepth {
+			tickets = append(tickets, j)
+		}
+		nodes = append(nodes, n)
+	}
+	return &tree{post: post, nodes: nodes}
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
@@ -0,0 +1,97 @@
+package seed_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mi-raf/comment-project/internal/database"
+	"github.com/mi-raf/comment-project/internal/models"
+	"github.com/mi-raf/comment-project/internal/seed"
+	"github.com/stretchr/testify/suite"
+)
+
+type SeedTestSuite struct {
+	suite.Suite
+	ctx context.Context
+}
+
+func (s *SeedTestSuite) SetupTest() {
+	s.ctx = context.Background()
+}
+
+func (s *SeedTestSuite) TestSeedCounts() {
+	// given
+	p, c := s.repositories()
+	cfg := seed.Config{Posts: 30, CommentsPerPost: 40, MaxDepth: 3, Seed: 7}
+
+	// when
+	st, err := seed.Run(s.ctx, p, c, cfg)
+
+	// then
+	s.NoError(err)
+	s.Equal(30, st.Posts)
+	s.Equal(30*40, st.Comments)
+	s.Equal(3, st.Depth)
+	posts, err := p.GetAll(s.ctx, 0, 100, models.PostOrderTime)
+	s.NoError(err)
+	s.Len(posts, 30)
+	for _, post := range posts {
+		s.Equal(int64(40), post.CommentCount)
+		comments, err := c.GetAllOfPost(s.ctx, post.Id, models.CommentCursor{}, 100)
+		s.NoError(err)
+		s.Len(comments, 40)
+		for _, cm := range comments {
+			s.LessOrEqual(cm.Level, 3)
+		}
+	}
+}
+
+func (s *SeedTestSuite) TestSeedDeterministic() {
+	// given
+	p1, c1 := s.repositories()
+	p2, c2 := s.repositories()
+	cfg := seed.Config{Posts: 5, CommentsPerPost: 20, MaxDepth: 4, Seed: 42}
+
+	// when
+	_, err := seed.Run(s.ctx, p1, c1, cfg)
+	s.NoError(err)
+	_, err = seed.Run(s.ctx, p2, c2, cfg)
+	s.NoError(err)
+
+	// then
+	posts1, err := p1.GetAll(s.ctx, 0, 10, models.PostOrderTime)
+	s.NoError(err)
+	posts2, err := p2.GetAll(s.ctx, 0, 10, models.PostOrderTime)
+	s.NoError(err)
+	s.Equal(posts1, posts2)
+	for _, post := range posts1 {
+		comments1, err := c1.GetAllOfPost(s.ctx, post.Id, models.CommentCursor{}, 100)
+		s.NoError(err)
+		comments2, err := c2.GetAllOfPost(s.ctx, post.Id, models.CommentCursor{}, 100)
+		s.NoError(err)
+		s.Equal(comments1, comments2)
+	}
+}
+
+func (s *SeedTestSuite) TestSeedIncorrectDepth() {
+	// given
+	p, c := s.repositories()
+
+	// when
+	_, err := seed.Run(s.ctx, p, c, seed.Config{Posts: 1, CommentsPerPost: 1, MaxDepth: 0})
+
+	// then
+	s.ErrorIs(err, seed.ErrClientData)
+}
+
+func (s *SeedTestSuite) repositories() (database.PostRepository, database.CommentRepository) {
+	p, _, err := database.NewPostRepositoryProvider(s.ctx, &database.PostConfig{InMemory: true})
+	s.NoError(err)
+	c, _, err := database.NewCommentRepositoryProvider(s.ctx, &database.CommentConfig{InMemory: true}, p)
+	s.NoError(err)
+	return p, c
+}
+
+func TestSeedTestSuite(t *testing.T) {
+	suite.Run(t, new(SeedTestSuite))
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/mi-raf/comment-project/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+// CACHE_PAGE is a size of cached first pages of comments, smaller pages are cut from them.
+const CACHE_PAGE = MAX_LIMIT
+
+type (
+	// CacheConfig sets the read-through cache of posts and first pages of their comments.
+	// Size is a number of posts and of pages kept in the cache, 0 disables it.
+	CacheConfig struct {
+		Size int
+		TTL  time.Duration
+	}
+
+	CacheCounters struct {
+		Hits    int64 `json:"hits"`
+		Misses  int64 `json:"misses"`
+		Entries int   `json:"entries"`
+	}
+
+	// CacheStats describes lookups of cached posts and pages of comments.
+	CacheStats struct {
+		Posts CacheCounters `json:"posts"`
+		Pages CacheCounters `json:"pages"`
+	}
+
+	countedLRU[V any] struct {
+		lru          *expirable.LRU[int64, V]
+		hits, misses atomic.Int64
+	}
+
+	// postCache keeps posts and first pages of their comments until a new comment of the post is received.
+	postCache struct {
+		posts *countedLRU[*models.PostDTO]
+		pages *countedLRU[[]*models.CommentDTO]
+		m     sync.Mutex
+		// gen is changed by every invalidation, so values loaded before it are not stored
+		gen atomic.Uint64
+	}
+)
+
+func newPostCache(cfg CacheConfig) *postCache {
+	if cfg.Size <= 0 {
+		return nil
+	}
+	log.Info().Int("size", cfg.Size).Dur("ttl", cfg.TTL).Msg("posts cache enabled")
+	return &postCache{
+		posts: &countedLRU[*models.PostDTO]{lru: expirable.NewLRU[int64, *models.PostDTO](cfg.Size, nil, cfg.TTL)},
+		pages: &countedLRU[[]*models.CommentDTO]{lru: expirable.NewLRU[int64, []*models.CommentDTO](cfg.Size, nil, cfg.TTL)},
+	}
+}
+
+func (c *countedLRU[V]) get(id int64) (V, bool) {
+	v, ok := c.lru.Get(id)
+	if ok {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	return v, ok
+}
+
+func (c *countedLRU[V]) counters() CacheCounters {
+	return CacheCounters{Hits: c.hits.Load(), Misses: c.misses.Load(), Entries: c.lru.Len()}
+}
+
+// store adds values loaded after the gen was read, unless the cache was invalidated since then.
+func (c *postCache) store(gen uint64, add func()) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.gen.Load() == gen {
+		add()
+	}
+}
+
+// invalidate removes the post and the page of its comments.
+func (c *postCache) invalidate(postId int64) {
+	if c == nil {
+		return
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.gen.Add(1)
+	c.posts.lru.Remove(postId)
+	c.pages.lru.Remove(postId)
+}
+
+// CacheStats returns counters of the posts cache, it reports false when the cache is disabled.
+func (ps *PostService) CacheStats() (CacheStats, bool) {
+	if ps.cache == nil {
+		return CacheStats{}, false
+	}
+	return CacheStats{Posts: ps.cache.posts.counters(), Pages: ps.cache.pages.counters()}, true
+}
+
+// getPost returns the post from the cache or loads it with the repository.
+func (ps *PostService) getPost(ctx context.Context, id int64) (*models.PostDTO, error) {
+	if ps.cache == nil {
+		return ps.p.Get(ctx, id)
+	}
+	if p, ok := ps.cache.posts.get(id); ok {
+		return p, nil
+	}
+	gen := ps.cache.gen.Load()
+	p, err := ps.p.Get(ctx, id)
+	if err != nil || p == nil {
+		return p, err
+	}
+	ps.cache.store(gen, func() { ps.cache.posts.lru.Add(id, p) })
+	return p, nil
+}
+
+// getPosts is getPost for several posts, which loads missed ones with one query.
+func (ps *PostService) getPosts(ctx context.Context, ids []int64) ([]*models.PostDTO, error) {
+	if ps.cache == nil {
+		return ps.p.GetManyByIDs(ctx, ids)
+	}
+	res := make([]*models.PostDTO, 0, len(ids))
+	var missed []int64
+	for _, id := range ids {
+		if p, ok := ps.cache.posts.get(id); ok {
+			res = append(res, p)
+		} else {
+			missed = append(missed, id)
+		}
+	}
+	if len(missed) == 0 {
+		return res, nil
+	}
+	gen := ps.cache.gen.Load()
+	posts, err := ps.p.GetManyByIDs(ctx, missed)
+	if err != nil {
+		return nil, err
+	}
+	ps.cache.store(gen, func() {
+		for _, p := range posts {
+			ps.cache.posts.lru.Add(p.Id, p)
+		}
+	})
+	return append(res, posts...), nil
+}
+
+// firstPage returns the first limit comments of the post, the page is cut from the cached one.
+func (ps *PostService) firstPage(ctx context.Context, id int64, limit int) ([]*models.CommentDTO, error) {
+	if ps.cache == nil {
+		return ps.c.GetAllOfPost(ctx, id, models.CommentCursor{}, limit)
+	}
+	page, ok := ps.cache.pages.get(id)
+	if !ok {
+		gen := ps.cache.gen.Load()
+		var err error
+		page, err = ps.c.GetAllOfPost(ctx, id, models.CommentCursor{}, CACHE_PAGE)
+		if err != nil {
+			return nil, err
+		}
+		ps.cache.store(gen, func() { ps.cache.pages.lru.Add(id, page) })
+	}
+	return page[:min(limit, len(page))], nil
+}
+
+// firstPages is firstPage for several posts, which loads missed pages with one query.
+func (ps *PostService) firstPages(ctx context.Context, ids []int64, limit int) (map[int64][]*models.CommentDTO, error) {
+	if ps.cache == nil {
+		return ps.c.GetAllOfPosts(ctx, ids, limit)
+	}
+	res := make(map[int64][]*models.CommentDTO, len(ids))
+	var missed []int64
+	for _, id := range ids {
+		if page, ok := ps.cache.pages.get(id); ok {
+			res[id] = page
+		} else {
+			missed = append(missed, id)
+		}
+	}
+	if len(missed) > 0 {
+		gen := ps.cache.gen.Load()
+		pages, err := ps.c.GetAllOfPosts(ctx, missed, CACHE_PAGE)
+		if err != nil {
+			return nil, err
+		}
+		ps.cache.store(gen, func() {
+			// posts without comments are cached as well
+			for _, id := range missed {
+				ps.cache.pages.lru.Add(id, pages[id])
+			}
+		})
+		for id, page := range pages {
+			res[id] = page
+		}
+	}
+	for id, page := range res {
+		res[id] = page[:min(limit, len(page))]
+	}
+	return res, nil
+}
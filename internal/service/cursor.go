@@ -0,0 +1,74 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mi-raf/comment-project/internal/models"
+)
+
+// parseCommentCursor parses the end cursor of a comments page, the empty cursor is the start.
+// The cursor is the id of the last read comment, which is followed by pinned comments
+// of already read subtrees after ':' and '+' while pinned subtrees are read, e.g. "42:7,3+".
+func parseCommentCursor(s string) (models.CommentCursor, error) {
+	var res models.CommentCursor
+	if s == "" {
+		return res, nil
+	}
+	after, pins, hasPins := strings.Cut(s, ":")
+	id, err := strconv.ParseInt(after, 10, 64)
+	if err != nil || id <= 0 {
+		return res, fmt.Errorf("%w: incorrect cursor %q", ErrClientData, s)
+	}
+	res.After = id
+	if !hasPins {
+		return res, nil
+	}
+	pins, res.MorePins = strings.CutSuffix(pins, "+")
+	if pins == "" {
+		return res, nil
+	}
+	for _, p := range strings.Split(pins, ",") {
+		pin, err := strconv.ParseInt(p, 10, 64)
+		if err != nil || pin <= 0 {
+			return res, fmt.Errorf("%w: incorrect cursor %q", ErrClientData, s)
+		}
+		res.Pins = append(res.Pins, pin)
+	}
+	return res, nil
+}
+
+func formatCommentCursor(c models.CommentCursor) string {
+	res := fmti64(c.After)
+	if len(c.Pins) == 0 && !c.MorePins {
+		return res
+	}
+	var b strings.Builder
+	b.WriteString(res)
+	b.WriteByte(':')
+	for i, p := range c.Pins {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(fmti64(p))
+	}
+	if c.MorePins {
+		b.WriteByte('+')
+	}
+	return b.String()
+}
+
+// nextCommentCursor returns the cursor after the page read from the cursor after, pinned comments
+// of the page are remembered so their subtrees keep the place if they are unpinned.
+func nextCommentCursor(after models.CommentCursor, page []*models.CommentDTO) models.CommentCursor {
+	last := page[len(page)-1]
+	res := models.CommentCursor{After: last.Id, Pins: slices.Clip(after.Pins), MorePins: last.PinSection}
+	for _, c := range page {
+		if c.Pinned && c.PinSection && !slices.Contains(res.Pins, c.Id) {
+			res.Pins = append(res.Pins, c.Id)
+		}
+	}
+	return res
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mi-raf/comment-project/internal/database"
+)
+
+const (
+	CHECK_OK     = "OK"
+	CHECK_FAILED = "FAILED"
+)
+
+var (
+	ErrSchemaVersion = errors.New("database schema is older than the binary")
+	ErrListener      = errors.New("comment notifications listener is not connected")
+	ErrHubStopped    = errors.New("subscription hub is stopped")
+)
+
+type (
+	// CheckResult is a result of one readiness check.
+	CheckResult struct {
+		Status    string  `json:"status"`
+		LatencyMs float64 `json:"latencyMs"`
+		Error     string  `json:"error,omitempty"`
+		Details   any     `json:"details,omitempty"`
+	}
+
+	SchemaStatus struct {
+		Version  uint `json:"version"`
+		Expected uint `json:"expected"`
+		Dirty    bool `json:"dirty"`
+	}
+
+	// HubStatus describes delivery of new comments to subscribers.
+	HubStatus struct {
+		Running       bool `json:"running"`
+		Subscribers   int  `json:"subscribers"`
+		Queued        int  `json:"queued"`
+		QueueCapacity int  `json:"queueCapacity"`
+	}
+
+	check func(ctx context.Context) (any, error)
+)
+
+// Readiness runs checks of the database, its schema, the notifications listener and the subscription hub concurrently.
+// Checks of dependencies which are not used, e.g. of the database with in memory storage, are skipped.
+func (ps *PostService) Readiness(ctx context.Context) map[string]CheckResult {
+	checks := map[string]check{"hub": ps.checkHub}
+	if pingers := ps.pingers(); len(pingers) > 0 {
+		checks["database"] = func(ctx context.Context) (any, error) {
+			for _, p := range pingers {
+				if err := p.Ping(ctx); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		}
+	}
+	if r, ok := ps.p.(database.SchemaReporter); ok && ps.schemaVersion > 0 {
+		checks["migrations"] = func(ctx context.Context) (any, error) {
+			return ps.checkSchema(ctx, r)
+		}
+	}
+	if _, ok := ps.ListenerStatus(); ok {
+		checks["listener"] = ps.checkListener
+	}
+
+	res := make(map[string]CheckResult, len(checks))
+	var m sync.Mutex
+	var wg sync.WaitGroup
+	for name, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			details, err := c(ctx)
+			r := CheckResult{Status: CHECK_OK, LatencyMs: float64(time.Since(start).Microseconds()) / 1000, Details: details}
+			if err != nil {
+				r.Status = CHECK_FAILED
+				r.Error = err.Error()
+			}
+			m.Lock()
+			res[name] = r
+			m.Unlock()
+		}()
+	}
+	wg.Wait()
+	return res
+}
+
+func (ps *PostService) pingers() []database.Pinger {
+	var res []database.Pinger
+	for _, r := range []any{ps.p, ps.c} {
+		if p, ok := r.(database.Pinger); ok {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// checkSchema fails on a dirty schema or a schema older than the binary. A newer schema is accepted,
+// so instances of the previous release stay ready while a new release migrates the database during a rolling update.
+func (ps *PostService) checkSchema(ctx context.Context, r database.SchemaReporter) (any, error) {
+	v, dirty, err := r.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	st := SchemaStatus{Version: v, Expected: ps.schemaVersion, Dirty: dirty}
+	if v < ps.schemaVersion || dirty {
+		return st, fmt.Errorf("%w: version %d (dirty %t), expected at least %d", ErrSchemaVersion, v, dirty, ps.schemaVersion)
+	}
+	return st, nil
+}
+
+func (ps *PostService) checkListener(ctx context.Context) (any, error) {
+	st, _ := ps.ListenerStatus()
+	if !st.Running || !st.Connected {
+		return st, ErrListener
+	}
+	return st, nil
+}
+
+func (ps *PostService) checkHub(ctx context.Context) (any, error) {
+	st := HubStatus{Running: ps.hubRunning.Load(), Queued: len(ps.ch), QueueCapacity: cap(ps.ch)}
+	ps.lock.RLock()
+	for _, subs := range ps.pc {
+		st.Subscribers += len(subs)
+	}
+	ps.lock.RUnlock()
+	if !st.Running {
+		return st, ErrHubStopped
+	}
+	return st, nil
+}
@@ -6,32 +6,95 @@ import (
 	"fmt"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/mi-raf/comment-project/graph/model"
 	"github.com/mi-raf/comment-project/internal/database"
+	"github.com/mi-raf/comment-project/internal/logging"
 	"github.com/mi-raf/comment-project/internal/models"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	MAX_LIMIT    = 100
 	CHANELS_SIZE = 10
+	// MAX_AUTHOR_LEN and MAX_COMMENT_LEN are limits of the database schema in characters.
+	MAX_AUTHOR_LEN  = 20
+	MAX_COMMENT_LEN = 2000
 )
 
+var tracer = otel.Tracer("github.com/mi-raf/comment-project/internal/service")
+
 var ErrDatabase = errors.New("errors while loading or saving data")
 var ErrClientData = errors.New("incorrect client data")
+var ErrPostNotFound = fmt.Errorf("%w: no such post", ErrClientData)
 
 type (
 	PostService struct {
-		lock sync.RWMutex
-		pc   map[int64]chan *model.CommentConnection
-		ch   <-chan models.CommentDTO
-		p    database.PostRepository
-		c    database.CommentRepository
+		lock    sync.RWMutex
+		pc      map[int64]map[chan *model.CommentConnection]struct{}
+		ch      <-chan models.CommentDTO
+		p       database.PostRepository
+		c       database.CommentRepository
+		maxPins int
+		events  EventPublisher
+		cache   *postCache
+		dropped atomic.Int64
+		// hubRunning is true while comments are delivered to subscribers.
+		hubRunning    atomic.Bool
+		schemaVersion uint
+		// closed is set on shutdown, new subscriptions are completed at once
+		closed bool
+		// updates receives ids of posts whose comments are changed by any instance, it is nil for in memory storage
+		updates <-chan int64
+		// postEvents and commentEvents are set when repositories enqueue events in the transactions which store entities
+		postEvents    bool
+		commentEvents bool
 	}
 
 	Config struct {
 		CommentChan <-chan models.CommentDTO
+		MaxPins     int
+		Events      EventPublisher
+		Cache       CacheConfig
+		// SchemaVersion is the version of migrations expected by the binary, zero skips its readiness check.
+		SchemaVersion uint
+	}
+
+	// EventPublisher receives events about created posts and comments, e.g. for webhooks.
+	EventPublisher interface {
+		Publish(ctx context.Context, event string, data any) error
+	}
+
+	// EventEncoder is an EventPublisher whose events can be enqueued by repositories in their transactions.
+	EventEncoder interface {
+		Encode(event string, data any) ([]byte, error)
+		// Wake starts delivery of events enqueued by repositories.
+		Wake()
+	}
+
+	// SubscriptionStats describes delivery of new comments to subscribers.
+	SubscriptionStats struct {
+		// Subscribers holds a number of subscribers of every post with subscribers.
+		Subscribers map[int64]int
+		// Queued is a number of received comments waiting for delivery, up to QueueCapacity.
+		Queued        int
+		QueueCapacity int
+		// Dropped is a number of comments which were not sent to slow subscribers, their subscriptions are closed.
+		Dropped int64
+	}
+
+	CommentEvent struct {
+		ID       string         `json:"id"`
+		PostID   string         `json:"postId"`
+		ParentID *string        `json:"parentId,omitempty"`
+		Comment  *model.Comment `json:"comment"`
 	}
 )
 
@@ -43,80 +106,218 @@ func NewCommentChan() (chan models.CommentDTO, func()) {
 }
 
 func NewPostService(ctx context.Context, p database.PostRepository, c database.CommentRepository, cfg *Config) (*PostService, func()) {
-	ps := &PostService{pc: make(map[int64]chan *model.CommentConnection), ch: cfg.CommentChan, p: p, c: c}
+	ps := &PostService{pc: make(map[int64]map[chan *model.CommentConnection]struct{}), ch: cfg.CommentChan, p: p, c: c, maxPins: cfg.MaxPins, events: cfg.Events, cache: newPostCache(cfg.Cache), schemaVersion: cfg.SchemaVersion}
+	if n, ok := c.(database.UpdateNotifier); ok {
+		ps.updates = n.Updates()
+	}
+	if e, ok := cfg.Events.(EventEncoder); ok {
+		if w, ok := p.(database.EventWriter); ok {
+			w.WriteEvents(encodeEvent(e))
+			ps.postEvents = true
+		}
+		if w, ok := c.(database.EventWriter); ok {
+			w.WriteEvents(encodeEvent(e))
+			ps.commentEvents = true
+		}
+	}
+	ps.hubRunning.Store(true)
 	go ps.ListenComments(ctx)
-	return ps, func() {
-		for _, ch := range ps.pc {
+	return ps, ps.CloseSubscriptions
+}
+
+// CloseSubscriptions closes channels of all subscribers on shutdown, so their subscriptions complete.
+// Channels of new subscribers are closed at once.
+func (ps *PostService) CloseSubscriptions() {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	if ps.closed {
+		return
+	}
+	ps.closed = true
+	n := 0
+	for id, subs := range ps.pc {
+		for ch := range subs {
 			close(ch)
+			n++
 		}
+		delete(ps.pc, id)
 	}
+	log.Info().Int("subscriptions", n).Msg("subscriptions closed")
 }
 
-func (ps *PostService) GetAllPosts(ctx context.Context, offset int64, limit int) ([]*model.ShortPost, error) {
-	log.Debug().Int64("offset", offset).Int("limit", limit).Msg("get all posts")
-	if offset < 0 {
-		log.Error().Int64("after", offset).Msg("incorrect after")
-		return nil, fmt.Errorf("after %d should be more than 0", offset)
+// ListenerStatus returns health of the comment notifications listener.
+// It reports false when comments are not received from the database, e.g. in memory.
+func (ps *PostService) ListenerStatus() (database.ListenerStatus, bool) {
+	l, ok := ps.c.(database.ListenerReporter)
+	if !ok {
+		return database.ListenerStatus{}, false
+	}
+	return l.ListenerStatus(), true
+}
+
+// SubscriptionStats returns numbers of subscribers and of comments waiting for them.
+func (ps *PostService) SubscriptionStats() SubscriptionStats {
+	ps.lock.RLock()
+	subs := make(map[int64]int, len(ps.pc))
+	for id, s := range ps.pc {
+		subs[id] = len(s)
 	}
-	posts, err := ps.p.GetAll(ctx, offset, getLimit(limit))
+	ps.lock.RUnlock()
+	return SubscriptionStats{Subscribers: subs, Queued: len(ps.ch), QueueCapacity: cap(ps.ch), Dropped: ps.dropped.Load()}
+}
+
+// PoolStats returns stats of database pools by repository, it is empty when data is stored in memory.
+func (ps *PostService) PoolStats() map[string]database.PoolStats {
+	res := make(map[string]database.PoolStats, 2)
+	if p, ok := ps.p.(database.PoolReporter); ok {
+		res["posts"] = p.PoolStats()
+	}
+	if c, ok := ps.c.(database.PoolReporter); ok {
+		res["comments"] = c.PoolStats()
+	}
+	return res
+}
+
+func (ps *PostService) GetAllPosts(ctx context.Context, offset int64, limit int, order model.PostOrder) (_ []*model.ShortPost, err error) {
+	ctx, span := startSpan(ctx, "GetAllPosts", attribute.Int64("offset", offset), attribute.Int("limit", limit))
+	defer endSpan(span, &err)
+	logging.Ctx(ctx).Debug().Int64("offset", offset).Int("limit", limit).Str("order", order.String()).Msg("get all posts")
+	o := models.PostOrderTime
+	if order == model.PostOrderLastActivity {
+		o = models.PostOrderLastActivity
+	}
+	posts, err := ps.loadPosts(ctx, offset, limit, o)
 	if err != nil {
-		log.Error().Err(err).Msg("error while loading all posts")
-		return nil, ErrDatabase
+		return nil, err
 	}
-	log.Debug().Int("result size", len(posts)).Msg("posts returned")
-	var res []*model.ShortPost
+	return mapShortPosts(posts), nil
+}
+
+// LatestPosts returns posts in the order of time with their text but without comments, e.g. for feeds.
+func (ps *PostService) LatestPosts(ctx context.Context, offset int64, limit int) (_ []*model.Post, err error) {
+	ctx, span := startSpan(ctx, "LatestPosts", attribute.Int64("offset", offset), attribute.Int("limit", limit))
+	defer endSpan(span, &err)
+	logging.Ctx(ctx).Debug().Int64("offset", offset).Int("limit", limit).Msg("get latest posts")
+	posts, err := ps.loadPosts(ctx, offset, limit, models.PostOrderTime)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*model.Post, 0, len(posts))
 	for _, p := range posts {
-		res = append(res, &model.ShortPost{
-			Author: p.Author,
-			ID:     fmti64(p.Id),
-			Title:  p.Title,
-		})
+		res = append(res, mapPost(p))
 	}
 	return res, nil
 }
 
-func (ps *PostService) CommentSubscribe(postId int64) <-chan *model.CommentConnection {
+func (ps *PostService) loadPosts(ctx context.Context, offset int64, limit int, order models.PostOrder) ([]*models.PostDTO, error) {
+	if offset < 0 {
+		logging.Ctx(ctx).Error().Int64("after", offset).Msg("incorrect after")
+		return nil, fmt.Errorf("%w: after %d should be more than 0", ErrClientData, offset)
+	}
+	posts, err := ps.p.GetAll(ctx, offset, getLimit(limit), order)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("error while loading all posts")
+		return nil, ErrDatabase
+	}
+	logging.Ctx(ctx).Debug().Int("result size", len(posts)).Msg("posts returned")
+	return posts, nil
+}
+
+// CommentSubscribe registers a subscriber for new comments of the post.
+// Every subscriber gets its own channel, which is closed when ctx is done, on shutdown
+// or when the subscriber does not read comments and its buffer of CHANELS_SIZE is full.
+func (ps *PostService) CommentSubscribe(ctx context.Context, postId int64) <-chan *model.CommentConnection {
+	_, span := startSpan(ctx, "CommentSubscribe", attribute.Int64("post.id", postId))
+	defer span.End()
+	ch := make(chan *model.CommentConnection, CHANELS_SIZE)
 	ps.lock.Lock()
-	defer ps.lock.Unlock()
-	if ch, ok := ps.pc[postId]; ok {
-		log.Debug().Int64("post id", postId).Msg("return existing")
+	if ps.closed {
+		ps.lock.Unlock()
+		close(ch)
 		return ch
 	}
-	ch := make(chan *model.CommentConnection, CHANELS_SIZE)
-	ps.pc[postId] = ch
-	log.Debug().Int64("post id", postId).Msg("return created")
+	subs, ok := ps.pc[postId]
+	if !ok {
+		subs = make(map[chan *model.CommentConnection]struct{})
+		ps.pc[postId] = subs
+	}
+	subs[ch] = struct{}{}
+	n := len(subs)
+	ps.lock.Unlock()
+	logging.Ctx(ctx).Debug().Int64("post id", postId).Int("subscribers", n).Msg("subscribed to comments")
+
+	go func() {
+		<-ctx.Done()
+		ps.unsubscribe(postId, ch)
+	}()
 	return ch
 }
 
+func (ps *PostService) unsubscribe(postId int64, ch chan *model.CommentConnection) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	subs, ok := ps.pc[postId]
+	if !ok {
+		return
+	}
+	if _, ok := subs[ch]; !ok {
+		return
+	}
+	delete(subs, ch)
+	close(ch)
+	if len(subs) == 0 {
+		delete(ps.pc, postId)
+	}
+	log.Debug().Int64("post id", postId).Msg("unsubscribed from comments")
+}
+
 func (ps *PostService) ListenComments(ctx context.Context) {
+	defer ps.hubRunning.Store(false)
 	for {
 		select {
 		case c, ok := <-ps.ch:
 			if !ok {
 				return
 			}
-			log.Debug().Int64("id", c.Id).Msg("new comment recevied")
+			logging.Ctx(ctx).Debug().Int64("id", c.Id).Msg("new comment recevied")
 			pId := c.PostId
+			ps.cache.invalidate(pId)
+			var lagging []chan *model.CommentConnection
 			ps.lock.RLock()
-			if ch, ok := ps.pc[pId]; ok {
-				ch <- &model.CommentConnection{
+			for ch := range ps.pc[pId] {
+				select {
+				case ch <- &model.CommentConnection{
 					Comment:  &model.Comment{Author: c.Author, Text: c.Text, Time: c.Time},
 					ID:       fmti64(c.Id),
 					ParentID: getNullableString(c.ParentId),
 					Level:    c.Level,
 					PostID:   fmti64(c.PostId),
+				}:
+					logging.Ctx(ctx).Debug().Int64("post id", c.Id).Msg("comment sent to channel")
+				default:
+					ps.dropped.Add(1)
+					lagging = append(lagging, ch)
 				}
-				log.Debug().Int64("post id", c.Id).Msg("comment sent to channel")
 			}
 			ps.lock.RUnlock()
+			// the subscription of a slow subscriber is closed, so it notices the lost comment and can subscribe again
+			for _, ch := range lagging {
+				logging.Ctx(ctx).Warn().Int64("post id", pId).Int64("comment id", c.Id).Msg("subscriber is too slow, comment dropped and subscription closed")
+				ps.unsubscribe(pId, ch)
+			}
+		case pId := <-ps.updates:
+			logging.Ctx(ctx).Debug().Int64("post id", pId).Msg("comments of post updated")
+			ps.cache.invalidate(pId)
 		case <-ctx.Done():
-			log.Info().Msg("context closed")
+			logging.Ctx(ctx).Info().Msg("context closed")
 			return
 		}
 	}
 }
 
-func (ps *PostService) CreatePost(ctx context.Context, input model.NewPost) (*model.Post, error) {
+func (ps *PostService) CreatePost(ctx context.Context, input model.NewPost) (_ *model.Post, err error) {
+	ctx, span := startSpan(ctx, "CreatePost")
+	defer endSpan(span, &err)
 	ic := true
 	if input.IsCommentable != nil {
 		ic = *input.IsCommentable
@@ -130,82 +331,315 @@ func (ps *PostService) CreatePost(ctx context.Context, input model.NewPost) (*mo
 	}
 	id, err := ps.p.Add(ctx, p)
 	if err != nil {
-		log.Error().Err(err).Str("title", p.Title).Msg("error while creating post")
+		logging.Ctx(ctx).Error().Err(err).Str("title", p.Title).Msg("error while creating post")
 		return nil, ErrDatabase
 	}
-	log.Debug().Int64("id", id).Msg("post created")
-	return &model.Post{
-		ID:            fmti64(id),
-		Author:        input.Author,
-		Text:          input.Text,
-		Title:         input.Title,
-		IsCommentable: ic,
-		Time:          input.Time,
-	}, nil
+	logging.Ctx(ctx).Debug().Int64("id", id).Msg("post created")
+	p.Id = id
+	res := mapPost(p)
+	ps.publish(ctx, models.EventPostCreated, res, ps.postEvents)
+	return res, nil
 }
 
-func (ps *PostService) CreateComment(ctx context.Context, postId int64, pc int64, comment model.NewComment) (int64, error) {
-	log.Debug().Int64("post id", postId).Int64("parent comment id", pc).Msg("creating new comment")
+func (ps *PostService) CreateComment(ctx context.Context, postId int64, pc int64, comment model.NewComment) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "CreateComment", attribute.Int64("post.id", postId))
+	defer endSpan(span, &err)
+	logging.Ctx(ctx).Debug().Int64("post id", postId).Int64("parent comment id", pc).Msg("creating new comment")
+	if n := utf8.RuneCountInString(comment.Author); n > MAX_AUTHOR_LEN {
+		return 0, fmt.Errorf("%w: author has %d characters, the limit is %d", ErrClientData, n, MAX_AUTHOR_LEN)
+	}
+	if n := utf8.RuneCountInString(comment.Text); n > MAX_COMMENT_LEN {
+		return 0, fmt.Errorf("%w: text has %d characters, the limit is %d", ErrClientData, n, MAX_COMMENT_LEN)
+	}
 	ic, err := ps.p.IsCommentable(ctx, postId)
 	if err != nil {
-		log.Error().Err(err).Int64("post id", postId).Msg("error while getting info about commentable")
+		logging.Ctx(ctx).Error().Err(err).Int64("post id", postId).Msg("error while getting info about commentable")
 		return 0, ErrDatabase
 	}
 	if !ic {
-		log.Debug().Int64("post id", postId).Msg("post is not commentable")
-		return 0, fmt.Errorf("can not comment post: %d", postId)
+		// a missing post is not commentable either
+		post, err := ps.p.Get(ctx, postId)
+		if err != nil {
+			logging.Ctx(ctx).Error().Err(err).Int64("post id", postId).Msg("error while getting info about post")
+			return 0, ErrDatabase
+		}
+		if post == nil {
+			return 0, fmt.Errorf("%w: %d", ErrPostNotFound, postId)
+		}
+		logging.Ctx(ctx).Debug().Int64("post id", postId).Msg("post is not commentable")
+		return 0, fmt.Errorf("%w: can not comment post: %d", ErrClientData, postId)
 	}
-	id, err := ps.c.Add(ctx, &models.CommentDTO{PostId: postId, ParentId: pc, Author: comment.Author, Text: comment.Text, Time: comment.Time})
+	c := &models.CommentDTO{PostId: postId, ParentId: pc, Author: comment.Author, Text: comment.Text, Time: comment.Time}
+	id, err := ps.c.Add(ctx, c)
 	if err != nil {
-		log.Error().Err(err).Int64("post id", postId).Str("author", comment.Author).Str("text", comment.Text).Msg("error while saving comment info")
+		logging.Ctx(ctx).Error().Err(err).Int64("post id", postId).Str("author", comment.Author).Str("text", logging.Redact(comment.Text)).Msg("error while saving comment info")
 		return 0, ErrDatabase
 	}
-	log.Debug().Int64("post id", postId).Int64("comment id", id).Msg("comment created")
+	logging.Ctx(ctx).Debug().Int64("post id", postId).Int64("comment id", id).Msg("comment created")
+	// other instances invalidate the post when they receive the comment
+	ps.cache.invalidate(postId)
+	c.Id = id
+	ps.publish(ctx, models.EventCommentCreated, mapCommentEvent(c), ps.commentEvents)
 	return id, nil
 }
 
-func (ps *PostService) Post(ctx context.Context, id int64, limit int) (*model.Post, error) {
-	log.Debug().Int64("post id", id).Msg("getting info about post")
-	post, err := ps.p.Get(ctx, id)
+func (ps *PostService) Post(ctx context.Context, id int64, limit int) (_ *model.Post, err error) {
+	ctx, span := startSpan(ctx, "Post", attribute.Int64("post.id", id))
+	defer endSpan(span, &err)
+	logging.Ctx(ctx).Debug().Int64("post id", id).Msg("getting info about post")
+	post, err := ps.getPost(ctx, id)
 	if err != nil {
-		log.Error().Err(err).Int64("post id", id).Msg("error while getting info about post")
+		logging.Ctx(ctx).Error().Err(err).Int64("post id", id).Msg("error while getting info about post")
 		return nil, ErrDatabase
 	}
 	if post == nil {
 		return nil, nil
 	}
 	limit = getLimit(limit)
-	log.Debug().Int64("post id", id).Int("limit", limit).Msg("getting info about comments of post")
-	cs, err := ps.c.GetAllOfPost(ctx, id, 0, limit)
+	logging.Ctx(ctx).Debug().Int64("post id", id).Int("limit", limit).Msg("getting info about comments of post")
+	cs, err := ps.firstPage(ctx, id, limit)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Int64("post id", id).Msg("error while getting comments for post in getting post")
+		return nil, ErrDatabase
+	}
+	logging.Ctx(ctx).Debug().Int64("post id", id).Int("result size", len(cs)).Msg("result comments of post info")
+	res := mapPost(post)
+	res.Comments = mapCommentPage(cs, models.CommentCursor{})
+	return res, nil
+
+}
+
+// PostsByIDs returns posts without comments, posts which do not exist are missing in the result.
+func (ps *PostService) PostsByIDs(ctx context.Context, ids []int64) (_ map[int64]*model.Post, err error) {
+	ctx, span := startSpan(ctx, "PostsByIDs", attribute.Int("posts", len(ids)))
+	defer endSpan(span, &err)
+	logging.Ctx(ctx).Debug().Ints64("post ids", ids).Msg("getting posts by ids")
+	posts, err := ps.getPosts(ctx, ids)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Ints64("post ids", ids).Msg("error while getting posts by ids")
+		return nil, ErrDatabase
+	}
+	res := make(map[int64]*model.Post, len(posts))
+	for _, p := range posts {
+		res[p.Id] = mapPost(p)
+	}
+	return res, nil
+}
+
+// CommentsByIDs returns comments by their ids, comments which do not exist are missing in the result.
+func (ps *PostService) CommentsByIDs(ctx context.Context, ids []int64) (_ map[int64]*model.CommentConnection, err error) {
+	ctx, span := startSpan(ctx, "CommentsByIDs", attribute.Int("comments", len(ids)))
+	defer endSpan(span, &err)
+	logging.Ctx(ctx).Debug().Ints64("comment ids", ids).Msg("getting comments by ids")
+	cs, err := ps.c.GetManyByIDs(ctx, ids)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Ints64("comment ids", ids).Msg("error while getting comments by ids")
+		return nil, ErrDatabase
+	}
+	res := make(map[int64]*model.CommentConnection, len(cs))
+	for _, c := range cs {
+		res[c.Id] = mapComment(c)
+	}
+	return res, nil
+}
+
+// CommentsOfPosts returns the first page of comments of every post like Comments does.
+func (ps *PostService) CommentsOfPosts(ctx context.Context, ids []int64, limit int) (_ map[int64]*model.CommentsResult, err error) {
+	ctx, span := startSpan(ctx, "CommentsOfPosts", attribute.Int("posts", len(ids)), attribute.Int("limit", limit))
+	defer endSpan(span, &err)
+	logging.Ctx(ctx).Debug().Ints64("post ids", ids).Int("limit", limit).Msg("getting comments of posts")
+	cs, err := ps.firstPages(ctx, ids, getLimit(limit))
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Ints64("post ids", ids).Msg("error while getting comments of posts")
+		return nil, ErrDatabase
+	}
+	res := make(map[int64]*model.CommentsResult, len(ids))
+	for _, id := range ids {
+		res[id] = mapCommentPage(cs[id], models.CommentCursor{})
+	}
+	return res, nil
+}
+
+// Comments returns the page of comments of the post after the end cursor of the previous page, the empty cursor is the first page.
+func (ps *PostService) Comments(ctx context.Context, postID int64, limit int, after string) (_ *model.CommentsResult, err error) {
+	ctx, span := startSpan(ctx, "Comments", attribute.Int64("post.id", postID), attribute.String("after", after))
+	defer endSpan(span, &err)
+	logging.Ctx(ctx).Debug().Int64("post id", postID).Int("limit", limit).Str("after", after).Msg("getting info about comments of post after cursor")
+	cursor, err := parseCommentCursor(after)
 	if err != nil {
-		log.Error().Err(err).Int64("post id", id).Msg("error while getting comments for post in getting post")
+		logging.Ctx(ctx).Error().Str("after", after).Msg("incorrect after")
+		return nil, err
+	}
+	var cs []*models.CommentDTO
+	if cursor.After == 0 {
+		cs, err = ps.firstPage(ctx, postID, getLimit(limit))
+	} else {
+		cs, err = ps.c.GetAllOfPost(ctx, postID, cursor, getLimit(limit))
+	}
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Int64("post id", postID).Msg("error while getting comments")
 		return nil, ErrDatabase
 	}
-	cr := mapComments(cs)
-	log.Debug().Int64("post id", id).Int("result size", len(cs)).Msg("result comments of post info")
-	return &model.Post{ID: strconv.FormatInt(post.Id, 10), Author: post.Author, Title: post.Title, Text: post.Text, Time: post.Time, IsCommentable: post.IsCommentable, Comments: cr}, nil
+	logging.Ctx(ctx).Debug().Int64("post id", postID).Int("result size", len(cs)).Msg("result comments info")
+	return mapCommentPage(cs, cursor), nil
+}
+
+// PinComment pins the comment, callers check that the request is allowed to do it.
+func (ps *PostService) PinComment(ctx context.Context, postID int64, commentID int64) (err error) {
+	ctx, span := startSpan(ctx, "PinComment", attribute.Int64("post.id", postID), attribute.Int64("comment.id", commentID))
+	defer endSpan(span, &err)
+	logging.Ctx(ctx).Debug().Int64("post id", postID).Int64("comment id", commentID).Msg("pinning comment")
+	if err := ps.checkPost(ctx, postID); err != nil {
+		return err
+	}
+	err = ps.c.Pin(ctx, postID, commentID, ps.maxPins)
+	if errors.Is(err, database.ErrPinLimit) {
+		logging.Ctx(ctx).Debug().Int64("post id", postID).Int("limit", ps.maxPins).Msg("too many pinned comments")
+		return fmt.Errorf("%w: no more than %d comments can be pinned", ErrClientData, ps.maxPins)
+	}
+	if errors.Is(err, database.ErrNoComment) {
+		logging.Ctx(ctx).Debug().Int64("post id", postID).Int64("comment id", commentID).Msg("no comment to pin")
+		return fmt.Errorf("%w: no comment %d in post %d", ErrClientData, commentID, postID)
+	}
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Int64("post id", postID).Int64("comment id", commentID).Msg("error while pinning comment")
+		return ErrDatabase
+	}
+	// other instances invalidate the post when they receive the update
+	ps.cache.invalidate(postID)
+	return nil
+}
+
+func (ps *PostService) UnpinComment(ctx context.Context, postID int64, commentID int64) (err error) {
+	ctx, span := startSpan(ctx, "UnpinComment", attribute.Int64("post.id", postID), attribute.Int64("comment.id", commentID))
+	defer endSpan(span, &err)
+	logging.Ctx(ctx).Debug().Int64("post id", postID).Int64("comment id", commentID).Msg("unpinning comment")
+	if err := ps.checkPost(ctx, postID); err != nil {
+		return err
+	}
+	if err := ps.c.Unpin(ctx, postID, commentID); err != nil {
+		logging.Ctx(ctx).Error().Err(err).Int64("post id", postID).Int64("comment id", commentID).Msg("error while unpinning comment")
+		return ErrDatabase
+	}
+	// other instances invalidate the post when they receive the update
+	ps.cache.invalidate(postID)
+	return nil
+}
 
+func (ps *PostService) Author(ctx context.Context, name string) (_ *model.Author, err error) {
+	ctx, span := startSpan(ctx, "Author")
+	defer endSpan(span, &err)
+	logging.Ctx(ctx).Debug().Str("author", name).Msg("getting info about author")
+	pa, err := ps.p.AuthorActivity(ctx, name)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Str("author", name).Msg("error while getting posts activity of author")
+		return nil, ErrDatabase
+	}
+	ca, err := ps.c.AuthorActivity(ctx, name)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Str("author", name).Msg("error while getting comments activity of author")
+		return nil, ErrDatabase
+	}
+	if pa.Count == 0 && ca.Count == 0 {
+		logging.Ctx(ctx).Debug().Str("author", name).Msg("author has no activity")
+		return nil, nil
+	}
+	first := pa.FirstSeen
+	if first.IsZero() || (!ca.FirstSeen.IsZero() && ca.FirstSeen.Before(first)) {
+		first = ca.FirstSeen
+	}
+	return &model.Author{
+		Name: name,
+		Stats: &model.AuthorStats{
+			PostCount:    int(pa.Count),
+			CommentCount: int(ca.Count),
+			FirstSeen:    getNullableTime(first),
+		},
+	}, nil
 }
 
-func (ps *PostService) Comments(ctx context.Context, postID int64, limit int, offset int64) (*model.CommentsResult, error) {
-	log.Debug().Int64("post id", postID).Int("limit", limit).Int64("offset", offset).Msg("getting info about comments of post with offset")
+func (ps *PostService) AuthorPosts(ctx context.Context, name string, offset int64, limit int) (_ []*model.ShortPost, err error) {
+	ctx, span := startSpan(ctx, "AuthorPosts")
+	defer endSpan(span, &err)
+	logging.Ctx(ctx).Debug().Str("author", name).Int64("offset", offset).Int("limit", limit).Msg("get posts of author")
 	if offset < 0 {
-		log.Error().Int64("after", offset).Msg("incorrect after")
-		return nil, fmt.Errorf("after %d should be more than 0", offset)
+		logging.Ctx(ctx).Error().Int64("after", offset).Msg("incorrect after")
+		return nil, fmt.Errorf("%w: after %d should be more than 0", ErrClientData, offset)
 	}
-	cs, err := ps.c.GetAllOfPost(ctx, postID, offset, getLimit(limit))
+	posts, err := ps.p.GetAllOfAuthor(ctx, name, offset, getLimit(limit))
 	if err != nil {
-		log.Error().Err(err).Int64("post id", postID).Msg("error while getting comments")
+		logging.Ctx(ctx).Error().Err(err).Str("author", name).Msg("error while loading posts of author")
+		return nil, ErrDatabase
+	}
+	return mapShortPosts(posts), nil
+}
+
+// AuthorComments returns comments of the author from the newest one, after is the end cursor of the previous page.
+func (ps *PostService) AuthorComments(ctx context.Context, name string, limit int, after string) (_ *model.CommentsResult, err error) {
+	ctx, span := startSpan(ctx, "AuthorComments")
+	defer endSpan(span, &err)
+	logging.Ctx(ctx).Debug().Str("author", name).Int("limit", limit).Str("after", after).Msg("get comments of author")
+	var id int64
+	if after != "" {
+		id, err = strconv.ParseInt(after, 10, 64)
+		if err != nil || id <= 0 {
+			logging.Ctx(ctx).Error().Str("after", after).Msg("incorrect after")
+			return nil, fmt.Errorf("%w: incorrect cursor %q", ErrClientData, after)
+		}
+	}
+	cs, err := ps.c.GetAllOfAuthor(ctx, name, id, getLimit(limit))
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Str("author", name).Msg("error while loading comments of author")
 		return nil, ErrDatabase
 	}
-	log.Debug().Int64("post id", postID).Int("result size", len(cs)).Msg("result comments info")
 	return mapComments(cs), nil
 }
 
-func fmti64 (data int64) string {
+func (ps *PostService) checkPost(ctx context.Context, postID int64) error {
+	post, err := ps.p.Get(ctx, postID)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Int64("post id", postID).Msg("error while getting info about post")
+		return ErrDatabase
+	}
+	if post == nil {
+		return fmt.Errorf("%w: %d", ErrPostNotFound, postID)
+	}
+	return nil
+}
+
+// publish enqueues the event, or only wakes up the publisher if the repository has enqueued it with the entity.
+func (ps *PostService) publish(ctx context.Context, event string, data any, enqueued bool) {
+	if ps.events == nil {
+		return
+	}
+	if enqueued {
+		ps.events.(EventEncoder).Wake()
+		return
+	}
+	if err := ps.events.Publish(ctx, event, data); err != nil {
+		logging.Ctx(ctx).Error().Err(err).Str("event", event).Msg("can not publish event")
+	}
+}
+
+func fmti64(data int64) string {
 	return strconv.FormatInt(data, 10)
 }
 
+func mapShortPosts(posts []*models.PostDTO) []*model.ShortPost {
+	var res []*model.ShortPost
+	for _, p := range posts {
+		res = append(res, &model.ShortPost{
+			Author:        p.Author,
+			ID:            fmti64(p.Id),
+			Title:         p.Title,
+			Time:          p.Time,
+			CommentCount:  int(p.CommentCount),
+			LastCommentAt: getNullableTime(p.LastCommentAt),
+		})
+	}
+	return res
+}
+
 func mapComments(c []*models.CommentDTO) *model.CommentsResult {
 	if c == nil || len(c) == 0 {
 		return &model.CommentsResult{
@@ -217,22 +651,63 @@ func mapComments(c []*models.CommentDTO) *model.CommentsResult {
 	}
 	var res []*model.CommentConnection
 	for _, cm := range c {
-		last := &model.CommentConnection{
-			ID:       fmti64(cm.Id),
-			PostID:   fmti64(cm.PostId),
-			ParentID: getNullableString(cm.ParentId),
-			Level:    cm.Level,
-			Comment:  &model.Comment{Author: cm.Author, Text: cm.Text},
-		}
-		res = append(res, last)
+		res = append(res, mapComment(cm))
 	}
-	pgi :=  &model.PageInfo{}
+	pgi := &model.PageInfo{}
 	if len(res) > 0 {
 		pgi.EndCursor = &res[len(res)-1].ID
 	}
 	return &model.CommentsResult{Comments: res, PageInfo: pgi}
 }
 
+// mapCommentPage maps comments of a post, the end cursor keeps pins of read subtrees.
+func mapCommentPage(c []*models.CommentDTO, after models.CommentCursor) *model.CommentsResult {
+	res := mapComments(c)
+	if len(c) > 0 {
+		cursor := formatCommentCursor(nextCommentCursor(after, c))
+		res.PageInfo.EndCursor = &cursor
+	}
+	return res
+}
+
+func mapComment(cm *models.CommentDTO) *model.CommentConnection {
+	return &model.CommentConnection{
+		ID:            fmti64(cm.Id),
+		PostID:        fmti64(cm.PostId),
+		ParentID:      getNullableString(cm.ParentId),
+		Level:         cm.Level,
+		Comment:       &model.Comment{Author: cm.Author, Text: cm.Text, Time: cm.Time},
+		Pinned:        cm.Pinned,
+		AuthorReplied: cm.AuthorReplied,
+	}
+}
+
+func mapPost(p *models.PostDTO) *model.Post {
+	return &model.Post{ID: fmti64(p.Id), Author: p.Author, Title: p.Title, Text: p.Text, Time: p.Time, IsCommentable: p.IsCommentable, CommentCount: int(p.CommentCount), LastCommentAt: getNullableTime(p.LastCommentAt)}
+}
+
+func mapCommentEvent(c *models.CommentDTO) *CommentEvent {
+	return &CommentEvent{
+		ID:       fmti64(c.Id),
+		PostID:   fmti64(c.PostId),
+		ParentID: getNullableString(c.ParentId),
+		Comment:  &model.Comment{Author: c.Author, Text: c.Text, Time: c.Time},
+	}
+}
+
+// encodeEvent returns bodies of events about stored entities, the same as the service publishes.
+func encodeEvent(e EventEncoder) database.EventEncoder {
+	return func(event string, entity any) ([]byte, error) {
+		switch v := entity.(type) {
+		case *models.PostDTO:
+			return e.Encode(event, mapPost(v))
+		case *models.CommentDTO:
+			return e.Encode(event, mapCommentEvent(v))
+		}
+		return nil, fmt.Errorf("no event for %T", entity)
+	}
+}
+
 func getNullableString(data int64) *string {
 	if data == 0 {
 		return nil
@@ -241,6 +716,13 @@ func getNullableString(data int64) *string {
 	return &res
 }
 
+func getNullableTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
 func getLimit(l int) int {
 	if l < 1 || l > MAX_LIMIT {
 		log.Debug().Int("user limit", l).Msg("switch to default limit")
@@ -248,3 +730,22 @@ func getLimit(l int) int {
 	}
 	return l
 }
+
+// startSpan starts a span of the method, which is ended by endSpan.
+// The context is not changed when the span is not recorded, e.g. when tracing is disabled.
+func startSpan(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	spanCtx, span := tracer.Start(ctx, "PostService."+method, trace.WithAttributes(attrs...))
+	if !span.IsRecording() {
+		return ctx, span
+	}
+	return spanCtx, span
+}
+
+// endSpan records the error returned by the method and ends its span.
+func endSpan(span trace.Span, err *error) {
+	if *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
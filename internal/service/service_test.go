@@ -4,11 +4,13 @@ import (
 	"context"
 	"errors"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/brianvoe/gofakeit/v7"
 	"github.com/mi-raf/comment-project/graph/model"
+	"github.com/mi-raf/comment-project/internal/database"
 	"github.com/mi-raf/comment-project/internal/models"
 	"github.com/mi-raf/comment-project/internal/service"
 	"github.com/stretchr/testify/mock"
@@ -34,7 +36,7 @@ func (suite *ServiceTestSuite) SetupTest() {
 	cc = make(chan models.CommentDTO, 10)
 	suite.cr = new(MockCommentRepository)
 	suite.pr = new(MockPostRepository)
-	suite.ps, suite.closer = service.NewPostService(suite.ctx, suite.pr, suite.cr, &service.Config{cc})
+	suite.ps, suite.closer = service.NewPostService(suite.ctx, suite.pr, suite.cr, &service.Config{CommentChan: cc, MaxPins: 2})
 }
 
 func (suite *ServiceTestSuite) TearDownTest() {
@@ -52,26 +54,50 @@ func (s *ServiceTestSuite) TestGetAllPosts() {
 		gofakeit.Struct(&f)
 		testPosts = append(testPosts, &f)
 	}
-	s.pr.On("GetAll", s.ctx, int64(0), 5).Return(testPosts, nil)
+	s.pr.On("GetAll", s.ctx, int64(0), 5, models.PostOrderTime).Return(testPosts, nil)
 
 	// when
-	res, err := s.ps.GetAllPosts(s.ctx, 0, 5)
+	res, err := s.ps.GetAllPosts(s.ctx, 0, 5, model.PostOrderTime)
 
 	// then
 	s.NoError(err)
 	var expected []*model.ShortPost
 	for _, r := range testPosts {
-		expected = append(expected, &model.ShortPost{ID: fmti64(r.Id), Author: r.Author, Title: r.Title})
+		expected = append(expected, &model.ShortPost{ID: fmti64(r.Id), Author: r.Author, Title: r.Title, Time: r.Time})
 	}
 	s.ElementsMatch(expected, res)
 }
 
+func (s *ServiceTestSuite) TestGetAllPostsByActivity() {
+	// given
+	var f models.PostDTO
+	gofakeit.Struct(&f)
+	f.CommentCount = 3
+	f.LastCommentAt = time.Now()
+	s.pr.On("GetAll", s.ctx, int64(0), 5, models.PostOrderLastActivity).Return([]*models.PostDTO{&f}, nil)
+
+	// when
+	res, err := s.ps.GetAllPosts(s.ctx, 0, 5, model.PostOrderLastActivity)
+
+	// then
+	s.NoError(err)
+	expected := []*model.ShortPost{{
+		ID:            fmti64(f.Id),
+		Author:        f.Author,
+		Title:         f.Title,
+		Time:          f.Time,
+		CommentCount:  3,
+		LastCommentAt: &f.LastCommentAt,
+	}}
+	s.Equal(expected, res)
+}
+
 func (s *ServiceTestSuite) TestGetAllPostsReturnError() {
 	// given
-	s.pr.On("GetAll", s.ctx, int64(0), 5).Return(nil, errors.New("Test error"))
+	s.pr.On("GetAll", s.ctx, int64(0), 5, models.PostOrderTime).Return(nil, errors.New("Test error"))
 
 	// when
-	_, err := s.ps.GetAllPosts(s.ctx, 0, 5)
+	_, err := s.ps.GetAllPosts(s.ctx, 0, 5, model.PostOrderTime)
 
 	// then
 	s.ErrorIs(err, service.ErrDatabase)
@@ -79,16 +105,38 @@ func (s *ServiceTestSuite) TestGetAllPostsReturnError() {
 
 func (s *ServiceTestSuite) TestGetAllPostsReturnNil() {
 	// given
-	s.pr.On("GetAll", s.ctx, int64(0), 5).Return(nil, nil)
+	s.pr.On("GetAll", s.ctx, int64(0), 5, models.PostOrderTime).Return(nil, nil)
 
 	// when
-	res, err := s.ps.GetAllPosts(s.ctx, 0, 5)
+	res, err := s.ps.GetAllPosts(s.ctx, 0, 5, model.PostOrderTime)
 
 	// then
 	s.Nil(res)
 	s.NoError(err)
 }
 
+func (s *ServiceTestSuite) TestLatestPosts() {
+	// given
+	var f models.PostDTO
+	gofakeit.Struct(&f)
+	s.pr.On("GetAll", s.ctx, int64(0), 5, models.PostOrderTime).Return([]*models.PostDTO{&f}, nil)
+
+	// when
+	res, err := s.ps.LatestPosts(s.ctx, 0, 5)
+
+	// then
+	s.NoError(err)
+	expected := []*model.Post{{
+		ID:            fmti64(f.Id),
+		Author:        f.Author,
+		Title:         f.Title,
+		Text:          f.Text,
+		Time:          f.Time,
+		IsCommentable: f.IsCommentable,
+	}}
+	s.Equal(expected, res)
+}
+
 func (s *ServiceTestSuite) TestGetPost() {
 	// given
 	var f models.PostDTO
@@ -101,7 +149,7 @@ func (s *ServiceTestSuite) TestGetPost() {
 		gofakeit.Struct(&f)
 		testComments = append(testComments, &f)
 	}
-	s.cr.On("GetAllOfPost", s.ctx, int64(1), int64(0), 5).Return(testComments, nil)
+	s.cr.On("GetAllOfPost", s.ctx, int64(1), models.CommentCursor{}, 5).Return(testComments, nil)
 
 	// when
 	post, err := s.ps.Post(s.ctx, 1, 5)
@@ -149,7 +197,7 @@ func (s *ServiceTestSuite) TestGetPostWithError() {
 	gofakeit.Struct(&f)
 	s.pr.On("Get", s.ctx, int64(1)).Return(nil, errors.New("Test Error"))
 
-	s.cr.On("GetAllOfPost", s.ctx, int64(1), int64(0), 5).Return(nil, nil)
+	s.cr.On("GetAllOfPost", s.ctx, int64(1), models.CommentCursor{}, 5).Return(nil, nil)
 
 	// when
 	_, err := s.ps.Post(s.ctx, 1, 5)
@@ -163,7 +211,7 @@ func (s *ServiceTestSuite) TestNotExistinigPost() {
 	// given
 	s.pr.On("Get", s.ctx, int64(1)).Return(nil, nil)
 
-	s.cr.On("GetAllOfPost", s.ctx, int64(1), int64(0), 5).Return(nil, nil)
+	s.cr.On("GetAllOfPost", s.ctx, int64(1), models.CommentCursor{}, 5).Return(nil, nil)
 
 	// when
 	_, err := s.ps.Post(s.ctx, 1, 5)
@@ -173,6 +221,54 @@ func (s *ServiceTestSuite) TestNotExistinigPost() {
 	s.cr.AssertNotCalled(s.T(), "GetAllOfPost", s.ctx, mock.Anything, mock.Anything)
 }
 
+func (s *ServiceTestSuite) TestPostsByIDs() {
+	// given
+	var f models.PostDTO
+	gofakeit.Struct(&f)
+	f.Id = 3
+	s.pr.On("GetManyByIDs", s.ctx, []int64{3, 4}).Return([]*models.PostDTO{&f}, nil)
+
+	// when
+	res, err := s.ps.PostsByIDs(s.ctx, []int64{3, 4})
+
+	// then
+	s.NoError(err)
+	s.Len(res, 1)
+	s.Equal("3", res[3].ID)
+	s.Equal(f.Title, res[3].Title)
+	s.Nil(res[3].Comments)
+}
+
+func (s *ServiceTestSuite) TestCommentsOfPosts() {
+	// given
+	c := &models.CommentDTO{Id: 7, PostId: 1, Author: "author", Text: "text", Level: 1, Pinned: true}
+	s.cr.On("GetAllOfPosts", s.ctx, []int64{1, 2}, service.MAX_LIMIT).Return(map[int64][]*models.CommentDTO{1: {c}}, nil)
+
+	// when
+	res, err := s.ps.CommentsOfPosts(s.ctx, []int64{1, 2}, 0)
+
+	// then
+	s.NoError(err)
+	s.Require().Len(res, 2)
+	s.Require().Len(res[1].Comments, 1)
+	s.Equal("7", res[1].Comments[0].ID)
+	s.True(res[1].Comments[0].Pinned)
+	s.Equal("7", *res[1].PageInfo.EndCursor)
+	s.Empty(res[2].Comments)
+	s.Nil(res[2].PageInfo.EndCursor)
+}
+
+func (s *ServiceTestSuite) TestCommentsByIDsWithError() {
+	// given
+	s.cr.On("GetManyByIDs", s.ctx, []int64{1}).Return(nil, errors.New("some error"))
+
+	// when
+	_, err := s.ps.CommentsByIDs(s.ctx, []int64{1})
+
+	// then
+	s.ErrorIs(err, service.ErrDatabase)
+}
+
 func (s *ServiceTestSuite) TestCreatePost() {
 	// given
 	var f model.NewPost
@@ -211,7 +307,7 @@ func (s *ServiceTestSuite) TestSubsribeAndListen() {
 	// given
 	var comment models.CommentDTO
 	gofakeit.Struct(&comment)
-	ch := s.ps.CommentSubscribe(comment.PostId)
+	ch := s.ps.CommentSubscribe(s.ctx, comment.PostId)
 
 	// when
 	cc <- comment
@@ -236,33 +332,87 @@ func (s *ServiceTestSuite) TestSubsribeAndListen() {
 	}
 }
 
+func (s *ServiceTestSuite) TestSlowSubscriberClosed() {
+	// given
+	var comment models.CommentDTO
+	gofakeit.Struct(&comment)
+	slow := s.ps.CommentSubscribe(s.ctx, comment.PostId)
+	fast := s.ps.CommentSubscribe(s.ctx, comment.PostId)
+	received := make(chan struct{})
+	go func() {
+		for range fast {
+			received <- struct{}{}
+		}
+	}()
+
+	// when
+	// the fast subscriber reads every comment before the next one, so only the slow one lags
+	for i := 0; i <= service.CHANELS_SIZE; i++ {
+		cc <- comment
+		select {
+		case <-received:
+		case <-time.After(2 * time.Second):
+			s.FailNow("it's been too long")
+		}
+	}
+
+	// then
+	n := 0
+	for range slow {
+		n++
+	}
+	s.Equal(service.CHANELS_SIZE, n)
+	s.Equal(int64(1), s.ps.SubscriptionStats().Dropped)
+	s.Equal(1, s.ps.SubscriptionStats().Subscribers[comment.PostId])
+}
+
 func (s *ServiceTestSuite) TestSubsribeToExistingAndListen() {
 	// given
 	var comment models.CommentDTO
 	gofakeit.Struct(&comment)
-	ch1 := s.ps.CommentSubscribe(comment.PostId)
-	ch2 := s.ps.CommentSubscribe(comment.PostId)
+	ch1 := s.ps.CommentSubscribe(s.ctx, comment.PostId)
+	ch2 := s.ps.CommentSubscribe(s.ctx, comment.PostId)
 
 	// when
 	cc <- comment
 
 	// then
-	s.Equal(ch1, ch2)
+	s.NotEqual(ch1, ch2)
+	expected := &model.CommentConnection{
+		ID:       fmti64(comment.Id),
+		ParentID: getNullableString(comment.ParentId),
+		PostID:   fmti64(comment.PostId),
+		Level:    comment.Level,
+		Comment: &model.Comment{
+			Author: comment.Author,
+			Text:   comment.Text,
+			Time:   comment.Time,
+		},
+	}
+	for _, ch := range []<-chan *model.CommentConnection{ch1, ch2} {
+		select {
+		case actual := <-ch:
+			s.Equal(expected, actual)
+		case <-time.After(2 * time.Second):
+			s.FailNow("it's been too long")
+		}
+	}
+}
 
+func (s *ServiceTestSuite) TestUnsubscribeOnContextDone() {
+	// given
+	var comment models.CommentDTO
+	gofakeit.Struct(&comment)
+	ctx, cancel := context.WithCancel(s.ctx)
+	ch := s.ps.CommentSubscribe(ctx, comment.PostId)
+
+	// when
+	cancel()
+
+	// then
 	select {
-	case actual := <-ch1:
-		expected := &model.CommentConnection{
-			ID:       fmti64(comment.Id),
-			ParentID: getNullableString(comment.ParentId),
-			PostID:   fmti64(comment.PostId),
-			Level:    comment.Level,
-			Comment: &model.Comment{
-				Author: comment.Author,
-				Text:   comment.Text,
-				Time:   comment.Time,
-			},
-		}
-		s.Equal(expected, actual)
+	case _, ok := <-ch:
+		s.False(ok)
 	case <-time.After(2 * time.Second):
 		s.FailNow("it's been too long")
 	}
@@ -276,10 +426,10 @@ func (s *ServiceTestSuite) TestGetComments() {
 		gofakeit.Struct(&f)
 		testComments = append(testComments, &f)
 	}
-	s.cr.On("GetAllOfPost", s.ctx, int64(1), int64(0), 5).Return(testComments, nil)
+	s.cr.On("GetAllOfPost", s.ctx, int64(1), models.CommentCursor{}, 5).Return(testComments, nil)
 
 	// when
-	res, err := s.ps.Comments(s.ctx, 1, 5, 0)
+	res, err := s.ps.Comments(s.ctx, 1, 5, "")
 	s.NoError(err)
 
 	var expectedComments []*model.CommentConnection
@@ -311,12 +461,12 @@ func (s *ServiceTestSuite) TestGetComments() {
 func (s *ServiceTestSuite) TestGetCommentsWithIncorrectLimit() {
 	// given
 	var testComments []*models.CommentDTO
-	s.cr.On("GetAllOfPost", s.ctx, int64(1), int64(0), 100).Return(testComments, nil)
+	s.cr.On("GetAllOfPost", s.ctx, int64(1), models.CommentCursor{}, 100).Return(testComments, nil)
 
 	// when
-	_, err := s.ps.Comments(s.ctx, 1, -1, 0)
+	_, err := s.ps.Comments(s.ctx, 1, -1, "")
 	s.NoError(err)
-	_, err = s.ps.Comments(s.ctx, 1, 101, 0)
+	_, err = s.ps.Comments(s.ctx, 1, 101, "")
 	s.NoError(err)
 
 	// then
@@ -329,20 +479,72 @@ func (s *ServiceTestSuite) TestGetCommentsWithIncorrectOffset() {
 	s.cr.On("GetAllOfPost", s.ctx, mock.Anything, mock.Anything, mock.Anything).Return(testComments, nil)
 
 	// when
-	_, err := s.ps.Comments(s.ctx, 1, 5, -1)
+	_, err := s.ps.Comments(s.ctx, 1, 5, "-1")
 
 	// then
 	s.Error(err)
 	s.cr.AssertNotCalled(s.T(), "GetAllOfPost")
 }
 
+func (s *ServiceTestSuite) TestGetCommentsByEndCursorWhilePinning() {
+	// given
+	ch := make(chan models.CommentDTO, 10)
+	pr := database.NewInMemnoryPostRepository()
+	cr := database.NewInMemnoryCommentRepository(ch)
+	ps, closer := service.NewPostService(s.ctx, pr, cr, &service.Config{CommentChan: ch, MaxPins: 3})
+	defer closer()
+	postID, err := pr.Add(s.ctx, &models.PostDTO{Author: "author", Title: "title", Text: "text", IsCommentable: true})
+	s.Require().NoError(err)
+	var ids []int64
+	for i := 0; i < 6; i++ {
+		c := models.CommentDTO{PostId: postID, Author: "author", Text: strconv.Itoa(i)}
+		if i == 5 {
+			c.ParentId = ids[3]
+		}
+		id, err := cr.Add(s.ctx, &c)
+		s.Require().NoError(err)
+		ids = append(ids, id)
+	}
+	s.NoError(cr.Pin(s.ctx, postID, ids[3], 3))
+	changes := []func(){
+		func() { s.NoError(cr.Unpin(s.ctx, postID, ids[3])) },
+		func() { s.NoError(cr.Pin(s.ctx, postID, ids[4], 3)) },
+		func() { s.NoError(cr.Pin(s.ctx, postID, ids[0], 3)) },
+		func() { s.NoError(cr.Unpin(s.ctx, postID, ids[4])) },
+	}
+
+	// when
+	var actual []int64
+	after := ""
+	for i := 0; ; i++ {
+		page, err := ps.Comments(s.ctx, postID, 2, after)
+		s.Require().NoError(err)
+		for _, c := range page.Comments {
+			id, err := strconv.ParseInt(c.ID, 10, 64)
+			s.Require().NoError(err)
+			actual = append(actual, id)
+		}
+		if len(page.Comments) < 2 {
+			break
+		}
+		after = *page.PageInfo.EndCursor
+		if i < len(changes) {
+			changes[i]()
+		}
+	}
+
+	// then
+	s.ElementsMatch(ids, actual)
+	s.Equal([]int64{ids[3], ids[5]}, actual[:2])
+}
+
 func (s *ServiceTestSuite) TestGetCommentsEmptyResult() {
 	// given
 	var testComments []*models.CommentDTO
 	s.cr.On("GetAllOfPost", s.ctx, mock.Anything, mock.Anything, mock.Anything).Return(testComments, nil)
 
 	// when
-	res, err := s.ps.Comments(s.ctx, 1, 5, 0)
+	res, err := s.ps.Comments(s.ctx, 1, 5, "")
 
 	// then
 	s.NoError(err)
@@ -371,20 +573,53 @@ func (s *ServiceTestSuite) TestCreateCommentNotCommentable() {
 	var f model.NewComment
 	gofakeit.Struct(&f)
 	s.pr.On("IsCommentable", s.ctx, mock.Anything).Return(false, nil)
+	s.pr.On("Get", s.ctx, int64(1)).Return(&models.PostDTO{Id: 1}, nil)
 	s.cr.On("Add", s.ctx, mock.Anything).Return(1, nil)
 	// when
 	_, err := s.ps.CreateComment(s.ctx, 1, 0, f)
 
 	// then
-	s.Error(err)
+	s.ErrorIs(err, service.ErrClientData)
+	s.NotErrorIs(err, service.ErrPostNotFound)
 	s.pr.AssertExpectations(s.T())
 	s.cr.AssertNotCalled(s.T(), "Add", s.ctx, mock.Anything)
 }
 
+func (s *ServiceTestSuite) TestCreateCommentPostNotFound() {
+	// given
+	var f model.NewComment
+	gofakeit.Struct(&f)
+	s.pr.On("IsCommentable", s.ctx, mock.Anything).Return(false, nil)
+	s.pr.On("Get", s.ctx, int64(1)).Return(nil, nil)
+	// when
+	_, err := s.ps.CreateComment(s.ctx, 1, 0, f)
+
+	// then
+	s.ErrorIs(err, service.ErrPostNotFound)
+	s.ErrorIs(err, service.ErrClientData)
+	s.cr.AssertNotCalled(s.T(), "Add", s.ctx, mock.Anything)
+}
+
+func (s *ServiceTestSuite) TestCreateCommentTooLong() {
+	cases := []model.NewComment{
+		{Author: strings.Repeat("а", service.MAX_AUTHOR_LEN+1), Text: "text"},
+		{Author: "author", Text: strings.Repeat("т", service.MAX_COMMENT_LEN+1)},
+	}
+	for _, c := range cases {
+		// when
+		_, err := s.ps.CreateComment(s.ctx, 1, 0, c)
+
+		// then
+		s.ErrorIs(err, service.ErrClientData)
+	}
+	s.pr.AssertNotCalled(s.T(), "IsCommentable", s.ctx, mock.Anything)
+	s.cr.AssertNotCalled(s.T(), "Add", s.ctx, mock.Anything)
+}
+
 func (s *ServiceTestSuite) TestCreateCommentListen() {
 	// given
 	var postId int64 = 1
-	ch := s.ps.CommentSubscribe(postId)
+	ch := s.ps.CommentSubscribe(s.ctx, postId)
 
 	var f model.NewComment
 	gofakeit.Struct(&f)
@@ -414,6 +649,457 @@ func (s *ServiceTestSuite) TestCreateCommentListen() {
 	}
 }
 
+func (s *ServiceTestSuite) TestPinComment() {
+	// given
+	var f models.PostDTO
+	gofakeit.Struct(&f)
+	s.pr.On("Get", s.ctx, int64(1)).Return(&f, nil)
+	s.cr.On("Pin", s.ctx, int64(1), int64(2), 2).Return(nil)
+
+	// when
+	err := s.ps.PinComment(s.ctx, 1, 2)
+
+	// then
+	s.NoError(err)
+	s.cr.AssertExpectations(s.T())
+}
+
+func (s *ServiceTestSuite) TestPinCommentPostNotFound() {
+	// given
+	s.pr.On("Get", s.ctx, int64(1)).Return(nil, nil)
+	s.cr.On("Pin", s.ctx, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	// when
+	err := s.ps.PinComment(s.ctx, 1, 2)
+
+	// then
+	s.ErrorIs(err, service.ErrPostNotFound)
+	s.cr.AssertNotCalled(s.T(), "Pin", s.ctx, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (s *ServiceTestSuite) TestPinCommentLimit() {
+	// given
+	var f models.PostDTO
+	gofakeit.Struct(&f)
+	s.pr.On("Get", s.ctx, int64(1)).Return(&f, nil)
+	s.cr.On("Pin", s.ctx, int64(1), int64(2), 2).Return(database.ErrPinLimit)
+
+	// when
+	err := s.ps.PinComment(s.ctx, 1, 2)
+
+	// then
+	s.ErrorIs(err, service.ErrClientData)
+}
+
+func (s *ServiceTestSuite) TestUnpinComment() {
+	// given
+	var f models.PostDTO
+	gofakeit.Struct(&f)
+	s.pr.On("Get", s.ctx, int64(1)).Return(&f, nil)
+	s.cr.On("Unpin", s.ctx, int64(1), int64(2)).Return(nil)
+
+	// when
+	err := s.ps.UnpinComment(s.ctx, 1, 2)
+
+	// then
+	s.NoError(err)
+	s.cr.AssertExpectations(s.T())
+}
+
+func (s *ServiceTestSuite) TestAuthor() {
+	// given
+	now := time.Now()
+	s.pr.On("AuthorActivity", s.ctx, "user").Return(&models.ActivityDTO{Count: 2, FirstSeen: now}, nil)
+	s.cr.On("AuthorActivity", s.ctx, "user").Return(&models.ActivityDTO{Count: 5, FirstSeen: now.Add(-time.Hour)}, nil)
+
+	// when
+	res, err := s.ps.Author(s.ctx, "user")
+
+	// then
+	s.NoError(err)
+	first := now.Add(-time.Hour)
+	expected := &model.Author{
+		Name: "user",
+		Stats: &model.AuthorStats{
+			PostCount:    2,
+			CommentCount: 5,
+			FirstSeen:    &first,
+		},
+	}
+	s.Equal(expected, res)
+}
+
+func (s *ServiceTestSuite) TestAuthorWithoutActivity() {
+	// given
+	s.pr.On("AuthorActivity", s.ctx, "user").Return(&models.ActivityDTO{}, nil)
+	s.cr.On("AuthorActivity", s.ctx, "user").Return(&models.ActivityDTO{}, nil)
+
+	// when
+	res, err := s.ps.Author(s.ctx, "user")
+
+	// then
+	s.NoError(err)
+	s.Nil(res)
+}
+
+func (s *ServiceTestSuite) TestAuthorComments() {
+	// given
+	var testComments []*models.CommentDTO
+	for i := 0; i < 3; i++ {
+		var f models.CommentDTO
+		gofakeit.Struct(&f)
+		f.Author = "user"
+		testComments = append(testComments, &f)
+	}
+	s.cr.On("GetAllOfAuthor", s.ctx, "user", int64(0), 3).Return(testComments, nil)
+
+	// when
+	res, err := s.ps.AuthorComments(s.ctx, "user", 3, "")
+
+	// then
+	s.NoError(err)
+	s.Len(res.Comments, 3)
+	s.Equal(fmti64(testComments[0].PostId), res.Comments[0].PostID)
+}
+
+func (s *ServiceTestSuite) TestCreateCommentPublishesEvent() {
+	// given
+	ev := new(MockEventPublisher)
+	ps, closer := service.NewPostService(s.ctx, s.pr, s.cr, &service.Config{CommentChan: make(chan models.CommentDTO), Events: ev})
+	defer closer()
+	var f model.NewComment
+	gofakeit.Struct(&f)
+	s.pr.On("IsCommentable", s.ctx, int64(1)).Return(true, nil)
+	s.cr.On("Add", s.ctx, mock.Anything).Return(5, nil)
+	ev.On("Publish", s.ctx, models.EventCommentCreated, mock.Anything).Return(nil)
+
+	// when
+	_, err := ps.CreateComment(s.ctx, 1, 2, f)
+
+	// then
+	s.NoError(err)
+	ev.AssertExpectations(s.T())
+	actual := ev.Calls[0].Arguments.Get(2).(*service.CommentEvent)
+	s.Equal("5", actual.ID)
+	s.Equal("1", actual.PostID)
+	s.Equal("2", *actual.ParentID)
+	s.Equal(f.Text, actual.Comment.Text)
+}
+
+func (s *ServiceTestSuite) TestCreateCommentEventEnqueuedByRepository() {
+	// given
+	ev := new(MockEventEncoder)
+	cr := &MockEventCommentRepository{MockCommentRepository: s.cr}
+	ps, closer := service.NewPostService(s.ctx, s.pr, cr, &service.Config{CommentChan: make(chan models.CommentDTO), Events: ev})
+	defer closer()
+	var f model.NewComment
+	gofakeit.Struct(&f)
+	s.pr.On("IsCommentable", s.ctx, int64(1)).Return(true, nil)
+	s.cr.On("Add", s.ctx, mock.Anything).Return(5, nil)
+	ev.On("Wake").Return()
+	ev.On("Encode", models.EventCommentCreated, mock.Anything).Return([]byte(`{}`), nil)
+
+	// when
+	_, err := ps.CreateComment(s.ctx, 1, 2, f)
+
+	// then
+	s.NoError(err)
+	ev.AssertNotCalled(s.T(), "Publish", mock.Anything, mock.Anything, mock.Anything)
+	ev.AssertCalled(s.T(), "Wake")
+	// the repository encodes the event with the id of the stored comment
+	s.Require().NotNil(cr.events)
+	body, err := cr.events(models.EventCommentCreated, &models.CommentDTO{Id: 5, PostId: 1, ParentId: 2, Author: f.Author, Text: f.Text, Time: f.Time})
+	s.NoError(err)
+	s.Equal([]byte(`{}`), body)
+	actual := ev.Calls[len(ev.Calls)-1].Arguments.Get(1).(*service.CommentEvent)
+	s.Equal("5", actual.ID)
+	s.Equal("1", actual.PostID)
+	s.Equal("2", *actual.ParentID)
+	s.Equal(f.Text, actual.Comment.Text)
+}
+
+func (s *ServiceTestSuite) TestCreatePostPublishesEvent() {
+	// given
+	ev := new(MockEventEncoder)
+	ps, closer := service.NewPostService(s.ctx, s.pr, s.cr, &service.Config{CommentChan: make(chan models.CommentDTO), Events: ev})
+	defer closer()
+	var f model.NewPost
+	gofakeit.Struct(&f)
+	s.pr.On("Add", s.ctx, mock.Anything).Return(3, nil)
+	ev.On("Publish", s.ctx, models.EventPostCreated, mock.Anything).Return(nil)
+
+	// when
+	res, err := ps.CreatePost(s.ctx, f)
+
+	// then
+	// the repository does not enqueue events, so the service publishes them
+	s.NoError(err)
+	ev.AssertExpectations(s.T())
+	ev.AssertNotCalled(s.T(), "Wake")
+	s.Equal(res, ev.Calls[0].Arguments.Get(2))
+	s.Equal("3", res.ID)
+}
+
+func (s *ServiceTestSuite) TestPostCached() {
+	// given
+	ps, _, closer := s.cachedService()
+	defer closer()
+	var f models.PostDTO
+	gofakeit.Struct(&f)
+	var comments []*models.CommentDTO
+	for i := 0; i < 5; i++ {
+		var c models.CommentDTO
+		gofakeit.Struct(&c)
+		comments = append(comments, &c)
+	}
+	s.pr.On("Get", s.ctx, int64(1)).Return(&f, nil).Once()
+	s.cr.On("GetAllOfPost", s.ctx, int64(1), models.CommentCursor{}, service.CACHE_PAGE).Return(comments, nil).Once()
+
+	// when
+	first, err := ps.Post(s.ctx, 1, 5)
+	s.NoError(err)
+	second, err := ps.Post(s.ctx, 1, 2)
+	s.NoError(err)
+	page, err := ps.Comments(s.ctx, 1, 3, "")
+
+	// then
+	s.NoError(err)
+	s.Equal(first.Title, second.Title)
+	s.Len(first.Comments.Comments, 5)
+	s.Equal(first.Comments.Comments[:2], second.Comments.Comments)
+	s.Equal(first.Comments.Comments[:3], page.Comments)
+	s.pr.AssertExpectations(s.T())
+	s.cr.AssertExpectations(s.T())
+	st, ok := ps.CacheStats()
+	s.True(ok)
+	s.Equal(service.CacheCounters{Hits: 1, Misses: 1, Entries: 1}, st.Posts)
+	s.Equal(service.CacheCounters{Hits: 2, Misses: 1, Entries: 1}, st.Pages)
+}
+
+func (s *ServiceTestSuite) TestPostCacheInvalidatedByComment() {
+	// given
+	ps, ch, closer := s.cachedService()
+	defer closer()
+	var f models.PostDTO
+	gofakeit.Struct(&f)
+	s.pr.On("Get", s.ctx, int64(1)).Return(&f, nil).Twice()
+	s.cr.On("GetAllOfPost", s.ctx, int64(1), models.CommentCursor{}, service.CACHE_PAGE).Return([]*models.CommentDTO{}, nil).Twice()
+	_, err := ps.Post(s.ctx, 1, 5)
+	s.NoError(err)
+
+	// when
+	ch <- models.CommentDTO{Id: 7, PostId: 1}
+	s.Eventually(func() bool {
+		st, _ := ps.CacheStats()
+		return st.Posts.Entries == 0 && st.Pages.Entries == 0
+	}, time.Second, 10*time.Millisecond)
+	_, err = ps.Post(s.ctx, 1, 5)
+
+	// then
+	s.NoError(err)
+	s.pr.AssertExpectations(s.T())
+	s.cr.AssertExpectations(s.T())
+}
+
+func (s *ServiceTestSuite) TestPostCacheInvalidatedByUpdate() {
+	// given
+	updates := make(chan int64)
+	cr := &MockNotifyingCommentRepository{MockCommentRepository: s.cr, updates: updates}
+	ps, closer := service.NewPostService(s.ctx, s.pr, cr, &service.Config{
+		CommentChan: make(chan models.CommentDTO),
+		Cache:       service.CacheConfig{Size: 10, TTL: time.Minute},
+	})
+	defer closer()
+	var f models.PostDTO
+	gofakeit.Struct(&f)
+	s.pr.On("Get", s.ctx, int64(1)).Return(&f, nil).Twice()
+	s.cr.On("GetAllOfPost", s.ctx, int64(1), models.CommentCursor{}, service.CACHE_PAGE).Return([]*models.CommentDTO{}, nil).Twice()
+	_, err := ps.Post(s.ctx, 1, 5)
+	s.NoError(err)
+
+	// when
+	// a comment of the post is pinned by another instance
+	updates <- 1
+	s.Eventually(func() bool {
+		st, _ := ps.CacheStats()
+		return st.Posts.Entries == 0 && st.Pages.Entries == 0
+	}, time.Second, 10*time.Millisecond)
+	_, err = ps.Post(s.ctx, 1, 5)
+
+	// then
+	s.NoError(err)
+	s.pr.AssertExpectations(s.T())
+	s.cr.AssertExpectations(s.T())
+}
+
+func (s *ServiceTestSuite) TestPostsByIDsLoadsMissedOnly() {
+	// given
+	ps, _, closer := s.cachedService()
+	defer closer()
+	first, second := &models.PostDTO{Id: 1, Title: "first"}, &models.PostDTO{Id: 2, Title: "second"}
+	s.pr.On("GetManyByIDs", s.ctx, []int64{1}).Return([]*models.PostDTO{first}, nil).Once()
+	s.pr.On("GetManyByIDs", s.ctx, []int64{2, 3}).Return([]*models.PostDTO{second}, nil).Once()
+	_, err := ps.PostsByIDs(s.ctx, []int64{1})
+	s.NoError(err)
+
+	// when
+	res, err := ps.PostsByIDs(s.ctx, []int64{1, 2, 3})
+
+	// then
+	s.NoError(err)
+	s.Len(res, 2)
+	s.Equal("first", res[1].Title)
+	s.Equal("second", res[2].Title)
+	s.pr.AssertExpectations(s.T())
+}
+
+func (s *ServiceTestSuite) TestPinInvalidatesCache() {
+	// given
+	ps, _, closer := s.cachedService()
+	defer closer()
+	var f models.PostDTO
+	gofakeit.Struct(&f)
+	s.pr.On("Get", s.ctx, int64(1)).Return(&f, nil)
+	s.cr.On("GetAllOfPost", s.ctx, int64(1), models.CommentCursor{}, service.CACHE_PAGE).Return([]*models.CommentDTO{}, nil).Twice()
+	s.cr.On("Pin", s.ctx, int64(1), int64(2), 0).Return(nil)
+	_, err := ps.Comments(s.ctx, 1, 5, "")
+	s.NoError(err)
+
+	// when
+	err = ps.PinComment(s.ctx, 1, 2)
+	s.NoError(err)
+	_, err = ps.Comments(s.ctx, 1, 5, "")
+
+	// then
+	s.NoError(err)
+	s.cr.AssertExpectations(s.T())
+}
+
+func (s *ServiceTestSuite) TestReadiness() {
+	// given
+	pr := &MockPgPostRepository{MockPostRepository: s.pr}
+	pr.On("Ping", mock.Anything).Return(nil)
+	pr.On("SchemaVersion", mock.Anything).Return(uint(3), false, nil)
+	ps, closer := s.checkedService(pr, 3)
+	defer closer()
+
+	// when
+	res := ps.Readiness(s.ctx)
+
+	// then
+	s.Len(res, 3)
+	for name, c := range res {
+		s.Equal(service.CHECK_OK, c.Status, name)
+		s.GreaterOrEqual(c.LatencyMs, 0.0)
+	}
+	s.Equal(service.SchemaStatus{Version: 3, Expected: 3}, res["migrations"].Details)
+	s.Equal(service.HubStatus{Running: true, QueueCapacity: 1}, res["hub"].Details)
+}
+
+func (s *ServiceTestSuite) TestReadinessFailedChecks() {
+	// given
+	pr := &MockPgPostRepository{MockPostRepository: s.pr}
+	pr.On("Ping", mock.Anything).Return(errors.New("connection refused"))
+	pr.On("SchemaVersion", mock.Anything).Return(uint(2), false, nil)
+	ps, closer := s.checkedService(pr, 3)
+	defer closer()
+
+	// when
+	res := ps.Readiness(s.ctx)
+
+	// then
+	s.Equal(service.CHECK_FAILED, res["database"].Status)
+	s.Equal("connection refused", res["database"].Error)
+	s.Equal(service.CHECK_FAILED, res["migrations"].Status)
+	s.Equal(service.CHECK_OK, res["hub"].Status)
+}
+
+func (s *ServiceTestSuite) TestReadinessSchema() {
+	for _, tc := range []struct {
+		name    string
+		version uint
+		dirty   bool
+		status  string
+	}{
+		{name: "same", version: 3, status: service.CHECK_OK},
+		{name: "newer", version: 4, status: service.CHECK_OK},
+		{name: "older", version: 2, status: service.CHECK_FAILED},
+		{name: "dirty", version: 3, dirty: true, status: service.CHECK_FAILED},
+		{name: "newer dirty", version: 4, dirty: true, status: service.CHECK_FAILED},
+	} {
+		s.Run(tc.name, func() {
+			// given
+			pr := &MockPgPostRepository{MockPostRepository: &MockPostRepository{}}
+			pr.On("Ping", mock.Anything).Return(nil)
+			pr.On("SchemaVersion", mock.Anything).Return(tc.version, tc.dirty, nil)
+			ps, closer := s.checkedService(pr, 3)
+			defer closer()
+
+			// when
+			res := ps.Readiness(s.ctx)
+
+			// then
+			s.Equal(tc.status, res["migrations"].Status)
+			s.Equal(service.SchemaStatus{Version: tc.version, Expected: 3, Dirty: tc.dirty}, res["migrations"].Details)
+		})
+	}
+}
+
+func (s *ServiceTestSuite) TestReadinessHubStopped() {
+	// given
+	ctx, cancel := context.WithCancel(s.ctx)
+	ps, closer := service.NewPostService(ctx, s.pr, s.cr, &service.Config{CommentChan: make(chan models.CommentDTO)})
+	defer closer()
+
+	// when
+	cancel()
+
+	// then
+	s.Eventually(func() bool {
+		return ps.Readiness(s.ctx)["hub"].Status == service.CHECK_FAILED
+	}, time.Second, 10*time.Millisecond)
+	s.NotContains(ps.Readiness(s.ctx), "database")
+}
+
+func (s *ServiceTestSuite) TestCloseSubscriptions() {
+	// given
+	first := s.ps.CommentSubscribe(s.ctx, 1)
+
+	// when
+	s.ps.CloseSubscriptions()
+	second := s.ps.CommentSubscribe(s.ctx, 1)
+
+	// then
+	_, ok := <-first
+	s.False(ok)
+	_, ok = <-second
+	s.False(ok)
+	s.Empty(s.ps.SubscriptionStats().Subscribers)
+}
+
+// checkedService returns a service over the repository with database checks, which expects the schema version.
+func (s *ServiceTestSuite) checkedService(pr *MockPgPostRepository, version uint) (*service.PostService, func()) {
+	ch := make(chan models.CommentDTO, 1)
+	ps, closer := service.NewPostService(s.ctx, pr, s.cr, &service.Config{CommentChan: ch, SchemaVersion: version})
+	return ps, func() {
+		closer()
+		close(ch)
+	}
+}
+
+// cachedService returns a service with the cache, which receives comments from the returned channel.
+func (s *ServiceTestSuite) cachedService() (*service.PostService, chan<- models.CommentDTO, func()) {
+	ch := make(chan models.CommentDTO)
+	ps, closer := service.NewPostService(s.ctx, s.pr, s.cr, &service.Config{
+		CommentChan: ch,
+		Cache:       service.CacheConfig{Size: 10, TTL: time.Minute},
+	})
+	return ps, ch, func() {
+		closer()
+		close(ch)
+	}
+}
+
 func fmti64(data int64) string {
 	return strconv.FormatInt(data, 10)
 }
@@ -428,13 +1114,76 @@ type MockCommentRepository struct {
 	mock.Mock
 }
 
+// MockPgPostRepository is a repository with checks of a database.
+type MockPgPostRepository struct {
+	*MockPostRepository
+}
+
+// MockNotifyingCommentRepository is a repository which receives updates of comments.
+type MockNotifyingCommentRepository struct {
+	*MockCommentRepository
+	updates chan int64
+}
+
+type MockEventPublisher struct {
+	mock.Mock
+}
+
+// MockEventEncoder is a publisher whose events can be enqueued by repositories.
+type MockEventEncoder struct {
+	MockEventPublisher
+}
+
+// MockEventCommentRepository is a repository which enqueues events in its transactions.
+type MockEventCommentRepository struct {
+	*MockCommentRepository
+	events database.EventEncoder
+}
+
+func (p *MockPgPostRepository) Ping(ctx context.Context) error {
+	args := p.Called(ctx)
+	return args.Error(0)
+}
+
+func (p *MockPgPostRepository) SchemaVersion(ctx context.Context) (uint, bool, error) {
+	args := p.Called(ctx)
+	return args.Get(0).(uint), args.Bool(1), args.Error(2)
+}
+
+func (c *MockNotifyingCommentRepository) Updates() <-chan int64 {
+	return c.updates
+}
+
+func (c *MockEventCommentRepository) WriteEvents(enc database.EventEncoder) {
+	c.events = enc
+}
+
+func (e *MockEventEncoder) Encode(event string, data any) ([]byte, error) {
+	args := e.Called(event, data)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (e *MockEventEncoder) Wake() {
+	e.Called()
+}
+
+func (e *MockEventPublisher) Publish(ctx context.Context, event string, data any) error {
+	args := e.Called(ctx, event, data)
+	return args.Error(0)
+}
+
 func (p *MockPostRepository) Add(ctx context.Context, post *models.PostDTO) (int64, error) {
 	args := p.Called(ctx, post)
 	return int64(args.Int(0)), args.Error(1)
 }
 
-func (p *MockPostRepository) GetAll(ctx context.Context, offset int64, limit int) ([]*models.PostDTO, error) {
-	args := p.Called(ctx, offset, limit)
+func (p *MockPostRepository) AddBatch(ctx context.Context, posts []*models.PostDTO) ([]int64, error) {
+	args := p.Called(ctx, posts)
+	return args.Get(0).([]int64), args.Error(1)
+}
+
+func (p *MockPostRepository) GetAll(ctx context.Context, offset int64, limit int, order models.PostOrder) ([]*models.PostDTO, error) {
+	args := p.Called(ctx, offset, limit, order)
 	f := args.Get(0)
 	if f == nil {
 		return nil, args.Error(1)
@@ -449,10 +1198,38 @@ func (p *MockPostRepository) Get(ctx context.Context, id int64) (*models.PostDTO
 	}
 	return f.(*models.PostDTO), args.Error(1)
 }
+func (p *MockPostRepository) GetManyByIDs(ctx context.Context, ids []int64) ([]*models.PostDTO, error) {
+	args := p.Called(ctx, ids)
+	f := args.Get(0)
+	if f == nil {
+		return nil, args.Error(1)
+	}
+	return f.([]*models.PostDTO), args.Error(1)
+}
 func (p *MockPostRepository) IsCommentable(ctx context.Context, id int64) (bool, error) {
 	args := p.Called(ctx, id)
 	return args.Bool(0), args.Error(1)
 }
+func (p *MockPostRepository) RecountComments(ctx context.Context) (int64, error) {
+	args := p.Called(ctx)
+	return int64(args.Int(0)), args.Error(1)
+}
+func (p *MockPostRepository) GetAllOfAuthor(ctx context.Context, author string, offset int64, limit int) ([]*models.PostDTO, error) {
+	args := p.Called(ctx, author, offset, limit)
+	f := args.Get(0)
+	if f == nil {
+		return nil, args.Error(1)
+	}
+	return f.([]*models.PostDTO), args.Error(1)
+}
+func (p *MockPostRepository) AuthorActivity(ctx context.Context, author string) (*models.ActivityDTO, error) {
+	args := p.Called(ctx, author)
+	f := args.Get(0)
+	if f == nil {
+		return nil, args.Error(1)
+	}
+	return f.(*models.ActivityDTO), args.Error(1)
+}
 
 func (p *MockCommentRepository) Add(ctx context.Context, c *models.CommentDTO) (int64, error) {
 	args := p.Called(ctx, c)
@@ -468,10 +1245,57 @@ func (p *MockCommentRepository) Add(ctx context.Context, c *models.CommentDTO) (
 	return int64(args.Int(0)), args.Error(1)
 }
 
-func (p *MockCommentRepository) GetAllOfPost(ctx context.Context, idPost int64, offset int64, limit int) ([]*models.CommentDTO, error) {
-	args := p.Called(ctx, idPost, offset, limit)
+func (p *MockCommentRepository) AddBatch(ctx context.Context, comments []*models.CommentDTO) ([]int64, error) {
+	args := p.Called(ctx, comments)
+	return args.Get(0).([]int64), args.Error(1)
+}
+
+func (p *MockCommentRepository) GetAllOfPost(ctx context.Context, idPost int64, after models.CommentCursor, limit int) ([]*models.CommentDTO, error) {
+	args := p.Called(ctx, idPost, after, limit)
 	return args.Get(0).([]*models.CommentDTO), args.Error(1)
 }
+func (p *MockCommentRepository) GetAllOfPosts(ctx context.Context, ids []int64, limit int) (map[int64][]*models.CommentDTO, error) {
+	args := p.Called(ctx, ids, limit)
+	f := args.Get(0)
+	if f == nil {
+		return nil, args.Error(1)
+	}
+	return f.(map[int64][]*models.CommentDTO), args.Error(1)
+}
+
+func (p *MockCommentRepository) GetManyByIDs(ctx context.Context, ids []int64) ([]*models.CommentDTO, error) {
+	args := p.Called(ctx, ids)
+	f := args.Get(0)
+	if f == nil {
+		return nil, args.Error(1)
+	}
+	return f.([]*models.CommentDTO), args.Error(1)
+}
+
+func (p *MockCommentRepository) Pin(ctx context.Context, idPost int64, id int64, limit int) error {
+	args := p.Called(ctx, idPost, id, limit)
+	return args.Error(0)
+}
+
+func (p *MockCommentRepository) Unpin(ctx context.Context, idPost int64, id int64) error {
+	args := p.Called(ctx, idPost, id)
+	return args.Error(0)
+}
+
+func (p *MockCommentRepository) GetAllOfAuthor(ctx context.Context, author string, after int64, limit int) ([]*models.CommentDTO, error) {
+	args := p.Called(ctx, author, after, limit)
+	return args.Get(0).([]*models.CommentDTO), args.Error(1)
+}
+
+func (p *MockCommentRepository) AuthorActivity(ctx context.Context, author string) (*models.ActivityDTO, error) {
+	args := p.Called(ctx, author)
+	f := args.Get(0)
+	if f == nil {
+		return nil, args.Error(1)
+	}
+	return f.(*models.ActivityDTO), args.Error(1)
+}
+
 func (p *MockCommentRepository) Get(ctx context.Context, id int64) (models.CommentDTO, error) {
 	args := p.Called(ctx, id)
 	return args.Get(0).(models.CommentDTO), args.Error(1)
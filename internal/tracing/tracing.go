@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+const (
+	EXPORTER_NONE   = "none"
+	EXPORTER_OTLP   = "otlp"
+	EXPORTER_STDOUT = "stdout"
+	EXPORTER_FILE   = "file"
+
+	SERVICE_NAME = "comments"
+	// SHUTDOWN_TIMEOUT limits export of the remaining spans on shutdown.
+	SHUTDOWN_TIMEOUT = 5 * time.Second
+)
+
+var ErrExporter = errors.New("unknown tracing exporter")
+
+type Config struct {
+	// Exporter is one of EXPORTER_* constants.
+	Exporter string
+	// Endpoint is an URL of an OTLP/HTTP collector, e.g. http://localhost:4318.
+	// Empty one is read from OTEL_EXPORTER_OTLP_ENDPOINT.
+	Endpoint string
+	// File receives spans of the file exporter as JSON lines.
+	File        string
+	SampleRatio float64
+}
+
+// Start sets the global tracer provider and W3C trace context propagation and returns its shutdown.
+// Spans are not recorded with the "none" exporter, but trace context of requests is still propagated.
+func Start(ctx context.Context, cfg *Config) (func(), error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var opt sdktrace.TracerProviderOption
+	closeFile := func() {}
+	switch cfg.Exporter {
+	case EXPORTER_NONE, "":
+		return func() {}, nil
+	case EXPORTER_OTLP:
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		exp, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, err
+		}
+		opt = sdktrace.WithBatcher(exp)
+	case EXPORTER_STDOUT:
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, err
+		}
+		opt = sdktrace.WithSyncer(exp)
+	case EXPORTER_FILE:
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		opt = sdktrace.WithSyncer(exp)
+		closeFile = func() {
+			if err := f.Close(); err != nil {
+				log.Error().Err(err).Str("file", cfg.File).Msg("can not close traces file")
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrExporter, cfg.Exporter)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		opt,
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(SERVICE_NAME))),
+	)
+	otel.SetTracerProvider(tp)
+	log.Info().Str("exporter", cfg.Exporter).Float64("sample ratio", cfg.SampleRatio).Msg("tracing enabled")
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+		defer cancel()
+		if err := tp.Shutdown(ctx); err != nil {
+			log.Error().Err(err).Msg("can not export remaining spans")
+		}
+		closeFile()
+	}, nil
+}
@@ -0,0 +1,51 @@
+package tracing_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mi-raf/comment-project/internal/tracing"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel"
+)
+
+type TracingTestSuite struct {
+	suite.Suite
+	ctx context.Context
+}
+
+func (s *TracingTestSuite) SetupSuite() {
+	s.ctx = context.Background()
+}
+
+func (s *TracingTestSuite) TestFileExporter() {
+	// given
+	path := filepath.Join(s.T().TempDir(), "traces.jsonl")
+	stop, err := tracing.Start(s.ctx, &tracing.Config{Exporter: tracing.EXPORTER_FILE, File: path, SampleRatio: 1})
+	s.Require().NoError(err)
+
+	// when
+	_, span := otel.Tracer("test").Start(s.ctx, "operation")
+	span.End()
+	stop()
+
+	// then
+	data, err := os.ReadFile(path)
+	s.NoError(err)
+	s.Contains(string(data), `"Name":"operation"`)
+	s.Contains(string(data), `"Value":"comments"`)
+}
+
+func (s *TracingTestSuite) TestUnknownExporter() {
+	// when
+	_, err := tracing.Start(s.ctx, &tracing.Config{Exporter: "jaeger"})
+
+	// then
+	s.ErrorIs(err, tracing.ErrExporter)
+}
+
+func TestTracingTestSuite(t *testing.T) {
+	suite.Run(t, new(TracingTestSuite))
+}
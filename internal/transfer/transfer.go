@@ -0,0 +1,229 @@
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mi-raf/comment-project/internal/database"
+	"github.com/mi-raf/comment-project/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+type Format string
+
+const (
+	// FormatJSONL writes one post with its comments per line.
+	FormatJSONL Format = "jsonl"
+	// FormatJSON writes an array of posts with their comments.
+	FormatJSON Format = "json"
+
+	PAGE_SIZE  = 100
+	BATCH_SIZE = 100
+)
+
+var ErrClientData = errors.New("incorrect transfer data")
+var ErrDatabase = errors.New("errors while loading or saving transfer data")
+
+type (
+	// Post is a post with all its comments in export files. Comments are ordered by id,
+	// so a parent comment is always before its replies.
+	Post struct {
+		ID          int64      `json:"id"`
+		Author      string     `json:"author"`
+		Title       string     `json:"title"`
+		Text        string     `json:"text"`
+		Commentable bool       `json:"commentable"`
+		Time        time.Time  `json:"time"`
+		Comments    []*Comment `json:"comments"`
+	}
+
+	Comment struct {
+		ID       int64     `json:"id"`
+		ParentID int64     `json:"parentId,omitempty"`
+		Author   string    `json:"author"`
+		Text     string    `json:"text"`
+		Time     time.Time `json:"time"`
+		Pinned   bool      `json:"pinned,omitempty"`
+	}
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatJSONL, FormatJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("%w: unknown format %q", ErrClientData, s)
+}
+
+// Export writes posts with their comment trees, only the post with postID if it is not 0.
+// It returns a number of written posts.
+func Export(ctx context.Context, r database.TransferRepository, w io.Writer, format Format, postID int64) (int, error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	if format == FormatJSON {
+		bw.WriteString("[\n")
+	}
+	n := 0
+	var after int64
+	for {
+		posts, err := r.ExportPosts(ctx, postID, after, PAGE_SIZE)
+		if err != nil {
+			return n, fmt.Errorf("%w: %w", ErrDatabase, err)
+		}
+		for _, p := range posts {
+			post, err := exportPost(ctx, r, p)
+			if err != nil {
+				return n, err
+			}
+			if format == FormatJSON && n > 0 {
+				bw.WriteString(",")
+			}
+			if err := enc.Encode(post); err != nil {
+				return n, err
+			}
+			n++
+			after = p.Id
+		}
+		if len(posts) < PAGE_SIZE {
+			break
+		}
+	}
+	if postID != 0 && n == 0 {
+		return 0, fmt.Errorf("%w: post %d is not found", ErrClientData, postID)
+	}
+	if format == FormatJSON {
+		bw.WriteString("]\n")
+	}
+	return n, bw.Flush()
+}
+
+func exportPost(ctx context.Context, r database.TransferRepository, p *models.PostDTO) (*Post, error) {
+	res := &Post{ID: p.Id, Author: p.Author, Title: p.Title, Text: p.Text, Commentable: p.IsCommentable, Time: p.Time, Comments: []*Comment{}}
+	var after int64
+	for {
+		comments, err := r.ExportComments(ctx, p.Id, after, PAGE_SIZE)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrDatabase, err)
+		}
+		for _, c := range comments {
+			res.Comments = append(res.Comments, &Comment{ID: c.Id, ParentID: c.ParentId, Author: c.Author, Text: c.Text, Time: c.Time, Pinned: c.Pinned})
+			after = c.Id
+		}
+		if len(comments) < PAGE_SIZE {
+			return res, nil
+		}
+	}
+}
+
+// Import reads posts written by Export in any format and stores them with new ids
+// in transactions of batchSize posts. Posts already imported from the source are skipped,
+// so an interrupted import of the same file can be repeated.
+// It returns numbers of read and stored posts.
+func Import(ctx context.Context, r database.TransferRepository, rd io.Reader, source string, batchSize int) (int, int, error) {
+	if batchSize < 1 {
+		batchSize = BATCH_SIZE
+	}
+	br := bufio.NewReader(rd)
+	dec := json.NewDecoder(br)
+	array, err := isArray(br)
+	if err != nil {
+		return 0, 0, err
+	}
+	if array {
+		if _, err := dec.Token(); err != nil {
+			return 0, 0, fmt.Errorf("%w: %w", ErrClientData, err)
+		}
+	}
+
+	read, stored := 0, 0
+	batch := make([]*models.PostTreeDTO, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := r.Import(ctx, source, batch)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrDatabase, err)
+		}
+		stored += n
+		log.Info().Int("read", read).Int("stored", stored).Msg("import batch committed")
+		batch = batch[:0]
+		return nil
+	}
+	for {
+		if array && !dec.More() {
+			break
+		}
+		var p Post
+		if err := dec.Decode(&p); err != nil {
+			if err == io.EOF && !array {
+				break
+			}
+			return read, stored, fmt.Errorf("%w: post %d: %w", ErrClientData, read+1, err)
+		}
+		tree, err := importPost(&p)
+		if err != nil {
+			return read, stored, err
+		}
+		read++
+		batch = append(batch, tree)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return read, stored, err
+			}
+		}
+	}
+	if array {
+		if _, err := dec.Token(); err != nil {
+			return read, stored, fmt.Errorf("%w: %w", ErrClientData, err)
+		}
+	}
+	return read, stored, flush()
+}
+
+// isArray reports whether the input is a JSON array without consuming it.
+func isArray(br *bufio.Reader) (bool, error) {
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			br.ReadByte()
+		default:
+			return b[0] == '[', nil
+		}
+	}
+}
+
+func importPost(p *Post) (*models.PostTreeDTO, error) {
+	if p.ID == 0 {
+		return nil, fmt.Errorf("%w: post without id", ErrClientData)
+	}
+	tree := &models.PostTreeDTO{
+		Post:     models.PostDTO{Id: p.ID, Author: p.Author, Title: p.Title, Text: p.Text, IsCommentable: p.Commentable, Time: p.Time},
+		Comments: make([]*models.CommentDTO, 0, len(p.Comments)),
+	}
+	seen := make(map[int64]struct{}, len(p.Comments))
+	for _, c := range p.Comments {
+		if _, ok := seen[c.ID]; ok || c.ID == 0 {
+			return nil, fmt.Errorf("%w: incorrect comment id %d in post %d", ErrClientData, c.ID, p.ID)
+		}
+		if _, ok := seen[c.ParentID]; c.ParentID != 0 && !ok {
+			return nil, fmt.Errorf("%w: parent %d of comment %d in post %d is not before it", ErrClientData, c.ParentID, c.ID, p.ID)
+		}
+		seen[c.ID] = struct{}{}
+		tree.Comments = append(tree.Comments, &models.CommentDTO{Id: c.ID, PostId: p.ID, ParentId: c.ParentID, Author: c.Author, Text: c.Text, Time: c.Time, Pinned: c.Pinned})
+	}
+	return tree, nil
+}
@@ -0,0 +1,189 @@
+package transfer_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/mi-raf/comment-project/internal/database"
+	"github.com/mi-raf/comment-project/internal/models"
+	"github.com/mi-raf/comment-project/internal/transfer"
+	"github.com/stretchr/testify/suite"
+)
+
+var errBroken = errors.New("connection is broken")
+
+// brokenRepository fails on comments and imports.
+type brokenRepository struct {
+	database.TransferRepository
+}
+
+func (brokenRepository) ExportComments(context.Context, int64, int64, int) ([]*models.CommentDTO, error) {
+	return nil, errBroken
+}
+
+func (brokenRepository) Import(context.Context, string, []*models.PostTreeDTO) (int, error) {
+	return 0, errBroken
+}
+
+type TransferTestSuite struct {
+	suite.Suite
+	ctx      context.Context
+	posts    *database.InMemoryPostRepository
+	comments *database.InMemoryCommentRepository
+	src      *database.InMemoryTransferRepository
+	dst      *database.InMemoryTransferRepository
+}
+
+func (s *TransferTestSuite) SetupTest() {
+	s.ctx = context.Background()
+	s.posts = database.NewInMemnoryPostRepository()
+	s.comments = database.NewInMemnoryCommentRepository(make(chan models.CommentDTO, 100))
+	s.src = database.NewInMemoryTransferRepository(s.posts, s.comments)
+	s.dst = database.NewInMemoryTransferRepository(database.NewInMemnoryPostRepository(), database.NewInMemnoryCommentRepository(nil))
+}
+
+func (s *TransferTestSuite) TestExportImport() {
+	// given
+	postId := s.addPost()
+	root := s.addComment(postId, 0)
+	reply := s.addComment(postId, root)
+	s.addComment(postId, reply)
+	s.NoError(s.comments.Pin(s.ctx, postId, reply, 3))
+	s.addPost()
+	var buf bytes.Buffer
+
+	// when
+	n, err := transfer.Export(s.ctx, s.src, &buf, transfer.FormatJSONL, 0)
+	s.NoError(err)
+	read, stored, err := transfer.Import(s.ctx, s.dst, &buf, "source", 1)
+
+	// then
+	s.NoError(err)
+	s.Equal(2, n)
+	s.Equal(2, read)
+	s.Equal(2, stored)
+	s.Equal(s.exportAll(s.src), s.exportAll(s.dst))
+}
+
+func (s *TransferTestSuite) TestExportPostJSON() {
+	// given
+	s.addPost()
+	postId := s.addPost()
+	s.addComment(postId, 0)
+	var buf bytes.Buffer
+
+	// when
+	n, err := transfer.Export(s.ctx, s.src, &buf, transfer.FormatJSON, postId)
+
+	// then
+	s.NoError(err)
+	s.Equal(1, n)
+	var posts []transfer.Post
+	s.NoError(json.Unmarshal(buf.Bytes(), &posts))
+	s.Len(posts, 1)
+	s.Equal(postId, posts[0].ID)
+	s.Len(posts[0].Comments, 1)
+}
+
+func (s *TransferTestSuite) TestImportResume() {
+	// given
+	postId := s.addPost()
+	s.addComment(postId, 0)
+	s.addPost()
+	var buf bytes.Buffer
+	_, err := transfer.Export(s.ctx, s.src, &buf, transfer.FormatJSON, 0)
+	s.NoError(err)
+	data := buf.String()
+	_, _, err = transfer.Import(s.ctx, s.dst, strings.NewReader(data), "source", 1)
+	s.NoError(err)
+
+	// when
+	read, stored, err := transfer.Import(s.ctx, s.dst, strings.NewReader(data), "source", 1)
+
+	// then
+	s.NoError(err)
+	s.Equal(2, read)
+	s.Equal(0, stored)
+	posts, err := s.dst.ExportPosts(s.ctx, 0, 0, 10)
+	s.NoError(err)
+	s.Len(posts, 2)
+}
+
+func (s *TransferTestSuite) TestImportParentAfterReply() {
+	// given
+	data := `{"id":1,"author":"a","title":"t","text":"t","commentable":true,"time":"2024-01-01T00:00:00Z","comments":[{"id":2,"parentId":3,"author":"a","text":"t","time":"2024-01-01T00:00:00Z"},{"id":3,"author":"a","text":"t","time":"2024-01-01T00:00:00Z"}]}`
+
+	// when
+	_, stored, err := transfer.Import(s.ctx, s.dst, strings.NewReader(data), "source", 1)
+
+	// then
+	s.ErrorIs(err, transfer.ErrClientData)
+	s.Equal(0, stored)
+}
+
+func (s *TransferTestSuite) TestDatabaseErrorsWrapped() {
+	// given
+	s.addPost()
+	r := brokenRepository{TransferRepository: s.src}
+	data := `{"id":1,"author":"a","title":"t","text":"t","commentable":true,"time":"2024-01-01T00:00:00Z","comments":[]}`
+
+	// when
+	_, exportErr := transfer.Export(s.ctx, r, &bytes.Buffer{}, transfer.FormatJSONL, 0)
+	_, _, importErr := transfer.Import(s.ctx, r, strings.NewReader(data), "source", 1)
+
+	// then
+	s.ErrorIs(exportErr, transfer.ErrDatabase)
+	s.ErrorIs(exportErr, errBroken)
+	s.ErrorIs(importErr, transfer.ErrDatabase)
+	s.ErrorIs(importErr, errBroken)
+}
+
+func (s *TransferTestSuite) addPost() int64 {
+	var p models.PostDTO
+	gofakeit.Struct(&p)
+	p.IsCommentable = true
+	p.Time = p.Time.UTC().Truncate(time.Microsecond)
+	id, err := s.posts.Add(s.ctx, &p)
+	s.NoError(err)
+	return id
+}
+
+func (s *TransferTestSuite) addComment(postId, parentId int64) int64 {
+	var c models.CommentDTO
+	gofakeit.Struct(&c)
+	c.PostId = postId
+	c.ParentId = parentId
+	c.Time = c.Time.UTC().Truncate(time.Microsecond)
+	id, err := s.comments.Add(s.ctx, &c)
+	s.NoError(err)
+	return id
+}
+
+// exportAll returns posts without ids, with comments referencing parents by position.
+func (s *TransferTestSuite) exportAll(r database.TransferRepository) []transfer.Post {
+	var buf bytes.Buffer
+	_, err := transfer.Export(s.ctx, r, &buf, transfer.FormatJSON, 0)
+	s.NoError(err)
+	var posts []transfer.Post
+	s.NoError(json.Unmarshal(buf.Bytes(), &posts))
+	for i := range posts {
+		posts[i].ID = 0
+		pos := make(map[int64]int64)
+		for j, c := range posts[i].Comments {
+			pos[c.ID] = int64(j + 1)
+			c.ID = 0
+			c.ParentID = pos[c.ParentID]
+		}
+	}
+	return posts
+}
+
+func TestTransferTestSuite(t *testing.T) {
+	suite.Run(t, new(TransferTestSuite))
+}
@@ -0,0 +1,373 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mi-raf/comment-project/graph/model"
+	"github.com/mi-raf/comment-project/internal/database"
+	"github.com/mi-raf/comment-project/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	SIGNATURE_HEADER = "X-Webhook-Signature"
+	TIMESTAMP_HEADER = "X-Webhook-Timestamp"
+	EVENT_HEADER     = "X-Webhook-Event"
+	DELIVERY_HEADER  = "X-Webhook-Delivery"
+
+	MAX_LIMIT = 100
+	// TIMEOUT limits an attempt when the config has no timeout, claimed deliveries are leased for two attempts.
+	TIMEOUT = 10 * time.Second
+	// CLEANUP_INTERVAL is how often old delivered and dead deliveries are deleted, or the retention if it is shorter.
+	CLEANUP_INTERVAL = time.Hour
+	CLEANUP_BATCH    = 1000
+)
+
+var ErrClientData = errors.New("incorrect webhook data")
+var ErrDatabase = errors.New("errors while loading or saving webhooks")
+
+type (
+	// Dispatcher stores deliveries of events for registered webhooks and sends them in background.
+	Dispatcher struct {
+		r      database.WebhookRepository
+		client *http.Client
+		cfg    Config
+		wake   chan struct{}
+		wg     sync.WaitGroup
+	}
+
+	Config struct {
+		// MaxAttempts is a number of failed attempts after which a delivery is dead.
+		MaxAttempts  int
+		BaseBackoff  time.Duration
+		MaxBackoff   time.Duration
+		PollInterval time.Duration
+		// Timeout limits an attempt, zero or less means TIMEOUT.
+		Timeout   time.Duration
+		BatchSize int
+		// Retention is how long delivered and dead deliveries are kept after they were enqueued, zero keeps them forever.
+		Retention time.Duration
+	}
+
+	envelope struct {
+		Event string    `json:"event"`
+		Time  time.Time `json:"time"`
+		Data  any       `json:"data"`
+	}
+)
+
+func NewDispatcher(ctx context.Context, r database.WebhookRepository, cfg *Config) (*Dispatcher, func()) {
+	d := &Dispatcher{
+		r:    r,
+		cfg:  *cfg,
+		wake: make(chan struct{}, 1),
+	}
+	if d.cfg.Timeout <= 0 {
+		d.cfg.Timeout = TIMEOUT
+	}
+	d.client = &http.Client{Timeout: d.cfg.Timeout}
+	ctx, cancel := context.WithCancel(ctx)
+	d.wg.Add(1)
+	go d.run(ctx)
+	return d, func() {
+		cancel()
+		d.wg.Wait()
+	}
+}
+
+// Publish stores a delivery of the event for every subscribed webhook.
+func (d *Dispatcher) Publish(ctx context.Context, event string, data any) error {
+	body, err := d.Encode(event, data)
+	if err != nil {
+		return err
+	}
+	n, err := d.r.Enqueue(ctx, event, body)
+	if err != nil {
+		return ErrDatabase
+	}
+	if n > 0 {
+		log.Debug().Str("event", event).Int64("deliveries", n).Msg("webhook deliveries enqueued")
+		d.Wake()
+	}
+	return nil
+}
+
+// Encode returns the body of deliveries of the event, so they can be enqueued by other repositories.
+func (d *Dispatcher) Encode(event string, data any) ([]byte, error) {
+	return json.Marshal(envelope{Event: event, Time: time.Now().UTC(), Data: data})
+}
+
+// Wake sends enqueued deliveries without waiting for the next poll.
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) Register(ctx context.Context, input model.NewWebhook) (*model.Webhook, error) {
+	u, err := url.Parse(input.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: incorrect url %q", ErrClientData, input.URL)
+	}
+	if input.Secret == "" {
+		return nil, fmt.Errorf("%w: secret is required", ErrClientData)
+	}
+	if len(input.Events) == 0 {
+		return nil, fmt.Errorf("%w: at least one event is required", ErrClientData)
+	}
+	events := make([]string, 0, len(input.Events))
+	for _, e := range input.Events {
+		if !e.IsValid() {
+			return nil, fmt.Errorf("%w: unknown event %q", ErrClientData, e)
+		}
+		events = append(events, eventName(e))
+	}
+	w := &models.WebhookDTO{URL: input.URL, Secret: input.Secret, Events: events, Active: true}
+	id, err := d.r.Add(ctx, w)
+	if err != nil {
+		return nil, ErrDatabase
+	}
+	w.Id = id
+	log.Info().Int64("webhook id", id).Str("url", input.URL).Msg("webhook registered")
+	return mapWebhook(w), nil
+}
+
+func (d *Dispatcher) Delete(ctx context.Context, id int64) (bool, error) {
+	ok, err := d.r.Delete(ctx, id)
+	if err != nil {
+		log.Error().Err(err).Int64("webhook id", id).Msg("can not delete webhook")
+		return false, ErrDatabase
+	}
+	return ok, nil
+}
+
+func (d *Dispatcher) Webhooks(ctx context.Context) ([]*model.Webhook, error) {
+	hooks, err := d.r.GetAll(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("can not get webhooks")
+		return nil, ErrDatabase
+	}
+	res := make([]*model.Webhook, 0, len(hooks))
+	for _, h := range hooks {
+		res = append(res, mapWebhook(h))
+	}
+	return res, nil
+}
+
+func (d *Dispatcher) Deliveries(ctx context.Context, webhookID int64, status *model.WebhookDeliveryStatus, limit int, offset int64) ([]*model.WebhookDelivery, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: after %d should be more than 0", ErrClientData, offset)
+	}
+	if limit < 1 || limit > MAX_LIMIT {
+		limit = MAX_LIMIT
+	}
+	f := models.DeliveryFilter{WebhookId: webhookID}
+	if status != nil {
+		f.Status = models.DeliveryStatus(strings.ToLower(status.String()))
+	}
+	ds, err := d.r.GetDeliveries(ctx, f, offset, limit)
+	if err != nil {
+		log.Error().Err(err).Msg("can not get webhook deliveries")
+		return nil, ErrDatabase
+	}
+	res := make([]*model.WebhookDelivery, 0, len(ds))
+	for _, del := range ds {
+		res = append(res, mapDelivery(del))
+	}
+	return res, nil
+}
+
+func (d *Dispatcher) run(ctx context.Context) {
+	defer d.wg.Done()
+	t := time.NewTicker(d.cfg.PollInterval)
+	defer t.Stop()
+	var cleaned time.Time
+	for {
+		d.deliverDue(ctx)
+		if d.cfg.Retention > 0 && time.Since(cleaned) >= min(CLEANUP_INTERVAL, d.cfg.Retention) {
+			d.deleteExpired(ctx)
+			cleaned = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-d.wake:
+		}
+	}
+}
+
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		// lease is longer than one attempt, so a claimed delivery is not sent twice by this process
+		ds, err := d.r.Claim(ctx, time.Now(), 2*d.cfg.Timeout, d.cfg.BatchSize)
+		if err != nil {
+			log.Error().Err(err).Msg("can not claim webhook deliveries")
+			return
+		}
+		if len(ds) == 0 {
+			return
+		}
+		var wg sync.WaitGroup
+		for _, del := range ds {
+			wg.Add(1)
+			go func(del *models.WebhookDeliveryDTO) {
+				defer wg.Done()
+				d.deliver(ctx, del)
+			}(del)
+		}
+		wg.Wait()
+	}
+}
+
+// deleteExpired deletes delivered and dead deliveries enqueued before the retention.
+func (d *Dispatcher) deleteExpired(ctx context.Context) {
+	before := time.Now().Add(-d.cfg.Retention)
+	var total int64
+	for ctx.Err() == nil {
+		n, err := d.r.DeleteFinished(ctx, before, CLEANUP_BATCH)
+		if err != nil {
+			log.Error().Err(err).Msg("can not delete old webhook deliveries")
+			return
+		}
+		total += n
+		if n < CLEANUP_BATCH {
+			break
+		}
+	}
+	if total > 0 {
+		log.Debug().Int64("deliveries", total).Time("before", before).Msg("old webhook deliveries deleted")
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, del *models.WebhookDeliveryDTO) {
+	code, err := d.send(ctx, del)
+	if err == nil {
+		log.Debug().Int64("delivery id", del.Id).Int("code", code).Msg("webhook delivered")
+		if err := d.r.MarkDelivered(ctx, del.Id, code, time.Now()); err != nil {
+			log.Error().Err(err).Int64("delivery id", del.Id).Msg("can not mark webhook delivery as delivered")
+		}
+		return
+	}
+	if ctx.Err() != nil {
+		// the delivery is retried after the lease when the process is stopped
+		return
+	}
+	attempts := del.Attempts + 1
+	dead := attempts >= d.cfg.MaxAttempts
+	next := time.Now().Add(d.backoff(attempts))
+	log.Warn().Err(err).Int64("delivery id", del.Id).Int("attempts", attempts).Bool("dead", dead).Msg("webhook delivery failed")
+	if err := d.r.MarkFailed(ctx, del.Id, code, err.Error(), next, dead); err != nil {
+		log.Error().Err(err).Int64("delivery id", del.Id).Msg("can not mark webhook delivery as failed")
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, del *models.WebhookDeliveryDTO) (int, error) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, del.URL, bytes.NewReader(del.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EVENT_HEADER, del.Event)
+	req.Header.Set(DELIVERY_HEADER, strconv.FormatInt(del.Id, 10))
+	req.Header.Set(TIMESTAMP_HEADER, ts)
+	req.Header.Set(SIGNATURE_HEADER, "sha256="+Sign(del.Secret, ts, del.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns delay before the next attempt: BaseBackoff doubled after every failure,
+// capped by MaxBackoff, with up to 10% of jitter.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.cfg.MaxBackoff
+	if attempts < 32 {
+		if b := d.cfg.BaseBackoff << (attempts - 1); b > 0 && b < delay {
+			delay = b
+		}
+	}
+	return delay + rand.N(delay/10+1)
+}
+
+// Sign returns hex encoded HMAC-SHA256 of "timestamp.body" with the webhook secret.
+func Sign(secret string, timestamp string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(timestamp))
+	m.Write([]byte("."))
+	m.Write(body)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// eventName converts COMMENT_CREATED to comment.created.
+func eventName(e model.WebhookEvent) string {
+	return strings.ReplaceAll(strings.ToLower(e.String()), "_", ".")
+}
+
+func eventFromName(name string) model.WebhookEvent {
+	return model.WebhookEvent(strings.ReplaceAll(strings.ToUpper(name), ".", "_"))
+}
+
+func mapWebhook(w *models.WebhookDTO) *model.Webhook {
+	events := make([]model.WebhookEvent, 0, len(w.Events))
+	for _, e := range w.Events {
+		// webhooks registered for comment.updated, which was never sent, keep it in the database
+		if ev := eventFromName(e); ev.IsValid() {
+			events = append(events, ev)
+		}
+	}
+	return &model.Webhook{
+		ID:     strconv.FormatInt(w.Id, 10),
+		URL:    w.URL,
+		Events: events,
+		Active: w.Active,
+		Time:   w.Time,
+	}
+}
+
+func mapDelivery(d *models.WebhookDeliveryDTO) *model.WebhookDelivery {
+	res := &model.WebhookDelivery{
+		ID:            strconv.FormatInt(d.Id, 10),
+		WebhookID:     strconv.FormatInt(d.WebhookId, 10),
+		Event:         eventFromName(d.Event),
+		Status:        model.WebhookDeliveryStatus(strings.ToUpper(string(d.Status))),
+		Attempts:      d.Attempts,
+		NextAttemptAt: d.NextAttemptAt,
+		Payload:       string(d.Payload),
+		Time:          d.Time,
+	}
+	if d.LastError != "" {
+		res.LastError = &d.LastError
+	}
+	if d.ResponseCode != 0 {
+		res.ResponseCode = &d.ResponseCode
+	}
+	if !d.DeliveredAt.IsZero() {
+		res.DeliveredAt = &d.DeliveredAt
+	}
+	return res
+}
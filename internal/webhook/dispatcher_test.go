@@ -0,0 +1,218 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mi-raf/comment-project/graph/model"
+	"github.com/mi-raf/comment-project/internal/database"
+	"github.com/mi-raf/comment-project/internal/models"
+	"github.com/mi-raf/comment-project/internal/webhook"
+	"github.com/stretchr/testify/suite"
+)
+
+type DispatcherTestSuite struct {
+	suite.Suite
+	ctx    context.Context
+	r      *database.InMemoryWebhookRepository
+	d      *webhook.Dispatcher
+	closer func()
+}
+
+func (s *DispatcherTestSuite) SetupTest() {
+	s.ctx = context.Background()
+	s.r = database.NewInMemoryWebhookRepository()
+	s.d, s.closer = webhook.NewDispatcher(s.ctx, s.r, &webhook.Config{
+		MaxAttempts:  3,
+		BaseBackoff:  time.Millisecond,
+		MaxBackoff:   10 * time.Millisecond,
+		PollInterval: 5 * time.Millisecond,
+		Timeout:      time.Second,
+		BatchSize:    10,
+	})
+}
+
+func (s *DispatcherTestSuite) TearDownTest() {
+	s.closer()
+}
+
+func (s *DispatcherTestSuite) TestDeliverSigned() {
+	// given
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- b
+	}))
+	defer srv.Close()
+	_, err := s.d.Register(s.ctx, model.NewWebhook{URL: srv.URL, Secret: "secret", Events: []model.WebhookEvent{model.WebhookEventCommentCreated}})
+	s.NoError(err)
+
+	// when
+	s.NoError(s.d.Publish(s.ctx, models.EventPostCreated, map[string]string{"id": "1"}))
+	s.NoError(s.d.Publish(s.ctx, models.EventCommentCreated, map[string]string{"id": "2"}))
+
+	// then
+	select {
+	case r := <-received:
+		body := <-bodies
+		s.Equal(models.EventCommentCreated, r.Header.Get(webhook.EVENT_HEADER))
+		s.Equal("sha256="+webhook.Sign("secret", r.Header.Get(webhook.TIMESTAMP_HEADER), body), r.Header.Get(webhook.SIGNATURE_HEADER))
+		var e struct {
+			Event string            `json:"event"`
+			Data  map[string]string `json:"data"`
+		}
+		s.NoError(json.Unmarshal(body, &e))
+		s.Equal(models.EventCommentCreated, e.Event)
+		s.Equal("2", e.Data["id"])
+	case <-time.After(2 * time.Second):
+		s.FailNow("it's been too long")
+	}
+	s.Eventually(func() bool {
+		ds, err := s.d.Deliveries(s.ctx, 0, nil, 10, 0)
+		return err == nil && len(ds) == 1 && ds[0].Status == model.WebhookDeliveryStatusDelivered
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func (s *DispatcherTestSuite) TestDeadAfterMaxAttempts() {
+	// given
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	_, err := s.d.Register(s.ctx, model.NewWebhook{URL: srv.URL, Secret: "secret", Events: []model.WebhookEvent{model.WebhookEventPostCreated}})
+	s.NoError(err)
+
+	// when
+	s.NoError(s.d.Publish(s.ctx, models.EventPostCreated, map[string]string{"id": "1"}))
+
+	// then
+	dead := model.WebhookDeliveryStatusDead
+	s.Eventually(func() bool {
+		ds, err := s.d.Deliveries(s.ctx, 0, &dead, 10, 0)
+		return err == nil && len(ds) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	ds, err := s.d.Deliveries(s.ctx, 0, &dead, 10, 0)
+	s.NoError(err)
+	s.Equal(3, ds[0].Attempts)
+	s.Equal(http.StatusInternalServerError, *ds[0].ResponseCode)
+	s.Equal(int32(3), calls.Load())
+}
+
+func (s *DispatcherTestSuite) TestLeaseWithoutTimeout() {
+	// given
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer srv.Close()
+	defer close(block)
+	r := database.NewInMemoryWebhookRepository()
+	d, closer := webhook.NewDispatcher(s.ctx, r, &webhook.Config{MaxAttempts: 3, PollInterval: 5 * time.Millisecond, BatchSize: 10})
+	defer closer()
+	_, err := d.Register(s.ctx, model.NewWebhook{URL: srv.URL, Secret: "secret", Events: []model.WebhookEvent{model.WebhookEventPostCreated}})
+	s.Require().NoError(err)
+
+	// when
+	s.NoError(d.Publish(s.ctx, models.EventPostCreated, map[string]string{"id": "1"}))
+
+	// then
+	s.Eventually(func() bool {
+		ds, err := d.Deliveries(s.ctx, 0, nil, 10, 0)
+		return err == nil && len(ds) == 1 && ds[0].NextAttemptAt.After(time.Now().Add(webhook.TIMEOUT))
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func (s *DispatcherTestSuite) TestDeleteAfterRetention() {
+	// given
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(webhook.EVENT_HEADER) == models.EventPostCreated {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	r := database.NewInMemoryWebhookRepository()
+	d, closer := webhook.NewDispatcher(s.ctx, r, &webhook.Config{
+		MaxAttempts:  3,
+		BaseBackoff:  time.Hour,
+		MaxBackoff:   time.Hour,
+		PollInterval: 5 * time.Millisecond,
+		BatchSize:    10,
+		Retention:    time.Millisecond,
+	})
+	defer closer()
+	_, err := d.Register(s.ctx, model.NewWebhook{URL: srv.URL, Secret: "secret", Events: []model.WebhookEvent{model.WebhookEventPostCreated, model.WebhookEventCommentCreated}})
+	s.Require().NoError(err)
+
+	// when
+	s.NoError(d.Publish(s.ctx, models.EventPostCreated, map[string]string{"id": "1"}))
+	s.NoError(d.Publish(s.ctx, models.EventCommentCreated, map[string]string{"id": "2"}))
+
+	// then
+	// the delivered comment is deleted and the post waiting for the next attempt is kept
+	s.Eventually(func() bool {
+		ds, err := d.Deliveries(s.ctx, 0, nil, 10, 0)
+		return err == nil && len(ds) == 1 && ds[0].Event == model.WebhookEventPostCreated
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func (s *DispatcherTestSuite) TestEncode() {
+	// when
+	body, err := s.d.Encode(models.EventPostCreated, map[string]string{"id": "1"})
+
+	// then
+	s.NoError(err)
+	var e struct {
+		Event string            `json:"event"`
+		Time  time.Time         `json:"time"`
+		Data  map[string]string `json:"data"`
+	}
+	s.NoError(json.Unmarshal(body, &e))
+	s.Equal(models.EventPostCreated, e.Event)
+	s.WithinDuration(time.Now(), e.Time, time.Minute)
+	s.Equal(map[string]string{"id": "1"}, e.Data)
+}
+
+func (s *DispatcherTestSuite) TestRegisterIncorrect() {
+
+	// when
+	_, err := s.d.Register(s.ctx, model.NewWebhook{URL: "ftp://example.com", Secret: "secret", Events: []model.WebhookEvent{model.WebhookEventPostCreated}})
+
+	// then
+	s.ErrorIs(err, webhook.ErrClientData)
+}
+
+func (s *DispatcherTestSuite) TestRegisterUpdatedEvent() {
+	// when
+	_, err := s.d.Register(s.ctx, model.NewWebhook{URL: "http://example.com", Secret: "secret", Events: []model.WebhookEvent{"COMMENT_UPDATED"}})
+
+	// then
+	s.ErrorIs(err, webhook.ErrClientData)
+}
+
+func (s *DispatcherTestSuite) TestWebhooksSkipUnknownEvents() {
+	// given
+	_, err := s.r.Add(s.ctx, &models.WebhookDTO{URL: "http://example.com", Secret: "secret", Events: []string{models.EventCommentCreated, "comment.updated"}})
+	s.Require().NoError(err)
+
+	// when
+	hooks, err := s.d.Webhooks(s.ctx)
+
+	// then
+	s.NoError(err)
+	s.Require().Len(hooks, 1)
+	s.Equal([]model.WebhookEvent{model.WebhookEventCommentCreated}, hooks[0].Events)
+}
+
+func TestDispatcherTestSuite(t *testing.T) {
+	suite.Run(t, new(DispatcherTestSuite))
+}
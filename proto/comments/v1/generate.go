@@ -0,0 +1,3 @@
+package commentsv1
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative comments/v1/comments.proto
@@ -1,2 +1,7 @@
-DELETE FROM Post;
-DELETE FROM Comment;
\ No newline at end of file
+DELETE FROM Import;
+DELETE FROM Outbox;
+DELETE FROM WebhookDelivery;
+DELETE FROM Webhook;
+DELETE FROM Pin;
+DELETE FROM Comment;
+DELETE FROM Post;
\ No newline at end of file
@@ -4,7 +4,9 @@ CREATE TABLE IF NOT EXISTS Post (
     title varchar(100) NOT NULL CONSTRAINT non_empty_title CHECK(length(title)>0),
     text_p text NOT NULL CONSTRAINT non_empty_text CHECK(length(text_p)>0),
     comm boolean NOT NULL DEFAULT true,
-    time_p TIMESTAMP WITH TIME ZONE
+    time_p TIMESTAMP WITH TIME ZONE,
+    comment_count bigint NOT NULL DEFAULT 0,
+    last_comment_at TIMESTAMP WITH TIME ZONE
 );
 
 CREATE TABLE IF NOT EXISTS Comment (
@@ -14,4 +16,50 @@ CREATE TABLE IF NOT EXISTS Comment (
     author  varchar(20) NOT NULL CONSTRAINT non_empty_name CHECK(length(author)>0),
     text_c varchar(2000) NOT NULL CONSTRAINT non_empty_text CHECK(length(text_c)>0), 
     time_c TIMESTAMP
-);
\ No newline at end of file
+);
+
+CREATE TABLE IF NOT EXISTS Pin (
+    id_c bigint PRIMARY KEY REFERENCES Comment(id_c),
+    id_p bigint NOT NULL REFERENCES Post(id_p),
+    time_pin TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS Webhook (
+    id_w bigserial PRIMARY KEY,
+    url text NOT NULL CONSTRAINT non_empty_url CHECK(length(url)>0),
+    secret text NOT NULL,
+    events text[] NOT NULL,
+    active boolean NOT NULL DEFAULT true,
+    time_w TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS WebhookDelivery (
+    id_d bigserial PRIMARY KEY,
+    id_w bigint NOT NULL REFERENCES Webhook(id_w) ON DELETE CASCADE,
+    event text NOT NULL,
+    payload jsonb NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    attempts int NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    last_error text,
+    response_code int,
+    time_d TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    delivered_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE IF NOT EXISTS Outbox (
+    id_o bigserial PRIMARY KEY,
+    topic text NOT NULL,
+    payload jsonb NOT NULL,
+    time_o TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    published_at TIMESTAMP WITH TIME ZONE,
+    xid xid8 NOT NULL DEFAULT pg_current_xact_id()
+);
+
+CREATE TABLE IF NOT EXISTS Import (
+    source text NOT NULL,
+    old_id bigint NOT NULL,
+    new_id bigint NOT NULL REFERENCES Post(id_p) ON DELETE CASCADE,
+    time_i TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    PRIMARY KEY (source, old_id)
+);